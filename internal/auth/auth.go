@@ -75,6 +75,17 @@ func GetAccessToken(ctx context.Context) string {
 	return ""
 }
 
+// GetIdentity returns the identity the auth context was issued to, or an empty string
+// if there is no auth context, or the security module does not provide identities
+func GetIdentity(ctx context.Context) string {
+	if sm, ok := securityModule.(plugins.IdentitySecurityModule); ok {
+		if authCtx := GetAuthContext(ctx); authCtx != nil {
+			return sm.Identity(authCtx)
+		}
+	}
+	return ""
+}
+
 // AuthRPC authorize an RPC call
 func AuthRPC(ctx context.Context, method string, args ...interface{}) error {
 	if securityModule != nil && !IsSystemContext(ctx) {
@@ -134,3 +145,18 @@ func AuthReadAsyncReplyByUUID(ctx context.Context) error {
 	}
 	return nil
 }
+
+// AuthPendingTransactions authorize the query, cancel or replace of transactions waiting to be mined.
+// Only a verified token is required, if the security module does not implement this plugpoint
+func AuthPendingTransactions(ctx context.Context) error {
+	if securityModule != nil && !IsSystemContext(ctx) {
+		authCtx := GetAuthContext(ctx)
+		if authCtx == nil {
+			return errors.Errorf(errors.SecurityModuleNoAuthContext)
+		}
+		if sm, ok := securityModule.(plugins.PendingTransactionsSecurityModule); ok {
+			return sm.AuthPendingTransactions(authCtx)
+		}
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/kaleido-io/ethconnect/internal/auth/authtest"
+	"github.com/kaleido-io/ethconnect/pkg/plugins"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -147,3 +148,49 @@ func TestAuthReadAsyncReplyByUUID(t *testing.T) {
 	RegisterSecurityModule(nil)
 
 }
+
+func TestGetIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", GetIdentity(context.Background()))
+
+	RegisterSecurityModule(&authtest.TestSecurityModule{})
+
+	assert.Equal("", GetIdentity(context.Background()))
+	assert.Equal("", GetIdentity(NewSystemAuthContext()))
+
+	ctx, _ := WithAuthContext(context.Background(), "testat")
+	assert.Equal("verified", GetIdentity(ctx))
+
+	RegisterSecurityModule(struct{ plugins.SecurityModule }{&authtest.TestSecurityModule{}})
+
+	assert.Equal("", GetIdentity(ctx))
+
+	RegisterSecurityModule(nil)
+
+}
+
+func TestAuthPendingTransactions(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(AuthPendingTransactions(context.Background()))
+
+	RegisterSecurityModule(&authtest.TestSecurityModule{})
+
+	assert.EqualError(AuthPendingTransactions(context.Background()), "No auth context")
+
+	assert.NoError(AuthPendingTransactions(NewSystemAuthContext()))
+
+	ctx, _ := WithAuthContext(context.Background(), "testat")
+	assert.NoError(AuthPendingTransactions(ctx))
+	assert.EqualError(AuthPendingTransactions(context.WithValue(ctx, ContextKeyAuthContext, 12345)), "badness")
+
+	// Modules without the optional plugpoint only require an auth context
+	RegisterSecurityModule(struct{ plugins.SecurityModule }{&authtest.TestSecurityModule{}})
+
+	assert.EqualError(AuthPendingTransactions(context.Background()), "No auth context")
+	assert.NoError(AuthPendingTransactions(context.WithValue(ctx, ContextKeyAuthContext, 12345)))
+
+	RegisterSecurityModule(nil)
+
+}
@@ -77,3 +77,18 @@ func (sm *TestSecurityModule) AuthReadAsyncReplyByUUID(authCtx interface{}) erro
 	}
 	return fmt.Errorf("badness")
 }
+
+// Identity of TEST MODULE returns the auth context, if it is a string
+func (sm *TestSecurityModule) Identity(authCtx interface{}) string {
+	identity, _ := authCtx.(string)
+	return identity
+}
+
+// AuthPendingTransactions of TEST MODULE returns true if there is an auth context
+func (sm *TestSecurityModule) AuthPendingTransactions(authCtx interface{}) error {
+	switch authCtx.(type) {
+	case string:
+		return nil
+	}
+	return fmt.Errorf("badness")
+}
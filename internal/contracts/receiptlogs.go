@@ -0,0 +1,81 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contracts
+
+import (
+	"strings"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/events"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+// DecodeLogs decodes the logs in a transaction receipt that were emitted by contract
+// instances known to the gateway, matching the first topic against the events in the ABI.
+// Anonymous events, and logs from other contracts, are left with just the raw topics and data
+func (g *smartContractGW) DecodeLogs(msg *messages.TransactionReceipt) {
+	abiEvents := make(map[string][]*ethbinding.ABIEvent)
+	for _, txLog := range msg.Logs {
+		if txLog.Address == nil || len(txLog.Topics) == 0 || txLog.Topics[0] == nil {
+			continue
+		}
+		addrHexNo0x := strings.ToLower(txLog.Address.Hex()[2:])
+		instanceEvents, loaded := abiEvents[addrHexNo0x]
+		if !loaded {
+			instanceEvents = g.eventsForInstance(addrHexNo0x)
+			abiEvents[addrHexNo0x] = instanceEvents
+		}
+		for _, event := range instanceEvents {
+			if event.Anonymous || event.ID != *txLog.Topics[0] {
+				continue
+			}
+			logInfo := msg.Headers.ReqID + ":" + txLog.LogIndex
+			args, err := events.DecodeLogData(logInfo, event, txLog.Topics, txLog.Data)
+			if err != nil {
+				log.Warnf("Failed to decode log: %s", err)
+				break
+			}
+			txLog.Event = event.RawName
+			txLog.Signature = ethbind.API.ABIEventSignature(event)
+			txLog.Args = args
+			break
+		}
+	}
+}
+
+// eventsForInstance returns the events in the ABI of a contract instance, or nil if
+// the instance is not known to the gateway
+func (g *smartContractGW) eventsForInstance(addrHexNo0x string) []*ethbinding.ABIEvent {
+	deployMsg, _, err := g.loadDeployMsgForInstance(addrHexNo0x)
+	if err != nil {
+		log.Debugf("Logs from %s will not be decoded: %s", addrHexNo0x, err)
+		return nil
+	}
+	var abiEvents []*ethbinding.ABIEvent
+	for i := range deployMsg.ABI {
+		if deployMsg.ABI[i].Type != "event" {
+			continue
+		}
+		event, err := ethbind.API.ABIElementMarshalingToABIEvent(&deployMsg.ABI[i])
+		if err != nil {
+			log.Warnf("Invalid event '%s' in ABI for %s: %s", deployMsg.ABI[i].Name, addrHexNo0x, err)
+			continue
+		}
+		abiEvents = append(abiEvents, event)
+	}
+	return abiEvents
+}
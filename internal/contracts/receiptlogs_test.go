@@ -0,0 +1,149 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contracts
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/tx"
+	"github.com/stretchr/testify/assert"
+)
+
+const testLogContractAddr = "123456789abcdef0123456789abcdef012345678"
+
+func newTestLogGateway(dir string, abi ethbinding.ABIMarshaling) *smartContractGW {
+	s, _ := NewSmartContractGateway(
+		&SmartContractGatewayConf{
+			StoragePath: dir,
+		},
+		&tx.TxnProcessorConf{},
+		nil, nil, nil, nil,
+	)
+	scgw := s.(*smartContractGW)
+	scgw.contractIndex[testLogContractAddr] = &contractInfo{
+		ABI:     "abi1",
+		Address: testLogContractAddr,
+	}
+	deployMsg := &messages.DeployContract{ABI: abi}
+	deployBytes, _ := json.Marshal(deployMsg)
+	ioutil.WriteFile(path.Join(dir, "abi_abi1.deploy.json"), deployBytes, 0644)
+	scgw.abiIndex["abi1"] = &abiInfo{}
+	return scgw
+}
+
+var testTransferABI = ethbinding.ABIMarshaling{
+	{
+		Type: "event",
+		Name: "Transfer",
+		Inputs: []ethbinding.ABIArgumentMarshaling{
+			{Name: "from", Type: "address", Indexed: true},
+			{Name: "to", Type: "address", Indexed: true},
+			{Name: "value", Type: "uint256"},
+		},
+	},
+}
+
+func testTransferLog(addr string) *messages.TransactionLog {
+	contractAddr := ethbind.API.HexToAddress(addr)
+	topic0 := ethbind.API.HexToHash(hex.EncodeToString(ethbind.API.Keccak256([]byte("Transfer(address,address,uint256)"))))
+	topic1 := ethbind.API.HexToHash("0x000000000000000000000000aa983ad2a0e0ed8ac639277f37be42f2a5d2618c")
+	topic2 := ethbind.API.HexToHash("0x0000000000000000000000002b8c0ecc76d0759a8f50b2e14a6881367d805832")
+	return &messages.TransactionLog{
+		Address:  &contractAddr,
+		LogIndex: "0",
+		Topics:   []*ethbinding.Hash{&topic0, &topic1, &topic2},
+		Data:     fmt.Sprintf("0x%064x", 12345),
+	}
+}
+
+func TestDecodeLogsKnownContract(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	scgw := newTestLogGateway(dir, testTransferABI)
+	receipt := &messages.TransactionReceipt{
+		Logs: []*messages.TransactionLog{testTransferLog(testLogContractAddr)},
+	}
+	scgw.DecodeLogs(receipt)
+
+	decoded := receipt.Logs[0]
+	assert.Equal("Transfer", decoded.Event)
+	assert.Equal("Transfer(address,address,uint256)", decoded.Signature)
+	assert.Equal("12345", decoded.Args["value"])
+	assert.Equal("0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c", decoded.Args["from"].(ethbinding.Address).Hex())
+	assert.Equal("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832", decoded.Args["to"].(ethbinding.Address).Hex())
+}
+
+func TestDecodeLogsUnknownContract(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	scgw := newTestLogGateway(dir, testTransferABI)
+	rawLog := testTransferLog("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832")
+	receipt := &messages.TransactionReceipt{
+		Logs: []*messages.TransactionLog{rawLog, {LogIndex: "1"}},
+	}
+	scgw.DecodeLogs(receipt)
+
+	assert.Equal("", rawLog.Event)
+	assert.Nil(rawLog.Args)
+	assert.Len(rawLog.Topics, 3)
+	assert.Equal(fmt.Sprintf("0x%064x", 12345), rawLog.Data)
+}
+
+func TestDecodeLogsUnknownEvent(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	scgw := newTestLogGateway(dir, ethbinding.ABIMarshaling{
+		{Type: "function", Name: "transfer"},
+		{Type: "event", Name: "Approval"},
+		{Type: "event", Name: "Bad", Inputs: []ethbinding.ABIArgumentMarshaling{{Name: "x", Type: "badness"}}},
+	})
+	receipt := &messages.TransactionReceipt{
+		Logs: []*messages.TransactionLog{testTransferLog(testLogContractAddr)},
+	}
+	scgw.DecodeLogs(receipt)
+
+	assert.Equal("", receipt.Logs[0].Event)
+}
+
+func TestDecodeLogsInsufficientTopics(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	scgw := newTestLogGateway(dir, testTransferABI)
+	txLog := testTransferLog(testLogContractAddr)
+	txLog.Topics = txLog.Topics[0:2]
+	receipt := &messages.TransactionReceipt{
+		Logs: []*messages.TransactionLog{txLog},
+	}
+	scgw.DecodeLogs(receipt)
+
+	assert.Equal("", txLog.Event)
+	assert.Nil(txLog.Args)
+}
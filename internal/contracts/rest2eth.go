@@ -43,7 +43,15 @@ import (
 // REST2EthAsyncDispatcher is passed in to process messages over a streaming system with
 // a receipt store. Only used for POST methods, when fly-sync is not set to true
 type REST2EthAsyncDispatcher interface {
-	DispatchMsgAsync(ctx context.Context, msg map[string]interface{}, ack bool) (*messages.AsyncSentMsg, error)
+	DispatchMsgAsync(ctx context.Context, msg map[string]interface{}, ack bool) (*messages.AsyncSentMsg, int, error)
+}
+
+// REST2EthIdempotentDispatcher can optionally be implemented by the REST2EthAsyncDispatcher,
+// so a retry of a synchronous POST with the same idempotency key is not submitted a second time.
+// BeginIdempotent returns the reply to the earlier request if there was one. Otherwise, if the
+// request has a key, it is reserved until the returned function is called with the reply
+type REST2EthIdempotentDispatcher interface {
+	BeginIdempotent(ctx context.Context) (*messages.AsyncSentMsg, int, func(reply *messages.AsyncSentMsg), error)
 }
 
 // rest2EthSyncDispatcher abstracts the processing of the transactions and queries
@@ -60,6 +68,12 @@ type rest2EthReplyProcessor interface {
 	ReplyWithReceiptAndError(receipt messages.ReplyWithHeaders, err error)
 }
 
+// rest2EthSentTXRecorder can optionally be implemented by a rest2EthReplyProcessor, to be told the
+// hash of a transaction that was submitted before an error reply
+type rest2EthSentTXRecorder interface {
+	SentTX(txHash string)
+}
+
 // rest2eth provides the HTTP <-> messages translation and dispatches for processing
 type rest2eth struct {
 	gw              smartContractGatewayInt
@@ -72,7 +86,8 @@ type rest2eth struct {
 }
 
 type restErrMsg struct {
-	Message string `json:"error"`
+	Message string                `json:"error"`
+	Revert  *messages.RevertError `json:"revert,omitempty"`
 }
 
 type restAsyncMsg struct {
@@ -86,23 +101,50 @@ type restReceiptAndError struct {
 
 // rest2EthInflight is instantiated for each async reply in flight
 type rest2EthSyncResponder struct {
-	r      *rest2eth
-	res    http.ResponseWriter
-	req    *http.Request
-	done   bool
-	waiter *sync.Cond
+	r       *rest2eth
+	res     http.ResponseWriter
+	req     *http.Request
+	done    bool
+	waiter  *sync.Cond
+	receipt messages.ReplyWithHeaders
+	txHash  string
+}
+
+// SentTX records the hash of a transaction that was submitted, when the reply is an error
+func (i *rest2EthSyncResponder) SentTX(txHash string) {
+	i.txHash = txHash
+}
+
+// idempotentReply is the reply recorded against the idempotency key of the request, if a
+// transaction was submitted. It is nil if nothing was submitted, so the request can be retried
+func (i *rest2EthSyncResponder) idempotentReply(requestID string) *messages.AsyncSentMsg {
+	var receipt map[string]interface{}
+	if i.receipt != nil {
+		b, _ := json.Marshal(i.receipt)
+		json.Unmarshal(b, &receipt)
+	} else if i.txHash != "" {
+		receipt = map[string]interface{}{"transactionHash": i.txHash}
+	} else {
+		return nil
+	}
+	return &messages.AsyncSentMsg{
+		Sent:    true,
+		Request: requestID,
+		Receipt: receipt,
+	}
 }
 
 var addrCheck = regexp.MustCompile("^(0x)?[0-9a-z]{40}$")
 
 func (i *rest2EthSyncResponder) ReplyWithError(err error) {
-	i.r.restErrReply(i.res, i.req, err, 500)
+	i.r.restErrReply(i.res, i.req, err, dispatchErrStatus(err))
 	i.done = true
 	i.waiter.Broadcast()
 	return
 }
 
 func (i *rest2EthSyncResponder) ReplyWithReceiptAndError(receipt messages.ReplyWithHeaders, err error) {
+	i.receipt = receipt
 	status := 500
 	reply, _ := json.MarshalIndent(&restReceiptAndError{err.Error(), receipt}, "", "  ")
 	log.Infof("<-- %s %s [%d]", i.req.Method, i.req.URL, status)
@@ -116,6 +158,7 @@ func (i *rest2EthSyncResponder) ReplyWithReceiptAndError(receipt messages.ReplyW
 }
 
 func (i *rest2EthSyncResponder) ReplyWithReceipt(receipt messages.ReplyWithHeaders) {
+	i.receipt = receipt
 	txReceiptMsg := receipt.IsReceipt()
 	if txReceiptMsg != nil && txReceiptMsg.ContractAddress != nil {
 		if err := i.r.gw.PostDeploy(txReceiptMsg); err != nil {
@@ -124,8 +167,13 @@ func (i *rest2EthSyncResponder) ReplyWithReceipt(receipt messages.ReplyWithHeade
 			return
 		}
 	}
+	if txReceiptMsg != nil && len(txReceiptMsg.Logs) > 0 {
+		i.r.gw.DecodeLogs(txReceiptMsg)
+	}
+	// A simulation that reverted is still a successful simulation
 	status := 200
-	if receipt.ReplyHeaders().MsgType != messages.MsgTypeTransactionSuccess {
+	if msgType := receipt.ReplyHeaders().MsgType; msgType != messages.MsgTypeTransactionSuccess &&
+		msgType != messages.MsgTypeTransactionSimulation && msgType != messages.MsgTypeTransactionPrepared {
 		status = 500
 	}
 	reply, _ := json.MarshalIndent(receipt, "", "  ")
@@ -190,6 +238,7 @@ type restCmd struct {
 	abiMethodElem *ethbinding.ABIElementMarshaling
 	abiEvent      *ethbinding.ABIEvent
 	abiEventElem  *ethbinding.ABIElementMarshaling
+	abiErrors     []*ethbinding.ABIElementMarshaling
 	isDeploy      bool
 	deployMsg     *messages.DeployContract
 	body          map[string]interface{}
@@ -334,6 +383,7 @@ func (r *rest2eth) resolveParams(res http.ResponseWriter, req *http.Request, par
 	if err != nil {
 		return c, err
 	}
+	c.abiErrors = eth.ErrorsFromABI(a)
 
 	// See addRoutes for all the various routes we support under the factory/instance.
 	// We need to handle the special case of
@@ -461,10 +511,10 @@ func (r *rest2eth) restHandler(res http.ResponseWriter, req *http.Request, param
 		} else if c.isDeploy {
 			r.deployContract(res, req, c.from, c.value, c.abiMethodElem, c.deployMsg, c.msgParams)
 		} else {
-			r.sendTransaction(res, req, c.from, c.addr, c.value, c.abiMethodElem, c.msgParams)
+			r.sendTransaction(res, req, c.from, c.addr, c.value, c.abiMethodElem, c.abiErrors, c.deployMsg.Headers.ID, c.msgParams)
 		}
 	} else {
-		r.callContract(res, req, c.from, c.addr, c.value, c.abiMethod, c.msgParams, c.blocknumber)
+		r.callContract(res, req, c.from, c.addr, c.value, c.abiMethod, c.abiErrors, c.msgParams, c.blocknumber)
 	}
 }
 
@@ -543,35 +593,36 @@ func (r *rest2eth) addPrivateTx(msg *messages.TransactionCommon, req *http.Reque
 func (r *rest2eth) deployContract(res http.ResponseWriter, req *http.Request, from string, value json.Number, abiMethodElem *ethbinding.ABIElementMarshaling, deployMsg *messages.DeployContract, msgParams []interface{}) {
 
 	deployMsg.Headers.MsgType = messages.MsgTypeDeployContract
+	deployMsg.ABIID = deployMsg.Headers.ID // the ID the ABI was loaded under
 	deployMsg.From = from
 	deployMsg.Gas = json.Number(getFlyParam("gas", req, false))
 	deployMsg.GasPrice = json.Number(getFlyParam("gasprice", req, false))
+	deployMsg.TxType = json.Number(getFlyParam("txtype", req, false))
+	deployMsg.MaxFeePerGas = json.Number(getFlyParam("maxfeepergas", req, false))
+	deployMsg.MaxPriorityFeePerGas = json.Number(getFlyParam("maxpriorityfeepergas", req, false))
+	deployMsg.GasPriceStrategy = getFlyParam("gaspricestrategy", req, false)
+	deployMsg.Confirmations = json.Number(getFlyParam("confirmations", req, false))
 	deployMsg.Value = value
 	deployMsg.Parameters = msgParams
 	if err := r.addPrivateTx(&deployMsg.TransactionCommon, req, res); err != nil {
 		r.restErrReply(res, req, err, 400)
 		return
 	}
+	deployMsg.Simulate = strings.ToLower(getFlyParam("simulate", req, true)) == "true"
+	deployMsg.Prepare = strings.ToLower(getFlyParam("prepare", req, true)) == "true"
 	deployMsg.RegisterAs = getFlyParam("register", req, false)
-	if deployMsg.RegisterAs != "" {
+	if deployMsg.RegisterAs != "" && !deployMsg.Simulate && !deployMsg.Prepare {
 		if err := r.gw.checkNameAvailable(deployMsg.RegisterAs, isRemote(deployMsg.Headers.CommonHeaders)); err != nil {
 			r.restErrReply(res, req, err, 409)
 			return
 		}
 	}
-	if strings.ToLower(getFlyParam("sync", req, true)) == "true" {
-		responder := &rest2EthSyncResponder{
-			r:      r,
-			res:    res,
-			req:    req,
-			done:   false,
-			waiter: sync.NewCond(&sync.Mutex{}),
-		}
-		r.syncDispatcher.DispatchDeployContractSync(req.Context(), deployMsg, responder)
-		responder.waiter.L.Lock()
-		for !responder.done {
-			responder.waiter.Wait()
-		}
+	// Simulations and prepared transactions are always synchronous, as nothing is submitted
+	if deployMsg.Simulate || deployMsg.Prepare || strings.ToLower(getFlyParam("sync", req, true)) == "true" {
+		submits := !deployMsg.Simulate && !deployMsg.Prepare
+		r.dispatchSync(res, req, &deployMsg.Headers.CommonHeaders, submits, func(responder rest2EthReplyProcessor) {
+			r.syncDispatcher.DispatchDeployContractSync(req.Context(), deployMsg, responder)
+		})
 	} else {
 		ack := (getFlyParam("noack", req, true) != "true") // turn on ack's by default
 
@@ -580,8 +631,8 @@ func (r *rest2eth) deployContract(res http.ResponseWriter, req *http.Request, fr
 		msgBytes, _ := json.Marshal(deployMsg)
 		var mapMsg map[string]interface{}
 		json.Unmarshal(msgBytes, &mapMsg)
-		if asyncResponse, err := r.asyncDispatcher.DispatchMsgAsync(req.Context(), mapMsg, ack); err != nil {
-			r.restErrReply(res, req, err, 500)
+		if asyncResponse, status, err := r.asyncDispatcher.DispatchMsgAsync(req.Context(), mapMsg, ack); err != nil {
+			r.restErrReply(res, req, err, status)
 		} else {
 			r.restAsyncReply(res, req, asyncResponse)
 		}
@@ -589,35 +640,37 @@ func (r *rest2eth) deployContract(res http.ResponseWriter, req *http.Request, fr
 	return
 }
 
-func (r *rest2eth) sendTransaction(res http.ResponseWriter, req *http.Request, from, addr string, value json.Number, abiMethodElem *ethbinding.ABIElementMarshaling, msgParams []interface{}) {
+func (r *rest2eth) sendTransaction(res http.ResponseWriter, req *http.Request, from, addr string, value json.Number, abiMethodElem *ethbinding.ABIElementMarshaling, abiErrors []*ethbinding.ABIElementMarshaling, abiID string, msgParams []interface{}) {
 
 	msg := &messages.SendTransaction{}
 	msg.Headers.MsgType = messages.MsgTypeSendTransaction
 	msg.Method = abiMethodElem
+	msg.Errors = abiErrors
 	msg.To = addr
 	msg.From = from
+	msg.ABIID = abiID
 	msg.Gas = json.Number(getFlyParam("gas", req, false))
 	msg.GasPrice = json.Number(getFlyParam("gasprice", req, false))
+	msg.TxType = json.Number(getFlyParam("txtype", req, false))
+	msg.MaxFeePerGas = json.Number(getFlyParam("maxfeepergas", req, false))
+	msg.MaxPriorityFeePerGas = json.Number(getFlyParam("maxpriorityfeepergas", req, false))
+	msg.GasPriceStrategy = getFlyParam("gaspricestrategy", req, false)
+	msg.Confirmations = json.Number(getFlyParam("confirmations", req, false))
 	msg.Value = value
 	msg.Parameters = msgParams
+	msg.Simulate = strings.ToLower(getFlyParam("simulate", req, true)) == "true"
+	msg.Prepare = strings.ToLower(getFlyParam("prepare", req, true)) == "true"
 	if err := r.addPrivateTx(&msg.TransactionCommon, req, res); err != nil {
 		r.restErrReply(res, req, err, 400)
 		return
 	}
 
-	if strings.ToLower(getFlyParam("sync", req, true)) == "true" {
-		responder := &rest2EthSyncResponder{
-			r:      r,
-			res:    res,
-			req:    req,
-			done:   false,
-			waiter: sync.NewCond(&sync.Mutex{}),
-		}
-		r.syncDispatcher.DispatchSendTransactionSync(req.Context(), msg, responder)
-		responder.waiter.L.Lock()
-		for !responder.done {
-			responder.waiter.Wait()
-		}
+	// Simulations and prepared transactions are always synchronous, as nothing is submitted
+	if msg.Simulate || msg.Prepare || strings.ToLower(getFlyParam("sync", req, true)) == "true" {
+		submits := !msg.Simulate && !msg.Prepare
+		r.dispatchSync(res, req, &msg.Headers.CommonHeaders, submits, func(responder rest2EthReplyProcessor) {
+			r.syncDispatcher.DispatchSendTransactionSync(req.Context(), msg, responder)
+		})
 	} else {
 		ack := (getFlyParam("noack", req, true) != "true") // turn on ack's by default
 
@@ -626,8 +679,8 @@ func (r *rest2eth) sendTransaction(res http.ResponseWriter, req *http.Request, f
 		msgBytes, _ := json.Marshal(msg)
 		var mapMsg map[string]interface{}
 		json.Unmarshal(msgBytes, &mapMsg)
-		if asyncResponse, err := r.asyncDispatcher.DispatchMsgAsync(req.Context(), mapMsg, ack); err != nil {
-			r.restErrReply(res, req, err, 500)
+		if asyncResponse, status, err := r.asyncDispatcher.DispatchMsgAsync(req.Context(), mapMsg, ack); err != nil {
+			r.restErrReply(res, req, err, status)
 		} else {
 			r.restAsyncReply(res, req, asyncResponse)
 		}
@@ -635,14 +688,14 @@ func (r *rest2eth) sendTransaction(res http.ResponseWriter, req *http.Request, f
 	return
 }
 
-func (r *rest2eth) callContract(res http.ResponseWriter, req *http.Request, from, addr string, value json.Number, abiMethod *ethbinding.ABIMethod, msgParams []interface{}, blocknumber string) {
+func (r *rest2eth) callContract(res http.ResponseWriter, req *http.Request, from, addr string, value json.Number, abiMethod *ethbinding.ABIMethod, abiErrors []*ethbinding.ABIElementMarshaling, msgParams []interface{}, blocknumber string) {
 	var err error
 	if from, err = r.processor.ResolveAddress(from); err != nil {
 		r.restErrReply(res, req, err, 500)
 		return
 	}
 
-	resBody, err := eth.CallMethod(req.Context(), r.rpc, nil, from, addr, value, abiMethod, msgParams, blocknumber)
+	resBody, err := eth.CallMethod(req.Context(), r.rpc, nil, from, addr, value, abiMethod, msgParams, blocknumber, abiErrors)
 	if err != nil {
 		r.restErrReply(res, req, err, 500)
 		return
@@ -657,9 +710,49 @@ func (r *rest2eth) callContract(res http.ResponseWriter, req *http.Request, from
 	return
 }
 
+// dispatchSync dispatches a request synchronously, and waits for the reply. A request that submits a
+// transaction is not dispatched if it repeats the idempotency key of an earlier request, and the
+// reply to the earlier request is returned instead
+func (r *rest2eth) dispatchSync(res http.ResponseWriter, req *http.Request, headers *messages.CommonHeaders, submits bool, dispatch func(responder rest2EthReplyProcessor)) {
+	var complete func(reply *messages.AsyncSentMsg)
+	if idempotent, ok := r.asyncDispatcher.(REST2EthIdempotentDispatcher); ok && submits {
+		existing, status, completeFn, err := idempotent.BeginIdempotent(req.Context())
+		if err != nil {
+			r.restErrReply(res, req, err, status)
+			return
+		}
+		if existing != nil {
+			r.restSentReply(res, req, existing, 200)
+			return
+		}
+		if complete = completeFn; complete != nil {
+			// The request needs an ID to record against the key, as an asynchronous request has
+			headers.ID = utils.UUIDv4()
+		}
+	}
+	responder := &rest2EthSyncResponder{
+		r:      r,
+		res:    res,
+		req:    req,
+		done:   false,
+		waiter: sync.NewCond(&sync.Mutex{}),
+	}
+	dispatch(responder)
+	responder.waiter.L.Lock()
+	for !responder.done {
+		responder.waiter.Wait()
+	}
+	if complete != nil {
+		complete(responder.idempotentReply(headers.ID))
+	}
+}
+
 func (r *rest2eth) restAsyncReply(res http.ResponseWriter, req *http.Request, asyncResponse *messages.AsyncSentMsg) {
+	r.restSentReply(res, req, asyncResponse, 202) // accepted
+}
+
+func (r *rest2eth) restSentReply(res http.ResponseWriter, req *http.Request, asyncResponse *messages.AsyncSentMsg, status int) {
 	resBytes, _ := json.Marshal(asyncResponse)
-	status := 202 // accepted
 	log.Infof("<-- %s %s [%d]:\n%s", req.Method, req.URL, status, string(resBytes))
 	log.Debugf("<-- %s", resBytes)
 	res.Header().Set("Content-Type", "application/json")
@@ -667,9 +760,17 @@ func (r *rest2eth) restAsyncReply(res http.ResponseWriter, req *http.Request, as
 	res.Write(resBytes)
 }
 
+// dispatchErrStatus returns 429 for transactions rejected by the submission rate limits
+func dispatchErrStatus(err error) int {
+	if tx.IsRateLimited(err) {
+		return 429
+	}
+	return 500
+}
+
 func (r *rest2eth) restErrReply(res http.ResponseWriter, req *http.Request, err error, status int) {
 	log.Errorf("<-- %s %s [%d]: %s", req.Method, req.URL, status, err)
-	reply, _ := json.Marshal(&restErrMsg{Message: err.Error()})
+	reply, _ := json.Marshal(&restErrMsg{Message: err.Error(), Revert: messages.RevertFromError(err)})
 	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(status)
 	res.Write(reply)
@@ -33,6 +33,7 @@ import (
 	"github.com/kaleido-io/ethconnect/internal/ethbind"
 	"github.com/kaleido-io/ethconnect/internal/events"
 	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/tx"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -41,25 +42,32 @@ type mockREST2EthDispatcher struct {
 	asyncDispatchMsg           map[string]interface{}
 	asyncDispatchAck           bool
 	asyncDispatchReply         *messages.AsyncSentMsg
+	asyncDispatchStatus        int
 	asyncDispatchError         error
 	sendTransactionMsg         *messages.SendTransaction
 	sendTransactionSyncReceipt *messages.TransactionReceipt
 	sendTransactionSyncError   error
+	sendTransactionSimulation  *messages.TransactionSimulation
+	sendTransactionPrepared    *messages.TransactionPrepared
 	deployContractMsg          *messages.DeployContract
 	deployContractSyncReceipt  *messages.TransactionReceipt
 	deployContractSyncError    error
 }
 
-func (m *mockREST2EthDispatcher) DispatchMsgAsync(ctx context.Context, msg map[string]interface{}, ack bool) (*messages.AsyncSentMsg, error) {
+func (m *mockREST2EthDispatcher) DispatchMsgAsync(ctx context.Context, msg map[string]interface{}, ack bool) (*messages.AsyncSentMsg, int, error) {
 	m.asyncDispatchMsg = msg
 	m.asyncDispatchAck = ack
-	return m.asyncDispatchReply, m.asyncDispatchError
+	return m.asyncDispatchReply, m.asyncDispatchStatus, m.asyncDispatchError
 }
 
 func (m *mockREST2EthDispatcher) DispatchSendTransactionSync(ctx context.Context, msg *messages.SendTransaction, replyProcessor rest2EthReplyProcessor) {
 	m.sendTransactionMsg = msg
 	if m.sendTransactionSyncError != nil {
 		replyProcessor.ReplyWithError(m.sendTransactionSyncError)
+	} else if m.sendTransactionSimulation != nil {
+		replyProcessor.ReplyWithReceipt(m.sendTransactionSimulation)
+	} else if m.sendTransactionPrepared != nil {
+		replyProcessor.ReplyWithReceipt(m.sendTransactionPrepared)
 	} else {
 		replyProcessor.ReplyWithReceipt(m.sendTransactionSyncReceipt)
 	}
@@ -74,6 +82,27 @@ func (m *mockREST2EthDispatcher) DispatchDeployContractSync(ctx context.Context,
 	}
 }
 
+type mockIdempotentDispatcher struct {
+	mockREST2EthDispatcher
+	begun    bool
+	existing *messages.AsyncSentMsg
+	status   int
+	err      error
+	reply    *messages.AsyncSentMsg
+	complete bool
+}
+
+func (m *mockIdempotentDispatcher) BeginIdempotent(ctx context.Context) (*messages.AsyncSentMsg, int, func(reply *messages.AsyncSentMsg), error) {
+	m.begun = true
+	if m.err != nil || m.existing != nil {
+		return m.existing, m.status, nil, m.err
+	}
+	return nil, 200, func(reply *messages.AsyncSentMsg) {
+		m.complete = true
+		m.reply = reply
+	}, nil
+}
+
 type mockABILoader struct {
 	loadABIError           error
 	deployMsg              *messages.DeployContract
@@ -84,6 +113,7 @@ type mockABILoader struct {
 	nameAvailableError     error
 	capturedAddr           string
 	postDeployError        error
+	decodedLogs            []*messages.TransactionLog
 }
 
 func (m *mockABILoader) SendReply(message interface{}) {
@@ -111,6 +141,9 @@ func (m *mockABILoader) PreDeploy(msg *messages.DeployContract) error { return n
 func (m *mockABILoader) PostDeploy(msg *messages.TransactionReceipt) error {
 	return m.postDeployError
 }
+func (m *mockABILoader) DecodeLogs(msg *messages.TransactionReceipt) {
+	m.decodedLogs = msg.Logs
+}
 func (m *mockABILoader) AddRoutes(router *httprouter.Router) { return }
 func (m *mockABILoader) Shutdown()                           { return }
 
@@ -271,6 +304,82 @@ func TestSendTransactionAsyncSuccess(t *testing.T) {
 	assert.Equal("0xB92F8CebA52fFb5F08f870bd355B1d32f0fd9f7C", dispatcher.asyncDispatchMsg["privateFor"].([]interface{})[1])
 }
 
+func TestSendTransactionAsyncDynamicFees(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	dispatcher := &mockREST2EthDispatcher{
+		asyncDispatchReply: &messages.AsyncSentMsg{
+			Sent:    true,
+			Request: "request1",
+		},
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	req.Header.Set("X-Firefly-TxType", "2")
+	req.Header.Set("X-Firefly-MaxFeePerGas", "1000")
+	req.Header.Set("X-Firefly-MaxPriorityFeePerGas", "10")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(202, res.Result().StatusCode)
+	assert.Equal(float64(2), dispatcher.asyncDispatchMsg["txType"])
+	assert.Equal(float64(1000), dispatcher.asyncDispatchMsg["maxFeePerGas"])
+	assert.Equal(float64(10), dispatcher.asyncDispatchMsg["maxPriorityFeePerGas"])
+}
+
+func TestSendTransactionAsyncGasPriceStrategy(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	dispatcher := &mockREST2EthDispatcher{
+		asyncDispatchReply: &messages.AsyncSentMsg{
+			Sent:    true,
+			Request: "request1",
+		},
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	req.Header.Set("X-Firefly-GasPriceStrategy", "feeHistory")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(202, res.Result().StatusCode)
+	assert.Equal("feeHistory", dispatcher.asyncDispatchMsg["gasPriceStrategy"])
+}
+
+func TestSendTransactionAsyncConfirmations(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	dispatcher := &mockREST2EthDispatcher{
+		asyncDispatchReply: &messages.AsyncSentMsg{
+			Sent:    true,
+			Request: "request1",
+		},
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	req.Header.Set("X-Firefly-Confirmations", "12")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(202, res.Result().StatusCode)
+	assert.Equal("12", dispatcher.asyncDispatchMsg["confirmations"])
+}
+
 func TestDeployContractAsyncSuccess(t *testing.T) {
 	assert := assert.New(t)
 	dir := tempdir()
@@ -403,6 +512,39 @@ func TestSendTransactionSyncSuccess(t *testing.T) {
 	assert.Equal(to, dispatcher.sendTransactionMsg.To)
 }
 
+func TestSendTransactionSyncDecodeLogs(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	receipt := &messages.TransactionReceipt{
+		ReplyCommon: messages.ReplyCommon{
+			Headers: messages.ReplyHeaders{
+				CommonHeaders: messages.CommonHeaders{
+					MsgType: messages.MsgTypeTransactionSuccess,
+				},
+			},
+		},
+		Logs: []*messages.TransactionLog{
+			{LogIndex: "0", Data: "0x"},
+		},
+	}
+	dispatcher := &mockREST2EthDispatcher{
+		sendTransactionSyncReceipt: receipt,
+	}
+	r, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	req.Header.Set("x-firefly-sync", "true")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+	assert.Equal(receipt.Logs, r.gw.(*mockABILoader).decodedLogs)
+}
+
 func TestSendTransactionSyncFailure(t *testing.T) {
 	assert := assert.New(t)
 	dir := tempdir()
@@ -706,6 +848,158 @@ func TestSendTransactionSyncFail(t *testing.T) {
 	assert.Equal("pop", reply.Message)
 }
 
+func TestSendTransactionSyncRateLimited(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	dispatcher := &mockREST2EthDispatcher{
+		sendTransactionSyncError: &tx.RateLimitError{Err: fmt.Errorf("pop")},
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	req.Header.Set("x-firefly-sync", "true")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(429, res.Result().StatusCode)
+	reply := restErrMsg{}
+	err := json.NewDecoder(res.Result().Body).Decode(&reply)
+	assert.NoError(err)
+	assert.Equal("pop", reply.Message)
+}
+
+func TestSendTransactionSimulate(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	simulation := &messages.TransactionSimulation{
+		NonceStr:     "12",
+		Reverted:     true,
+		RevertReason: "Muppetry detected",
+	}
+	simulation.Headers.MsgType = messages.MsgTypeTransactionSimulation
+	dispatcher := &mockREST2EthDispatcher{
+		sendTransactionSimulation: simulation,
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	q := req.URL.Query()
+	q.Set("fly-simulate", "")
+	req.URL.RawQuery = q.Encode()
+	router.ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+	assert.True(dispatcher.sendTransactionMsg.Simulate)
+	assert.Nil(dispatcher.asyncDispatchMsg)
+	var reply messages.TransactionSimulation
+	err := json.NewDecoder(res.Result().Body).Decode(&reply)
+	assert.NoError(err)
+	assert.True(reply.Reverted)
+	assert.Equal("Muppetry detected", reply.RevertReason)
+	assert.Equal("12", reply.NonceStr)
+}
+
+func TestSendTransactionPrepare(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	prepared := &messages.TransactionPrepared{
+		NonceStr:    "12",
+		SigningHash: "0xae56bc1e8d5f4a1c3b0b7e3b9c4fb5e8b0e4b2d1e6d8c5f0a3b1c2d3e4f5a6b7",
+	}
+	prepared.Headers.MsgType = messages.MsgTypeTransactionPrepared
+	dispatcher := &mockREST2EthDispatcher{
+		sendTransactionPrepared: prepared,
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	q := req.URL.Query()
+	q.Set("fly-prepare", "")
+	req.URL.RawQuery = q.Encode()
+	router.ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+	assert.True(dispatcher.sendTransactionMsg.Prepare)
+	assert.False(dispatcher.sendTransactionMsg.Simulate)
+	assert.Nil(dispatcher.asyncDispatchMsg)
+	var reply messages.TransactionPrepared
+	err := json.NewDecoder(res.Result().Body).Decode(&reply)
+	assert.NoError(err)
+	assert.Equal("12", reply.NonceStr)
+	assert.Equal(prepared.SigningHash, reply.SigningHash)
+}
+
+func TestSendTransactionSyncReverted(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	dispatcher := &mockREST2EthDispatcher{
+		sendTransactionSyncError: &eth.RevertError{
+			Err: fmt.Errorf("EVM reverted with panic code 0x1: Assertion failed"),
+			Details: &messages.RevertError{
+				Name:      "Panic",
+				Signature: "Panic(uint256)",
+				Args:      map[string]interface{}{"code": "0x1"},
+			},
+		},
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	req.Header.Set("x-firefly-sync", "true")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(500, res.Result().StatusCode)
+	reply := restErrMsg{}
+	err := json.NewDecoder(res.Result().Body).Decode(&reply)
+	assert.NoError(err)
+	assert.Equal("EVM reverted with panic code 0x1: Assertion failed", reply.Message)
+	assert.Equal("Panic", reply.Revert.Name)
+	assert.Equal("0x1", reply.Revert.Args["code"])
+}
+
+func TestSendTransactionAsyncRateLimited(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+
+	bodyMap := make(map[string]interface{})
+	bodyMap["i"] = 12345
+	bodyMap["s"] = "testing"
+	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
+	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
+	dispatcher := &mockREST2EthDispatcher{
+		asyncDispatchStatus: 429,
+		asyncDispatchError:  &tx.RateLimitError{Err: fmt.Errorf("pop")},
+	}
+	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
+	router.ServeHTTP(res, req)
+
+	assert.Equal(429, res.Result().StatusCode)
+	reply := restErrMsg{}
+	err := json.NewDecoder(res.Result().Body).Decode(&reply)
+	assert.NoError(err)
+	assert.Equal("pop", reply.Message)
+}
+
 func TestSendTransactionAsyncFail(t *testing.T) {
 	assert := assert.New(t)
 	dir := tempdir()
@@ -717,7 +1011,8 @@ func TestSendTransactionAsyncFail(t *testing.T) {
 	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
 	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
 	dispatcher := &mockREST2EthDispatcher{
-		asyncDispatchError: fmt.Errorf("pop"),
+		asyncDispatchStatus: 500,
+		asyncDispatchError:  fmt.Errorf("pop"),
 	}
 	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
 	router.ServeHTTP(res, req)
@@ -739,7 +1034,8 @@ func TestDeployContractAsyncFail(t *testing.T) {
 	bodyMap["s"] = "testing"
 	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
 	dispatcher := &mockREST2EthDispatcher{
-		asyncDispatchError: fmt.Errorf("pop"),
+		asyncDispatchStatus: 500,
+		asyncDispatchError:  fmt.Errorf("pop"),
 	}
 	_, _, router, res, _ := newTestREST2EthAndMsg(t, dispatcher, from, "", bodyMap)
 	body, _ := json.Marshal(&bodyMap)
@@ -765,7 +1061,8 @@ func TestSendTransactionAsyncBadMethod(t *testing.T) {
 	to := "0x567a417717cb6c59ddc1035705f02c0fd1ab1872"
 	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
 	dispatcher := &mockREST2EthDispatcher{
-		asyncDispatchError: fmt.Errorf("pop"),
+		asyncDispatchStatus: 500,
+		asyncDispatchError:  fmt.Errorf("pop"),
 	}
 	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
 	router.ServeHTTP(res, req)
@@ -787,7 +1084,8 @@ func TestSendTransactionBadContract(t *testing.T) {
 	to := "badness"
 	from := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
 	dispatcher := &mockREST2EthDispatcher{
-		asyncDispatchError: fmt.Errorf("pop"),
+		asyncDispatchStatus: 500,
+		asyncDispatchError:  fmt.Errorf("pop"),
 	}
 	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
 	router.ServeHTTP(res, req)
@@ -833,7 +1131,8 @@ func TestSendTransactionMissingContract(t *testing.T) {
 	to := "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8"
 	from := ""
 	dispatcher := &mockREST2EthDispatcher{
-		asyncDispatchError: fmt.Errorf("pop"),
+		asyncDispatchStatus: 500,
+		asyncDispatchError:  fmt.Errorf("pop"),
 	}
 	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
 	router.ServeHTTP(res, req)
@@ -878,6 +1177,37 @@ func TestSendTransactionBadMethodABI(t *testing.T) {
 	assert.Equal("Invalid method 'badmethod' in ABI: unsupported arg type: badness", reply.Message)
 }
 
+func TestSendTransactionABIID(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir()
+	defer cleanup(dir)
+	dispatcher := &mockREST2EthDispatcher{
+		asyncDispatchReply: &messages.AsyncSentMsg{
+			Sent:    true,
+			Request: "request1",
+		},
+	}
+	abiLoader := &mockABILoader{
+		deployMsg: &messages.DeployContract{
+			ABI: ethbinding.ABIMarshaling{
+				{
+					Name: "set", Type: "function", Inputs: []ethbinding.ABIArgumentMarshaling{
+						{Name: "i", Type: "uint256"},
+					},
+				},
+			},
+		},
+	}
+	abiLoader.deployMsg.Headers.ID = "abi1"
+	_, _, router := newTestREST2EthCustomAbiLoader(dispatcher, abiLoader)
+	req := httptest.NewRequest("POST", "/contracts/0x567a417717cb6c59ddc1035705f02c0fd1ab1872/set", bytes.NewReader([]byte(`{"i":12345}`)))
+	req.Header.Add("x-firefly-from", "0x66c5fe653e7a9ebb628a6d40f0452d1e358baee8")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(202, res.Result().StatusCode)
+	assert.Equal("abi1", dispatcher.asyncDispatchMsg["abiId"])
+}
+
 func TestSendTransactionBadEventABI(t *testing.T) {
 	assert := assert.New(t)
 	dir := tempdir()
@@ -1012,7 +1342,8 @@ func TestSendTransactionBadFrom(t *testing.T) {
 	to := "66C5FE653E7A9EBB628A6D40F0452D1E358BAEE8"
 	from := "badness"
 	dispatcher := &mockREST2EthDispatcher{
-		asyncDispatchError: fmt.Errorf("pop"),
+		asyncDispatchStatus: 500,
+		asyncDispatchError:  fmt.Errorf("pop"),
 	}
 	_, _, router, res, req := newTestREST2EthAndMsg(t, dispatcher, from, to, bodyMap)
 	router.ServeHTTP(res, req)
@@ -1558,3 +1889,145 @@ func TestSubscribeWithAddressSubmgrFailure(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal("pop", reply.Message)
 }
+
+func newTestIdempotentREST2Eth(dispatcher *mockIdempotentDispatcher) *rest2eth {
+	return newREST2eth(&mockABILoader{}, &mockRPC{}, nil, nil, &mockProcessor{}, dispatcher, dispatcher)
+}
+
+func TestDispatchSyncIdempotentRecordsReceipt(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatcher := &mockIdempotentDispatcher{}
+	dispatcher.sendTransactionSyncReceipt = &messages.TransactionReceipt{
+		ReplyCommon: messages.ReplyCommon{
+			Headers: messages.ReplyHeaders{
+				CommonHeaders: messages.CommonHeaders{
+					MsgType: messages.MsgTypeTransactionSuccess,
+				},
+			},
+		},
+	}
+	r := newTestIdempotentREST2Eth(dispatcher)
+	msg := &messages.SendTransaction{}
+	req := httptest.NewRequest("POST", "/contracts/0x567a417717cb6c59ddc1035705f02c0fd1ab1872/set?fly-sync", nil)
+	res := httptest.NewRecorder()
+	r.dispatchSync(res, req, &msg.Headers.CommonHeaders, true, func(responder rest2EthReplyProcessor) {
+		dispatcher.DispatchSendTransactionSync(req.Context(), msg, responder)
+	})
+
+	assert.Equal(200, res.Result().StatusCode)
+	assert.True(dispatcher.begun)
+	assert.True(dispatcher.complete)
+	assert.NotEmpty(msg.Headers.ID)
+	assert.Equal(msg.Headers.ID, dispatcher.reply.Request)
+	assert.Equal(messages.MsgTypeTransactionSuccess, dispatcher.reply.Receipt["headers"].(map[string]interface{})["type"])
+}
+
+func TestDispatchSyncIdempotentRepeat(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatcher := &mockIdempotentDispatcher{
+		existing: &messages.AsyncSentMsg{
+			Sent:    true,
+			Request: "request1",
+			Receipt: map[string]interface{}{"transactionHash": "0x12345"},
+		},
+		status: 200,
+	}
+	r := newTestIdempotentREST2Eth(dispatcher)
+	msg := &messages.SendTransaction{}
+	req := httptest.NewRequest("POST", "/contracts/0x567a417717cb6c59ddc1035705f02c0fd1ab1872/set?fly-sync", nil)
+	res := httptest.NewRecorder()
+	r.dispatchSync(res, req, &msg.Headers.CommonHeaders, true, func(responder rest2EthReplyProcessor) {
+		dispatcher.DispatchSendTransactionSync(req.Context(), msg, responder)
+	})
+
+	assert.Equal(200, res.Result().StatusCode)
+	assert.Nil(dispatcher.sendTransactionMsg)
+	var reply messages.AsyncSentMsg
+	err := json.NewDecoder(res.Result().Body).Decode(&reply)
+	assert.NoError(err)
+	assert.Equal("request1", reply.Request)
+	assert.Equal("0x12345", reply.Receipt["transactionHash"])
+}
+
+func TestDispatchSyncIdempotentInProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatcher := &mockIdempotentDispatcher{
+		status: 409,
+		err:    fmt.Errorf("in progress"),
+	}
+	r := newTestIdempotentREST2Eth(dispatcher)
+	msg := &messages.SendTransaction{}
+	req := httptest.NewRequest("POST", "/contracts/0x567a417717cb6c59ddc1035705f02c0fd1ab1872/set?fly-sync", nil)
+	res := httptest.NewRecorder()
+	r.dispatchSync(res, req, &msg.Headers.CommonHeaders, true, func(responder rest2EthReplyProcessor) {
+		dispatcher.DispatchSendTransactionSync(req.Context(), msg, responder)
+	})
+
+	assert.Equal(409, res.Result().StatusCode)
+	assert.Nil(dispatcher.sendTransactionMsg)
+}
+
+func TestDispatchSyncIdempotentErrorAfterSend(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatcher := &mockIdempotentDispatcher{}
+	r := newTestIdempotentREST2Eth(dispatcher)
+	msg := &messages.SendTransaction{}
+	req := httptest.NewRequest("POST", "/contracts/0x567a417717cb6c59ddc1035705f02c0fd1ab1872/set?fly-sync", nil)
+	res := httptest.NewRecorder()
+	r.dispatchSync(res, req, &msg.Headers.CommonHeaders, true, func(responder rest2EthReplyProcessor) {
+		syncCtx := &syncTxInflight{replyProcessor: responder, sendMsg: msg}
+		syncCtx.SendErrorReplyWithTX(408, fmt.Errorf("timed out"), "0x12345")
+	})
+
+	assert.Equal(500, res.Result().StatusCode)
+	assert.True(dispatcher.complete)
+	assert.Equal("0x12345", dispatcher.reply.Receipt["transactionHash"])
+}
+
+func TestDispatchSyncIdempotentErrorNotRecorded(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatcher := &mockIdempotentDispatcher{}
+	dispatcher.sendTransactionSyncError = fmt.Errorf("pop")
+	r := newTestIdempotentREST2Eth(dispatcher)
+	msg := &messages.SendTransaction{}
+	req := httptest.NewRequest("POST", "/contracts/0x567a417717cb6c59ddc1035705f02c0fd1ab1872/set?fly-sync", nil)
+	res := httptest.NewRecorder()
+	r.dispatchSync(res, req, &msg.Headers.CommonHeaders, true, func(responder rest2EthReplyProcessor) {
+		dispatcher.DispatchSendTransactionSync(req.Context(), msg, responder)
+	})
+
+	assert.Equal(500, res.Result().StatusCode)
+	assert.True(dispatcher.complete)
+	assert.Nil(dispatcher.reply)
+}
+
+func TestDispatchSyncSimulationNotIdempotent(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatcher := &mockIdempotentDispatcher{}
+	dispatcher.sendTransactionSimulation = &messages.TransactionSimulation{
+		ReplyCommon: messages.ReplyCommon{
+			Headers: messages.ReplyHeaders{
+				CommonHeaders: messages.CommonHeaders{
+					MsgType: messages.MsgTypeTransactionSimulation,
+				},
+			},
+		},
+	}
+	r := newTestIdempotentREST2Eth(dispatcher)
+	msg := &messages.SendTransaction{}
+	req := httptest.NewRequest("POST", "/contracts/0x567a417717cb6c59ddc1035705f02c0fd1ab1872/set?fly-simulate", nil)
+	res := httptest.NewRecorder()
+	r.dispatchSync(res, req, &msg.Headers.CommonHeaders, false, func(responder rest2EthReplyProcessor) {
+		dispatcher.DispatchSendTransactionSync(req.Context(), msg, responder)
+	})
+
+	assert.Equal(200, res.Result().StatusCode)
+	assert.False(dispatcher.begun)
+	assert.Empty(msg.Headers.ID)
+}
@@ -64,6 +64,7 @@ const (
 type SmartContractGateway interface {
 	PreDeploy(msg *messages.DeployContract) error
 	PostDeploy(msg *messages.TransactionReceipt) error
+	DecodeLogs(msg *messages.TransactionReceipt)
 	AddRoutes(router *httprouter.Router)
 	SendReply(message interface{})
 	Shutdown()
@@ -424,6 +425,7 @@ func (g *smartContractGW) storeDeployableABI(msg *messages.DeployContract, compi
 	}
 
 	requestID := msg.Headers.ID
+	msg.ABIID = requestID
 	// We store the swagger in a generic format that can be used to deploy
 	// additional instances, or generically call other instances
 	// Generate and store the swagger
@@ -169,6 +169,7 @@ func TestPreDeployCompileAndPostDeploy(t *testing.T) {
 	err = json.Unmarshal(deployStashBytes, &deployStash)
 	assert.NoError(err)
 	assert.NotEmpty(deployStash.CompilerVersion)
+	assert.Equal("message1", deployStash.ABIID)
 
 	contractAddr := ethbind.API.HexToAddress("0x0123456789AbcdeF0123456789abCdef01234567")
 	receipt := messages.TransactionReceipt{
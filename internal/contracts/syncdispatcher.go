@@ -82,6 +82,9 @@ func (t *syncTxInflight) SendErrorReplyWithGapFill(status int, err error, gapFil
 }
 
 func (t *syncTxInflight) SendErrorReplyWithTX(status int, err error, txHash string) {
+	if sent, ok := t.replyProcessor.(rest2EthSentTXRecorder); ok && txHash != "" {
+		sent.SentTX(txHash)
+	}
 	t.SendErrorReply(status, errors.Errorf(errors.RESTGatewaySyncWrapErrorWithTXDetail, txHash, err))
 }
 
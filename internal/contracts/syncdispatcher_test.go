@@ -57,7 +57,37 @@ func (p *mockProcessor) OnMessage(c tx.TxnContext) {
 		c.Reply(p.reply)
 	}
 }
-func (p *mockProcessor) Init(eth.RPCClient) {}
+func (p *mockProcessor) Init(eth.RPCClient) error                  { return nil }
+func (p *mockProcessor) Resume(tx.RecoveredTxnContextFactory)      {}
+func (p *mockProcessor) WatchReorgs(tx.RecoveredTxnContextFactory) {}
+func (p *mockProcessor) PendingTransaction(id string) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+func (p *mockProcessor) CancelTransaction(ctx context.Context, id string) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+func (p *mockProcessor) ReplaceTransaction(ctx context.Context, id string, fees *messages.TransactionFees) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+func (p *mockProcessor) NonceStates() []*messages.NonceState { return nil }
+func (p *mockProcessor) NonceState(address string) (*messages.NonceState, error) {
+	return nil, nil
+}
+func (p *mockProcessor) ResyncNonce(ctx context.Context, address string) (*messages.NonceState, error) {
+	return nil, nil
+}
+func (p *mockProcessor) FillNonceGap(ctx context.Context, address string, nonce int64) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+func (p *mockProcessor) ReleaseScheduled(tx.RecoveredTxnContextFactory)          {}
+func (p *mockProcessor) ScheduledTransactions() []*messages.ScheduledTransaction { return nil }
+func (p *mockProcessor) ScheduledTransaction(id string) (*messages.ScheduledTransaction, error) {
+	return nil, nil
+}
+func (p *mockProcessor) CancelScheduled(id string) (*messages.ScheduledTransaction, error) {
+	return nil, nil
+}
+func (p *mockProcessor) Close() {}
 
 type mockReplyProcessor struct {
 	err     error
@@ -142,12 +142,58 @@ const (
 	// KVStoreMemFilteringUnsupported memory db is really just for testing. No filtering support
 	KVStoreMemFilteringUnsupported = "Memory receipts do not support filtering"
 
+	// KeystoreReadDir the configured keystore directory could not be listed
+	KeystoreReadDir = "Failed to read keystore directory %s: %s"
+	// KeystoreReadFile a file in the keystore directory, or a password file, could not be read
+	KeystoreReadFile = "Failed to read %s: %s"
+	// KeystoreNoPassword no password file or environment variable supplies a password for a key
+	KeystoreNoPassword = "No password configured for keystore file %s"
+	// KeystoreUnlockFailed a keystore file could not be decrypted
+	KeystoreUnlockFailed = "Failed to unlock keystore file %s: %s"
+	// KeystoreBadFile the keystore file is not a valid V3 keystore
+	KeystoreBadFile = "Invalid keystore file: %s"
+	// KeystoreAddressMismatch the key decrypted from a keystore file is not for the address recorded in the file
+	KeystoreAddressMismatch = "Keystore file address %s does not match the decrypted key address %s"
+	// KeystoreBadChainID the chain ID configured for keystore signing is not a valid integer
+	KeystoreBadChainID = "Invalid chain ID '%s' for keystore signing"
+	// KeystoreSignTxFailed the unlocked key could not be used to sign the transaction
+	KeystoreSignTxFailed = "Keystore failed to sign transaction: %s"
+
+	// GasPriceBadStrategy the gas price strategy configured, or requested on a transaction, is not one we support
+	GasPriceBadStrategy = "Unknown gas price strategy '%s'"
+	// GasPriceBadConfigValue a numeric value in the gas price configuration could not be parsed
+	GasPriceBadConfigValue = "Invalid %s in gas price configuration: '%s'"
+	// GasPriceStrategyNotConfigured a strategy was requested on a transaction, but the configuration it requires is missing
+	GasPriceStrategyNotConfigured = "Gas price strategy '%s' is not configured"
+	// GasPriceOracleBadResponse the external gas price oracle returned a response without a usable price
+	GasPriceOracleBadResponse = "Gas price oracle response does not contain a valid '%s'"
+	// GasPriceFeeHistoryEmpty the node returned no blocks for eth_feeHistory
+	GasPriceFeeHistoryEmpty = "No fee history returned by the node"
+
 	// HDWalletSigningFailed problem returned from remote HDWallet API
 	HDWalletSigningFailed = "HDWallet signing failed"
 	// HDWalletSigningBadData we got a response, but not with the correct fields
 	HDWalletSigningBadData = "Unexpected response from HDWallet"
 	// HDWalletSigningNoConfig we had a request for HD Wallet signing, but we don't have the required config
 	HDWalletSigningNoConfig = "No HD Wallet Configuration"
+	// HDWalletSignTxFailed the key returned from the HD Wallet could not be used to sign the transaction
+	HDWalletSignTxFailed = "HDWallet failed to sign transaction: %s"
+	// HDWalletLocalReadFailed the mnemonic, seed or passphrase for the local HD Wallet could not be read
+	HDWalletLocalReadFailed = "Failed to read HD Wallet %s from %s: %s"
+	// HDWalletLocalEnvNotSet the environment variable configured to supply a local HD Wallet secret is not set
+	HDWalletLocalEnvNotSet = "No HD Wallet %s in environment variable %s"
+	// HDWalletLocalBadMnemonic the local HD Wallet mnemonic is not a valid BIP-39 English mnemonic
+	HDWalletLocalBadMnemonic = "Invalid HD Wallet mnemonic: %s"
+	// HDWalletLocalBadSeed the local HD Wallet seed is not valid hex of the length BIP-32 requires
+	HDWalletLocalBadSeed = "Invalid HD Wallet seed: %s"
+	// HDWalletLocalBadTemplate a derivation path template could not be parsed
+	HDWalletLocalBadTemplate = "Invalid HD Wallet derivation path template '%s': %s"
+	// HDWalletLocalBadPath a derivation path is not of the form m/44'/60'/0'/0/1
+	HDWalletLocalBadPath = "Invalid HD Wallet derivation path '%s'"
+	// HDWalletLocalBadChainID the chain ID configured for local HD Wallet signing is not a valid integer
+	HDWalletLocalBadChainID = "Invalid chain ID '%s' for HD Wallet signing"
+	// HDWalletLocalDeriveFailed the derivation produced an invalid key, as BIP-32 allows with very low probability
+	HDWalletLocalDeriveFailed = "Failed to derive HD Wallet key for path '%s'"
 
 	// HelperStrToAddressRequiredField re-usable error for missing fields
 	HelperStrToAddressRequiredField = "'%s' must be supplied"
@@ -217,6 +263,19 @@ const (
 	// RemoteRegistryLookupGenericProcessingFailed we don't return the full original error over the REST API after logging
 	RemoteRegistryLookupGenericProcessingFailed = "Error processing contract registry response"
 
+	// RemoteSignerNoURL a remote signer was configured without a signing endpoint
+	RemoteSignerNoURL = "No URL configured for remote signer %d"
+	// RemoteSignerBadAddress an address routed to a remote signer is not a valid hex address
+	RemoteSignerBadAddress = "Invalid address '%s' configured for remote signer %d"
+	// RemoteSignerMultipleDefaults more than one remote signer was configured without a list of addresses
+	RemoteSignerMultipleDefaults = "Only one remote signer can be configured without addresses"
+	// RemoteSignerTLSConfig the TLS configuration for a remote signer is invalid
+	RemoteSignerTLSConfig = "Invalid TLS configuration for remote signer %d: %s"
+	// RemoteSignerSignTxFailed the signing service did not sign the transaction
+	RemoteSignerSignTxFailed = "Remote signer failed to sign transaction: %s"
+	// RemoteSignerBadResponse the signing service returned a result that is not a signed transaction
+	RemoteSignerBadResponse = "Unexpected response from remote signer"
+
 	// RESTGatewayGatewayNotFound the gateway REST API interface (the 'factory' / ABI generic interface) was not found
 	RESTGatewayGatewayNotFound = "Gateway not found"
 	// RESTGatewayInstanceNotFound the instance REST API interface (an individual registered address) was not found
@@ -308,6 +367,10 @@ const (
 	RPCCallReturnedError = "%s returned: %s"
 	// RPCConnectFailed error connecting to back-end server over JSON/RPC
 	RPCConnectFailed = "JSON/RPC connection to %s failed: %s"
+	// RPCInvalidSelection unknown policy for choosing between JSON/RPC endpoints
+	RPCInvalidSelection = "Invalid JSON/RPC endpoint selection '%s'. Valid options: failover, roundrobin"
+	// RPCNoEndpoints none of the JSON/RPC endpoints could be connected
+	RPCNoEndpoints = "No JSON/RPC endpoints available"
 
 	// SecurityModulePluginLoad failed to load .so
 	SecurityModulePluginLoad = "Failed to load plugin: %s"
@@ -326,12 +389,22 @@ const (
 	TransactionSendOutputTypeUnknown = "ABI output %d: Unable to map %s to etherueum type: %s"
 	// TransactionSendGasEstimateFailed gas estimation failed prior to sending TX
 	TransactionSendGasEstimateFailed = "Failed to calculate gas for transaction: %s"
+	// TransactionSendGasEstimateExceedsLimit gas estimate is above the configured limit
+	TransactionSendGasEstimateExceedsLimit = "Estimated gas %d exceeds the limit of %d"
 	// TransactionSendCallFailedNoRevert failed to perform an eth_call with a JSON/RPC error (not a revert)
 	TransactionSendCallFailedNoRevert = "Call failed: %s"
 	// TransactionSendCallFailedRevertMessage directly passes the revert message from the EVM
 	TransactionSendCallFailedRevertMessage = "%s"
 	// TransactionSendCallFailedRevertNoMessage when we couldn't process the EVM revert message
 	TransactionSendCallFailedRevertNoMessage = "EVM reverted. Failed to decode error message"
+	// TransactionSendCallFailedRevertPanic the EVM reverted with a Panic(uint256) code, such as from an assert or overflow
+	TransactionSendCallFailedRevertPanic = "EVM reverted with panic code 0x%x: %s"
+	// TransactionSendCallFailedRevertCustomError the EVM reverted with a custom error from the ABI
+	TransactionSendCallFailedRevertCustomError = "EVM reverted with custom error %s: %s"
+	// TransactionRevertReasonNoReplay the transaction cannot be replayed to find why it failed
+	TransactionRevertReasonNoReplay = "Transaction %s cannot be replayed to find the revert reason"
+	// TransactionRevertReasonFailed the node returned an error replaying or tracing a failed transaction
+	TransactionRevertReasonFailed = "Failed to find the revert reason for transaction %s: %s"
 	// TransactionSendMissingPrivateFromOrion there is no default privateFrom in Orion, so the user must always supply it
 	TransactionSendMissingPrivateFromOrion = "private-from is required when submitting private transactions via Orion"
 	// TransactionSendPrivateTXWithExternalSigner we don't allow private transactions to be combined with a HD Wallet or other external signer currently
@@ -350,6 +423,64 @@ const (
 	TransactionSendBadGas = "Converting supplied 'gas' to integer: %s"
 	// TransactionSendBadGasPrice a user-supplied gasPrice (eth to pay for each unit of gas spent) string in the JSON input cannot be processed
 	TransactionSendBadGasPrice = "Converting supplied 'gasPrice' to big integer"
+	// TransactionSendBadMaxFeePerGas a user-supplied maxFeePerGas (EIP-1559 fee cap for each unit of gas) string in the JSON input cannot be processed
+	TransactionSendBadMaxFeePerGas = "Converting supplied 'maxFeePerGas' to big integer"
+	// TransactionSendBadMaxPriorityFeePerGas a user-supplied maxPriorityFeePerGas (EIP-1559 tip for each unit of gas) string in the JSON input cannot be processed
+	TransactionSendBadMaxPriorityFeePerGas = "Converting supplied 'maxPriorityFeePerGas' to big integer"
+	// TransactionSendBadTxType a user-supplied transaction type is not one that can be submitted
+	TransactionSendBadTxType = "Unsupported 'txType' '%s' - must be 0 (legacy) or 2 (EIP-1559 dynamic fee)"
+	// TransactionSendGasPriceWithDynamicFees a legacy gasPrice was supplied alongside EIP-1559 fee fields
+	TransactionSendGasPriceWithDynamicFees = "'gasPrice' cannot be combined with 'maxFeePerGas' or 'maxPriorityFeePerGas' on a dynamic-fee transaction"
+	// TransactionSendDynamicFeesWithLegacyType EIP-1559 fee fields were supplied on a transaction explicitly marked as legacy
+	TransactionSendDynamicFeesWithLegacyType = "'maxFeePerGas' and 'maxPriorityFeePerGas' cannot be used on a legacy (type 0) transaction"
+	// TransactionSendRawTxnDecode a pre-signed transaction could not be decoded from the supplied hex RLP encoding
+	TransactionSendRawTxnDecode = "Failed to decode raw transaction: %s"
+	// TransactionSendRawTxnSender the sender could not be recovered from the signature of a pre-signed transaction
+	TransactionSendRawTxnSender = "Failed to recover sender from raw transaction signature: %s"
+	// TransactionSendRawTxnResign a pre-signed transaction cannot be signed again, such as to replace it with increased fees
+	TransactionSendRawTxnResign = "Transaction %s was signed by the submitter, and cannot be re-signed"
+	// TransactionBatchUnsupported the transport that delivered a batch cannot reply separately for each item
+	TransactionBatchUnsupported = "Batch messages are not supported on this transport"
+	// TransactionBatchFromMissing a batch must specify the sender of all of its items
+	TransactionBatchFromMissing = "Batch must specify 'from', the sender of all of its items"
+	// TransactionBatchEmpty a batch must contain at least one item
+	TransactionBatchEmpty = "Batch must contain at least one item"
+	// TransactionBatchItemInvalid an item in a batch could not be parsed
+	TransactionBatchItemInvalid = "Batch item %d is invalid: %s"
+	// TransactionBatchItemType only transactions built by the gateway can be included in a batch
+	TransactionBatchItemType = "Batch item %d has type '%s'. Only SendTransaction and DeployContract can be batched"
+	// TransactionBatchItemFrom every item in a batch must be from the sender of the batch
+	TransactionBatchItemFrom = "Batch item %d is from '%s', but the batch is from '%s'"
+	// TransactionPreparePrivate private transactions are signed by the node, so cannot be prepared for signing by an external wallet
+	TransactionPreparePrivate = "Private transactions cannot be prepared for signing outside of the node"
+	// TransactionPrepareEncodeFailed the prepared transaction could not be encoded for the reply
+	TransactionPrepareEncodeFailed = "Failed to encode the prepared transaction: %s"
+	// TransactionBatchItemScheduled the items in a batch are submitted together, so cannot be scheduled individually
+	TransactionBatchItemScheduled = "Batch item %d cannot set notBefore or notBeforeBlock"
+	// TransactionBatchItemNonce the nonces of the items in a batch are assigned by the gateway
+	TransactionBatchItemNonce = "Batch item %d cannot set a nonce, as the items of a batch are assigned consecutive nonces"
+	// TransactionBatchItemNotSubmitted every item in a batch must be submitted, so cannot be simulated or prepared
+	TransactionBatchItemNotSubmitted = "Batch item %d cannot set simulate or prepare"
+	// TransactionBatchNodeNonce the node assigns the nonces for the sender, so the items of a batch might not be given consecutive nonces
+	TransactionBatchNodeNonce = "Batch from '%s' cannot be assigned consecutive nonces, as the node assigns the nonces for this sender. Enable alwaysManageNonce, or use a local or remote signer"
+	// TransactionBatchItemSkipped an earlier item in a batch failed, so the later items are not submitted
+	TransactionBatchItemSkipped = "Batch item %d was not submitted, as batch item %d failed"
+	// TransactionScheduleNotEnabled a transaction with notBefore or notBeforeBlock needs the scheduler DB to hold it until it is due
+	TransactionScheduleNotEnabled = "Scheduled transactions are not enabled. Configure a scheduler DB to submit transactions with notBefore or notBeforeBlock"
+	// TransactionScheduleBadNotBefore the notBefore time could not be parsed
+	TransactionScheduleBadNotBefore = "Invalid notBefore '%s'. Must be an RFC3339 timestamp"
+	// TransactionScheduleBadNotBeforeBlock the notBeforeBlock is not a non-negative integer
+	TransactionScheduleBadNotBeforeBlock = "Invalid notBeforeBlock '%s'"
+	// TransactionScheduleDBLoad failed to open the DB used to hold scheduled transactions
+	TransactionScheduleDBLoad = "Failed to open scheduled transaction DB at %s: %s"
+	// TransactionScheduleStore failed to persist a scheduled transaction
+	TransactionScheduleStore = "Failed to store scheduled transaction: %s"
+	// TransactionScheduledNotFound no transaction is scheduled with the request ID
+	TransactionScheduledNotFound = "No scheduled transaction found for request '%s'"
+	// TransactionScheduledCancelled the reply for a scheduled transaction that was cancelled before it was due
+	TransactionScheduledCancelled = "Scheduled transaction was cancelled before it was submitted"
+	// TransactionScheduledOutcomeUnknown the reply for a scheduled transaction that was being submitted at a restart, and was not in the journal
+	TransactionScheduledOutcomeUnknown = "Scheduled transaction was being submitted before a restart. It is not submitted again, and the outcome is unknown"
 	// TransactionSendInputTypeBadNumber the input JSON value supplied for a method parameter cannot be converted to a number
 	TransactionSendInputTypeBadNumber = "Method '%s' param %s: Could not be converted to a number"
 	// TransactionSendInputTypeBadJSONTypeForNumber the input JSON value supplied for a method parameter was not a number or a string, and needs to be converted to a number
@@ -395,6 +526,44 @@ const (
 	TransactionSendReceiptCheckError = "Error obtaining transaction receipt (%d retries): %s"
 	// TransactionSendReceiptCheckTimeout we didn't have a problem asking the node for a receipt, but the transaction wasn't mined at the end of the timeout
 	TransactionSendReceiptCheckTimeout = "Timed out waiting for transaction receipt"
+	// TransactionSendConfirmationsTimeout the transaction was mined, but did not reach the required depth before the timeout
+	TransactionSendConfirmationsTimeout = "Timed out waiting for confirmations. Transaction mined in block %d with %d of %d confirmations"
+	// TransactionSendBadConfirmations the confirmations requested are not a non-negative integer
+	TransactionSendBadConfirmations = "Invalid confirmations '%s'"
+	// TransactionSendJournalLoad failed to open the DB used to journal in-flight transactions
+	TransactionSendJournalLoad = "Failed to open in-flight transaction journal DB at %s: %s"
+	// TransactionSendRecoveredNoRequest a transaction recovered from the journal has no original request payload
+	TransactionSendRecoveredNoRequest = "Original request not available for transaction recovered after restart"
+	// TransactionSendRecoveredResumed a redelivered request arrived after the transaction recovered for it was resumed
+	TransactionSendRecoveredResumed = "Transaction %s recovered after restart was already resumed, and its receipt is delivered as a notification"
+	// TransactionSendRateLimitedInFlight the maximum number of transactions are already in-flight
+	TransactionSendRateLimitedInFlight = "Too many transactions in-flight (limit %d)"
+	// TransactionSendRateLimitedAddrInFlight the maximum number of transactions are already in-flight for the from address
+	TransactionSendRateLimitedAddrInFlight = "Too many transactions in-flight for %s (limit %d)"
+	// TransactionSendRateLimitedTPS the from address is submitting transactions faster than the configured rate
+	TransactionSendRateLimitedTPS = "Transactions from %s exceed the rate limit of %g per second"
+	// TransactionNotificationNoRequest a notification for a receipt that was already delivered has no original request payload
+	TransactionNotificationNoRequest = "Original request not available for notification"
+	// TransactionReplaceNotReplaceable a stuck transaction cannot be replaced, as it is private, or we cannot sign it
+	TransactionReplaceNotReplaceable = "Transaction %s cannot be replaced, as it is private, or it was signed by the submitter"
+	// TransactionReplaceNonceLookup failed to query the node for the nonce it assigned to a transaction being replaced
+	TransactionReplaceNonceLookup = "Failed to find the nonce assigned by the node to transaction %s: %s"
+	// TransactionReplaceNonceUnknown the node does not know the transaction being replaced, so the nonce it assigned is unknown
+	TransactionReplaceNonceUnknown = "Transaction %s was not found on the node, so the nonce assigned to it is unknown"
+	// TransactionReplaceMaxGasPrice bumping the fees of a stuck transaction would exceed the configured maximum
+	TransactionReplaceMaxGasPrice = "Replacement gas price %s would exceed the configured maximum of %s"
+	// TransactionReplaceBadFees the fees supplied to replace a pending transaction could not be parsed
+	TransactionReplaceBadFees = "Invalid fees for transaction replacement: %s"
+	// TransactionPendingNotFound no transaction waiting to be mined matches the supplied request ID or hash
+	TransactionPendingNotFound = "No pending transaction found for '%s'"
+	// TransactionNonceNotTracked there are no in-flight transactions for the address, so no nonce state
+	TransactionNonceNotTracked = "No in-flight transactions for address %s"
+	// TransactionNonceGapFillInUse the nonce requested for a gap-fill belongs to a transaction that is in-flight
+	TransactionNonceGapFillInUse = "Nonce %d for %s is in use by in-flight transaction %s. Cancel the transaction instead"
+	// TransactionNonceGapFillBadNonce the nonce supplied for a gap-fill is missing or invalid
+	TransactionNonceGapFillBadNonce = "Invalid nonce for gap-fill: '%s'"
+	// TransactionReplaceBadMaxGasPrice the configured maximum gas price for replacement transactions is not a valid integer
+	TransactionReplaceBadMaxGasPrice = "Invalid maximum gas price for transaction replacement: '%s'"
 
 	// TransactionCallInvalidBlockNumber on "eth_call" the optional parameter for the target blocknumber failed to parse to a big integer
 	TransactionCallInvalidBlockNumber = "Invalid blocknumber. Failed to parse into big integer"
@@ -425,6 +594,10 @@ const (
 	WebhooksInvalidMsgTypeMissing = "Invalid message - missing 'headers.type' (or not a string)"
 	// WebhooksInvalidMsgFromMissing need to specify a msg type in the header
 	WebhooksInvalidMsgFromMissing = "Invalid message - missing 'from' (or not a string)"
+	// WebhooksInvalidMsgRawTxnMissing need to specify the signed transaction for a SendRawTransaction
+	WebhooksInvalidMsgRawTxnMissing = "Invalid message - missing 'rawTransaction' (or not a string)"
+	// WebhooksInvalidMsgBatchItems need to specify the items of a Batch
+	WebhooksInvalidMsgBatchItems = "Invalid message - missing 'items' (or not an array)"
 	// WebhooksInvalidMsgType need to specify a valid msg type in the header
 	WebhooksInvalidMsgType = "Invalid message type: %s"
 	// WebhooksKafkaUnexpectedErrFmt problem processing an error that came back from Kafka, so do a deep dump
@@ -440,6 +613,12 @@ const (
 	WebhooksDirectTooManyInflight = "Too many in-flight transactions"
 	// WebhooksDirectBadHeaders problem processing for in-memory operation
 	WebhooksDirectBadHeaders = "Failed to process headers in message"
+	// WebhooksIdempotencyDBLoad failed to open the DB used to record idempotency keys
+	WebhooksIdempotencyDBLoad = "Failed to open idempotency DB at %s: %s"
+	// WebhooksIdempotencyLookup failed to check for an earlier request with the same idempotency key
+	WebhooksIdempotencyLookup = "Failed to check idempotency key '%s': %s"
+	// WebhooksIdempotencyInProgress a request with the same idempotency key is still being submitted
+	WebhooksIdempotencyInProgress = "A request with idempotency key '%s' is already being processed"
 )
 
 type Error string
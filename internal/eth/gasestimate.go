@@ -0,0 +1,72 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+)
+
+const (
+	defaultGasEstimateMultiplier = 1.2
+	defaultGasEstimateTimeout    = 30 * time.Second
+)
+
+// GasEstimatePolicy controls how the gas limit is chosen from the result of eth_estimateGas,
+// for transactions that do not specify their gas. Zero values use the defaults, of a
+// 20% buffer for variation as the chain changes between estimation and submission
+type GasEstimatePolicy struct {
+	Multiplier float64 `json:"multiplier,omitempty"`
+	Floor      uint64  `json:"floor,omitempty"`
+	Ceiling    uint64  `json:"ceiling,omitempty"`
+	FailAbove  uint64  `json:"failAbove,omitempty"`
+	TimeoutSec int     `json:"timeoutSec,omitempty"`
+}
+
+func (p *GasEstimatePolicy) timeout() time.Duration {
+	if p == nil || p.TimeoutSec <= 0 {
+		return defaultGasEstimateTimeout
+	}
+	return time.Duration(p.TimeoutSec) * time.Second
+}
+
+// gasLimit applies the policy to an estimate. The multiplier is applied first, then the
+// floor, then the ceiling - which never reduces the limit below the estimate itself,
+// as the transaction would be certain to fail
+func (p *GasEstimatePolicy) gasLimit(estimate uint64) (uint64, error) {
+	multiplier := defaultGasEstimateMultiplier
+	var floor, ceiling, failAbove uint64
+	if p != nil {
+		if p.Multiplier > 0 {
+			multiplier = p.Multiplier
+		}
+		floor, ceiling, failAbove = p.Floor, p.Ceiling, p.FailAbove
+	}
+	if failAbove > 0 && estimate > failAbove {
+		return 0, errors.Errorf(errors.TransactionSendGasEstimateExceedsLimit, estimate, failAbove)
+	}
+	gas := uint64(float64(estimate) * multiplier)
+	if gas < floor {
+		gas = floor
+	}
+	if ceiling > 0 && gas > ceiling {
+		gas = ceiling
+	}
+	if gas < estimate {
+		gas = estimate
+	}
+	return gas, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGasLimitDefaultPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	var policy *GasEstimatePolicy
+	gas, err := policy.gasLimit(1000)
+	assert.NoError(err)
+	assert.Equal(uint64(1200), gas)
+	assert.Equal(30*time.Second, policy.timeout())
+}
+
+func TestGasLimitMultiplierFloorCeiling(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := &GasEstimatePolicy{Multiplier: 1.5, Floor: 50000, Ceiling: 100000, TimeoutSec: 5}
+	gas, err := policy.gasLimit(1000)
+	assert.NoError(err)
+	assert.Equal(uint64(50000), gas)
+
+	gas, err = policy.gasLimit(40000)
+	assert.NoError(err)
+	assert.Equal(uint64(60000), gas)
+
+	gas, err = policy.gasLimit(80000)
+	assert.NoError(err)
+	assert.Equal(uint64(100000), gas)
+
+	// The ceiling never reduces the limit below the estimate
+	gas, err = policy.gasLimit(150000)
+	assert.NoError(err)
+	assert.Equal(uint64(150000), gas)
+
+	assert.Equal(5*time.Second, policy.timeout())
+}
+
+func TestGasLimitFailAbove(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := &GasEstimatePolicy{FailAbove: 1000}
+	gas, err := policy.gasLimit(1000)
+	assert.NoError(err)
+	assert.Equal(uint64(1200), gas)
+
+	_, err = policy.gasLimit(1001)
+	assert.EqualError(err, "Estimated gas 1001 exceeds the limit of 1000")
+}
+
+func TestSendGasEstimatePolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.SendTransaction
+	msg.Parameters = []interface{}{}
+	msg.MethodName = "testFunc"
+	msg.To = "0x2b8c0ECc76d0759a8F50b2E14A6881367D805832"
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.Value = "0"
+	signer := &mockTXSigner{
+		signed: []byte("testbytes"),
+		from:   "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
+	}
+	tx, err := NewSendTxn(&msg, signer)
+	assert.Nil(err)
+	assert.Equal("testFunc()", tx.MethodSig)
+	tx.GasEstimatePolicy = &GasEstimatePolicy{Multiplier: 2}
+
+	rpc := testRPCClient{
+		resultWrangler: func(retString interface{}) {
+			if gas, ok := retString.(**ethbinding.HexUint64); ok {
+				**gas = ethbinding.HexUint64(1000)
+			}
+		},
+	}
+
+	err = tx.Send(context.Background(), &rpc)
+	assert.NoError(err)
+	assert.Equal(uint64(1000), tx.GasEstimate)
+	assert.Equal(uint64(2000), signer.capturedTX.Gas())
+}
+
+func TestSendGasEstimateExceedsLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.SendTransaction
+	msg.Parameters = []interface{}{}
+	msg.MethodName = "testFunc"
+	msg.To = "0x2b8c0ECc76d0759a8F50b2E14A6881367D805832"
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.Value = "0"
+	tx, err := NewSendTxn(&msg, nil)
+	assert.Nil(err)
+	tx.GasEstimatePolicy = &GasEstimatePolicy{FailAbove: 500}
+
+	rpc := testRPCClient{
+		resultWrangler: func(retString interface{}) {
+			if gas, ok := retString.(**ethbinding.HexUint64); ok {
+				**gas = ethbinding.HexUint64(1000)
+			}
+		},
+	}
+
+	err = tx.Send(context.Background(), &rpc)
+	assert.EqualError(err, "Estimated gas 1000 exceeds the limit of 500")
+	assert.Equal("eth_estimateGas", rpc.capturedMethod)
+	assert.Equal("", rpc.capturedMethod2)
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"time"
 
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
 	"github.com/kaleido-io/ethconnect/internal/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -29,6 +30,10 @@ func (tx *Txn) GetTXReceipt(ctx context.Context, rpc RPCClient) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	if len(tx.SubmittedHashes) > 0 {
+		return tx.getReplacedTXReceipt(ctx, rpc)
+	}
+
 	if err := rpc.CallContext(ctx, &tx.Receipt, "eth_getTransactionReceipt", tx.Hash); err != nil {
 		return false, errors.Errorf(errors.RPCCallReturnedError, "eth_getTransactionReceipt", err)
 	}
@@ -45,3 +50,100 @@ func (tx *Txn) GetTXReceipt(ctx context.Context, rpc RPCClient) (bool, error) {
 
 	return isMined, nil
 }
+
+// GetBlockNumber gets the number of the latest block on the node
+func GetBlockNumber(ctx context.Context, rpc RPCClient) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var blockNumber ethbinding.HexUint64
+	if err := rpc.CallContext(ctx, &blockNumber, "eth_blockNumber"); err != nil {
+		return 0, errors.Errorf(errors.RPCCallReturnedError, "eth_blockNumber", err)
+	}
+	log.Debugf("eth_blockNumber()=%d", blockNumber)
+	return uint64(blockNumber), nil
+}
+
+// getReplacedTXReceipt checks every hash submitted for a transaction that has been
+// replaced, newest first, as any one of them might be the one that is mined.
+// The Hash of the transaction is updated to the one that was mined
+func (tx *Txn) getReplacedTXReceipt(ctx context.Context, rpc RPCClient) (bool, error) {
+	for i := len(tx.SubmittedHashes) - 1; i >= 0; i-- {
+		hash := tx.SubmittedHashes[i]
+		var receipt TxnReceipt
+		if err := rpc.CallContext(ctx, &receipt, "eth_getTransactionReceipt", hash); err != nil {
+			return false, errors.Errorf(errors.RPCCallReturnedError, "eth_getTransactionReceipt", err)
+		}
+		isMined := receipt.BlockNumber != nil && receipt.BlockNumber.ToInt().Uint64() > 0
+		log.Debugf("eth_getTransactionReceipt(%x,latest)=%t", hash, isMined)
+		if isMined {
+			tx.Hash = hash
+			tx.Receipt = receipt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetTXReceipts gets the receipts for a set of public transactions in a single JSON-RPC batch,
+// checking every submitted hash of those that have been replaced. The result for each
+// transaction is the same as GetTXReceipt would return
+func GetTXReceipts(ctx context.Context, rpc RPCClientBatch, txns []*Txn) ([]bool, []error) {
+	start := time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// The calls for each transaction are newest hash first, and end at callEnd[i]
+	var calls []ethbinding.BatchElem
+	callEnd := make([]int, len(txns))
+	for i, tx := range txns {
+		hashes := []string{tx.Hash}
+		if len(tx.SubmittedHashes) > 0 {
+			hashes = make([]string, 0, len(tx.SubmittedHashes))
+			for j := len(tx.SubmittedHashes) - 1; j >= 0; j-- {
+				hashes = append(hashes, tx.SubmittedHashes[j])
+			}
+		}
+		for _, hash := range hashes {
+			calls = append(calls, ethbinding.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{hash},
+				Result: &TxnReceipt{},
+			})
+		}
+		callEnd[i] = len(calls)
+	}
+
+	isMined := make([]bool, len(txns))
+	errs := make([]error, len(txns))
+	if err := rpc.BatchCallContext(ctx, calls); err != nil {
+		for i := range txns {
+			errs[i] = errors.Errorf(errors.RPCCallReturnedError, "eth_getTransactionReceipt", err)
+		}
+		return isMined, errs
+	}
+
+	callStart := 0
+	for i, tx := range txns {
+		for _, call := range calls[callStart:callEnd[i]] {
+			if call.Error != nil {
+				errs[i] = errors.Errorf(errors.RPCCallReturnedError, "eth_getTransactionReceipt", call.Error)
+				break
+			}
+			receipt := call.Result.(*TxnReceipt)
+			if receipt.BlockNumber != nil && receipt.BlockNumber.ToInt().Uint64() > 0 {
+				tx.Hash = call.Args[0].(string)
+				tx.Receipt = *receipt
+				isMined[i] = true
+				break
+			}
+			if len(tx.SubmittedHashes) == 0 {
+				tx.Receipt = *receipt
+			}
+		}
+		callStart = callEnd[i]
+	}
+	log.Debugf("eth_getTransactionReceipt batch of %d calls for %d transactions [%.2fs]", len(calls), len(txns), time.Now().UTC().Sub(start).Seconds())
+	return isMined, errs
+}
@@ -128,3 +128,135 @@ func TestGetTXReceiptOrionTXFail(t *testing.T) {
 	assert.Equal("priv_getTransactionReceipt", r.capturedMethod2)
 	assert.Equal(false, isMined)
 }
+
+func TestGetTXReceiptReplacedMined(t *testing.T) {
+	assert := assert.New(t)
+
+	checked := []string{}
+	r := NewMockRPCClientForSync(nil, func(method string, res interface{}, args ...interface{}) {
+		hash := args[0].(string)
+		checked = append(checked, hash)
+		if hash == "0x111" {
+			var blockNumber ethbinding.HexBigInt
+			blockNumber.ToInt().SetInt64(10)
+			res.(*TxnReceipt).BlockNumber = &blockNumber
+		}
+	})
+
+	tx := Txn{
+		Hash:            "0x333",
+		SubmittedHashes: []string{"0x111", "0x222", "0x333"},
+	}
+	isMined, err := tx.GetTXReceipt(context.Background(), r)
+
+	assert.NoError(err)
+	assert.True(isMined)
+	assert.Equal([]string{"0x333", "0x222", "0x111"}, checked)
+	assert.Equal("0x111", tx.Hash)
+	assert.Equal(int64(10), tx.Receipt.BlockNumber.ToInt().Int64())
+}
+
+func TestGetTXReceiptReplacedNotMined(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewMockRPCClientForSync(nil, nil)
+	tx := Txn{
+		Hash:            "0x222",
+		SubmittedHashes: []string{"0x111", "0x222"},
+	}
+	isMined, err := tx.GetTXReceipt(context.Background(), r)
+
+	assert.NoError(err)
+	assert.False(isMined)
+	assert.Equal("0x222", tx.Hash)
+}
+
+func TestGetTXReceiptReplacedFail(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewMockRPCClientForSync(fmt.Errorf("pop"), nil)
+	tx := Txn{
+		Hash:            "0x222",
+		SubmittedHashes: []string{"0x111", "0x222"},
+	}
+	_, err := tx.GetTXReceipt(context.Background(), r)
+
+	assert.EqualError(err, "eth_getTransactionReceipt returned: pop")
+}
+
+func TestGetBlockNumber(t *testing.T) {
+	assert := assert.New(t)
+
+	r := testRPCClient{
+		resultWrangler: func(result interface{}) {
+			*(result.(*ethbinding.HexUint64)) = 12345
+		},
+	}
+	blockNumber, err := GetBlockNumber(context.Background(), &r)
+	assert.NoError(err)
+	assert.Equal(uint64(12345), blockNumber)
+	assert.Equal("eth_blockNumber", r.capturedMethod)
+}
+
+func TestGetBlockNumberErr(t *testing.T) {
+	assert := assert.New(t)
+
+	r := testRPCClient{
+		mockError: fmt.Errorf("pop"),
+	}
+	_, err := GetBlockNumber(context.Background(), &r)
+	assert.EqualError(err, "eth_blockNumber returned: pop")
+}
+
+func TestGetTXReceiptsBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls []string
+	r := NewMockRPCClientForSync(nil, func(method string, res interface{}, args ...interface{}) {
+		hash := args[0].(string)
+		calls = append(calls, hash)
+		if hash == "0x2" || hash == "0x3" {
+			var blockNumber ethbinding.HexBigInt
+			blockNumber.ToInt().SetInt64(10)
+			res.(*TxnReceipt).BlockNumber = &blockNumber
+		}
+	})
+
+	notMined := &Txn{Hash: "0x1"}
+	mined := &Txn{Hash: "0x2"}
+	replaced := &Txn{Hash: "0x5", SubmittedHashes: []string{"0x3", "0x4", "0x5"}}
+	isMined, errs := GetTXReceipts(context.Background(), r, []*Txn{notMined, mined, replaced})
+
+	assert.Equal([]bool{false, true, true}, isMined)
+	assert.Equal([]error{nil, nil, nil}, errs)
+	assert.Equal([]string{"0x1", "0x2", "0x5", "0x4", "0x3"}, calls)
+	assert.Equal("0x2", mined.Hash)
+	assert.Equal(int64(10), mined.Receipt.BlockNumber.ToInt().Int64())
+	assert.Equal("0x3", replaced.Hash)
+	assert.Equal(int64(10), replaced.Receipt.BlockNumber.ToInt().Int64())
+}
+
+func TestGetTXReceiptsCallFail(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewMockRPCClientForSync(fmt.Errorf("pop"), nil)
+
+	isMined, errs := GetTXReceipts(context.Background(), r, []*Txn{{Hash: "0x1"}})
+	assert.False(isMined[0])
+	assert.EqualError(errs[0], "eth_getTransactionReceipt returned: pop")
+}
+
+type testBatchFailRPC struct{}
+
+func (r *testBatchFailRPC) BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error {
+	return fmt.Errorf("pop")
+}
+
+func TestGetTXReceiptsBatchFail(t *testing.T) {
+	assert := assert.New(t)
+
+	isMined, errs := GetTXReceipts(context.Background(), &testBatchFailRPC{}, []*Txn{{Hash: "0x1"}, {Hash: "0x2"}})
+	assert.Equal([]bool{false, false}, isMined)
+	assert.EqualError(errs[0], "eth_getTransactionReceipt returned: pop")
+	assert.EqualError(errs[1], "eth_getTransactionReceipt returned: pop")
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	log "github.com/sirupsen/logrus"
+)
+
+// Prepared is an unsigned transaction, complete with nonce, fees and gas limit, and the
+// hash that must be signed by the sender for it to be submitted.
+// Unsigned is the transaction encoded as it would be sent, but with zero v, r and s values:
+// the RLP list of a legacy transaction, or the type byte and RLP list of a dynamic-fee
+// transaction. It is not the EIP-155 signing payload, which is what SigningHash is the hash of
+type Prepared struct {
+	ChainID     *big.Int
+	Unsigned    []byte
+	SigningHash ethbinding.Hash
+}
+
+// GetChainID gets the chain ID of the node, which is included in the signature of a transaction
+func GetChainID(ctx context.Context, rpc RPCClient) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var chainID ethbinding.HexBigInt
+	if err := rpc.CallContext(ctx, &chainID, "eth_chainId"); err != nil {
+		return nil, errors.Errorf(errors.RPCCallReturnedError, "eth_chainId", err)
+	}
+	return chainID.ToInt(), nil
+}
+
+// Prepare estimates the gas for the transaction, if no gas limit was supplied, and returns
+// the unsigned transaction for signing outside of the gateway. Nothing is submitted, and
+// the signed transaction can be sent later as a pre-signed transaction
+func (tx *Txn) Prepare(ctx context.Context, rpc RPCClient) (*Prepared, error) {
+	if tx.PrivacyGroupID != "" || len(tx.PrivateFor) > 0 {
+		return nil, errors.Errorf(errors.TransactionPreparePrivate)
+	}
+
+	gas := ethbinding.HexUint64(tx.EthTX.Gas())
+	txArgs := tx.sendTXArgs()
+	ctx = withSender(ctx, txArgs.From)
+	if uint64(gas) == uint64(0) {
+		if err := tx.calculateGas(ctx, rpc, txArgs, &gas); err != nil {
+			return nil, err
+		}
+		tx.EthTX = tx.withGas(uint64(gas))
+	}
+
+	chainID, err := GetChainID(ctx, rpc)
+	if err != nil {
+		return nil, err
+	}
+	// The chain ID is part of the payload of a dynamic-fee transaction, rather than the signature
+	etx := tx.EthTX
+	if etx.Type() == ethbinding.DynamicFeeTxType {
+		etx = ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     etx.Nonce(),
+			To:        etx.To(),
+			Value:     etx.Value(),
+			Gas:       etx.Gas(),
+			GasFeeCap: etx.GasFeeCap(),
+			GasTipCap: etx.GasTipCap(),
+			Data:      etx.Data(),
+		})
+		tx.EthTX = etx
+	}
+	unsigned, err := etx.MarshalBinary()
+	if err != nil {
+		return nil, errors.Errorf(errors.TransactionPrepareEncodeFailed, err)
+	}
+	prepared := &Prepared{
+		ChainID:     chainID,
+		Unsigned:    unsigned,
+		SigningHash: ethbind.API.NewLondonSigner(chainID).Hash(etx),
+	}
+	log.Infof("Prepared transaction from %s. nonce=%d gas=%d signingHash=%s", txArgs.From, etx.Nonce(), etx.Gas(), prepared.SigningHash.Hex())
+	return prepared, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/stretchr/testify/assert"
+)
+
+type prepareRPCClient struct {
+	chainID     int64
+	chainIDErr  error
+	estimate    uint64
+	estimateErr error
+	calls       []string
+}
+
+func (r *prepareRPCClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	r.calls = append(r.calls, method)
+	switch method {
+	case "eth_chainId":
+		if r.chainIDErr != nil {
+			return r.chainIDErr
+		}
+		chainID := ethbinding.HexBigInt(*big.NewInt(r.chainID))
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(chainID))
+		return nil
+	case "eth_estimateGas":
+		if r.estimateErr != nil {
+			return r.estimateErr
+		}
+		estimate := ethbinding.HexUint64(r.estimate)
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(&estimate))
+		return nil
+	case "eth_call":
+		return nil
+	}
+	return fmt.Errorf("unexpected method %s", method)
+}
+
+func signPrepared(t *testing.T, tx *Txn, prepared *Prepared) (string, ethbinding.Address) {
+	return newTestRawTxn(t, tx.EthTX, ethbind.API.NewLondonSigner(prepared.ChainID))
+}
+
+func TestPrepareLegacy(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &prepareRPCClient{chainID: 1337}
+	tx := newTestSimulateTxn(50000)
+	prepared, err := tx.Prepare(context.Background(), rpc)
+	assert.NoError(err)
+	assert.Equal([]string{"eth_chainId"}, rpc.calls)
+	assert.Equal(int64(1337), prepared.ChainID.Int64())
+	assert.Equal(uint64(50000), tx.EthTX.Gas())
+
+	unsigned := new(ethbinding.Transaction)
+	assert.NoError(unsigned.UnmarshalBinary(prepared.Unsigned))
+	assert.Equal(uint64(5), unsigned.Nonce())
+	v, r, s := unsigned.RawSignatureValues()
+	assert.Zero(v.Sign())
+	assert.Zero(r.Sign())
+	assert.Zero(s.Sign())
+	assert.Equal(ethbind.API.NewEIP155Signer(big.NewInt(1337)).Hash(unsigned), prepared.SigningHash)
+
+	// The submitter signs the prepared transaction, and sends it as a pre-signed transaction
+	rawHex, from := signPrepared(t, tx, prepared)
+	etx, sender, err := DecodeRawTransaction(rawHex)
+	assert.NoError(err)
+	assert.Equal(from, sender)
+	assert.Equal(prepared.SigningHash, ethbind.API.NewLondonSigner(prepared.ChainID).Hash(etx))
+}
+
+func TestPrepareDynamicFeeEstimateGas(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &prepareRPCClient{chainID: 1337, estimate: 21000}
+	to := ethbind.API.HexToAddress("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832")
+	tx := &Txn{
+		From: ethbind.API.HexToAddress("0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"),
+		EthTX: ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+			Nonce:     3,
+			GasTipCap: big.NewInt(10),
+			GasFeeCap: big.NewInt(100),
+			To:        &to,
+			Value:     big.NewInt(0),
+		}),
+	}
+	prepared, err := tx.Prepare(context.Background(), rpc)
+	assert.NoError(err)
+	assert.Equal([]string{"eth_estimateGas", "eth_chainId"}, rpc.calls)
+	assert.Equal(uint64(21000), tx.GasEstimate)
+	assert.Equal(uint64(25200), tx.EthTX.Gas())
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), tx.EthTX.Type())
+	assert.Equal(int64(1337), tx.EthTX.ChainId().Int64())
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), prepared.Unsigned[0])
+
+	rawHex, from := signPrepared(t, tx, prepared)
+	_, sender, err := DecodeRawTransaction(rawHex)
+	assert.NoError(err)
+	assert.Equal(from, sender)
+}
+
+func TestPrepareGasEstimateFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &prepareRPCClient{chainID: 1337, estimateErr: fmt.Errorf("pop")}
+	_, err := newTestSimulateTxn(0).Prepare(context.Background(), rpc)
+	assert.Regexp("Failed to calculate gas for transaction: pop", err)
+}
+
+func TestPrepareChainIDFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &prepareRPCClient{chainIDErr: fmt.Errorf("pop")}
+	_, err := newTestSimulateTxn(50000).Prepare(context.Background(), rpc)
+	assert.Regexp("eth_chainId returned: pop", err)
+}
+
+func TestPreparePrivate(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := newTestSimulateTxn(50000)
+	tx.PrivateFor = []string{"member1"}
+	_, err := tx.Prepare(context.Background(), &prepareRPCClient{})
+	assert.Regexp("Private transactions cannot be prepared", err)
+}
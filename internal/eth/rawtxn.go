@@ -0,0 +1,89 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+)
+
+const (
+	// RawSignerType is the signer type of transactions that were signed by the submitter
+	RawSignerType = "pre-signed"
+)
+
+// rawSigner returns the signed bytes supplied by the submitter of a transaction. It cannot
+// sign any other transaction, such as a replacement with increased fees
+type rawSigner struct {
+	from ethbinding.Address
+	hash ethbinding.Hash
+	raw  []byte
+}
+
+func (s *rawSigner) Type() string {
+	return RawSignerType
+}
+
+func (s *rawSigner) Address() string {
+	return s.from.Hex()
+}
+
+func (s *rawSigner) Sign(tx *ethbinding.Transaction) ([]byte, error) {
+	if tx.Hash() != s.hash {
+		return nil, errors.Errorf(errors.TransactionSendRawTxnResign, s.hash.Hex())
+	}
+	return s.raw, nil
+}
+
+// DecodeRawTransaction decodes a hex encoded signed transaction, of any type, and
+// recovers the sender from the signature
+func DecodeRawTransaction(rawHex string) (*ethbinding.Transaction, ethbinding.Address, error) {
+	var from ethbinding.Address
+	raw, err := ethbind.API.HexDecode(rawHex)
+	if err != nil {
+		return nil, from, errors.Errorf(errors.TransactionSendRawTxnDecode, err)
+	}
+	etx := new(ethbinding.Transaction)
+	if err = etx.UnmarshalBinary(raw); err != nil {
+		return nil, from, errors.Errorf(errors.TransactionSendRawTxnDecode, err)
+	}
+	// The London signer handles legacy (including pre-EIP155), access list and dynamic fee transactions
+	if from, err = ethbind.API.NewLondonSigner(etx.ChainId()).Sender(etx); err != nil {
+		return nil, from, errors.Errorf(errors.TransactionSendRawTxnSender, err)
+	}
+	return etx, from, nil
+}
+
+// NewRawTxn builds a transaction from a SendRawTransaction message. The signed bytes are
+// submitted unchanged with eth_sendRawTransaction
+func NewRawTxn(msg *messages.SendRawTransaction) (*Txn, error) {
+	etx, from, err := DecodeRawTransaction(msg.RawTransaction)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := etx.MarshalBinary()
+	return &Txn{
+		From:  from,
+		EthTX: etx,
+		Signer: &rawSigner{
+			from: from,
+			hash: etx.Hash(),
+			raw:  raw,
+		},
+		Errors: msg.Errors,
+	}, nil
+}
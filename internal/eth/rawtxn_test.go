@@ -0,0 +1,140 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRawTxnKey = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+func newTestRawTxn(t *testing.T, etx *ethbinding.Transaction, signer ethbinding.Signer) (string, ethbinding.Address) {
+	key, err := ethbind.API.HexToECDSA(testRawTxnKey)
+	assert.NoError(t, err)
+	signed, err := ethbind.API.SignTx(etx, signer, key)
+	assert.NoError(t, err)
+	raw, err := signed.MarshalBinary()
+	assert.NoError(t, err)
+	return ethbind.API.HexEncode(raw), ethbind.API.PubkeyToAddress(key.PublicKey)
+}
+
+func newTestLegacyRawTxn(t *testing.T) (string, ethbinding.Address) {
+	to := ethbind.API.HexToAddress("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832")
+	etx := ethbind.API.NewTransaction(12, to, big.NewInt(0), 50000, big.NewInt(1000), []byte{0x01, 0x02})
+	return newTestRawTxn(t, etx, ethbind.API.NewEIP155Signer(big.NewInt(1337)))
+}
+
+func TestNewRawTxnLegacy(t *testing.T) {
+	assert := assert.New(t)
+
+	rawHex, from := newTestLegacyRawTxn(t)
+	tx, err := NewRawTxn(&messages.SendRawTransaction{
+		RawTransaction: rawHex,
+		Errors:         ErrorsFromABI(testCustomErrorABI),
+	})
+	assert.NoError(err)
+	assert.Equal(from, tx.From)
+	assert.Equal(uint64(12), tx.EthTX.Nonce())
+	assert.Equal(uint64(50000), tx.EthTX.Gas())
+	assert.Len(tx.Errors, 2)
+	assert.Equal(RawSignerType, tx.Signer.Type())
+	assert.Equal(from.Hex(), tx.Signer.Address())
+	assert.False(tx.Replaceable())
+
+	signed, err := tx.Signer.Sign(tx.EthTX)
+	assert.NoError(err)
+	assert.Equal(rawHex, ethbind.API.HexEncode(signed))
+}
+
+func TestNewRawTxnDynamicFee(t *testing.T) {
+	assert := assert.New(t)
+
+	to := ethbind.API.HexToAddress("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832")
+	etx := ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+		ChainID:   big.NewInt(1337),
+		Nonce:     3,
+		GasTipCap: big.NewInt(10),
+		GasFeeCap: big.NewInt(100),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(0),
+	})
+	rawHex, from := newTestRawTxn(t, etx, ethbind.API.NewLondonSigner(big.NewInt(1337)))
+
+	tx, err := NewRawTxn(&messages.SendRawTransaction{RawTransaction: rawHex})
+	assert.NoError(err)
+	assert.Equal(from, tx.From)
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), tx.EthTX.Type())
+	assert.Equal(uint64(3), tx.EthTX.Nonce())
+}
+
+func TestNewRawTxnCannotResign(t *testing.T) {
+	assert := assert.New(t)
+
+	rawHex, _ := newTestLegacyRawTxn(t)
+	tx, err := NewRawTxn(&messages.SendRawTransaction{RawTransaction: rawHex})
+	assert.NoError(err)
+
+	_, err = tx.Signer.Sign(tx.withGas(60000))
+	assert.Regexp("was signed by the submitter, and cannot be re-signed", err)
+
+	err = tx.Replace(context.Background(), &testRPCClient{}, 10, nil)
+	assert.Regexp("cannot be replaced", err)
+}
+
+func TestDecodeRawTransactionBadHex(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, err := DecodeRawTransaction("!!")
+	assert.Regexp("Failed to decode raw transaction", err)
+}
+
+func TestDecodeRawTransactionBadRLP(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, err := DecodeRawTransaction("0xf8")
+	assert.Regexp("Failed to decode raw transaction", err)
+}
+
+func TestDecodeRawTransactionBadSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	to := ethbind.API.HexToAddress("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832")
+	unsigned := ethbind.API.NewTransaction(12, to, big.NewInt(0), 50000, big.NewInt(1000), nil)
+	raw, _ := unsigned.MarshalBinary()
+	_, _, err := DecodeRawTransaction(ethbind.API.HexEncode(raw))
+	assert.Regexp("Failed to recover sender from raw transaction signature", err)
+}
+
+func TestSendRawTxn(t *testing.T) {
+	assert := assert.New(t)
+
+	rawHex, _ := newTestLegacyRawTxn(t)
+	tx, err := NewRawTxn(&messages.SendRawTransaction{RawTransaction: rawHex})
+	assert.NoError(err)
+
+	rpc := &testRPCClient{}
+	err = tx.Send(context.Background(), rpc)
+	assert.NoError(err)
+	assert.Equal("eth_sendRawTransaction", rpc.capturedMethod)
+	assert.Equal(rawHex, rpc.capturedArgs[0])
+}
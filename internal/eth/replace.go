@@ -0,0 +1,285 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replaceable checks whether the transaction can be replaced with a higher priced
+// transaction for the same nonce. The transaction must be public, as the privacy managers
+// do not support replacement, and we must be able to sign the replacement.
+// Where the node assigned the nonce, it is looked up before the replacement is sent
+func (tx *Txn) Replaceable() bool {
+	if _, presigned := tx.Signer.(*rawSigner); presigned {
+		return false
+	}
+	return tx.EthTX != nil && tx.PrivacyGroupID == "" && len(tx.PrivateFor) == 0
+}
+
+// resolveNodeNonce returns the submitted transaction with the nonce the node assigned to it,
+// so that replacements are sent with the same nonce. The transaction itself is not updated
+func (tx *Txn) resolveNodeNonce(ctx context.Context, rpc RPCClient) (*ethbinding.Transaction, error) {
+	if !tx.NodeAssignNonce {
+		return tx.EthTX, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var submitted *struct {
+		Nonce ethbinding.HexUint64 `json:"nonce"`
+	}
+	if err := rpc.CallContext(ctx, &submitted, "eth_getTransactionByHash", tx.Hash); err != nil {
+		return nil, errors.Errorf(errors.TransactionReplaceNonceLookup, tx.Hash, err)
+	}
+	if submitted == nil {
+		return nil, errors.Errorf(errors.TransactionReplaceNonceUnknown, tx.Hash)
+	}
+	log.Infof("TX:%s Nonce %d assigned by node", tx.Hash, submitted.Nonce)
+	return tx.withNonceAndGas(uint64(submitted.Nonce), tx.EthTX.Gas()), nil
+}
+
+// Replace re-submits the transaction with the same nonce, with the gas price (or the
+// EIP-1559 fees) increased by the supplied percentage. Where the fees were left for the node
+// to choose, the current price reported by the node is used as the base.
+// On success all hashes submitted for this transaction are kept in SubmittedHashes,
+// so that whichever one is mined can be found. On failure the transaction is unchanged
+func (tx *Txn) Replace(ctx context.Context, rpc RPCClient, bumpPercent int64, maxGasPrice *big.Int) error {
+	if !tx.Replaceable() {
+		return errors.Errorf(errors.TransactionReplaceNotReplaceable, tx.Hash)
+	}
+	etx, err := tx.resolveNodeNonce(ctx, rpc)
+	if err != nil {
+		return err
+	}
+	replacement, err := tx.bumpFees(ctx, rpc, etx, bumpPercent, maxGasPrice)
+	if err != nil {
+		return err
+	}
+	return tx.replaceWith(ctx, rpc, replacement, tx.IsCancelling())
+}
+
+// ReplaceFees re-submits the transaction with the same nonce, using the supplied gas
+// settings. If no new fee fields are supplied, the existing fees are increased by the
+// supplied percentage, so the node accepts the replacement
+func (tx *Txn) ReplaceFees(ctx context.Context, rpc RPCClient, update *messages.TransactionFees, bumpPercent int64, maxGasPrice *big.Int) error {
+	if !tx.Replaceable() {
+		return errors.Errorf(errors.TransactionReplaceNotReplaceable, tx.Hash)
+	}
+	etx, err := tx.resolveNodeNonce(ctx, rpc)
+	if err != nil {
+		return err
+	}
+	fees := &txnFees{
+		txType:               update.TxType,
+		gasPrice:             update.GasPrice,
+		maxFeePerGas:         update.MaxFeePerGas,
+		maxPriorityFeePerGas: update.MaxPriorityFeePerGas,
+	}
+	if *fees == (txnFees{}) {
+		bumped, err := tx.bumpFees(ctx, rpc, etx, bumpPercent, maxGasPrice)
+		if err != nil {
+			return err
+		}
+		fees = feesOf(bumped)
+	}
+	gas := update.Gas
+	if gas == "" {
+		gas = json.Number(strconv.FormatUint(etx.Gas(), 10))
+	}
+	to := ""
+	if etx.To() != nil {
+		to = etx.To().Hex()
+	}
+	rebuilt := &Txn{}
+	err = rebuilt.genEthTransaction(tx.From.Hex(), to,
+		json.Number(strconv.FormatUint(etx.Nonce(), 10)),
+		json.Number(etx.Value().String()), gas, fees, etx.Data())
+	if err != nil {
+		return err
+	}
+	return tx.replaceWith(ctx, rpc, rebuilt.EthTX, tx.IsCancelling())
+}
+
+// Cancel replaces the transaction with a zero value transfer to the sending address,
+// built in the same way as a gap-fill transaction, with the fees increased by the
+// supplied percentage so the node accepts it as a replacement
+func (tx *Txn) Cancel(ctx context.Context, rpc RPCClient, bumpPercent int64, maxGasPrice *big.Int) error {
+	if !tx.Replaceable() {
+		return errors.Errorf(errors.TransactionReplaceNotReplaceable, tx.Hash)
+	}
+	etx, err := tx.resolveNodeNonce(ctx, rpc)
+	if err != nil {
+		return err
+	}
+	bumped, err := tx.bumpFees(ctx, rpc, etx, bumpPercent, maxGasPrice)
+	if err != nil {
+		return err
+	}
+	nilTX, err := newNilTX(tx.From.Hex(), int64(bumped.Nonce()), tx.Signer, feesOf(bumped))
+	if err != nil {
+		return err
+	}
+	return tx.replaceWith(ctx, rpc, nilTX.EthTX, true)
+}
+
+// IsCancelling is true once a cancel has been submitted for the transaction
+func (tx *Txn) IsCancelling() bool {
+	return len(tx.CancelHashes) > 0
+}
+
+// IsCancelled is true if the mined transaction is one of the cancel transactions
+func (tx *Txn) IsCancelled() bool {
+	for _, hash := range tx.CancelHashes {
+		if hash == tx.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceWith submits the replacement, and records the hashes. The replacement always has
+// the nonce set, including where the node assigned the nonce of the original transaction.
+// On failure the transaction is unchanged
+func (tx *Txn) replaceWith(ctx context.Context, rpc RPCClient, replacement *ethbinding.Transaction, isCancel bool) error {
+	prevTX := tx.EthTX
+	prevHash := tx.Hash
+	prevNodeAssignNonce := tx.NodeAssignNonce
+
+	tx.EthTX = replacement
+	tx.NodeAssignNonce = false
+	if err := tx.Send(ctx, rpc); err != nil {
+		tx.EthTX = prevTX
+		tx.Hash = prevHash
+		tx.NodeAssignNonce = prevNodeAssignNonce
+		return err
+	}
+	if len(tx.SubmittedHashes) == 0 {
+		tx.SubmittedHashes = []string{prevHash}
+	}
+	tx.SubmittedHashes = append(tx.SubmittedHashes, tx.Hash)
+	if isCancel {
+		tx.CancelHashes = append(tx.CancelHashes, tx.Hash)
+	}
+	log.Infof("TX:%s Replaced TX:%s nonce=%d cancel=%t", tx.Hash, prevHash, replacement.Nonce(), isCancel)
+	return nil
+}
+
+// feesOf extracts the fee fields of an existing transaction
+func feesOf(etx *ethbinding.Transaction) *txnFees {
+	if etx.Type() == ethbinding.DynamicFeeTxType {
+		return &txnFees{
+			txType:               json.Number(strconv.Itoa(ethbinding.DynamicFeeTxType)),
+			maxFeePerGas:         json.Number(etx.GasFeeCap().String()),
+			maxPriorityFeePerGas: json.Number(etx.GasTipCap().String()),
+		}
+	}
+	return &txnFees{
+		gasPrice: json.Number(etx.GasPrice().String()),
+	}
+}
+
+func (tx *Txn) bumpFees(ctx context.Context, rpc RPCClient, etx *ethbinding.Transaction, bumpPercent int64, maxGasPrice *big.Int) (*ethbinding.Transaction, error) {
+	if etx.Type() == ethbinding.DynamicFeeTxType {
+		return tx.bumpDynamicFees(ctx, rpc, etx, bumpPercent, maxGasPrice)
+	}
+	return tx.bumpGasPrice(ctx, rpc, etx, bumpPercent, maxGasPrice)
+}
+
+func (tx *Txn) bumpGasPrice(ctx context.Context, rpc RPCClient, etx *ethbinding.Transaction, bumpPercent int64, maxGasPrice *big.Int) (*ethbinding.Transaction, error) {
+	gasPrice := etx.GasPrice()
+	if gasPrice.Sign() == 0 {
+		nodePrice, err := tx.nodeFee(ctx, rpc, "eth_gasPrice")
+		if err != nil {
+			return nil, err
+		}
+		gasPrice = nodePrice
+	}
+	gasPrice = bumpFee(gasPrice, bumpPercent)
+	if maxGasPrice != nil && gasPrice.Cmp(maxGasPrice) > 0 {
+		return nil, errors.Errorf(errors.TransactionReplaceMaxGasPrice, gasPrice.Text(10), maxGasPrice.Text(10))
+	}
+	if etx.To() != nil {
+		return ethbind.API.NewTransaction(etx.Nonce(), *etx.To(), etx.Value(), etx.Gas(), gasPrice, etx.Data()), nil
+	}
+	return ethbind.API.NewContractCreation(etx.Nonce(), etx.Value(), etx.Gas(), gasPrice, etx.Data()), nil
+}
+
+func (tx *Txn) bumpDynamicFees(ctx context.Context, rpc RPCClient, etx *ethbinding.Transaction, bumpPercent int64, maxGasPrice *big.Int) (*ethbinding.Transaction, error) {
+	maxFee := etx.GasFeeCap()
+	maxPriorityFee := etx.GasTipCap()
+	var err error
+	if maxFee.Sign() == 0 {
+		if maxFee, err = tx.nodeFee(ctx, rpc, "eth_gasPrice"); err != nil {
+			return nil, err
+		}
+	}
+	if maxPriorityFee.Sign() == 0 {
+		if maxPriorityFee, err = tx.nodeFee(ctx, rpc, "eth_maxPriorityFeePerGas"); err != nil {
+			return nil, err
+		}
+	}
+	// Both the fee cap and the tip must be increased for the node to accept the replacement
+	maxFee = bumpFee(maxFee, bumpPercent)
+	maxPriorityFee = bumpFee(maxPriorityFee, bumpPercent)
+	if maxPriorityFee.Cmp(maxFee) > 0 {
+		maxFee = maxPriorityFee
+	}
+	if maxGasPrice != nil && maxFee.Cmp(maxGasPrice) > 0 {
+		return nil, errors.Errorf(errors.TransactionReplaceMaxGasPrice, maxFee.Text(10), maxGasPrice.Text(10))
+	}
+	return ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+		ChainID:   etx.ChainId(),
+		Nonce:     etx.Nonce(),
+		To:        etx.To(),
+		Value:     etx.Value(),
+		Gas:       etx.Gas(),
+		GasFeeCap: maxFee,
+		GasTipCap: maxPriorityFee,
+		Data:      etx.Data(),
+	}), nil
+}
+
+// nodeFee queries the node for its current view of a fee
+func (tx *Txn) nodeFee(ctx context.Context, rpc RPCClient, method string) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var fee ethbinding.HexBigInt
+	if err := rpc.CallContext(ctx, &fee, method); err != nil {
+		return nil, errors.Errorf(errors.RPCCallReturnedError, method, err)
+	}
+	return fee.ToInt(), nil
+}
+
+// bumpFee increases the fee by the percentage, and always by at least 1 wei
+func bumpFee(fee *big.Int, bumpPercent int64) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+bumpPercent))
+	bumped.Div(bumped, big.NewInt(100))
+	if bumped.Cmp(fee) <= 0 {
+		bumped.Add(fee, big.NewInt(1))
+	}
+	return bumped
+}
@@ -0,0 +1,395 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReplaceTxn(t *testing.T, txType, gasPrice, maxFee, maxPriorityFee json.Number) (*Txn, *mockTXSigner) {
+	var msg messages.SendTransaction
+	msg.Parameters = []interface{}{}
+	msg.MethodName = "testFunc"
+	msg.To = "0x2b8c0ECc76d0759a8F50b2E14A6881367D805832"
+	msg.From = "hd-u0abcd1234-u0bcde9876-12345"
+	msg.Nonce = "5"
+	msg.Gas = "50000"
+	msg.TxType = txType
+	msg.GasPrice = gasPrice
+	msg.MaxFeePerGas = maxFee
+	msg.MaxPriorityFeePerGas = maxPriorityFee
+
+	signer := &mockTXSigner{
+		signed: []byte("testbytes"),
+		from:   "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
+	}
+	tx, err := NewSendTxn(&msg, signer)
+	assert.NoError(t, err)
+	tx.Hash = "0x111"
+	return tx, signer
+}
+
+func replaceRPC(nodeFees map[string]int64, newHash string) *MockRPCClient {
+	return NewMockRPCClientForSync(nil, func(method string, res interface{}, args ...interface{}) {
+		switch method {
+		case "eth_sendRawTransaction":
+			*(res.(*string)) = newHash
+		case "eth_getTransactionByHash":
+			json.Unmarshal([]byte(fmt.Sprintf(`{"nonce":"0x%x"}`, nodeFees[method])), res)
+		default:
+			res.(*ethbinding.HexBigInt).ToInt().SetInt64(nodeFees[method])
+		}
+	})
+}
+
+func TestReplaceLegacyGasPrice(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "100", "", "")
+	err := tx.Replace(context.Background(), replaceRPC(nil, "0x222"), 10, nil)
+	assert.NoError(err)
+
+	assert.Equal(uint8(ethbinding.LegacyTxType), signer.capturedTX.Type())
+	assert.Equal("110", signer.capturedTX.GasPrice().String())
+	assert.Equal(uint64(5), signer.capturedTX.Nonce())
+	assert.Equal(uint64(50000), signer.capturedTX.Gas())
+	assert.Equal("0x222", tx.Hash)
+	assert.Equal([]string{"0x111", "0x222"}, tx.SubmittedHashes)
+
+	err = tx.Replace(context.Background(), replaceRPC(nil, "0x333"), 10, nil)
+	assert.NoError(err)
+	assert.Equal("121", signer.capturedTX.GasPrice().String())
+	assert.Equal([]string{"0x111", "0x222", "0x333"}, tx.SubmittedHashes)
+}
+
+func TestReplaceLegacyNodeGasPrice(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "", "", "")
+	rpc := replaceRPC(map[string]int64{"eth_gasPrice": 1000}, "0x222")
+	err := tx.Replace(context.Background(), rpc, 20, nil)
+	assert.NoError(err)
+	assert.Equal("1200", signer.capturedTX.GasPrice().String())
+}
+
+func TestReplaceDynamicFees(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "", "200", "10")
+	err := tx.Replace(context.Background(), replaceRPC(nil, "0x222"), 10, nil)
+	assert.NoError(err)
+
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), signer.capturedTX.Type())
+	assert.Equal("220", signer.capturedTX.GasFeeCap().String())
+	assert.Equal("11", signer.capturedTX.GasTipCap().String())
+	assert.Equal(uint64(5), signer.capturedTX.Nonce())
+	assert.Equal([]string{"0x111", "0x222"}, tx.SubmittedHashes)
+}
+
+func TestReplaceDynamicNodeFees(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "2", "", "", "")
+	rpc := replaceRPC(map[string]int64{
+		"eth_gasPrice":             1000,
+		"eth_maxPriorityFeePerGas": 2,
+	}, "0x222")
+	err := tx.Replace(context.Background(), rpc, 10, nil)
+	assert.NoError(err)
+
+	assert.Equal("1100", signer.capturedTX.GasFeeCap().String())
+	assert.Equal("3", signer.capturedTX.GasTipCap().String())
+}
+
+func TestReplaceDynamicTipAboveFeeCap(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "", "10", "")
+	rpc := replaceRPC(map[string]int64{"eth_maxPriorityFeePerGas": 100}, "0x222")
+	err := tx.Replace(context.Background(), rpc, 10, nil)
+	assert.NoError(err)
+	assert.Equal("110", signer.capturedTX.GasFeeCap().String())
+	assert.Equal("110", signer.capturedTX.GasTipCap().String())
+}
+
+func TestReplaceMaxGasPrice(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	origTX := tx.EthTX
+	err := tx.Replace(context.Background(), replaceRPC(nil, "0x222"), 10, big.NewInt(105))
+	assert.EqualError(err, "Replacement gas price 110 would exceed the configured maximum of 105")
+	assert.Equal(origTX, tx.EthTX)
+	assert.Equal("0x111", tx.Hash)
+	assert.Empty(tx.SubmittedHashes)
+}
+
+func TestReplaceDynamicMaxGasPrice(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "", "200", "10")
+	err := tx.Replace(context.Background(), replaceRPC(nil, "0x222"), 10, big.NewInt(210))
+	assert.EqualError(err, "Replacement gas price 220 would exceed the configured maximum of 210")
+}
+
+func TestReplaceSendFails(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	origTX := tx.EthTX
+	err := tx.Replace(context.Background(), NewMockRPCClientForSync(fmt.Errorf("pop"), nil), 10, nil)
+	assert.EqualError(err, "pop")
+	assert.Equal(origTX, tx.EthTX)
+	assert.Equal("0x111", tx.Hash)
+	assert.Empty(tx.SubmittedHashes)
+}
+
+func TestReplaceNodeGasPriceFails(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "", "", "")
+	err := tx.Replace(context.Background(), NewMockRPCClientForSync(fmt.Errorf("pop"), nil), 10, nil)
+	assert.EqualError(err, "eth_gasPrice returned: pop")
+}
+
+func TestReplaceDynamicNodeFeesFail(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "2", "", "", "")
+	err := tx.Replace(context.Background(), NewMockRPCClientForSync(fmt.Errorf("pop"), nil), 10, nil)
+	assert.EqualError(err, "eth_gasPrice returned: pop")
+
+	tx, _ = newTestReplaceTxn(t, "", "", "100", "")
+	err = tx.Replace(context.Background(), NewMockRPCClientForSync(fmt.Errorf("pop"), nil), 10, nil)
+	assert.EqualError(err, "eth_maxPriorityFeePerGas returned: pop")
+}
+
+func TestReplaceNotReplaceable(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	tx.PrivateFor = []string{"member1"}
+	assert.False(tx.Replaceable())
+	err := tx.Replace(context.Background(), replaceRPC(nil, "0x222"), 10, nil)
+	assert.EqualError(err, "Transaction 0x111 cannot be replaced, as it is private, or it was signed by the submitter")
+
+	tx, _ = newTestReplaceTxn(t, "", "100", "", "")
+	tx.PrivacyGroupID = "group1"
+	assert.False(tx.Replaceable())
+}
+
+func TestReplaceNodeAssignedNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "100", "", "")
+	tx.NodeAssignNonce = true
+	assert.True(tx.Replaceable())
+	rpc := replaceRPC(map[string]int64{"eth_getTransactionByHash": 9}, "0x222")
+	err := tx.Replace(context.Background(), rpc, 10, nil)
+	assert.NoError(err)
+
+	assert.Equal(uint64(9), signer.capturedTX.Nonce())
+	assert.Equal("110", signer.capturedTX.GasPrice().String())
+	assert.False(tx.NodeAssignNonce)
+	assert.Equal([]string{"0x111", "0x222"}, tx.SubmittedHashes)
+}
+
+func TestReplaceNodeAssignedNonceSendFails(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "100", "", "")
+	tx.NodeAssignNonce = true
+	origTX := tx.EthTX
+	signer.signErr = fmt.Errorf("pop")
+	rpc := replaceRPC(map[string]int64{"eth_getTransactionByHash": 9}, "0x222")
+	err := tx.Replace(context.Background(), rpc, 10, nil)
+	assert.EqualError(err, "pop")
+
+	// The nonce from the node is only applied once a replacement is sent
+	assert.Equal(origTX, tx.EthTX)
+	assert.True(tx.NodeAssignNonce)
+	assert.Equal("0x111", tx.Hash)
+	assert.Empty(tx.SubmittedHashes)
+}
+
+func TestReplaceNodeAssignedNonceUnknown(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	tx.NodeAssignNonce = true
+	rpc := NewMockRPCClientForSync(nil, func(method string, res interface{}, args ...interface{}) {})
+	err := tx.Cancel(context.Background(), rpc, 10, nil)
+	assert.EqualError(err, "Transaction 0x111 was not found on the node, so the nonce assigned to it is unknown")
+	assert.True(tx.NodeAssignNonce)
+	assert.Equal("0x111", tx.Hash)
+}
+
+func TestReplaceNodeAssignedNonceLookupFail(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	tx.NodeAssignNonce = true
+	err := tx.ReplaceFees(context.Background(), NewMockRPCClientForSync(fmt.Errorf("pop"), nil), &messages.TransactionFees{}, 10, nil)
+	assert.EqualError(err, "Failed to find the nonce assigned by the node to transaction 0x111: pop")
+}
+
+func TestReplaceFeesLegacy(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "100", "", "")
+	err := tx.ReplaceFees(context.Background(), replaceRPC(nil, "0x222"), &messages.TransactionFees{
+		GasPrice: "500",
+		Gas:      "60000",
+	}, 10, nil)
+	assert.NoError(err)
+
+	assert.Equal("500", signer.capturedTX.GasPrice().String())
+	assert.Equal(uint64(60000), signer.capturedTX.Gas())
+	assert.Equal(uint64(5), signer.capturedTX.Nonce())
+	assert.Equal("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832", signer.capturedTX.To().Hex())
+	assert.Equal([]string{"0x111", "0x222"}, tx.SubmittedHashes)
+	assert.False(tx.IsCancelling())
+}
+
+func TestReplaceFeesSwitchToDynamic(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "100", "", "")
+	err := tx.ReplaceFees(context.Background(), replaceRPC(nil, "0x222"), &messages.TransactionFees{
+		MaxFeePerGas:         "300",
+		MaxPriorityFeePerGas: "20",
+	}, 10, nil)
+	assert.NoError(err)
+
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), signer.capturedTX.Type())
+	assert.Equal("300", signer.capturedTX.GasFeeCap().String())
+	assert.Equal("20", signer.capturedTX.GasTipCap().String())
+	assert.Equal(uint64(50000), signer.capturedTX.Gas())
+}
+
+func TestReplaceFeesGasOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "", "200", "10")
+	err := tx.ReplaceFees(context.Background(), replaceRPC(nil, "0x222"), &messages.TransactionFees{
+		Gas: "70000",
+	}, 10, nil)
+	assert.NoError(err)
+
+	// The fees are bumped, so the node accepts the replacement
+	assert.Equal(uint64(70000), signer.capturedTX.Gas())
+	assert.Equal("220", signer.capturedTX.GasFeeCap().String())
+	assert.Equal("11", signer.capturedTX.GasTipCap().String())
+}
+
+func TestReplaceFeesGasOnlyMaxGasPrice(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	err := tx.ReplaceFees(context.Background(), replaceRPC(nil, "0x222"), &messages.TransactionFees{
+		Gas: "70000",
+	}, 10, big.NewInt(105))
+	assert.Regexp("Replacement gas price 110 would exceed the configured maximum of 105", err)
+	assert.Equal("0x111", tx.Hash)
+}
+
+func TestReplaceFeesBadFees(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	err := tx.ReplaceFees(context.Background(), replaceRPC(nil, "0x222"), &messages.TransactionFees{
+		GasPrice:     "100",
+		MaxFeePerGas: "300",
+	}, 10, nil)
+	assert.Regexp("gasPrice", err)
+	assert.Equal("0x111", tx.Hash)
+	assert.Empty(tx.SubmittedHashes)
+}
+
+func TestReplaceFeesNotReplaceable(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	tx.PrivateFor = []string{"member1"}
+	err := tx.ReplaceFees(context.Background(), replaceRPC(nil, "0x222"), &messages.TransactionFees{}, 10, nil)
+	assert.Regexp("cannot be replaced", err)
+}
+
+func TestCancelLegacy(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "100", "", "")
+	err := tx.Cancel(context.Background(), replaceRPC(nil, "0x222"), 10, nil)
+	assert.NoError(err)
+
+	assert.Equal("110", signer.capturedTX.GasPrice().String())
+	assert.Equal(uint64(5), signer.capturedTX.Nonce())
+	assert.Equal(signer.from, signer.capturedTX.To().Hex())
+	assert.Equal("0", signer.capturedTX.Value().String())
+	assert.Empty(signer.capturedTX.Data())
+	assert.Equal([]string{"0x111", "0x222"}, tx.SubmittedHashes)
+	assert.Equal([]string{"0x222"}, tx.CancelHashes)
+	assert.True(tx.IsCancelling())
+	assert.True(tx.IsCancelled())
+
+	// A further replacement of a cancel is also a cancel
+	err = tx.Replace(context.Background(), replaceRPC(nil, "0x333"), 10, nil)
+	assert.NoError(err)
+	assert.Equal([]string{"0x222", "0x333"}, tx.CancelHashes)
+	assert.Empty(signer.capturedTX.Data())
+
+	// The original might still be the one that is mined
+	tx.Hash = "0x111"
+	assert.False(tx.IsCancelled())
+}
+
+func TestCancelDynamicFees(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, signer := newTestReplaceTxn(t, "", "", "200", "10")
+	err := tx.Cancel(context.Background(), replaceRPC(nil, "0x222"), 10, nil)
+	assert.NoError(err)
+
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), signer.capturedTX.Type())
+	assert.Equal("220", signer.capturedTX.GasFeeCap().String())
+	assert.Equal("11", signer.capturedTX.GasTipCap().String())
+	assert.Equal(signer.from, signer.capturedTX.To().Hex())
+}
+
+func TestCancelFails(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, _ := newTestReplaceTxn(t, "", "100", "", "")
+	err := tx.Cancel(context.Background(), replaceRPC(nil, "0x222"), 10, big.NewInt(105))
+	assert.Regexp("would exceed", err)
+	assert.False(tx.IsCancelling())
+
+	err = tx.Cancel(context.Background(), NewMockRPCClientForSync(fmt.Errorf("pop"), nil), 10, nil)
+	assert.EqualError(err, "pop")
+	assert.False(tx.IsCancelling())
+	assert.Equal("0x111", tx.Hash)
+
+	tx.PrivacyGroupID = "group1"
+	err = tx.Cancel(context.Background(), replaceRPC(nil, "0x222"), 10, nil)
+	assert.Regexp("cannot be replaced", err)
+}
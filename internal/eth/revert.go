@@ -0,0 +1,253 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	panicFunctionSelector = "0x4e487b71" // the signature of Panic(uint256), used by Solidity 0.8.0+ for assert failures and runtime checks
+)
+
+// panicReasons are the codes Solidity uses for a Panic(uint256), per
+// https://docs.soliditylang.org/en/v0.8.9/control-structures.html#panic-via-assert-and-error-via-require
+var panicReasons = map[uint64]string{
+	0x00: "Generic compiler inserted panic",
+	0x01: "Assertion failed",
+	0x11: "Arithmetic operation underflowed or overflowed outside of an unchecked block",
+	0x12: "Division or modulo by zero",
+	0x21: "Value too big or negative converted into an enum type",
+	0x22: "Incorrectly encoded storage byte array accessed",
+	0x31: "pop() called on an empty array",
+	0x32: "Array index out of bounds",
+	0x41: "Too much memory allocated, or an array created that is too large",
+	0x51: "Zero-initialized variable of internal function type called",
+}
+
+// RevertError is returned when a transaction or call reverted, with the reason
+// decoded from the return data
+type RevertError struct {
+	Err     error
+	Details *messages.RevertError
+}
+
+func (e *RevertError) Error() string {
+	return e.Err.Error()
+}
+
+// Revert returns the decoded reason for the revert, to include in replies
+func (e *RevertError) Revert() *messages.RevertError {
+	return e.Details
+}
+
+// rpcDataError is implemented by JSON/RPC errors that carry data, such as the return
+// data of a call that reverted
+type rpcDataError interface {
+	ErrorData() interface{}
+}
+
+// rpcErrorData returns the hex data attached to a JSON/RPC error, if any
+func rpcErrorData(err error) string {
+	if dataErr, ok := err.(rpcDataError); ok {
+		hexString, _ := dataErr.ErrorData().(string)
+		return hexString
+	}
+	return ""
+}
+
+// ErrorsFromABI returns the custom error entries of an ABI
+func ErrorsFromABI(abi ethbinding.ABIMarshaling) []*ethbinding.ABIElementMarshaling {
+	var abiErrors []*ethbinding.ABIElementMarshaling
+	for i := range abi {
+		if abi[i].Type == "error" {
+			abiErrors = append(abiErrors, &abi[i])
+		}
+	}
+	return abiErrors
+}
+
+// decodeRevert checks if the hex return data of a call is a revert, and if so decodes it.
+// Error(string) and Panic(uint256) are built into Solidity, and custom errors are matched
+// against the error entries of the ABI. Returns nil if the data is not a revert we recognize
+func decodeRevert(hexString string, abiErrors []*ethbinding.ABIElementMarshaling) *RevertError {
+	if reason, reverted, err := decodeRevertReason(hexString); reverted {
+		revert := &RevertError{
+			Details: &messages.RevertError{
+				Name:      "Error",
+				Signature: "Error(string)",
+			},
+		}
+		if err != nil {
+			revert.Err = errors.Errorf(errors.TransactionSendCallFailedRevertNoMessage)
+		} else {
+			revert.Err = errors.Errorf(errors.TransactionSendCallFailedRevertMessage, reason)
+			revert.Details.Args = map[string]interface{}{"reason": reason}
+		}
+		return revert
+	}
+	if strings.HasPrefix(hexString, panicFunctionSelector) && len(hexString) == 74 {
+		return decodePanic(hexString)
+	}
+	return decodeCustomError(hexString, abiErrors)
+}
+
+func decodePanic(hexString string) *RevertError {
+	code := new(big.Int)
+	if _, ok := code.SetString(hexString[10:74], 16); !ok {
+		return nil
+	}
+	reason := "Unknown panic code"
+	if known, ok := panicReasons[code.Uint64()]; ok && code.IsUint64() {
+		reason = known
+	}
+	log.Warnf("EVM Reverted. Panic=0x%x Reason='%s'", code, reason)
+	return &RevertError{
+		Err: errors.Errorf(errors.TransactionSendCallFailedRevertPanic, code, reason),
+		Details: &messages.RevertError{
+			Name:      "Panic",
+			Signature: "Panic(uint256)",
+			Args: map[string]interface{}{
+				"code":   "0x" + code.Text(16),
+				"reason": reason,
+			},
+		},
+	}
+}
+
+func decodeCustomError(hexString string, abiErrors []*ethbinding.ABIElementMarshaling) *RevertError {
+	data, err := ethbind.API.HexDecode(hexString)
+	if err != nil || len(data) < 4 {
+		return nil
+	}
+	for _, abiError := range abiErrors {
+		inputs, err := ethbind.API.ABIArgumentsMarshalingToABIArguments(abiError.Inputs)
+		if err != nil {
+			log.Warnf("Invalid custom error '%s' in ABI: %s", abiError.Name, err)
+			continue
+		}
+		types := make([]string, len(inputs))
+		for i, input := range inputs {
+			types[i] = input.Type.String()
+		}
+		signature := abiError.Name + "(" + strings.Join(types, ",") + ")"
+		if !bytes.Equal(ethbind.API.Keccak256([]byte(signature))[0:4], data[0:4]) {
+			continue
+		}
+		args := ProcessRLPBytes(inputs, data[4:])
+		argsJSON, _ := json.Marshal(&args)
+		log.Warnf("EVM Reverted. CustomError=%s Args=%s", signature, argsJSON)
+		return &RevertError{
+			Err: errors.Errorf(errors.TransactionSendCallFailedRevertCustomError, abiError.Name, argsJSON),
+			Details: &messages.RevertError{
+				Name:      abiError.Name,
+				Signature: signature,
+				Args:      args,
+			},
+		}
+	}
+	return nil
+}
+
+// callTraceResult is the part of the debug_traceTransaction output of the callTracer we need.
+// The output of the top-level call is the revert data, if the error is a revert
+type callTraceResult struct {
+	Output string `json:"output"`
+	Error  string `json:"error"`
+}
+
+// MinedRevertReason finds why a transaction that was mined failed. The return data is
+// taken from the callTracer of debug_traceTransaction when useTrace is set and the node supports it, and
+// otherwise by replaying the transaction with eth_call against the parent of the block
+// it was mined in. The replay does not include the transactions earlier in the same
+// block, so the state might differ. Returns a nil revert if no reason could be decoded
+func (tx *Txn) MinedRevertReason(ctx context.Context, rpc RPCClient, useTrace bool) (revert *RevertError, revertData string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	hash := tx.Hash
+	if tx.Receipt.TransactionHash != nil {
+		hash = tx.Receipt.TransactionHash.Hex()
+	}
+	traced := false
+	if useTrace {
+		var result callTraceResult
+		traceOpts := map[string]interface{}{"tracer": "callTracer"}
+		if traceErr := rpc.CallContext(ctx, &result, "debug_traceTransaction", hash, traceOpts); traceErr != nil {
+			log.Warnf("Failed to trace transaction %s. Replaying with eth_call: %s", hash, traceErr)
+		} else {
+			traced = true
+			log.Debugf("Trace of transaction %s failed with '%s'", hash, result.Error)
+			revertData = result.Output
+			if revertData != "" && !strings.HasPrefix(revertData, "0x") {
+				revertData = "0x" + revertData
+			}
+		}
+	}
+	if !traced {
+		if revertData, err = tx.replayForRevert(ctx, rpc, hash); err != nil {
+			return nil, "", err
+		}
+	}
+	if revertData == "0x" {
+		revertData = ""
+	}
+	return decodeRevert(revertData, tx.Errors), revertData, nil
+}
+
+// replayForRevert calls the transaction at the parent block, and returns the revert data
+func (tx *Txn) replayForRevert(ctx context.Context, rpc RPCClient, hash string) (string, error) {
+	if tx.EthTX == nil || tx.Receipt.BlockNumber == nil || tx.PrivateFor != nil || tx.PrivacyGroupID != "" {
+		return "", errors.Errorf(errors.TransactionRevertReasonNoReplay, hash)
+	}
+	parentBlock := new(big.Int).Set(tx.Receipt.BlockNumber.ToInt())
+	if parentBlock.Sign() > 0 {
+		parentBlock.Sub(parentBlock, big.NewInt(1))
+	}
+	// Fees are omitted, as the base fee of the parent block might be higher
+	txArgs := tx.sendTXArgs()
+	txArgs.GasPrice = nil
+	txArgs.MaxFeePerGas = nil
+	txArgs.MaxPriorityFeePerGas = nil
+	gas := ethbinding.HexUint64(tx.EthTX.Gas())
+	txArgs.Gas = &gas
+	if tx.Receipt.From != nil {
+		txArgs.From = tx.Receipt.From.Hex()
+	}
+
+	var hexString string
+	if err := rpc.CallContext(ctx, &hexString, "eth_call", txArgs, ethbind.API.EncodeBig(parentBlock)); err != nil {
+		// Nodes that return the revert as an error, include the return data with it
+		if data := rpcErrorData(err); data != "" {
+			return data, nil
+		}
+		if strings.Contains(err.Error(), executionReverted) {
+			return "", nil
+		}
+		return "", errors.Errorf(errors.TransactionRevertReasonFailed, hash, err)
+	}
+	return hexString, nil
+}
@@ -0,0 +1,325 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCustomErrorABI = ethbinding.ABIMarshaling{
+	{
+		Type: "function",
+		Name: "transfer",
+	},
+	{
+		Type: "error",
+		Name: "InsufficientBalance",
+		Inputs: []ethbinding.ABIArgumentMarshaling{
+			{Name: "available", Type: "uint256"},
+			{Name: "required", Type: "uint256"},
+		},
+	},
+	{
+		Type: "error",
+		Name: "Unauthorized",
+	},
+}
+
+func testCustomErrorData(signature string, words ...string) string {
+	selector := ethbind.API.Keccak256([]byte(signature))[0:4]
+	data := "0x" + hex.EncodeToString(selector)
+	for _, w := range words {
+		data += fmt.Sprintf("%064s", w)
+	}
+	return data
+}
+
+func TestErrorsFromABI(t *testing.T) {
+	assert := assert.New(t)
+
+	abiErrors := ErrorsFromABI(testCustomErrorABI)
+	assert.Len(abiErrors, 2)
+	assert.Equal("InsufficientBalance", abiErrors[0].Name)
+	assert.Equal("Unauthorized", abiErrors[1].Name)
+	assert.Nil(ErrorsFromABI(ethbinding.ABIMarshaling{}))
+}
+
+func TestDecodeRevertErrorString(t *testing.T) {
+	assert := assert.New(t)
+
+	revertErr := decodeRevert(testRevertData, nil)
+	assert.EqualError(revertErr, "Muppetry detected")
+	assert.Equal(&messages.RevertError{
+		Name:      "Error",
+		Signature: "Error(string)",
+		Args:      map[string]interface{}{"reason": "Muppetry detected"},
+	}, revertErr.Revert())
+}
+
+func TestDecodeRevertPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	revertErr := decodeRevert(panicFunctionSelector+fmt.Sprintf("%064x", 0x11), nil)
+	assert.EqualError(revertErr, "EVM reverted with panic code 0x11: Arithmetic operation underflowed or overflowed outside of an unchecked block")
+	assert.Equal("Panic", revertErr.Details.Name)
+	assert.Equal("Panic(uint256)", revertErr.Details.Signature)
+	assert.Equal("0x11", revertErr.Details.Args["code"])
+
+	revertErr = decodeRevert(panicFunctionSelector+fmt.Sprintf("%064x", 0x99), nil)
+	assert.EqualError(revertErr, "EVM reverted with panic code 0x99: Unknown panic code")
+
+	revertErr = decodeRevert(panicFunctionSelector+"ff"+fmt.Sprintf("%062x", 0x01), nil)
+	assert.Regexp("Unknown panic code", revertErr)
+
+	assert.Nil(decodeRevert(panicFunctionSelector+fmt.Sprintf("%062s", "!!"), nil))
+}
+
+func TestDecodeRevertCustomError(t *testing.T) {
+	assert := assert.New(t)
+
+	abiErrors := ErrorsFromABI(testCustomErrorABI)
+	revertErr := decodeRevert(testCustomErrorData("InsufficientBalance(uint256,uint256)", "a", "14"), abiErrors)
+	assert.EqualError(revertErr, `EVM reverted with custom error InsufficientBalance: {"available":"10","required":"20"}`)
+	assert.Equal(&messages.RevertError{
+		Name:      "InsufficientBalance",
+		Signature: "InsufficientBalance(uint256,uint256)",
+		Args: map[string]interface{}{
+			"available": "10",
+			"required":  "20",
+		},
+	}, revertErr.Revert())
+
+	revertErr = decodeRevert(testCustomErrorData("Unauthorized()"), abiErrors)
+	assert.Equal("Unauthorized()", revertErr.Details.Signature)
+}
+
+func TestDecodeRevertNotRecognized(t *testing.T) {
+	assert := assert.New(t)
+
+	abiErrors := ErrorsFromABI(testCustomErrorABI)
+	assert.Nil(decodeRevert(testCustomErrorData("Other(uint256)", "1"), abiErrors))
+	assert.Nil(decodeRevert("0x0000000000000000000000000000000000000000000000000000000000000001", nil))
+	assert.Nil(decodeRevert("0x01", abiErrors))
+	assert.Nil(decodeRevert("", abiErrors))
+	assert.Nil(decodeRevert("!!", abiErrors))
+}
+
+func TestDecodeRevertBadCustomErrorABI(t *testing.T) {
+	assert := assert.New(t)
+
+	abiErrors := []*ethbinding.ABIElementMarshaling{
+		{
+			Type: "error",
+			Name: "Bad",
+			Inputs: []ethbinding.ABIArgumentMarshaling{
+				{Name: "x", Type: "badness"},
+			},
+		},
+	}
+	assert.Nil(decodeRevert(testCustomErrorData("Bad(badness)"), abiErrors))
+}
+
+func TestCallMethodRevertCustomErrorInRPCError(t *testing.T) {
+	assert := assert.New(t)
+
+	method := &ethbinding.ABIMethod{}
+	method.Name = "testFunc"
+
+	rpc := &testRPCClient{
+		mockError: &testRPCDataError{
+			msg:  "execution reverted",
+			data: testCustomErrorData("InsufficientBalance(uint256,uint256)", "a", "14"),
+		},
+	}
+
+	_, err := CallMethod(context.Background(), rpc, nil,
+		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
+		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
+		json.Number("12345"), method, []interface{}{}, "", ErrorsFromABI(testCustomErrorABI))
+
+	assert.Regexp("InsufficientBalance", err)
+	revert := messages.RevertFromError(err)
+	assert.Equal("InsufficientBalance", revert.Name)
+	assert.Equal("20", revert.Args["required"])
+}
+
+func TestCallMethodRevertPanicInResult(t *testing.T) {
+	assert := assert.New(t)
+
+	method := &ethbinding.ABIMethod{}
+	method.Name = "testFunc"
+
+	rpc := &testRPCClient{
+		resultWrangler: func(retString interface{}) {
+			retVal := panicFunctionSelector + fmt.Sprintf("%064x", 0x32)
+			reflect.ValueOf(retString).Elem().Set(reflect.ValueOf(retVal))
+		},
+	}
+
+	_, err := CallMethod(context.Background(), rpc, nil,
+		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
+		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
+		json.Number("12345"), method, []interface{}{}, "", nil)
+
+	assert.EqualError(err, "EVM reverted with panic code 0x32: Array index out of bounds")
+	assert.Equal("Panic", messages.RevertFromError(err).Name)
+}
+
+func TestCallMethodRPCErrorNoRevertData(t *testing.T) {
+	assert := assert.New(t)
+
+	method := &ethbinding.ABIMethod{}
+	method.Name = "testFunc"
+
+	rpc := &testRPCClient{
+		mockError: &testRPCDataError{
+			msg:  "execution reverted",
+			data: "0x",
+		},
+	}
+
+	_, err := CallMethod(context.Background(), rpc, nil,
+		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
+		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
+		json.Number("12345"), method, []interface{}{}, "", nil)
+
+	assert.EqualError(err, "Call failed: execution reverted")
+	assert.Nil(messages.RevertFromError(err))
+}
+
+func TestNewSendTxnRetainsErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.SendTransaction
+	msg.MethodName = "testFunc"
+	msg.To = "0x2b8c0ECc76d0759a8F50b2E14A6881367D805832"
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.Nonce = "123"
+	msg.Gas = "456"
+	msg.Errors = ErrorsFromABI(testCustomErrorABI)
+	tx, err := NewSendTxn(&msg, nil)
+	assert.NoError(err)
+	assert.Equal(msg.Errors, tx.Errors)
+}
+
+func newTestMinedTxn() *Txn {
+	tx := newTestSimulateTxn(50000)
+	blockNumber := ethbinding.HexBigInt(*big.NewInt(100))
+	txHash := ethbind.API.HexToHash("0xe2215336b09f9b5b82e36e1144ed64f40a42e61b68fdaca82549fd98b8531a89")
+	tx.Receipt.BlockNumber = &blockNumber
+	tx.Receipt.TransactionHash = &txHash
+	return tx
+}
+
+func TestMinedRevertReasonCall(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		callErr: &testRPCDataError{
+			msg:  "execution reverted",
+			data: panicFunctionSelector + fmt.Sprintf("%064x", 0x01),
+		},
+	}
+	revert, revertData, err := newTestMinedTxn().MinedRevertReason(context.Background(), rpc, false)
+	assert.NoError(err)
+	assert.Equal("Panic", revert.Details.Name)
+	assert.Equal(panicFunctionSelector+fmt.Sprintf("%064x", 0x01), revertData)
+	assert.Equal("0x63", rpc.callArgs[1])
+	txArgs := rpc.callArgs[0].(*SendTXArgs)
+	assert.Equal(uint64(50000), uint64(*txArgs.Gas))
+	assert.Nil(txArgs.GasPrice)
+}
+
+func TestMinedRevertReasonCallNoData(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		callErr: fmt.Errorf("execution reverted"),
+	}
+	revert, revertData, err := newTestMinedTxn().MinedRevertReason(context.Background(), rpc, false)
+	assert.NoError(err)
+	assert.Nil(revert)
+	assert.Equal("", revertData)
+
+	rpc = &simulateRPCClient{
+		callResult: "0x",
+	}
+	revert, revertData, err = newTestMinedTxn().MinedRevertReason(context.Background(), rpc, false)
+	assert.NoError(err)
+	assert.Nil(revert)
+	assert.Equal("", revertData)
+}
+
+func TestMinedRevertReasonCallFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		callErr: fmt.Errorf("pop"),
+	}
+	_, _, err := newTestMinedTxn().MinedRevertReason(context.Background(), rpc, false)
+	assert.Regexp("Failed to find the revert reason.*pop", err)
+}
+
+func TestMinedRevertReasonNoReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := newTestMinedTxn()
+	tx.EthTX = nil
+	_, _, err := tx.MinedRevertReason(context.Background(), &simulateRPCClient{}, false)
+	assert.Regexp("cannot be replayed", err)
+
+	tx = newTestMinedTxn()
+	tx.PrivateFor = []string{"node1"}
+	_, _, err = tx.MinedRevertReason(context.Background(), &simulateRPCClient{}, false)
+	assert.Regexp("cannot be replayed", err)
+}
+
+func TestMinedRevertReasonTrace(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := NewMockRPCClientForSync(nil, func(method string, res interface{}, args ...interface{}) {
+		json.Unmarshal([]byte(`{"type":"CALL","error":"execution reverted","output":"`+testRevertData+`"}`), res)
+	})
+	revert, revertData, err := newTestMinedTxn().MinedRevertReason(context.Background(), rpc, true)
+	assert.NoError(err)
+	assert.Equal("Muppetry detected", revert.Error())
+	assert.Equal(testRevertData, revertData)
+	assert.Equal("debug_traceTransaction", rpc.MethodCapture)
+	assert.Equal("0xe2215336b09f9b5b82e36e1144ed64f40a42e61b68fdaca82549fd98b8531a89", rpc.ArgsCapture[0])
+	assert.Equal(map[string]interface{}{"tracer": "callTracer"}, rpc.ArgsCapture[1])
+}
+
+func TestMinedRevertReasonTraceNoOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := NewMockRPCClientForSync(nil, func(method string, res interface{}, args ...interface{}) {
+		json.Unmarshal([]byte(`{"type":"CALL","error":"out of gas"}`), res)
+	})
+	revert, revertData, err := newTestMinedTxn().MinedRevertReason(context.Background(), rpc, true)
+	assert.NoError(err)
+	assert.Nil(revert)
+	assert.Empty(revertData)
+}
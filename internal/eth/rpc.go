@@ -36,18 +36,49 @@ type RPCConf struct {
 	RPC RPCConnOpts `json:"rpc"`
 }
 
-// RPCConnOpts configuration params
+// RPCConnOpts configuration params. When multiple URLs are configured, requests are spread
+// across the endpoints according to the selection policy, with failover between them
 type RPCConnOpts struct {
-	URL string `json:"url"`
+	URL                   string   `json:"url"`
+	URLs                  []string `json:"urls,omitempty"`
+	Selection             string   `json:"selection,omitempty"`
+	HealthCheckIntervalMS int      `json:"healthCheckIntervalMS,omitempty"`
+}
+
+// Endpoints returns the URL followed by the additional URLs
+func (c *RPCConnOpts) Endpoints() []string {
+	var urls []string
+	if c.URL != "" {
+		urls = append(urls, c.URL)
+	}
+	for _, u := range c.URLs {
+		if u != "" && u != c.URL {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// Configured is true if at least one URL is set
+func (c *RPCConnOpts) Configured() bool {
+	return len(c.Endpoints()) > 0
 }
 
 // RPCConnect wraps rpc.Dial with useful logging, avoiding logging username/password
 func RPCConnect(conf *RPCConnOpts) (RPCClientAll, error) {
-	u, _ := url.Parse(conf.URL)
+	endpoints := conf.Endpoints()
+	if len(endpoints) > 1 {
+		return newRPCPool(conf, dialEndpoint)
+	}
+	rpcURL := conf.URL
+	if len(endpoints) == 1 {
+		rpcURL = endpoints[0]
+	}
+	u, _ := url.Parse(rpcURL)
 	if u.User != nil {
 		u.User = url.UserPassword(u.User.Username(), "xxxxxx")
 	}
-	rpcClient, err := ethbind.API.Dial(conf.URL)
+	rpcClient, err := ethbind.API.Dial(rpcURL)
 	if err != nil {
 		return nil, errors.Errorf(errors.RPCConnectFailed, u, err)
 	}
@@ -66,6 +97,7 @@ func CobraInitRPC(cmd *cobra.Command, rconf *RPCConf) {
 // Other packages use RPCClientAll
 type rcpClient interface {
 	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+	BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error
 	Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (*ethbinding.ClientSubscription, error)
 	Close()
 }
@@ -103,6 +135,19 @@ func (w *rpcWrapper) CallContext(ctx context.Context, result interface{}, method
 	return err
 }
 
+func (w *rpcWrapper) BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error {
+	for _, elem := range b {
+		if err := auth.AuthRPC(ctx, elem.Method, elem.Args...); err != nil {
+			log.Errorf("JSON/RPC %s - not authorized: %s", elem.Method, err)
+			return errors.Errorf(errors.Unauthorized)
+		}
+	}
+	log.Tracef("RPC batch --> %d calls", len(b))
+	err := w.rpc.BatchCallContext(ctx, b)
+	log.Tracef("RPC batch <-- %d calls", len(b))
+	return err
+}
+
 func (w *rpcWrapper) Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (RPCClientSubscription, error) {
 	if err := auth.AuthRPCSubscribe(ctx, namespace, channel, args...); err != nil {
 		log.Errorf("JSON/RPC Subscribe - not authorized: %s", err)
@@ -120,6 +165,7 @@ func (w *rpcWrapper) Close() {
 type RPCClientAll interface {
 	RPCClosable
 	RPCClient
+	RPCClientBatch
 	RPCClientAsync
 }
 
@@ -133,6 +179,11 @@ type RPCClient interface {
 	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
 }
 
+// RPCClientBatch is implemented by clients that can send a set of calls in a single JSON-RPC batch request
+type RPCClientBatch interface {
+	BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error
+}
+
 // RPCClientAsync refers to the async functions from the ethereum RPC client that we use
 type RPCClientAsync interface {
 	Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (RPCClientSubscription, error)
@@ -36,6 +36,9 @@ type mockEthClient struct{}
 func (w *mockEthClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
 	return nil
 }
+func (w *mockEthClient) BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error {
+	return nil
+}
 func (w *mockEthClient) Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (*ethbinding.ClientSubscription, error) {
 	return nil, nil
 }
@@ -49,6 +52,7 @@ func TestRPCWrapperConformsToInterface(t *testing.T) {
 	rpc = w
 	rpc.Subscribe(context.Background(), "", nil)
 	rpc.CallContext(context.Background(), nil, "")
+	rpc.BatchCallContext(context.Background(), []ethbinding.BatchElem{{Method: "test"}})
 	rpc.Close()
 }
 
@@ -139,6 +143,8 @@ func TestCallContextWrapperAuth(t *testing.T) {
 	assert.EqualError(err, "Unauthorized")
 	err = w.CallContext(context.Background(), nil, "")
 	assert.EqualError(err, "Unauthorized")
+	err = w.BatchCallContext(context.Background(), []ethbinding.BatchElem{{Method: "test"}})
+	assert.EqualError(err, "Unauthorized")
 
 	auth.RegisterSecurityModule(nil)
 }
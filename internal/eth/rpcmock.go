@@ -16,6 +16,8 @@ package eth
 
 import (
 	"context"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
 )
 
 // MockRPCSubscription allows convenient subscription mocking in other packages
@@ -81,6 +83,14 @@ func (m *MockRPCClient) CallContext(ctx context.Context, result interface{}, met
 	return m.callError
 }
 
+// BatchCallContext processes each call in the batch as CallContext would, setting the call error on each
+func (m *MockRPCClient) BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error {
+	for i := range b {
+		b[i].Error = m.CallContext(ctx, b[i].Result, b[i].Method, b[i].Args...)
+	}
+	return nil
+}
+
 // Subscribe returns the subscription already configured in the mock
 func (m *MockRPCClient) Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (RPCClientSubscription, error) {
 	m.SubResult.Namespace = namespace
@@ -0,0 +1,425 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// RPCSelectionFailover sends each request to the first healthy endpoint, in the configured order
+	RPCSelectionFailover = "failover"
+	// RPCSelectionRoundRobin spreads the requests across the healthy endpoints
+	RPCSelectionRoundRobin = "roundrobin"
+
+	defaultHealthCheckIntervalMS = 5000
+)
+
+// RPCEndpointStats are the metrics for one endpoint of a multi-endpoint connection
+type RPCEndpointStats struct {
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	Requests            uint64     `json:"requests"`
+	Failures            uint64     `json:"failures"`
+	Failovers           uint64     `json:"failovers"`
+	AvgLatencyMS        float64    `json:"avgLatencyMS"`
+	HealthChecks        uint64     `json:"healthChecks"`
+	HealthCheckFailures uint64     `json:"healthCheckFailures"`
+	LastHealthCheck     *time.Time `json:"lastHealthCheck,omitempty"`
+	BlockNumber         uint64     `json:"blockNumber"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+// RPCClientStats is implemented by clients that report metrics for each of their endpoints
+type RPCClientStats interface {
+	EndpointStats() []RPCEndpointStats
+}
+
+type rpcEndpoint struct {
+	url          string
+	stats        RPCEndpointStats
+	totalLatency time.Duration
+	rpc          rcpClient // nil until the endpoint has been dialed successfully
+}
+
+// rpcPool is an RPCClientAll that spreads requests across a set of endpoints. Endpoints
+// are marked unhealthy when a request fails to get a response, and healthy again once
+// they respond to an eth_blockNumber health check. Requests for the same sender go to
+// the same endpoint while it is healthy, so the nonces we assign stay consistent
+type rpcPool struct {
+	conf      *RPCConnOpts
+	dial      func(url string) (rcpClient, error)
+	mux       sync.Mutex
+	endpoints []*rpcEndpoint
+	next      int
+	sticky    map[string]*rpcEndpoint
+	closed    chan struct{}
+}
+
+type senderContextKey struct{}
+
+// withSender routes the requests made with the context to the endpoint used for the sender
+func withSender(ctx context.Context, from string) context.Context {
+	return context.WithValue(ctx, senderContextKey{}, from)
+}
+
+func dialEndpoint(url string) (rcpClient, error) {
+	rpcClient, err := ethbind.API.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return rpcClient, nil
+}
+
+// redactURL avoids logging passwords
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "<invalid url>"
+	}
+	if u.User != nil {
+		u.User = url.UserPassword(u.User.Username(), "xxxxxx")
+	}
+	return u.String()
+}
+
+func newRPCPool(conf *RPCConnOpts, dial func(url string) (rcpClient, error)) (*rpcPool, error) {
+	switch conf.Selection {
+	case "":
+		conf.Selection = RPCSelectionFailover
+	case RPCSelectionFailover, RPCSelectionRoundRobin:
+	default:
+		return nil, errors.Errorf(errors.RPCInvalidSelection, conf.Selection)
+	}
+	if conf.HealthCheckIntervalMS <= 0 {
+		conf.HealthCheckIntervalMS = defaultHealthCheckIntervalMS
+	}
+	p := &rpcPool{
+		conf:   conf,
+		dial:   dial,
+		sticky: make(map[string]*rpcEndpoint),
+		closed: make(chan struct{}),
+	}
+	connected := 0
+	for _, endpointURL := range conf.Endpoints() {
+		e := &rpcEndpoint{url: endpointURL}
+		e.stats.URL = redactURL(endpointURL)
+		var err error
+		if e.rpc, err = dial(endpointURL); err != nil {
+			log.Errorf("JSON/RPC connection to %s failed: %s", e.stats.URL, err)
+			e.stats.LastError = err.Error()
+		} else {
+			e.stats.Healthy = true
+			connected++
+		}
+		p.endpoints = append(p.endpoints, e)
+	}
+	if connected == 0 {
+		return nil, errors.Errorf(errors.RPCNoEndpoints)
+	}
+	log.Infof("JSON/RPC connections established to %d of %d endpoints (selection=%s)", connected, len(p.endpoints), conf.Selection)
+	go p.healthCheckLoop()
+	return p, nil
+}
+
+// candidates returns the endpoints to try for a request, in order. The first is the endpoint
+// the sender is pinned to, or chosen by the selection policy. The other healthy endpoints
+// follow, and then the unhealthy endpoints as a last resort
+func (p *rpcPool) candidates(sender string) []*rpcEndpoint {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	var healthy, unhealthy []*rpcEndpoint
+	for _, e := range p.endpoints {
+		if e.rpc == nil {
+			continue
+		} else if e.stats.Healthy {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	if p.conf.Selection == RPCSelectionRoundRobin && len(healthy) > 1 {
+		start := p.next % len(healthy)
+		p.next++
+		healthy = append(healthy[start:], healthy[:start]...)
+	}
+	if sender != "" && len(healthy) > 0 {
+		if pinned, ok := p.sticky[sender]; ok && pinned.stats.Healthy {
+			ordered := []*rpcEndpoint{pinned}
+			for _, e := range healthy {
+				if e != pinned {
+					ordered = append(ordered, e)
+				}
+			}
+			healthy = ordered
+		} else {
+			p.sticky[sender] = healthy[0]
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// stickySender returns the sender for requests that must go to the same endpoint
+// as the other requests for that sender
+func stickySender(ctx context.Context, method string, args []interface{}) string {
+	if sender, ok := ctx.Value(senderContextKey{}).(string); ok {
+		return strings.ToLower(sender)
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	switch method {
+	case "eth_getTransactionCount", "priv_getTransactionCount", "eth_sendTransaction", "eea_sendTransaction":
+		switch a := args[0].(type) {
+		case *ethbinding.Address:
+			return strings.ToLower(a.Hex())
+		case string:
+			return strings.ToLower(a)
+		case *SendTXArgs:
+			return strings.ToLower(a.From)
+		}
+	}
+	return ""
+}
+
+// isSubmission is true for the requests that must not be sent to another endpoint
+// when they fail, as the node might have accepted the transaction before the failure
+func isSubmission(method string) bool {
+	switch method {
+	case "eth_sendTransaction", "eth_sendRawTransaction", "eea_sendTransaction":
+		return true
+	}
+	return false
+}
+
+// isEndpointFailure is true if the endpoint failed to respond, rather than
+// returning an error response to the request
+func isEndpointFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	switch err.(type) {
+	case interface{ ErrorCode() int }, *json.UnmarshalTypeError, *json.SyntaxError:
+		return false
+	}
+	return true
+}
+
+// call sends the request to each candidate endpoint in turn, until one responds
+func (p *rpcPool) call(ctx context.Context, method, sender string, retry bool, fn func(rpc rcpClient) error) error {
+	candidates := p.candidates(sender)
+	if len(candidates) == 0 {
+		return errors.Errorf(errors.RPCNoEndpoints)
+	}
+	var err error
+	for i, e := range candidates {
+		if i > 0 {
+			log.Warnf("JSON/RPC %s failing over from %s to %s", method, candidates[i-1].stats.URL, e.stats.URL)
+		}
+		start := time.Now()
+		err = fn(e.rpc)
+		failed := isEndpointFailure(ctx, err)
+		p.recordRequest(e, time.Since(start), failed, retry && i < len(candidates)-1, err)
+		if !failed && i > 0 && sender != "" {
+			p.pin(sender, e)
+		}
+		if !failed || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// pin moves the sender to the endpoint that responded after a failover
+func (p *rpcPool) pin(sender string, e *rpcEndpoint) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.sticky[sender] = e
+}
+
+func (p *rpcPool) recordRequest(e *rpcEndpoint, latency time.Duration, failed, failover bool, err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	e.stats.Requests++
+	e.totalLatency += latency
+	if failed {
+		if e.stats.Healthy {
+			log.Warnf("JSON/RPC endpoint %s marked unhealthy: %s", e.stats.URL, err)
+		}
+		e.stats.Healthy = false
+		e.stats.Failures++
+		e.stats.LastError = err.Error()
+		if failover {
+			e.stats.Failovers++
+		}
+	}
+}
+
+func (p *rpcPool) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if err := auth.AuthRPC(ctx, method, args...); err != nil {
+		log.Errorf("JSON/RPC %s - not authorized: %s", method, err)
+		return errors.Errorf(errors.Unauthorized)
+	}
+	log.Tracef("RPC [%s] --> %+v", method, args)
+	err := p.call(ctx, method, stickySender(ctx, method, args), !isSubmission(method), func(rpc rcpClient) error {
+		return rpc.CallContext(ctx, result, method, args...)
+	})
+	log.Tracef("RPC [%s] <-- %+v", method, result)
+	return err
+}
+
+func (p *rpcPool) BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error {
+	for _, elem := range b {
+		if err := auth.AuthRPC(ctx, elem.Method, elem.Args...); err != nil {
+			log.Errorf("JSON/RPC %s - not authorized: %s", elem.Method, err)
+			return errors.Errorf(errors.Unauthorized)
+		}
+	}
+	retry := true
+	for _, elem := range b {
+		retry = retry && !isSubmission(elem.Method)
+	}
+	log.Tracef("RPC batch --> %d calls", len(b))
+	err := p.call(ctx, "batch", "", retry, func(rpc rcpClient) error {
+		return rpc.BatchCallContext(ctx, b)
+	})
+	log.Tracef("RPC batch <-- %d calls", len(b))
+	return err
+}
+
+// Subscribe subscribes on the first endpoint that supports subscriptions. Callers subscribe
+// again when the subscription fails, which moves it to another endpoint if that one is down
+func (p *rpcPool) Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (RPCClientSubscription, error) {
+	if err := auth.AuthRPCSubscribe(ctx, namespace, channel, args...); err != nil {
+		log.Errorf("JSON/RPC Subscribe - not authorized: %s", err)
+		return nil, errors.Errorf(errors.Unauthorized)
+	}
+	err := errors.Errorf(errors.RPCNoEndpoints)
+	for _, e := range p.candidates("") {
+		var tSub *ethbinding.ClientSubscription
+		if tSub, err = e.rpc.Subscribe(ctx, namespace, channel, args...); err == nil {
+			log.Debugf("JSON/RPC subscribed to %s on %s", namespace, e.stats.URL)
+			return &subWrapper{s: tSub}, nil
+		}
+		log.Debugf("JSON/RPC subscribe to %s on %s failed: %s", namespace, e.stats.URL, err)
+	}
+	return nil, err
+}
+
+func (p *rpcPool) Close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+	close(p.closed)
+	for _, e := range p.endpoints {
+		if e.rpc != nil {
+			e.rpc.Close()
+		}
+	}
+}
+
+// EndpointStats returns a snapshot of the metrics for each endpoint
+func (p *rpcPool) EndpointStats() []RPCEndpointStats {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	stats := make([]RPCEndpointStats, len(p.endpoints))
+	for i, e := range p.endpoints {
+		stats[i] = e.stats
+		if e.stats.Requests > 0 {
+			stats[i].AvgLatencyMS = float64(e.totalLatency.Microseconds()) / 1000 / float64(e.stats.Requests)
+		}
+	}
+	return stats
+}
+
+func (p *rpcPool) healthCheckLoop() {
+	interval := time.Duration(p.conf.HealthCheckIntervalMS) * time.Millisecond
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-time.After(interval):
+		}
+		p.checkHealth(interval)
+	}
+}
+
+// checkHealth probes all the endpoints in parallel, dialing any that have not yet connected
+func (p *rpcPool) checkHealth(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *rpcEndpoint) {
+			defer wg.Done()
+			p.checkEndpoint(e, timeout)
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (p *rpcPool) checkEndpoint(e *rpcEndpoint, timeout time.Duration) {
+	p.mux.Lock()
+	rpc := e.rpc
+	p.mux.Unlock()
+
+	var err error
+	var blockNumber ethbinding.HexUint64
+	if rpc == nil {
+		rpc, err = p.dial(e.url)
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err = rpc.CallContext(ctx, &blockNumber, "eth_blockNumber")
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if e.rpc == nil && rpc != nil {
+		e.rpc = rpc
+	}
+	now := time.Now().UTC()
+	e.stats.LastHealthCheck = &now
+	e.stats.HealthChecks++
+	if err != nil {
+		if e.stats.Healthy {
+			log.Warnf("JSON/RPC endpoint %s marked unhealthy: %s", e.stats.URL, err)
+		}
+		e.stats.Healthy = false
+		e.stats.HealthCheckFailures++
+		e.stats.LastError = err.Error()
+		return
+	}
+	if !e.stats.Healthy {
+		log.Infof("JSON/RPC endpoint %s healthy at block %d", e.stats.URL, uint64(blockNumber))
+	}
+	e.stats.Healthy = true
+	e.stats.BlockNumber = uint64(blockNumber)
+}
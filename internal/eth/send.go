@@ -32,13 +32,15 @@ const (
 	errorFunctionSelector = "0x08c379a0" // per https://solidity.readthedocs.io/en/v0.4.24/control-structures.html the signature of Error(string)
 )
 
-// calculateGas uses eth_estimateGas to estimate the gas required, providing a buffer
-// of 20% for variation as the chain changes between estimation and submission.
+// calculateGas uses eth_estimateGas to estimate the gas required, and applies the
+// estimation policy of the transaction to choose the gas limit. The raw estimate is
+// retained, so it can be reported with the receipt
 func (tx *Txn) calculateGas(ctx context.Context, rpc RPCClient, txArgs *SendTXArgs, gas *ethbinding.HexUint64) (err error) {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, tx.GasEstimatePolicy.timeout())
 	defer cancel()
 
-	if err := rpc.CallContext(ctx, &gas, "eth_estimateGas", txArgs); err != nil {
+	estimate := new(ethbinding.HexUint64)
+	if err := rpc.CallContext(ctx, &estimate, "eth_estimateGas", txArgs); err != nil {
 		// Now we attempt a call of the transaction, because that will return us a useful error in the case, of a revert.
 		estError := errors.Errorf(errors.TransactionSendGasEstimateFailed, err)
 		log.Errorf(estError.Error())
@@ -48,84 +50,102 @@ func (tx *Txn) calculateGas(ctx context.Context, rpc RPCClient, txArgs *SendTXAr
 		// If the call succeeds, after estimate completed - we still need to fail with the estimate error
 		return estError
 	}
-	*gas = ethbinding.HexUint64(float64(*gas) * 1.2)
+	tx.GasEstimate = uint64(*estimate)
+	limit, err := tx.GasEstimatePolicy.gasLimit(tx.GasEstimate)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Gas estimate=%d limit=%d", tx.GasEstimate, limit)
+	*gas = ethbinding.HexUint64(limit)
 	return nil
 }
 
-// Call synchronously calls the method, without mining a transaction, and returns the result as RLP encoded bytes or nil
-func (tx *Txn) Call(ctx context.Context, rpc RPCClient, blocknumber string) (res []byte, err error) {
+// sendTXArgs builds the JSON/RPC arguments for the transaction, with the fee
+// fields appropriate to the transaction type
+func (tx *Txn) sendTXArgs() *SendTXArgs {
 	data := ethbinding.HexBytes(tx.EthTX.Data())
 	txArgs := &SendTXArgs{
-		From:     tx.From.Hex(),
-		GasPrice: ethbinding.HexBigInt(*tx.EthTX.GasPrice()),
-		Value:    ethbinding.HexBigInt(*tx.EthTX.Value()),
-		Data:     &data,
+		From:  tx.From.Hex(),
+		Value: ethbinding.HexBigInt(*tx.EthTX.Value()),
+		Data:  &data,
+	}
+	if tx.EthTX.Type() == ethbinding.DynamicFeeTxType {
+		maxFee := ethbinding.HexBigInt(*tx.EthTX.GasFeeCap())
+		maxPriorityFee := ethbinding.HexBigInt(*tx.EthTX.GasTipCap())
+		txArgs.MaxFeePerGas = &maxFee
+		txArgs.MaxPriorityFeePerGas = &maxPriorityFee
+	} else {
+		gasPrice := ethbinding.HexBigInt(*tx.EthTX.GasPrice())
+		txArgs.GasPrice = &gasPrice
 	}
 	var to = tx.EthTX.To()
 	if to != nil {
 		txArgs.To = to.Hex()
 	}
+	return txArgs
+}
+
+// Call synchronously calls the method, without mining a transaction, and returns the result as RLP encoded bytes or nil
+func (tx *Txn) Call(ctx context.Context, rpc RPCClient, blocknumber string) (res []byte, err error) {
+	txArgs := tx.sendTXArgs()
 
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	var hexString string
 	if err = rpc.CallContext(ctx, &hexString, "eth_call", txArgs, blocknumber); err != nil {
+		// Nodes that return the revert as an error, include the return data with it
+		if revertErr := decodeRevert(rpcErrorData(err), tx.Errors); revertErr != nil {
+			return nil, revertErr
+		}
 		return nil, errors.Errorf(errors.TransactionSendCallFailedNoRevert, err)
 	}
 	if len(hexString) == 0 || hexString == "0x" {
 		return nil, nil
 	}
-	retStrLen := uint64(len(hexString))
-	if strings.HasPrefix(hexString, errorFunctionSelector) && retStrLen > 138 {
-		// The call reverted. Process the error response
-		dataOffsetHex := new(big.Int)
-		dataOffsetHex.SetString(hexString[10:74], 16)
-		errorStringLen := new(big.Int)
-		errorStringLen.SetString(hexString[74:138], 16)
-		hexStringEnd := errorStringLen.Uint64()*2 + 138
-		if hexStringEnd > retStrLen {
-			hexStringEnd = retStrLen
-		}
-		errorStringHex := hexString[138:hexStringEnd]
-		errorStringBytes, err := hex.DecodeString(errorStringHex)
-		log.Warnf("EVM Reverted. Message='%s' Offset='%s'", errorStringBytes, dataOffsetHex.Text(10))
-		if err != nil {
-			return nil, errors.Errorf(errors.TransactionSendCallFailedRevertNoMessage)
-		}
-		return nil, errors.Errorf(errors.TransactionSendCallFailedRevertMessage, errorStringBytes)
+	if revertErr := decodeRevert(hexString, tx.Errors); revertErr != nil {
+		// The call reverted
+		return nil, revertErr
 	}
 	log.Debugf("eth_call response: %s", hexString)
 	res = ethbind.API.FromHex(hexString)
 	return
 }
 
+// decodeRevertReason checks if the hex return data of a call is an Error(string) revert,
+// and if so decodes the reason string
+func decodeRevertReason(hexString string) (reason string, reverted bool, err error) {
+	retStrLen := uint64(len(hexString))
+	if !strings.HasPrefix(hexString, errorFunctionSelector) || retStrLen <= 138 {
+		return "", false, nil
+	}
+	dataOffsetHex := new(big.Int)
+	dataOffsetHex.SetString(hexString[10:74], 16)
+	errorStringLen := new(big.Int)
+	errorStringLen.SetString(hexString[74:138], 16)
+	hexStringEnd := errorStringLen.Uint64()*2 + 138
+	if hexStringEnd > retStrLen {
+		hexStringEnd = retStrLen
+	}
+	errorStringHex := hexString[138:hexStringEnd]
+	errorStringBytes, err := hex.DecodeString(errorStringHex)
+	log.Warnf("EVM Reverted. Message='%s' Offset='%s'", errorStringBytes, dataOffsetHex.Text(10))
+	return string(errorStringBytes), true, err
+}
+
 // Send sends an individual transaction, choosing external or internal signing
 func (tx *Txn) Send(ctx context.Context, rpc RPCClient) (err error) {
 	start := time.Now().UTC()
 
 	gas := ethbinding.HexUint64(tx.EthTX.Gas())
-	data := ethbinding.HexBytes(tx.EthTX.Data())
-	txArgs := &SendTXArgs{
-		From:     tx.From.Hex(),
-		GasPrice: ethbinding.HexBigInt(*tx.EthTX.GasPrice()),
-		Value:    ethbinding.HexBigInt(*tx.EthTX.Value()),
-		Data:     &data,
-	}
-	var to = tx.EthTX.To()
-	if to != nil {
-		txArgs.To = to.Hex()
-	}
+	txArgs := tx.sendTXArgs()
+	ctx = withSender(ctx, txArgs.From)
 	if uint64(gas) == uint64(0) {
 		if err = tx.calculateGas(ctx, rpc, txArgs, &gas); err != nil {
 			return err
 		}
 		// Re-encode the EthTX (for external HD Wallet signing)
-		if to != nil {
-			tx.EthTX = ethbind.API.NewTransaction(tx.EthTX.Nonce(), *tx.EthTX.To(), tx.EthTX.Value(), uint64(gas), tx.EthTX.GasPrice(), tx.EthTX.Data())
-		} else {
-			tx.EthTX = ethbind.API.NewContractCreation(tx.EthTX.Nonce(), tx.EthTX.Value(), uint64(gas), tx.EthTX.GasPrice(), tx.EthTX.Data())
-		}
+		tx.EthTX = tx.withGas(uint64(gas))
 	}
 	txArgs.Gas = &gas
 
@@ -150,9 +170,12 @@ type SendTXArgs struct {
 	From     string                `json:"from"`
 	To       string                `json:"to,omitempty"`
 	Gas      *ethbinding.HexUint64 `json:"gas,omitempty"`
-	GasPrice ethbinding.HexBigInt  `json:"gasPrice,omitempty"`
+	GasPrice *ethbinding.HexBigInt `json:"gasPrice,omitempty"`
 	Value    ethbinding.HexBigInt  `json:"value,omitempty"`
 	Data     *ethbinding.HexBytes  `json:"data"`
+	// EIP-1559 dynamic-fee extensions
+	MaxFeePerGas         *ethbinding.HexBigInt `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *ethbinding.HexBigInt `json:"maxPriorityFeePerGas,omitempty"`
 	// EEA spec extensions
 	PrivateFrom    string   `json:"privateFrom,omitempty"`
 	PrivateFor     []string `json:"privateFor,omitempty"`
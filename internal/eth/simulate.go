@@ -0,0 +1,89 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	executionReverted = "execution reverted"
+)
+
+// Simulation is the outcome of running a transaction against the pending block
+type Simulation struct {
+	GasEstimate  uint64
+	ReturnData   []byte
+	Reverted     bool
+	RevertReason string
+	Revert       *messages.RevertError
+}
+
+// Simulate runs eth_estimateGas and eth_call for the transaction against the pending block.
+// Nothing is signed or submitted. A revert is reported in the simulation, rather than as an error
+func (tx *Txn) Simulate(ctx context.Context, rpc RPCClient) (*Simulation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	sim := &Simulation{}
+	txArgs := tx.sendTXArgs()
+	estimate := new(ethbinding.HexUint64)
+	estimateErr := rpc.CallContext(ctx, &estimate, "eth_estimateGas", txArgs, "pending")
+	if estimateErr == nil {
+		sim.GasEstimate = uint64(*estimate)
+	}
+
+	// The call uses the gas limit of the transaction, if one was supplied
+	if gas := tx.EthTX.Gas(); gas > 0 {
+		hexGas := ethbinding.HexUint64(gas)
+		txArgs.Gas = &hexGas
+	}
+	var hexString string
+	if err := rpc.CallContext(ctx, &hexString, "eth_call", txArgs, "pending"); err != nil {
+		if !strings.Contains(err.Error(), executionReverted) {
+			return nil, errors.Errorf(errors.TransactionSendCallFailedNoRevert, err)
+		}
+		// Nodes that return the revert as an error, include the return data with it
+		sim.Reverted = true
+		sim.setRevert(decodeRevert(rpcErrorData(err), tx.Errors))
+	} else if revertErr := decodeRevert(hexString, tx.Errors); revertErr != nil {
+		sim.Reverted = true
+		sim.setRevert(revertErr)
+	} else if len(hexString) > 0 && hexString != "0x" {
+		sim.ReturnData = ethbind.API.FromHex(hexString)
+	}
+
+	// If the call succeeds, but the estimate failed, the transaction would fail to send
+	if estimateErr != nil && !sim.Reverted {
+		return nil, errors.Errorf(errors.TransactionSendGasEstimateFailed, estimateErr)
+	}
+	log.Infof("Simulated transaction from %s. gasEstimate=%d reverted=%t", txArgs.From, sim.GasEstimate, sim.Reverted)
+	return sim, nil
+}
+
+func (sim *Simulation) setRevert(revertErr *RevertError) {
+	if revertErr != nil {
+		sim.RevertReason = revertErr.Error()
+		sim.Revert = revertErr.Details
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRevertData = "0x08c379a0000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000114d75707065747279206465746563746564000000000000000000000000000000"
+
+type simulateRPCClient struct {
+	estimate    uint64
+	estimateErr error
+	callResult  string
+	callErr     error
+	callArgs    []interface{}
+}
+
+func (r *simulateRPCClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	switch method {
+	case "eth_estimateGas":
+		if r.estimateErr != nil {
+			return r.estimateErr
+		}
+		estimate := ethbinding.HexUint64(r.estimate)
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(&estimate))
+		return nil
+	case "eth_call":
+		r.callArgs = args
+		if r.callErr != nil {
+			return r.callErr
+		}
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(r.callResult))
+		return nil
+	}
+	return fmt.Errorf("unexpected method %s", method)
+}
+
+type testRPCDataError struct {
+	msg  string
+	data interface{}
+}
+
+func (e *testRPCDataError) Error() string          { return e.msg }
+func (e *testRPCDataError) ErrorData() interface{} { return e.data }
+
+func newTestSimulateTxn(gas uint64) *Txn {
+	return &Txn{
+		From:  ethbind.API.HexToAddress("0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"),
+		EthTX: ethbind.API.NewTransaction(5, ethbind.API.HexToAddress("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832"), big.NewInt(0), gas, big.NewInt(0), []byte{0x01, 0x02}),
+	}
+}
+
+func TestSimulateSuccess(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimate:   21000,
+		callResult: "0x0000000000000000000000000000000000000000000000000000000000000001",
+	}
+	sim, err := newTestSimulateTxn(50000).Simulate(context.Background(), rpc)
+	assert.NoError(err)
+	assert.Equal(uint64(21000), sim.GasEstimate)
+	assert.False(sim.Reverted)
+	assert.Len(sim.ReturnData, 32)
+	assert.Equal("pending", rpc.callArgs[1])
+	txArgs := rpc.callArgs[0].(*SendTXArgs)
+	assert.Equal(uint64(50000), uint64(*txArgs.Gas))
+}
+
+func TestSimulateNoReturnData(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimate:   21000,
+		callResult: "0x",
+	}
+	sim, err := newTestSimulateTxn(0).Simulate(context.Background(), rpc)
+	assert.NoError(err)
+	assert.Nil(sim.ReturnData)
+	txArgs := rpc.callArgs[0].(*SendTXArgs)
+	assert.Nil(txArgs.Gas)
+}
+
+func TestSimulateRevertInError(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimateErr: fmt.Errorf("execution reverted: Muppetry detected"),
+		callErr: &testRPCDataError{
+			msg:  "execution reverted: Muppetry detected",
+			data: testRevertData,
+		},
+	}
+	sim, err := newTestSimulateTxn(0).Simulate(context.Background(), rpc)
+	assert.NoError(err)
+	assert.True(sim.Reverted)
+	assert.Equal("Muppetry detected", sim.RevertReason)
+	assert.Equal(uint64(0), sim.GasEstimate)
+}
+
+func TestSimulateRevertInErrorNoData(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimateErr: fmt.Errorf("execution reverted"),
+		callErr:     fmt.Errorf("execution reverted"),
+	}
+	sim, err := newTestSimulateTxn(0).Simulate(context.Background(), rpc)
+	assert.NoError(err)
+	assert.True(sim.Reverted)
+	assert.Equal("", sim.RevertReason)
+}
+
+func TestSimulateRevertInResult(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimateErr: fmt.Errorf("execution reverted"),
+		callResult:  testRevertData,
+	}
+	sim, err := newTestSimulateTxn(0).Simulate(context.Background(), rpc)
+	assert.NoError(err)
+	assert.True(sim.Reverted)
+	assert.Equal("Muppetry detected", sim.RevertReason)
+	assert.Nil(sim.ReturnData)
+}
+
+func TestSimulateCallFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimate: 21000,
+		callErr:  fmt.Errorf("pop"),
+	}
+	_, err := newTestSimulateTxn(0).Simulate(context.Background(), rpc)
+	assert.Regexp("pop", err)
+}
+
+func TestSimulateEstimateFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimateErr: fmt.Errorf("out of gas"),
+		callResult:  "0x",
+	}
+	_, err := newTestSimulateTxn(0).Simulate(context.Background(), rpc)
+	assert.Regexp("out of gas", err)
+}
+
+func TestSimulateRevertCustomError(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := &simulateRPCClient{
+		estimateErr: fmt.Errorf("execution reverted"),
+		callErr: &testRPCDataError{
+			msg:  "execution reverted",
+			data: testCustomErrorData("InsufficientBalance(uint256,uint256)", "a", "14"),
+		},
+	}
+	tx := newTestSimulateTxn(0)
+	tx.Errors = ErrorsFromABI(testCustomErrorABI)
+	sim, err := tx.Simulate(context.Background(), rpc)
+	assert.NoError(err)
+	assert.True(sim.Reverted)
+	assert.Regexp("InsufficientBalance", sim.RevertReason)
+	assert.Equal("InsufficientBalance(uint256,uint256)", sim.Revert.Signature)
+}
@@ -37,16 +37,22 @@ import (
 // Txn wraps an ethereum transaction, along with the logic to send it over
 // JSON/RPC to a node
 type Txn struct {
-	NodeAssignNonce  bool
-	OrionPrivateAPIS bool
-	From             ethbinding.Address
-	EthTX            *ethbinding.Transaction
-	Hash             string
-	Receipt          TxnReceipt
-	PrivateFrom      string
-	PrivateFor       []string
-	PrivacyGroupID   string
-	Signer           TXSigner
+	NodeAssignNonce   bool
+	OrionPrivateAPIS  bool
+	From              ethbinding.Address
+	EthTX             *ethbinding.Transaction
+	Hash              string
+	Receipt           TxnReceipt
+	PrivateFrom       string
+	PrivateFor        []string
+	PrivacyGroupID    string
+	Signer            TXSigner
+	SubmittedHashes   []string
+	CancelHashes      []string
+	MethodSig         string
+	GasEstimate       uint64
+	GasEstimatePolicy *GasEstimatePolicy
+	Errors            []*ethbinding.ABIElementMarshaling
 }
 
 // TxnReceipt is the receipt obtained over JSON/RPC from the ethereum client
@@ -61,6 +67,15 @@ type TxnReceipt struct {
 	Status            *ethbinding.HexBigInt `json:"status"`
 	To                *ethbinding.Address   `json:"to"`
 	TransactionIndex  *ethbinding.HexUint   `json:"transactionIndex"`
+	Logs              []*TxnLog             `json:"logs"`
+}
+
+// TxnLog is a log emitted by a transaction, as included in its receipt
+type TxnLog struct {
+	Address  *ethbinding.Address `json:"address"`
+	Topics   []*ethbinding.Hash  `json:"topics"`
+	Data     string              `json:"data"`
+	LogIndex *ethbinding.HexUint `json:"logIndex"`
 }
 
 // NewContractDeployTxn builds a new ethereum transaction from the supplied
@@ -113,7 +128,7 @@ func NewContractDeployTxn(msg *messages.DeployContract, signer TXSigner) (tx *Tx
 	}
 
 	// Generate the ethereum transaction
-	if err = tx.genEthTransaction(from, "", msg.Nonce, msg.Value, msg.Gas, msg.GasPrice, data); err != nil {
+	if err = tx.genEthTransaction(from, "", msg.Nonce, msg.Value, msg.Gas, newTxnFees(&msg.TransactionCommon), data); err != nil {
 		return
 	}
 
@@ -121,16 +136,18 @@ func NewContractDeployTxn(msg *messages.DeployContract, signer TXSigner) (tx *Tx
 	tx.PrivateFrom = msg.PrivateFrom
 	tx.PrivateFor = msg.PrivateFor
 	tx.PrivacyGroupID = msg.PrivacyGroupID
+	tx.Errors = ErrorsFromABI(compiled.ABI)
 	return
 }
 
 // CallMethod performs eth_call to return data from the chain
-func CallMethod(ctx context.Context, rpc RPCClient, signer TXSigner, from, addr string, value json.Number, methodABI *ethbinding.ABIMethod, msgParams []interface{}, blocknumber string) (map[string]interface{}, error) {
+func CallMethod(ctx context.Context, rpc RPCClient, signer TXSigner, from, addr string, value json.Number, methodABI *ethbinding.ABIMethod, msgParams []interface{}, blocknumber string, abiErrors []*ethbinding.ABIElementMarshaling) (map[string]interface{}, error) {
 	log.Debugf("Calling method. ABI: %+v Params: %+v", methodABI, msgParams)
-	tx, err := buildTX(signer, from, addr, "", value, "", "", methodABI, msgParams)
+	tx, err := buildTX(signer, from, addr, "", value, "", &txnFees{}, methodABI, msgParams)
 	if err != nil {
 		return nil, err
 	}
+	tx.Errors = abiErrors
 	callOption := "latest"
 	// only allowed values are "earliest/latest/pending", "", a number string "12345" or a hex number "0xab23"
 	// "latest" and "" (no fly-blocknumber given) are equivalent
@@ -324,18 +341,23 @@ func NewSendTxn(msg *messages.SendTransaction, signer TXSigner) (tx *Txn, err er
 		}
 	}
 
-	if tx, err = buildTX(signer, msg.From, msg.To, msg.Nonce, msg.Value, msg.Gas, msg.GasPrice, methodABI, msg.Parameters); err != nil {
+	if tx, err = buildTX(signer, msg.From, msg.To, msg.Nonce, msg.Value, msg.Gas, newTxnFees(&msg.TransactionCommon), methodABI, msg.Parameters); err != nil {
 		return
 	}
 
 	// retain private transaction fields
 	tx.PrivateFrom = msg.PrivateFrom
 	tx.PrivateFor = msg.PrivateFor
+	tx.Errors = msg.Errors
 	return
 }
 
 // NewNilTX returns a transaction without any data from/to the same address
 func NewNilTX(from string, nonce int64, signer TXSigner) (tx *Txn, err error) {
+	return newNilTX(from, nonce, signer, &txnFees{gasPrice: json.Number("0")})
+}
+
+func newNilTX(from string, nonce int64, signer TXSigner, fees *txnFees) (tx *Txn, err error) {
 	tx = &Txn{Signer: signer}
 	if tx.Signer != nil {
 		from = signer.Address()
@@ -343,13 +365,13 @@ func NewNilTX(from string, nonce int64, signer TXSigner) (tx *Txn, err error) {
 	err = tx.genEthTransaction(
 		from, from,
 		json.Number(strconv.FormatInt(nonce, 10)),
-		json.Number("0"), json.Number("90000"), json.Number("0"),
+		json.Number("0"), json.Number("90000"), fees,
 		[]byte{})
 	return
 }
 
-func buildTX(signer TXSigner, msgFrom, msgTo string, msgNonce, msgValue, msgGas, msgGasPrice json.Number, methodABI *ethbinding.ABIMethod, params []interface{}) (tx *Txn, err error) {
-	tx = &Txn{Signer: signer}
+func buildTX(signer TXSigner, msgFrom, msgTo string, msgNonce, msgValue, msgGas json.Number, fees *txnFees, methodABI *ethbinding.ABIMethod, params []interface{}) (tx *Txn, err error) {
+	tx = &Txn{Signer: signer, MethodSig: methodABI.Sig}
 
 	// Build correctly typed args for the ethereum call
 	typedArgs, err := tx.generateTypedArgs(params, methodABI)
@@ -374,11 +396,66 @@ func buildTX(signer TXSigner, msgFrom, msgTo string, msgNonce, msgValue, msgGas,
 	}
 
 	// Generate the ethereum transaction
-	err = tx.genEthTransaction(from, msgTo, msgNonce, msgValue, msgGas, msgGasPrice, packedCall)
+	err = tx.genEthTransaction(from, msgTo, msgNonce, msgValue, msgGas, fees, packedCall)
 	return
 }
 
-func (tx *Txn) genEthTransaction(msgFrom, msgTo string, msgNonce, msgValue, msgGas, msgGasPrice json.Number, data []byte) (err error) {
+// txnFees are the pricing fields supplied for a transaction. Either a legacy gasPrice,
+// or the EIP-1559 maxFeePerGas/maxPriorityFeePerGas pair for a dynamic-fee transaction
+type txnFees struct {
+	txType               json.Number
+	gasPrice             json.Number
+	maxFeePerGas         json.Number
+	maxPriorityFeePerGas json.Number
+}
+
+func newTxnFees(msg *messages.TransactionCommon) *txnFees {
+	return &txnFees{
+		txType:               msg.TxType,
+		gasPrice:             msg.GasPrice,
+		maxFeePerGas:         msg.MaxFeePerGas,
+		maxPriorityFeePerGas: msg.MaxPriorityFeePerGas,
+	}
+}
+
+// isDynamicFee determines the transaction type, which is inferred from
+// the fee fields when not explicitly supplied
+func (f *txnFees) isDynamicFee() (bool, error) {
+	hasDynamicFees := f.maxFeePerGas != "" || f.maxPriorityFeePerGas != ""
+	if f.txType == "" {
+		if hasDynamicFees && f.gasPrice != "" {
+			return false, errors.Errorf(errors.TransactionSendGasPriceWithDynamicFees)
+		}
+		return hasDynamicFees, nil
+	}
+	txType, err := f.txType.Int64()
+	switch {
+	case err == nil && txType == ethbinding.LegacyTxType:
+		if hasDynamicFees {
+			return false, errors.Errorf(errors.TransactionSendDynamicFeesWithLegacyType)
+		}
+		return false, nil
+	case err == nil && txType == ethbinding.DynamicFeeTxType:
+		if f.gasPrice != "" {
+			return false, errors.Errorf(errors.TransactionSendGasPriceWithDynamicFees)
+		}
+		return true, nil
+	default:
+		return false, errors.Errorf(errors.TransactionSendBadTxType, f.txType)
+	}
+}
+
+func parseBigFee(val json.Number, errID errors.ErrorID) (*big.Int, error) {
+	i := big.NewInt(0)
+	if val.String() != "" {
+		if _, ok := i.SetString(val.String(), 10); !ok {
+			return nil, errors.Errorf(errID)
+		}
+	}
+	return i, nil
+}
+
+func (tx *Txn) genEthTransaction(msgFrom, msgTo string, msgNonce, msgValue, msgGas json.Number, fees *txnFees, data []byte) (err error) {
 
 	if msgFrom != "" {
 		tx.From, err = utils.StrToAddress("from", msgFrom)
@@ -413,32 +490,81 @@ func (tx *Txn) genEthTransaction(msgFrom, msgTo string, msgNonce, msgValue, msgG
 		}
 	}
 
-	gasPrice := big.NewInt(0)
-	if msgGasPrice.String() != "" {
-		if _, ok := gasPrice.SetString(msgGasPrice.String(), 10); !ok {
-			err = errors.Errorf(errors.TransactionSendBadGasPrice)
-			return
-		}
+	dynamicFee, err := fees.isDynamicFee()
+	if err != nil {
+		return
 	}
 
-	var toAddr ethbinding.Address
+	var toAddr *ethbinding.Address
 	var toStr string
 	if msgTo != "" {
-		if toAddr, err = utils.StrToAddress("to", msgTo); err != nil {
+		var addr ethbinding.Address
+		if addr, err = utils.StrToAddress("to", msgTo); err != nil {
+			return
+		}
+		toAddr = &addr
+		toStr = addr.Hex()
+	}
+
+	if dynamicFee {
+		var maxFee, maxPriorityFee *big.Int
+		if maxFee, err = parseBigFee(fees.maxFeePerGas, errors.TransactionSendBadMaxFeePerGas); err != nil {
+			return
+		}
+		if maxPriorityFee, err = parseBigFee(fees.maxPriorityFeePerGas, errors.TransactionSendBadMaxPriorityFeePerGas); err != nil {
 			return
 		}
-		tx.EthTX = ethbind.API.NewTransaction(uint64(nonce), toAddr, value, uint64(gas), gasPrice, data)
-		toStr = toAddr.Hex()
+		tx.EthTX = ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+			Nonce:     uint64(nonce),
+			To:        toAddr,
+			Value:     value,
+			Gas:       uint64(gas),
+			GasFeeCap: maxFee,
+			GasTipCap: maxPriorityFee,
+			Data:      data,
+		})
 	} else {
-		tx.EthTX = ethbind.API.NewContractCreation(uint64(nonce), value, uint64(gas), gasPrice, data)
-		toStr = ""
+		var gasPrice *big.Int
+		if gasPrice, err = parseBigFee(fees.gasPrice, errors.TransactionSendBadGasPrice); err != nil {
+			return
+		}
+		if toAddr != nil {
+			tx.EthTX = ethbind.API.NewTransaction(uint64(nonce), *toAddr, value, uint64(gas), gasPrice, data)
+		} else {
+			tx.EthTX = ethbind.API.NewContractCreation(uint64(nonce), value, uint64(gas), gasPrice, data)
+		}
 	}
 	etx := tx.EthTX
-	log.Debugf("TX:%s From='%s' To='%s' Nonce=%d Value=%d Gas=%d GasPrice=%d",
-		etx.Hash().Hex(), tx.From.Hex(), toStr, etx.Nonce(), etx.Value(), etx.Gas(), etx.GasPrice())
+	log.Debugf("TX:%s From='%s' To='%s' Type=%d Nonce=%d Value=%d Gas=%d GasPrice=%d MaxFeePerGas=%d MaxPriorityFeePerGas=%d",
+		etx.Hash().Hex(), tx.From.Hex(), toStr, etx.Type(), etx.Nonce(), etx.Value(), etx.Gas(), etx.GasPrice(), etx.GasFeeCap(), etx.GasTipCap())
 	return
 }
 
+// withGas returns a copy of the unsigned transaction with the gas limit updated,
+// preserving the transaction type and fee fields
+func (tx *Txn) withGas(gas uint64) *ethbinding.Transaction {
+	return tx.withNonceAndGas(tx.EthTX.Nonce(), gas)
+}
+
+func (tx *Txn) withNonceAndGas(nonce, gas uint64) *ethbinding.Transaction {
+	etx := tx.EthTX
+	if etx.Type() == ethbinding.DynamicFeeTxType {
+		return ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+			ChainID:   etx.ChainId(),
+			Nonce:     nonce,
+			To:        etx.To(),
+			Value:     etx.Value(),
+			Gas:       gas,
+			GasFeeCap: etx.GasFeeCap(),
+			GasTipCap: etx.GasTipCap(),
+			Data:      etx.Data(),
+		})
+	} else if etx.To() != nil {
+		return ethbind.API.NewTransaction(nonce, *etx.To(), etx.Value(), gas, etx.GasPrice(), etx.Data())
+	}
+	return ethbind.API.NewContractCreation(nonce, etx.Value(), gas, etx.GasPrice(), etx.Data())
+}
+
 func (tx *Txn) getInteger(methodName string, path string, requiredType *ethbinding.ABIType, suppliedType reflect.Type, param interface{}) (val int64, err error) {
 	if suppliedType.Kind() == reflect.String {
 		if val, err = strconv.ParseInt(param.(string), 10, 64); err != nil {
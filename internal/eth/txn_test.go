@@ -28,6 +28,7 @@ import (
 	"github.com/kaleido-io/ethconnect/internal/messages"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Slim interface for stubbing
@@ -354,6 +355,117 @@ func TestNewContractDeployBadGasPrice(t *testing.T) {
 	assert.Regexp("Converting supplied 'gasPrice' to big integer", err.Error())
 }
 
+func TestNewContractDeployTxnDynamicFee(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.DeployContract
+	msg.Solidity = simpleStorage
+	msg.Parameters = []interface{}{float64(999999)}
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.Nonce = "123"
+	msg.Value = "0"
+	msg.Gas = "456"
+	msg.MaxFeePerGas = "789"
+	msg.MaxPriorityFeePerGas = "12"
+	tx, err := NewContractDeployTxn(&msg, nil)
+	require.NoError(t, err)
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), tx.EthTX.Type())
+	rpc := testRPCClient{}
+
+	tx.Send(context.Background(), &rpc)
+
+	assert.Equal("eth_sendTransaction", rpc.capturedMethod)
+	jsonBytesSent, _ := json.Marshal(rpc.capturedArgs[0])
+	var jsonSent map[string]interface{}
+	json.Unmarshal(jsonBytesSent, &jsonSent)
+	assert.Equal("0x7b", jsonSent["nonce"])
+	assert.Equal("0x1c8", jsonSent["gas"])
+	assert.Equal("0x315", jsonSent["maxFeePerGas"])
+	assert.Equal("0xc", jsonSent["maxPriorityFeePerGas"])
+	assert.Nil(jsonSent["gasPrice"])
+}
+
+func TestNewContractDeployTxnExplicitDynamicFeeType(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.DeployContract
+	msg.Solidity = simpleStorage
+	msg.Parameters = []interface{}{float64(999999)}
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.TxType = "2"
+	tx, err := NewContractDeployTxn(&msg, nil)
+	require.NoError(t, err)
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), tx.EthTX.Type())
+	assert.Equal("0", tx.EthTX.GasFeeCap().String())
+}
+
+func TestNewContractDeployBadMaxFeePerGas(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.DeployContract
+	msg.Solidity = simpleStorage
+	msg.Parameters = []interface{}{float64(999999)}
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.MaxFeePerGas = "abc"
+	_, err := NewContractDeployTxn(&msg, nil)
+	assert.Regexp("Converting supplied 'maxFeePerGas' to big integer", err.Error())
+}
+
+func TestNewContractDeployBadMaxPriorityFeePerGas(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.DeployContract
+	msg.Solidity = simpleStorage
+	msg.Parameters = []interface{}{float64(999999)}
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.MaxPriorityFeePerGas = "abc"
+	_, err := NewContractDeployTxn(&msg, nil)
+	assert.Regexp("Converting supplied 'maxPriorityFeePerGas' to big integer", err.Error())
+}
+
+func TestNewContractDeployGasPriceWithDynamicFees(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.DeployContract
+	msg.Solidity = simpleStorage
+	msg.Parameters = []interface{}{float64(999999)}
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.GasPrice = "789"
+	msg.MaxFeePerGas = "789"
+	_, err := NewContractDeployTxn(&msg, nil)
+	assert.Regexp("'gasPrice' cannot be combined", err.Error())
+
+	msg.MaxFeePerGas = ""
+	msg.TxType = "2"
+	_, err = NewContractDeployTxn(&msg, nil)
+	assert.Regexp("'gasPrice' cannot be combined", err.Error())
+}
+
+func TestNewContractDeployDynamicFeesWithLegacyType(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.DeployContract
+	msg.Solidity = simpleStorage
+	msg.Parameters = []interface{}{float64(999999)}
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.TxType = "0"
+	msg.MaxPriorityFeePerGas = "1"
+	_, err := NewContractDeployTxn(&msg, nil)
+	assert.Regexp("cannot be used on a legacy", err.Error())
+}
+
+func TestNewContractDeployBadTxType(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.DeployContract
+	msg.Solidity = simpleStorage
+	msg.Parameters = []interface{}{float64(999999)}
+	msg.From = "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c"
+	msg.TxType = "1"
+	_, err := NewContractDeployTxn(&msg, nil)
+	assert.Regexp("Unsupported 'txType' '1'", err.Error())
+}
+
 func TestNewContractDeployTxnBadContract(t *testing.T) {
 	assert := assert.New(t)
 
@@ -783,7 +895,7 @@ func TestCallMethod(t *testing.T) {
 	res, err := CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), genMethod(params), params, "")
+		json.Number("12345"), genMethod(params), params, "", nil)
 	assert.NoError(err)
 	assert.Equal(map[string]interface{}{
 		"retval1": "1",
@@ -804,7 +916,7 @@ func TestCallMethod(t *testing.T) {
 	_, err = CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), genMethod(params), params, "pending")
+		json.Number("12345"), genMethod(params), params, "pending", nil)
 	assert.NoError(err)
 	assert.Equal("eth_call", rpc.capturedMethod2)
 	assert.Equal("pending", rpc.capturedArgs2[1])
@@ -812,7 +924,7 @@ func TestCallMethod(t *testing.T) {
 	_, err = CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), genMethod(params), params, "earliest")
+		json.Number("12345"), genMethod(params), params, "earliest", nil)
 	assert.NoError(err)
 	assert.Equal("eth_call", rpc.capturedMethod2)
 	assert.Equal("earliest", rpc.capturedArgs2[1])
@@ -820,7 +932,7 @@ func TestCallMethod(t *testing.T) {
 	_, err = CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), genMethod(params), params, "0x1234")
+		json.Number("12345"), genMethod(params), params, "0x1234", nil)
 	assert.NoError(err)
 	assert.Equal("eth_call", rpc.capturedMethod2)
 	assert.Equal("0x1234", rpc.capturedArgs2[1])
@@ -828,7 +940,7 @@ func TestCallMethod(t *testing.T) {
 	_, err = CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), genMethod(params), params, "12345")
+		json.Number("12345"), genMethod(params), params, "12345", nil)
 	assert.NoError(err)
 	assert.Equal("eth_call", rpc.capturedMethod2)
 	assert.Equal("0x3039", rpc.capturedArgs2[1])
@@ -836,7 +948,7 @@ func TestCallMethod(t *testing.T) {
 	_, err = CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), genMethod(params), params, "0")
+		json.Number("12345"), genMethod(params), params, "0", nil)
 	assert.NoError(err)
 	assert.Equal("eth_call", rpc.capturedMethod2)
 	assert.Equal("0x0", rpc.capturedArgs2[1])
@@ -857,7 +969,7 @@ func TestCallMethodFail(t *testing.T) {
 	_, err := CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), method, params, "")
+		json.Number("12345"), method, params, "", nil)
 
 	assert.Equal("eth_call", rpc.capturedMethod)
 	assert.EqualError(err, "Call failed: pop")
@@ -865,7 +977,7 @@ func TestCallMethodFail(t *testing.T) {
 	_, err = CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), method, params, "ab2345")
+		json.Number("12345"), method, params, "ab2345", nil)
 	assert.EqualError(err, "Invalid blocknumber. Failed to parse into big integer")
 }
 
@@ -887,7 +999,7 @@ func TestCallMethodRevert(t *testing.T) {
 	_, err := CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), method, params, "")
+		json.Number("12345"), method, params, "", nil)
 
 	assert.Equal("eth_call", rpc.capturedMethod)
 	assert.EqualError(err, "Muppetry detected")
@@ -911,7 +1023,7 @@ func TestCallMethodRevertBadStrLen(t *testing.T) {
 	_, err := CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), method, params, "")
+		json.Number("12345"), method, params, "", nil)
 
 	assert.Equal("eth_call", rpc.capturedMethod)
 	// Should read up to the end of the padding, and not panic
@@ -936,7 +1048,7 @@ func TestCallMethodRevertBadBytes(t *testing.T) {
 	_, err := CallMethod(context.Background(), rpc, nil,
 		"0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
 		"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
-		json.Number("12345"), method, params, "")
+		json.Number("12345"), method, params, "", nil)
 
 	assert.Equal("eth_call", rpc.capturedMethod)
 	assert.EqualError(err, "EVM reverted. Failed to decode error message")
@@ -949,7 +1061,7 @@ func TestCallMethodBadArgs(t *testing.T) {
 		mockError: fmt.Errorf("pop"),
 	}
 
-	_, err := CallMethod(context.Background(), rpc, nil, "badness", "", json.Number(""), &ethbinding.ABIMethod{}, []interface{}{}, "")
+	_, err := CallMethod(context.Background(), rpc, nil, "badness", "", json.Number(""), &ethbinding.ABIMethod{}, []interface{}{}, "", nil)
 
 	assert.EqualError(err, "Supplied value for 'from' is not a valid hex address")
 }
@@ -1065,6 +1177,45 @@ func TestSendWithTXSignerOK(t *testing.T) {
 	assert.Equal("0x746573746279746573", rpc.capturedArgs2[0])
 }
 
+func TestSendWithTXSignerDynamicFeeCalcGas(t *testing.T) {
+	assert := assert.New(t)
+
+	var msg messages.SendTransaction
+	msg.Parameters = []interface{}{}
+
+	signer := &mockTXSigner{
+		signed: []byte("testbytes"),
+		from:   "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
+	}
+
+	msg.MethodName = "testFunc"
+	msg.To = "0x2b8c0ECc76d0759a8F50b2E14A6881367D805832"
+	msg.From = "hd-u0abcd1234-u0bcde9876-12345"
+	msg.Value = "0"
+	msg.MaxFeePerGas = "789"
+	msg.MaxPriorityFeePerGas = "12"
+	tx, err := NewSendTxn(&msg, signer)
+	require.NoError(t, err)
+
+	rpc := testRPCClient{
+		resultWrangler: func(retString interface{}) {
+			if gas, ok := retString.(**ethbinding.HexUint64); ok {
+				**gas = ethbinding.HexUint64(1000)
+			}
+		},
+	}
+
+	tx.Send(context.Background(), &rpc)
+	assert.Equal("eth_estimateGas", rpc.capturedMethod)
+	assert.Equal("eth_sendRawTransaction", rpc.capturedMethod2)
+	// The re-encoded transaction must retain the type and fees after gas estimation
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), signer.capturedTX.Type())
+	assert.Equal("789", signer.capturedTX.GasFeeCap().String())
+	assert.Equal("12", signer.capturedTX.GasTipCap().String())
+	assert.Equal(uint64(1200), signer.capturedTX.Gas())
+	assert.Equal("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832", signer.capturedTX.To().String())
+}
+
 func TestSendWithTXSignerFail(t *testing.T) {
 	assert := assert.New(t)
 
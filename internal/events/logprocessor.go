@@ -94,21 +94,12 @@ func (lp *logProcessor) initBlockHWM(intVal *big.Int) {
 
 func (lp *logProcessor) processLogEntry(subInfo string, entry *logEntry, idx int) (err error) {
 
-	var data []byte
-	if strings.HasPrefix(entry.Data, "0x") {
-		data, err = ethbind.API.HexDecode(entry.Data)
-		if err != nil {
-			return errors.Errorf(errors.EventStreamsLogDecode, subInfo, err)
-		}
-	}
-
 	result := &eventData{
 		Address:          entry.Address.String(),
 		BlockNumber:      entry.BlockNumber.ToInt().String(),
 		TransactionIndex: entry.TransactionIndex.String(),
 		TransactionHash:  entry.TransactionHash.String(),
 		Signature:        ethbind.API.ABIEventSignature(lp.event),
-		Data:             make(map[string]interface{}),
 		SubID:            lp.subID,
 		LogIndex:         strconv.Itoa(idx),
 		batchComplete:    lp.batchComplete,
@@ -116,28 +107,52 @@ func (lp *logProcessor) processLogEntry(subInfo string, entry *logEntry, idx int
 	if lp.stream.spec.Timestamps {
 		result.Timestamp = strconv.FormatUint(entry.Timestamp, 10)
 	}
+	if result.Data, err = DecodeLogData(subInfo, lp.event, entry.Topics, entry.Data); err != nil {
+		return err
+	}
+
+	// Ok, now we have the full event in a friendly map output. Pass it down to the event processor
+	log.Infof("%s: Dispatching event. Address=%s BlockNumber=%s TxIndex=%s", subInfo, result.Address, result.BlockNumber, result.TransactionIndex)
+	lp.stream.handleEvent(result)
+	return nil
+}
+
+// DecodeLogData decodes the fields of an event from a log. Indexed fields are taken from
+// the topics, and the rest from the data. The logInfo is used as the prefix of errors
+func DecodeLogData(logInfo string, event *ethbinding.ABIEvent, topics []*ethbinding.Hash, hexData string) (map[string]interface{}, error) {
+
+	var data []byte
+	if strings.HasPrefix(hexData, "0x") {
+		var err error
+		data, err = ethbind.API.HexDecode(hexData)
+		if err != nil {
+			return nil, errors.Errorf(errors.EventStreamsLogDecode, logInfo, err)
+		}
+	}
+
+	result := make(map[string]interface{})
 	topicIdx := 0
-	if !lp.event.Anonymous {
+	if !event.Anonymous {
 		topicIdx++ // first index is the hash of the event description
 	}
 
 	// We need split out the indexed args that we parse out of the topic, from the data args
 	var dataArgs ethbinding.ABIArguments
-	dataArgs = make([]ethbinding.ABIArgument, 0, len(lp.event.Inputs))
-	for idx, input := range lp.event.Inputs {
+	dataArgs = make([]ethbinding.ABIArgument, 0, len(event.Inputs))
+	for idx, input := range event.Inputs {
 		var val interface{}
 		if input.Indexed {
-			if topicIdx >= len(entry.Topics) {
-				return errors.Errorf(errors.EventStreamsLogDecodeInsufficientTopics, subInfo, idx, ethbind.API.ABIEventSignature(lp.event))
+			if topicIdx >= len(topics) {
+				return nil, errors.Errorf(errors.EventStreamsLogDecodeInsufficientTopics, logInfo, idx, ethbind.API.ABIEventSignature(event))
 			}
-			topic := entry.Topics[topicIdx]
+			topic := topics[topicIdx]
 			topicIdx++
 			if topic != nil {
 				val = topicToValue(topic, &input)
 			} else {
 				val = nil
 			}
-			result.Data[input.Name] = val
+			result[input.Name] = val
 		} else {
 			dataArgs = append(dataArgs, input)
 		}
@@ -147,14 +162,10 @@ func (lp *logProcessor) processLogEntry(subInfo string, entry *logEntry, idx int
 	if len(dataArgs) > 0 {
 		dataMap := eth.ProcessRLPBytes(dataArgs, data)
 		for k, v := range dataMap {
-			result.Data[k] = v
+			result[k] = v
 		}
 	}
-
-	// Ok, now we have the full event in a friendly map output. Pass it down to the event processor
-	log.Infof("%s: Dispatching event. Address=%s BlockNumber=%s TxIndex=%s", subInfo, result.Address, result.BlockNumber, result.TransactionIndex)
-	lp.stream.handleEvent(result)
-	return nil
+	return result, nil
 }
 
 func topicToValue(topic *ethbinding.Hash, input *ethbinding.ABIArgument) interface{} {
@@ -33,10 +33,15 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const (
+	defaultRecoveryGraceSec = 30
+)
+
 // KafkaBridgeConf defines the YAML config structure for a Kafka bridge instance
 type KafkaBridgeConf struct {
-	Kafka       KafkaCommonConf `json:"kafka"`
-	MaxInFlight int             `json:"maxInFlight"`
+	Kafka            KafkaCommonConf `json:"kafka"`
+	MaxInFlight      int             `json:"maxInFlight"`
+	RecoveryGraceSec int             `json:"recoveryGraceSec,omitempty"`
 	tx.TxnProcessorConf
 	eth.RPCConf
 }
@@ -64,7 +69,7 @@ func (k *KafkaBridge) SetConf(conf *KafkaBridgeConf) {
 
 // ValidateConf validates the configuration
 func (k *KafkaBridge) ValidateConf() (err error) {
-	if k.conf.RPC.URL == "" {
+	if !k.conf.RPC.Configured() {
 		return errors.Errorf(errors.ConfigNoRPC)
 	}
 	if k.conf.MaxTXWaitTime < 10 {
@@ -101,6 +106,7 @@ func (k *KafkaBridge) CobraInit() (cmd *cobra.Command) {
 	eth.CobraInitRPC(cmd, &k.conf.RPCConf)
 	tx.CobraInitTxnProcessor(cmd, &k.conf.TxnProcessorConf)
 	cmd.Flags().IntVarP(&k.conf.MaxInFlight, "maxinflight", "m", utils.DefInt("KAFKA_MAX_INFLIGHT", 0), "Maximum messages to hold in-flight")
+	cmd.Flags().IntVar(&k.conf.RecoveryGraceSec, "recovery-grace", utils.DefInt("KAFKA_RECOVERY_GRACE", 0), "Seconds to wait for Kafka to redeliver the requests for recovered transactions, before resuming them as notifications")
 	return
 }
 
@@ -252,6 +258,24 @@ func (c *msgContext) Unmarshal(msg interface{}) (err error) {
 	return
 }
 
+// Backpressure is true, as we stop consuming from Kafka while requests wait for
+// capacity under the submission rate limits
+func (c *msgContext) Backpressure() bool {
+	return true
+}
+
+// RedeliveryKey is the topic, partition and offset of the request, which Kafka redelivers
+// after a restart if the offset was not committed
+func (c *msgContext) RedeliveryKey() string {
+	return c.reqOffset
+}
+
+// NewItemContext delivers the replies for an item of a batch as notifications, as only
+// the reply to the batch itself completes the request for the committed offsets
+func (c *msgContext) NewItemContext(headers *messages.CommonHeaders) tx.TxnContext {
+	return c.bridge.newNotificationContext(headers, c.timeReceived)
+}
+
 func (c *msgContext) SendErrorReply(status int, err error) {
 	c.SendErrorReplyWithTX(status, err, "")
 }
@@ -317,6 +341,82 @@ func (c msgContext) Encode() ([]byte, error) {
 	return c.replyBytes, nil
 }
 
+// notificationContext delivers a notification for a request that has already been
+// replied to, such as a reorg of the block containing the transaction. There is no
+// in-flight request, so the notification is sent without a request offset, and
+// does not affect the offsets committed for the requests
+type notificationContext struct {
+	bridge       *KafkaBridge
+	headers      *messages.CommonHeaders
+	timeReceived time.Time
+}
+
+// notificationMetadata distinguishes notifications from replies in the producer loops
+type notificationMetadata struct {
+	reqID string
+}
+
+func (k *KafkaBridge) newNotificationContext(headers *messages.CommonHeaders, timeReceived time.Time) tx.TxnContext {
+	return &notificationContext{
+		bridge:       k,
+		headers:      headers,
+		timeReceived: timeReceived,
+	}
+}
+
+func (n *notificationContext) Context() context.Context {
+	return context.Background()
+}
+
+func (n *notificationContext) Headers() *messages.CommonHeaders {
+	return n.headers
+}
+
+func (n *notificationContext) Unmarshal(msg interface{}) error {
+	return errors.Errorf(errors.TransactionNotificationNoRequest)
+}
+
+func (n *notificationContext) SendErrorReply(status int, err error) {
+	n.SendErrorReplyWithTX(status, err, "")
+}
+
+func (n *notificationContext) SendErrorReplyWithGapFill(status int, err error, gapFillTxHash string, gapFillSucceeded bool) {
+	n.SendErrorReplyWithTX(status, err, "")
+}
+
+func (n *notificationContext) SendErrorReplyWithTX(status int, err error, txHash string) {
+	log.Warnf("Failed to process notification %s: %s", n, err)
+	errMsg := messages.NewErrorReply(err, []byte{})
+	errMsg.TXHash = txHash
+	n.Reply(errMsg)
+}
+
+func (n *notificationContext) Reply(replyMessage messages.ReplyWithHeaders) {
+	replyHeaders := replyMessage.ReplyHeaders()
+	replyHeaders.ID = utils.UUIDv4()
+	replyHeaders.Context = n.headers.Context
+	replyHeaders.ReqID = n.headers.ID
+	replyHeaders.Received = n.timeReceived.UTC().Format(time.RFC3339Nano)
+	replyHeaders.Elapsed = time.Now().UTC().Sub(n.timeReceived).Seconds()
+	msgBytes, _ := json.Marshal(replyMessage)
+	// Partitioned in the same way as the replies to requests
+	key := n.headers.Account
+	if key == "" {
+		key = n.headers.ID
+	}
+	log.Infof("Sending notification: %s", n)
+	n.bridge.kafka.Producer().Input() <- &sarama.ProducerMessage{
+		Topic:    n.bridge.kafka.Conf().TopicOut,
+		Key:      sarama.StringEncoder(key),
+		Metadata: &notificationMetadata{reqID: n.headers.ID},
+		Value:    sarama.ByteEncoder(msgBytes),
+	}
+}
+
+func (n *notificationContext) String() string {
+	return fmt.Sprintf("Notification[%s/%s]", n.headers.MsgType, n.headers.ID)
+}
+
 // NewKafkaBridge creates a new KafkaBridge
 func NewKafkaBridge(printYAML *bool) *KafkaBridge {
 	k := &KafkaBridge{
@@ -365,6 +465,11 @@ func (k *KafkaBridge) ProducerErrorLoop(consumer KafkaConsumer, producer KafkaPr
 	log.Debugf("Kafka producer error loop started")
 	defer wg.Done()
 	for err := range producer.Errors() {
+		if notification, ok := err.Msg.Metadata.(*notificationMetadata); ok {
+			// Notifications have no in-flight request to retry, so the failure is only logged
+			log.Errorf("Kafka producer failed for notification for request %s: %s", notification.reqID, err)
+			continue
+		}
 		k.inFlightCond.L.Lock()
 		// If we fail to send a reply, this is significant. We have a request in flight
 		// and we have probably already sent the message.
@@ -385,6 +490,10 @@ func (k *KafkaBridge) ProducerSuccessLoop(consumer KafkaConsumer, producer Kafka
 	log.Debugf("Kafka producer successes loop started")
 	defer wg.Done()
 	for msg := range producer.Successes() {
+		if notification, ok := msg.Metadata.(*notificationMetadata); ok {
+			log.Infof("Notification sent for request %s", notification.reqID)
+			continue
+		}
 		k.inFlightCond.L.Lock()
 		reqOffset := msg.Metadata.(string)
 		if ctx, ok := k.inFlight[reqOffset]; ok {
@@ -407,7 +516,23 @@ func (k *KafkaBridge) connect() (err error) {
 	if k.rpc, err = eth.RPCConnect(&k.conf.RPC); err != nil {
 		return
 	}
-	k.processor.Init(k.rpc)
+	// Recovered transactions are claimed when Kafka redelivers the uncommitted request
+	// messages. Any left after the grace period, including those whose offsets were
+	// already committed, are resumed with their replies delivered as notifications
+	if err = k.processor.Init(k.rpc); err != nil {
+		return
+	}
+	graceSec := k.conf.RecoveryGraceSec
+	if graceSec <= 0 {
+		graceSec = defaultRecoveryGraceSec
+	}
+	time.AfterFunc(time.Duration(graceSec)*time.Second, func() {
+		k.processor.Resume(k.newNotificationContext)
+	})
+	k.processor.WatchReorgs(k.newNotificationContext)
+	// The requests for scheduled transactions are already committed, so replies
+	// are delivered in the same way as notifications when they are released
+	k.processor.ReleaseScheduled(k.newNotificationContext)
 	return
 }
 
@@ -427,5 +552,6 @@ func (k *KafkaBridge) Start() (err error) {
 
 	// Defer to KafkaCommon processing
 	err = k.kafka.Start()
+	k.processor.Close()
 	return
 }
@@ -15,6 +15,7 @@
 package kafka
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -43,6 +44,7 @@ type testKafkaCommon struct {
 	startErr        error
 	validateErr     error
 	cobraInitCalled bool
+	producer        KafkaProducer
 }
 
 func (k *testKafkaCommon) Start() error {
@@ -71,22 +73,82 @@ func (k *testKafkaCommon) SetConf(*KafkaCommonConf) {
 }
 
 func (k *testKafkaCommon) Producer() KafkaProducer {
-	return nil
+	return k.producer
 }
 
 type testKafkaMsgProcessor struct {
-	messages chan tx.TxnContext
-	rpc      eth.RPCClient
+	messages               chan tx.TxnContext
+	rpc                    eth.RPCClient
+	newNotificationContext tx.RecoveredTxnContextFactory
+	newScheduledContext    tx.RecoveredTxnContextFactory
+	resumed                chan tx.RecoveredTxnContextFactory
 }
 
 func (p *testKafkaMsgProcessor) ResolveAddress(from string) (resolvedFrom string, err error) {
 	return from, nil
 }
 
-func (p *testKafkaMsgProcessor) Init(rpc eth.RPCClient) {
+func (p *testKafkaMsgProcessor) Init(rpc eth.RPCClient) error {
 	p.rpc = rpc
+	return nil
+}
+
+func (p *testKafkaMsgProcessor) Resume(newTxnContext tx.RecoveredTxnContextFactory) {
+	if p.resumed != nil {
+		p.resumed <- newTxnContext
+	}
+}
+
+func (p *testKafkaMsgProcessor) WatchReorgs(newTxnContext tx.RecoveredTxnContextFactory) {
+	p.newNotificationContext = newTxnContext
+}
+
+func (p *testKafkaMsgProcessor) PendingTransaction(id string) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+
+func (p *testKafkaMsgProcessor) CancelTransaction(ctx context.Context, id string) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+
+func (p *testKafkaMsgProcessor) ReplaceTransaction(ctx context.Context, id string, fees *messages.TransactionFees) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+
+func (p *testKafkaMsgProcessor) NonceStates() []*messages.NonceState {
+	return nil
+}
+
+func (p *testKafkaMsgProcessor) NonceState(address string) (*messages.NonceState, error) {
+	return nil, nil
+}
+
+func (p *testKafkaMsgProcessor) ResyncNonce(ctx context.Context, address string) (*messages.NonceState, error) {
+	return nil, nil
+}
+
+func (p *testKafkaMsgProcessor) FillNonceGap(ctx context.Context, address string, nonce int64) (*messages.PendingTransaction, error) {
+	return nil, nil
+}
+
+func (p *testKafkaMsgProcessor) ReleaseScheduled(newTxnContext tx.RecoveredTxnContextFactory) {
+	p.newScheduledContext = newTxnContext
+}
+
+func (p *testKafkaMsgProcessor) ScheduledTransactions() []*messages.ScheduledTransaction {
+	return nil
+}
+
+func (p *testKafkaMsgProcessor) ScheduledTransaction(id string) (*messages.ScheduledTransaction, error) {
+	return nil, nil
 }
 
+func (p *testKafkaMsgProcessor) CancelScheduled(id string) (*messages.ScheduledTransaction, error) {
+	return nil, nil
+}
+
+func (p *testKafkaMsgProcessor) Close() {}
+
 func (p *testKafkaMsgProcessor) OnMessage(msg tx.TxnContext) {
 	log.Infof("Dispatched message context to processor: %s", msg)
 	p.messages <- msg
@@ -175,6 +237,24 @@ func TestDefIntWithGoodEnvVar(t *testing.T) {
 	assert.Equal(123, k.conf.MaxInFlight)
 }
 
+func TestRecoveredResumedAfterGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+
+	k, kafkaCmd := newTestKafkaBridge()
+	processor := k.processor.(*testKafkaMsgProcessor)
+	processor.resumed = make(chan tx.RecoveredTxnContextFactory, 1)
+	args := append([]string{"--recovery-grace", "1"}, kbMinWorkingArgs...)
+	kafkaCmd.SetArgs(args)
+	err := kafkaCmd.Execute()
+	assert.Nil(err)
+	assert.Equal(1, k.conf.RecoveryGraceSec)
+
+	newTxnContext := <-processor.resumed
+	assert.NotNil(newTxnContext)
+	msgCtx := &msgContext{reqOffset: "in:0:12345"}
+	assert.Equal("in:0:12345", msgCtx.RedeliveryKey())
+}
+
 func TestPrintYAML(t *testing.T) {
 	assert := assert.New(t)
 
@@ -598,3 +678,83 @@ func TestProducerSuccessLoopPanicsMsgNotInflight(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestNotificationReply(t *testing.T) {
+	assert := assert.New(t)
+
+	k, _, mockConsumer, mockProducer, wg := setupMocks()
+	k.kafka.(*testKafkaCommon).producer = mockProducer
+	wg.Add(1)
+	go k.ProducerErrorLoop(mockConsumer, mockProducer, wg)
+
+	headers := &messages.CommonHeaders{
+		ID:      "req1",
+		MsgType: messages.MsgTypeSendTransaction,
+		Account: "0xAA983AD2a0e0eD8ac639277F37be42F2A5d2618c",
+		Context: map[string]interface{}{"some": "data"},
+	}
+	notifyCtx := k.newNotificationContext(headers, time.Now().UTC())
+	assert.Equal(headers, notifyCtx.Headers())
+	assert.NotNil(notifyCtx.Context())
+	assert.Regexp("not available for notification", notifyCtx.Unmarshal(nil))
+	assert.Equal("Notification[SendTransaction/req1]", notifyCtx.String())
+
+	go func() {
+		var notification messages.TransactionReorged
+		notification.Headers.MsgType = messages.MsgTypeTransactionReorged
+		notification.Removed = true
+		notifyCtx.Reply(&notification)
+	}()
+	notifyMsg := <-mockProducer.MockInput
+	assert.Equal(sarama.StringEncoder(headers.Account), notifyMsg.Key)
+	replyBytes, _ := notifyMsg.Value.Encode()
+	var notificationSent messages.TransactionReorged
+	err := json.Unmarshal(replyBytes, &notificationSent)
+	assert.NoError(err)
+	assert.Equal(messages.MsgTypeTransactionReorged, notificationSent.Headers.MsgType)
+	assert.Equal("req1", notificationSent.Headers.ReqID)
+	assert.Empty(notificationSent.Headers.ReqOffset)
+	assert.Equal("data", notificationSent.Headers.Context["some"])
+	assert.True(notificationSent.Removed)
+
+	// Neither a success or an error for the notification affects the in-flight requests
+	mockProducer.MockSuccesses <- notifyMsg
+	mockProducer.MockErrors <- &sarama.ProducerError{Err: fmt.Errorf("pop"), Msg: notifyMsg}
+
+	go notifyCtx.SendErrorReply(500, fmt.Errorf("pop"))
+	errMsg := <-mockProducer.MockInput
+	replyBytes, _ = errMsg.Value.Encode()
+	var errReply messages.ErrorReply
+	json.Unmarshal(replyBytes, &errReply)
+	assert.Equal("pop", errReply.ErrorMessage)
+	assert.Equal(0, len(k.inFlight))
+
+	mockProducer.AsyncClose()
+	mockConsumer.Close()
+	wg.Wait()
+}
+
+func TestMsgContextNewItemContext(t *testing.T) {
+	assert := assert.New(t)
+
+	k, _, _, _, _ := setupMocks()
+	var txnContext tx.TxnContext = &msgContext{bridge: k}
+	batchCtx, ok := txnContext.(tx.BatchTxnContext)
+	assert.True(ok)
+	headers := &messages.CommonHeaders{
+		ID:      "batch1-0",
+		MsgType: messages.MsgTypeSendTransaction,
+		BatchID: "batch1",
+	}
+	itemCtx := batchCtx.NewItemContext(headers)
+	assert.Equal(headers, itemCtx.Headers())
+	assert.Equal("Notification[SendTransaction/batch1-0]", itemCtx.String())
+}
+
+func TestMsgContextBackpressure(t *testing.T) {
+	assert := assert.New(t)
+	var txnContext tx.TxnContext = &msgContext{}
+	bpCtx, ok := txnContext.(tx.BackpressureTxnContext)
+	assert.True(ok)
+	assert.True(bpCtx.Backpressure())
+}
@@ -15,6 +15,8 @@
 package kvstore
 
 import (
+	"sort"
+
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
@@ -47,11 +49,41 @@ func (m *MockKV) Delete(key string) error {
 	return m.DeleteErr
 }
 
-// NewIterator for a new iterator
+// NewIterator for a new iterator, over a sorted snapshot of the keys
 func (m *MockKV) NewIterator() KVIterator {
-	return nil // not implemented in mock
+	keys := make([]string, 0, len(m.KVS))
+	for k := range m.KVS {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return &mockKVIterator{
+		kv:   m,
+		keys: keys,
+		idx:  -1,
+	}
+}
+
+type mockKVIterator struct {
+	kv   *MockKV
+	keys []string
+	idx  int
+}
+
+func (i *mockKVIterator) Key() string {
+	return i.keys[i.idx]
+}
+
+func (i *mockKVIterator) Value() []byte {
+	return i.kv.KVS[i.keys[i.idx]]
 }
 
+func (i *mockKVIterator) Next() bool {
+	i.idx++
+	return i.idx < len(i.keys)
+}
+
+func (i *mockKVIterator) Release() {}
+
 // Close it
 func (m *MockKV) Close() {}
 
@@ -35,3 +35,23 @@ func TestExerciseMockLDB(t *testing.T) {
 	m.Close()
 
 }
+
+func TestMockLDBIterator(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := NewMockKV(nil)
+	m.Put("key2", []byte("val2"))
+	m.Put("key1", []byte("val1"))
+
+	it := m.NewIterator()
+	defer it.Release()
+	assert.True(it.Next())
+	assert.Equal("key1", it.Key())
+	assert.Equal("val1", string(it.Value()))
+	assert.True(it.Next())
+	assert.Equal("key2", it.Key())
+	assert.Equal("val2", string(it.Value()))
+	assert.False(it.Next())
+
+}
@@ -28,10 +28,26 @@ const (
 	MsgTypeDeployContract = "DeployContract"
 	// MsgTypeSendTransaction - send a transaction
 	MsgTypeSendTransaction = "SendTransaction"
+	// MsgTypeSendRawTransaction - send a transaction that was signed by the submitter
+	MsgTypeSendRawTransaction = "SendRawTransaction"
+	// MsgTypeBatch - an ordered list of transactions from the same sender
+	MsgTypeBatch = "Batch"
+	// MsgTypeBatchComplete - every transaction in a batch has been replied to
+	MsgTypeBatchComplete = "BatchComplete"
 	// MsgTypeTransactionSuccess - a transaction receipt where status is 1
 	MsgTypeTransactionSuccess = "TransactionSuccess"
 	// MsgTypeTransactionFailure - a transaction receipt where status is 0
 	MsgTypeTransactionFailure = "TransactionFailure"
+	// MsgTypeTransactionCancelled - a transaction receipt where the nonce was consumed by a cancel transaction
+	MsgTypeTransactionCancelled = "TransactionCancelled"
+	// MsgTypeTransactionReorged - the block in a receipt that was already delivered is no longer in the canonical chain
+	MsgTypeTransactionReorged = "TransactionReorged"
+	// MsgTypeTransactionSimulation - the outcome of simulating a transaction, that was not submitted
+	MsgTypeTransactionSimulation = "TransactionSimulation"
+	// MsgTypeTransactionPrepared - an unsigned transaction, returned for signing outside of the gateway
+	MsgTypeTransactionPrepared = "TransactionPrepared"
+	// MsgTypeTransactionScheduled - a transaction is held by the scheduler, and will be submitted when it is due
+	MsgTypeTransactionScheduled = "TransactionScheduled"
 	// RecordHeaderAccessToken - record header name for passing JWT token over messaging
 	RecordHeaderAccessToken = "fly-accesstoken"
 )
@@ -41,6 +57,40 @@ type AsyncSentMsg struct {
 	Sent    bool   `json:"sent"`
 	Request string `json:"id"`
 	Msg     string `json:"msg,omitempty"`
+	// Receipt is included when a repeated request returns the reply to the original request
+	Receipt map[string]interface{} `json:"receipt,omitempty"`
+}
+
+// TransactionFees are the new gas settings to replace a pending transaction
+type TransactionFees struct {
+	Gas                  json.Number `json:"gas,omitempty"`
+	GasPrice             json.Number `json:"gasPrice,omitempty"`
+	TxType               json.Number `json:"txType,omitempty"`
+	MaxFeePerGas         json.Number `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas json.Number `json:"maxPriorityFeePerGas,omitempty"`
+}
+
+// PendingTransaction is the status of a transaction that is waiting to be mined
+type PendingTransaction struct {
+	RequestID       string   `json:"requestId,omitempty"`
+	From            string   `json:"from"`
+	Nonce           int64    `json:"nonce"`
+	TransactionHash string   `json:"transactionHash"`
+	SubmittedHashes []string `json:"submittedHashes,omitempty"`
+	Cancelling      bool     `json:"cancelling,omitempty"`
+}
+
+// NonceState is the nonce tracking state for an address with transactions in-flight
+type NonceState struct {
+	Address      string                `json:"address"`
+	HighestNonce int64                 `json:"highestNonce"`
+	InFlight     int                   `json:"inFlight"`
+	Transactions []*PendingTransaction `json:"transactions,omitempty"`
+}
+
+// NonceGapFill is a request to submit a gap-fill transaction for a nonce
+type NonceGapFill struct {
+	Nonce json.Number `json:"nonce"`
 }
 
 // CommonHeaders are common to all messages
@@ -49,6 +99,7 @@ type CommonHeaders struct {
 	MsgType string                 `json:"type"`
 	Account string                 `json:"account,omitempty"`
 	Context map[string]interface{} `json:"ctx,omitempty"`
+	BatchID string                 `json:"batchId,omitempty"`
 }
 
 // RequestCommon is a common interface to all requests
@@ -68,6 +119,8 @@ type ReplyHeaders struct {
 	Elapsed   float64 `json:"timeElapsed"`
 	ReqOffset string  `json:"requestOffset"`
 	ReqID     string  `json:"requestId"`
+	// Scheduled is set on the replies to a transaction released by the scheduler, which replace the TransactionScheduled reply
+	Scheduled bool `json:"scheduled,omitempty"`
 }
 
 // ReplyWithHeaders gives common access the reply headers
@@ -111,6 +164,23 @@ type TransactionCommon struct {
 	PrivateFrom    string        `json:"privateFrom,omitempty"`
 	PrivateFor     []string      `json:"privateFor,omitempty"`
 	PrivacyGroupID string        `json:"privacyGroupId,omitempty"`
+	// EIP-1559 extensions for dynamic-fee transactions
+	TxType               json.Number `json:"txType,omitempty"`
+	MaxFeePerGas         json.Number `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas json.Number `json:"maxPriorityFeePerGas,omitempty"`
+	// Overrides the configured strategy for choosing a gas price, when no fees are supplied
+	GasPriceStrategy string `json:"gasPriceStrategy,omitempty"`
+	// The ID of the ABI in the contract gateway, which selects the gas estimation overrides for the contract
+	ABIID string `json:"abiId,omitempty"`
+	// Overrides the configured number of blocks to wait for, before replying with the receipt
+	Confirmations json.Number `json:"confirmations,omitempty"`
+	// Simulates the transaction against the pending block, rather than submitting it
+	Simulate bool `json:"simulate,omitempty"`
+	// Returns the unsigned transaction for signing by an external wallet, rather than submitting it
+	Prepare bool `json:"prepare,omitempty"`
+	// Holds the transaction in the scheduler until the time (RFC3339) and/or block number is reached
+	NotBefore      string      `json:"notBefore,omitempty"`
+	NotBeforeBlock json.Number `json:"notBeforeBlock,omitempty"`
 }
 
 // SendTransaction message instructs the bridge to install a contract
@@ -119,6 +189,28 @@ type SendTransaction struct {
 	To         string                           `json:"to"`
 	Method     *ethbinding.ABIElementMarshaling `json:"method,omitempty"`
 	MethodName string                           `json:"methodName,omitempty"`
+	// The custom errors of the contract ABI, used to decode the reason if the transaction reverts
+	Errors []*ethbinding.ABIElementMarshaling `json:"errors,omitempty"`
+}
+
+// SendRawTransaction message instructs the bridge to submit a transaction that was signed
+// offline. The sender, nonce and fees all come from the signed transaction
+type SendRawTransaction struct {
+	RequestCommon
+	RawTransaction string `json:"rawTransaction"`
+	// Overrides the configured number of blocks to wait for, before replying with the receipt
+	Confirmations json.Number `json:"confirmations,omitempty"`
+	// The custom errors of the contract ABI, used to decode the reason if the transaction reverts
+	Errors []*ethbinding.ABIElementMarshaling `json:"errors,omitempty"`
+}
+
+// Batch message instructs the bridge to submit an ordered list of SendTransaction and
+// DeployContract messages from the same sender, which are assigned consecutive nonces.
+// Each item is replied to separately, then the batch once every item has been replied to
+type Batch struct {
+	RequestCommon
+	From  string            `json:"from"`
+	Items []json.RawMessage `json:"items"`
 }
 
 // DeployContract message instructs the bridge to install a contract
@@ -160,16 +252,139 @@ type TransactionReceipt struct {
 	TransactionIndexStr  string                `json:"transactionIndex"`
 	TransactionIndexHex  *ethbinding.HexUint   `json:"transactionIndexHex,omitempty"`
 	RegisterAs           string                `json:"registerAs,omitempty"`
+	SubmittedHashes      []string              `json:"submittedHashes,omitempty"`
+	GasStr               string                `json:"gas,omitempty"`
+	GasEstimateStr       string                `json:"gasEstimate,omitempty"`
+	ConfirmationsStr     string                `json:"confirmations,omitempty"`
+	HeadBlockNumberStr   string                `json:"headBlockNumber,omitempty"`
+	RevertReason         string                `json:"revertReason,omitempty"`
+	RevertData           string                `json:"revertData,omitempty"`
+	Revert               *RevertError          `json:"revert,omitempty"`
+	Logs                 []*TransactionLog     `json:"logs,omitempty"`
+}
+
+// TransactionLog is a log emitted by a mined transaction. The event is decoded into
+// the name, signature and args when the gateway knows the ABI of the emitting contract
+type TransactionLog struct {
+	Address   *ethbinding.Address    `json:"address"`
+	LogIndex  string                 `json:"logIndex"`
+	Topics    []*ethbinding.Hash     `json:"topics"`
+	Data      string                 `json:"data"`
+	Event     string                 `json:"event,omitempty"`
+	Signature string                 `json:"signature,omitempty"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// TransactionReorged is sent after a receipt has been delivered, if the block that
+// contained the transaction is replaced in a chain reorganization. The receipt fields
+// are from the block the transaction is now mined in, unless it has been removed
+type TransactionReorged struct {
+	TransactionReceipt
+	PreviousBlockHash      *ethbinding.Hash `json:"previousBlockHash"`
+	PreviousBlockNumberStr string           `json:"previousBlockNumber"`
+	Removed                bool             `json:"removed"`
+}
+
+// BatchComplete is sent once every item in a batch has been replied to. The reply to each
+// item has its own request ID, and the ID of the batch in the batchId header
+type BatchComplete struct {
+	ReplyCommon
+	Items []*BatchItemResult `json:"items"`
+}
+
+// BatchItemResult summarizes the reply to an item in a batch
+type BatchItemResult struct {
+	RequestID       string `json:"requestId"`
+	Type            string `json:"type"`
+	TransactionHash string `json:"transactionHash,omitempty"`
+	ErrorMessage    string `json:"errorMessage,omitempty"`
+}
+
+// TransactionSimulation is sent in reply to a request to simulate a transaction. Nothing
+// is signed or submitted, so the nonce is the one that would be used if sent now
+type TransactionSimulation struct {
+	ReplyCommon
+	From           string                 `json:"from"`
+	To             string                 `json:"to,omitempty"`
+	NonceStr       string                 `json:"nonce"`
+	GasEstimateStr string                 `json:"gasEstimate,omitempty"`
+	Outputs        map[string]interface{} `json:"outputs,omitempty"`
+	Reverted       bool                   `json:"reverted"`
+	RevertReason   string                 `json:"revertReason,omitempty"`
+	Revert         *RevertError           `json:"revert,omitempty"`
+}
+
+// TransactionPrepared is sent in reply to a request to prepare a transaction. The nonce is
+// not reserved, and nothing is submitted until the signed transaction is sent with a
+// SendRawTransaction message. The unsigned transaction is encoded with zero v, r and s
+// values, rather than as the EIP-155 signing payload that the signing hash is taken over
+type TransactionPrepared struct {
+	ReplyCommon
+	From                    string `json:"from"`
+	To                      string `json:"to,omitempty"`
+	ChainIDStr              string `json:"chainId"`
+	TxTypeStr               string `json:"txType"`
+	NonceStr                string `json:"nonce"`
+	GasStr                  string `json:"gas"`
+	GasEstimateStr          string `json:"gasEstimate,omitempty"`
+	GasPriceStr             string `json:"gasPrice,omitempty"`
+	MaxFeePerGasStr         string `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGasStr string `json:"maxPriorityFeePerGas,omitempty"`
+	ValueStr                string `json:"value"`
+	Data                    string `json:"data"`
+	UnsignedTransaction     string `json:"unsignedTransaction"`
+	SigningHash             string `json:"signingHash"`
+}
+
+// TransactionScheduled is sent in reply to a request with a notBefore time or block number that
+// has not been reached. The transaction is submitted when it is due, and the receipt delivered then
+type TransactionScheduled struct {
+	ReplyCommon
+	NotBefore      string `json:"notBefore,omitempty"`
+	NotBeforeBlock string `json:"notBeforeBlock,omitempty"`
+}
+
+// ScheduledTransaction is the status of a transaction that is held by the scheduler until it is due
+type ScheduledTransaction struct {
+	RequestID      string `json:"requestId"`
+	Type           string `json:"type"`
+	From           string `json:"from"`
+	NotBefore      string `json:"notBefore,omitempty"`
+	NotBeforeBlock string `json:"notBeforeBlock,omitempty"`
+	Scheduled      string `json:"scheduled"`
+}
+
+// RevertError is the decoded reason a transaction or call reverted. The name is Error or Panic
+// for the errors built into Solidity, or the name of a custom error from the ABI.
+// The args of a Panic include a readable reason for the code
+type RevertError struct {
+	Name      string                 `json:"name"`
+	Signature string                 `json:"signature"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// reverted is implemented by errors that carry the decoded reason for a revert
+type reverted interface {
+	Revert() *RevertError
+}
+
+// RevertFromError returns the decoded reason for a revert carried by the error, if any
+func RevertFromError(err error) *RevertError {
+	if r, ok := err.(reverted); ok {
+		return r.Revert()
+	}
+	return nil
 }
 
 // ErrorReply is
 type ErrorReply struct {
 	ReplyCommon
-	ErrorMessage     string `json:"errorMessage,omitempty"`
-	OriginalMessage  string `json:"requestPayload,omitempty"`
-	TXHash           string `json:"transactionHash,omitempty"`
-	GapFillTxHash    string `json:"gapFillTxHash,omitempty"`
-	GapFillSucceeded *bool  `json:"gapFillSucceeded,omitempty"`
+	ErrorMessage     string       `json:"errorMessage,omitempty"`
+	OriginalMessage  string       `json:"requestPayload,omitempty"`
+	TXHash           string       `json:"transactionHash,omitempty"`
+	GapFillTxHash    string       `json:"gapFillTxHash,omitempty"`
+	GapFillSucceeded *bool        `json:"gapFillSucceeded,omitempty"`
+	Revert           *RevertError `json:"revert,omitempty"`
 }
 
 // NewErrorReply is a helper to construct an error message
@@ -178,6 +393,7 @@ func NewErrorReply(err error, origMsg interface{}) *ErrorReply {
 	errMsg.Headers.MsgType = MsgTypeError
 	if err != nil {
 		errMsg.ErrorMessage = err.Error()
+		errMsg.Revert = RevertFromError(err)
 	}
 	if reflect.TypeOf(origMsg).Kind() == reflect.Slice {
 		errMsg.OriginalMessage = string(origMsg.([]byte))
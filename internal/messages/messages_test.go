@@ -90,6 +90,34 @@ func TestErrorMessageForUnparsableBinaryData(t *testing.T) {
 	assert.Equal("\u0000\ufffd\ufffd\ufffd\ufffd", unmarshaledErrMsg.OriginalMessage)
 }
 
+type testRevertErr struct {
+	revert *RevertError
+}
+
+func (e *testRevertErr) Error() string        { return "reverted" }
+func (e *testRevertErr) Revert() *RevertError { return e.revert }
+
+func TestErrorMessageForRevert(t *testing.T) {
+	assert := assert.New(t)
+
+	exampleErrMsg := NewErrorReply(&testRevertErr{
+		revert: &RevertError{
+			Name:      "InsufficientBalance",
+			Signature: "InsufficientBalance(uint256)",
+			Args:      map[string]interface{}{"available": "10"},
+		},
+	}, []byte{})
+	marshaledErrMsg, _ := json.Marshal(&exampleErrMsg)
+	var unmarshaledErrMsg ErrorReply
+	json.Unmarshal(marshaledErrMsg, &unmarshaledErrMsg)
+	assert.Equal("reverted", unmarshaledErrMsg.ErrorMessage)
+	assert.Equal("InsufficientBalance", unmarshaledErrMsg.Revert.Name)
+	assert.Equal("InsufficientBalance(uint256)", unmarshaledErrMsg.Revert.Signature)
+	assert.Equal("10", unmarshaledErrMsg.Revert.Args["available"])
+
+	assert.Nil(RevertFromError(fmt.Errorf("pop")))
+}
+
 func TestIsReceiptForReceipt(t *testing.T) {
 	assert := assert.New(t)
 	var m ReplyWithHeaders
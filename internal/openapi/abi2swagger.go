@@ -299,6 +299,66 @@ func (c *ABI2Swagger) getCommonParameters() map[string]spec.Parameter {
 			Type: "integer",
 		},
 	}
+	params["txTypeParam"] = spec.Parameter{
+		ParamProps: spec.ParamProps{
+			Description:     fmt.Sprintf("Transaction type - 0 for legacy, 2 for EIP-1559 dynamic fee (inferred from the fees if not set) (header: x-%s-txtype)", utils.GetenvOrDefaultLowerCase("PREFIX_LONG", "firefly")),
+			Name:            fmt.Sprintf("%s-txtype", utils.GetenvOrDefaultLowerCase("PREFIX_SHORT", "fly")),
+			In:              "query",
+			Required:        false,
+			AllowEmptyValue: true,
+		},
+		SimpleSchema: spec.SimpleSchema{
+			Type: "integer",
+		},
+	}
+	params["maxFeePerGasParam"] = spec.Parameter{
+		ParamProps: spec.ParamProps{
+			Description:     fmt.Sprintf("EIP-1559 maximum total fee per gas, including the base fee (header: x-%s-maxfeepergas)", utils.GetenvOrDefaultLowerCase("PREFIX_LONG", "firefly")),
+			Name:            fmt.Sprintf("%s-maxfeepergas", utils.GetenvOrDefaultLowerCase("PREFIX_SHORT", "fly")),
+			In:              "query",
+			Required:        false,
+			AllowEmptyValue: true,
+		},
+		SimpleSchema: spec.SimpleSchema{
+			Type: "integer",
+		},
+	}
+	params["maxPriorityFeePerGasParam"] = spec.Parameter{
+		ParamProps: spec.ParamProps{
+			Description:     fmt.Sprintf("EIP-1559 maximum priority fee (tip) per gas (header: x-%s-maxpriorityfeepergas)", utils.GetenvOrDefaultLowerCase("PREFIX_LONG", "firefly")),
+			Name:            fmt.Sprintf("%s-maxpriorityfeepergas", utils.GetenvOrDefaultLowerCase("PREFIX_SHORT", "fly")),
+			In:              "query",
+			Required:        false,
+			AllowEmptyValue: true,
+		},
+		SimpleSchema: spec.SimpleSchema{
+			Type: "integer",
+		},
+	}
+	params["gasPriceStrategyParam"] = spec.Parameter{
+		ParamProps: spec.ParamProps{
+			Description:     fmt.Sprintf("Strategy for choosing the gas price when no fees are set - none, fixed, node, feeHistory or oracle (header: x-%s-gaspricestrategy)", utils.GetenvOrDefaultLowerCase("PREFIX_LONG", "firefly")),
+			Name:            fmt.Sprintf("%s-gaspricestrategy", utils.GetenvOrDefaultLowerCase("PREFIX_SHORT", "fly")),
+			In:              "query",
+			Required:        false,
+			AllowEmptyValue: true,
+		},
+		SimpleSchema: spec.SimpleSchema{
+			Type: "string",
+		},
+	}
+	params["confirmationsParam"] = spec.Parameter{
+		ParamProps: spec.ParamProps{
+			Description:     fmt.Sprintf("Number of blocks, including the one containing the transaction, before the receipt is returned (header: x-%s-confirmations)", utils.GetenvOrDefaultLowerCase("PREFIX_LONG", "firefly")),
+			Name:            fmt.Sprintf("%s-confirmations", utils.GetenvOrDefaultLowerCase("PREFIX_SHORT", "fly")),
+			In:              "query",
+			Required:        false,
+			AllowEmptyValue: true,
+		},
+		SimpleSchema: spec.SimpleSchema{
+			Type: "integer",
+		},
+	}
 	params["syncParam"] = spec.Parameter{
 		ParamProps: spec.ParamProps{
 			Description:     fmt.Sprintf("Block the HTTP request until the tx is mined (does not store the receipt) (header: x-%s-sync)", utils.GetenvOrDefaultLowerCase("PREFIX_LONG", "firefly")),
@@ -397,6 +457,11 @@ func (c *ABI2Swagger) addCommonParams(op *spec.Operation, isPOST bool, isConstru
 	valueParam, _ := spec.NewRef("#/parameters/valueParam")
 	gasParam, _ := spec.NewRef("#/parameters/gasParam")
 	gaspriceParam, _ := spec.NewRef("#/parameters/gaspriceParam")
+	txTypeParam, _ := spec.NewRef("#/parameters/txTypeParam")
+	maxFeePerGasParam, _ := spec.NewRef("#/parameters/maxFeePerGasParam")
+	maxPriorityFeePerGasParam, _ := spec.NewRef("#/parameters/maxPriorityFeePerGasParam")
+	gasPriceStrategyParam, _ := spec.NewRef("#/parameters/gasPriceStrategyParam")
+	confirmationsParam, _ := spec.NewRef("#/parameters/confirmationsParam")
 	syncParam, _ := spec.NewRef("#/parameters/syncParam")
 	callParam, _ := spec.NewRef("#/parameters/callParam")
 	privateFromParam, _ := spec.NewRef("#/parameters/privateFromParam")
@@ -424,6 +489,31 @@ func (c *ABI2Swagger) addCommonParams(op *spec.Operation, isPOST bool, isConstru
 			Ref: gaspriceParam,
 		},
 	})
+	op.Parameters = append(op.Parameters, spec.Parameter{
+		Refable: spec.Refable{
+			Ref: txTypeParam,
+		},
+	})
+	op.Parameters = append(op.Parameters, spec.Parameter{
+		Refable: spec.Refable{
+			Ref: maxFeePerGasParam,
+		},
+	})
+	op.Parameters = append(op.Parameters, spec.Parameter{
+		Refable: spec.Refable{
+			Ref: maxPriorityFeePerGasParam,
+		},
+	})
+	op.Parameters = append(op.Parameters, spec.Parameter{
+		Refable: spec.Refable{
+			Ref: gasPriceStrategyParam,
+		},
+	})
+	op.Parameters = append(op.Parameters, spec.Parameter{
+		Refable: spec.Refable{
+			Ref: confirmationsParam,
+		},
+	})
 	if isPOST {
 		op.Parameters = append(op.Parameters, spec.Parameter{
 			Refable: spec.Refable{
@@ -0,0 +1,219 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// IdempotencyKeyHeader is the HTTP header a client can use to identify retries of the same request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// defaultIdempotencyWindowSec is how long a request is remembered, when not configured
+	defaultIdempotencyWindowSec = 24 * 60 * 60
+)
+
+// IdempotencyConf is the configuration for detecting repeated submissions of the same request
+type IdempotencyConf struct {
+	LevelDBPath string `json:"levelDB,omitempty"`
+	WindowSec   int    `json:"windowSec,omitempty"`
+}
+
+// idempotencyRecord is the persisted reply to a request submitted with an idempotency key
+type idempotencyRecord struct {
+	Created time.Time              `json:"created"`
+	Reply   *messages.AsyncSentMsg `json:"reply"`
+}
+
+type idempotencyKeyContextKey struct{}
+
+// idempotency records the reply to each request submitted with an idempotency key, so
+// a retry of that request within the window returns the original reply (and the receipt,
+// once stored) rather than submitting the transaction a second time
+type idempotency struct {
+	db         kvstore.KVStore
+	window     time.Duration
+	receipts   *receiptStore
+	lock       sync.Mutex
+	inProgress map[string]bool
+	lastPurge  time.Time
+	purging    sync.WaitGroup
+}
+
+func newIdempotency(db kvstore.KVStore, windowSec int, receipts *receiptStore) *idempotency {
+	if windowSec <= 0 {
+		windowSec = defaultIdempotencyWindowSec
+	}
+	i := &idempotency{
+		db:         db,
+		window:     time.Duration(windowSec) * time.Second,
+		receipts:   receipts,
+		inProgress: make(map[string]bool),
+		lastPurge:  time.Now(),
+	}
+	i.purgeExpired()
+	return i
+}
+
+// openIdempotency opens the LevelDB configured to record idempotency keys
+func openIdempotency(conf *IdempotencyConf, receipts *receiptStore) (*idempotency, error) {
+	db, err := kvstore.NewLDBKeyValueStore(conf.LevelDBPath)
+	if err != nil {
+		return nil, errors.Errorf(errors.WebhooksIdempotencyDBLoad, conf.LevelDBPath, err)
+	}
+	return newIdempotency(db, conf.WindowSec, receipts), nil
+}
+
+// withIdempotencyKey adds the key from the Idempotency-Key HTTP header of a request to its
+// context, so it is available however the request is dispatched to the webhooks
+func withIdempotencyKey(parent http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if key := req.Header.Get(IdempotencyKeyHeader); key != "" {
+			req = req.WithContext(context.WithValue(req.Context(), idempotencyKeyContextKey{}, key))
+		}
+		parent.ServeHTTP(res, req)
+	})
+}
+
+// idempotencyKey returns the key from the Idempotency-Key HTTP header, or the ID
+// the client supplied in the headers of the message. Keys are scoped to the authenticated
+// identity, so a client cannot be given the reply to the request of another client
+func idempotencyKey(ctx context.Context, headers map[string]interface{}) string {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	if !ok || key == "" {
+		key, _ = headers["id"].(string)
+	}
+	if key == "" {
+		return ""
+	}
+	if identity := auth.GetIdentity(ctx); identity != "" {
+		return url.PathEscape(identity) + "/" + key
+	}
+	return key
+}
+
+// begin returns the reply to an earlier request with the same key, if there was one within
+// the window. Otherwise the key is reserved until complete is called, so a concurrent retry
+// is rejected rather than submitted
+func (i *idempotency) begin(key string) (*messages.AsyncSentMsg, int, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.inProgress[key] {
+		return nil, 409, errors.Errorf(errors.WebhooksIdempotencyInProgress, key)
+	}
+	b, err := i.db.Get(key)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, 500, errors.Errorf(errors.WebhooksIdempotencyLookup, key, err)
+	}
+	if err == nil {
+		var record idempotencyRecord
+		if err := json.Unmarshal(b, &record); err != nil {
+			log.Warnf("Discarding invalid idempotency record for key '%s': %s", key, err)
+		} else if record.Reply != nil && time.Since(record.Created) < i.window {
+			return i.withReceipt(record.Reply), 200, nil
+		}
+	}
+	i.inProgress[key] = true
+	return nil, 200, nil
+}
+
+// complete releases the key, and records the reply if the request was submitted.
+// Once per window, expired records are purged in the background
+func (i *idempotency) complete(key string, reply *messages.AsyncSentMsg) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	delete(i.inProgress, key)
+	if reply == nil {
+		return
+	}
+	b, _ := json.Marshal(&idempotencyRecord{
+		Created: time.Now().UTC(),
+		Reply:   reply,
+	})
+	if err := i.db.Put(key, b); err != nil {
+		log.Errorf("Failed to record idempotency key '%s' for request %s: %s", key, reply.Request, err)
+	}
+	if time.Since(i.lastPurge) > i.window {
+		i.lastPurge = time.Now()
+		i.purging.Add(1)
+		go func() {
+			defer i.purging.Done()
+			i.purgeExpired()
+		}()
+	}
+}
+
+// withReceipt returns a copy of the original reply, including the receipt if one has been stored
+func (i *idempotency) withReceipt(original *messages.AsyncSentMsg) *messages.AsyncSentMsg {
+	reply := *original
+	if i.receipts == nil {
+		return &reply
+	}
+	receipt, err := i.receipts.persistence.GetReceipt(reply.Request)
+	if err != nil {
+		log.Warnf("Failed to get receipt for repeated request %s: %s", reply.Request, err)
+	} else if receipt != nil {
+		reply.Receipt = *receipt
+	}
+	return &reply
+}
+
+// purgeExpired deletes the records of requests older than the window. The records are
+// scanned without holding the lock, which is only taken to delete each expired record,
+// in case the key has been used again since it was scanned
+func (i *idempotency) purgeExpired() {
+	var expired []string
+	it := i.db.NewIterator()
+	for it.Next() {
+		if i.isExpired(it.Value()) {
+			expired = append(expired, it.Key())
+		}
+	}
+	it.Release()
+	for _, key := range expired {
+		i.lock.Lock()
+		if b, err := i.db.Get(key); err == nil && i.isExpired(b) {
+			i.db.Delete(key)
+		}
+		i.lock.Unlock()
+	}
+	if len(expired) > 0 {
+		log.Infof("Purged %d expired idempotency records", len(expired))
+	}
+}
+
+func (i *idempotency) isExpired(b []byte) bool {
+	var record idempotencyRecord
+	return json.Unmarshal(b, &record) != nil || time.Since(record.Created) >= i.window
+}
+
+func (i *idempotency) close() {
+	i.purging.Wait()
+	i.db.Close()
+}
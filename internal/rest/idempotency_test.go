@@ -0,0 +1,277 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/auth/authtest"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+const testIdempotentMsg = `{"headers":{"type":"SendTransaction"},"from":"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832"}`
+
+func newTestIdempotentWebhooks(db kvstore.KVStore) (*webhooks, *mockHandler, *memoryReceipts, http.Handler) {
+	handler := &mockHandler{}
+	receipts, p := newReceiptsTestStore(nil)
+	w := newWebhooks(handler, nil)
+	w.idempotency = newIdempotency(db, 60, receipts)
+	router := &httprouter.Router{}
+	w.addRoutes(router)
+	return w, handler, p, withIdempotencyKey(router)
+}
+
+func postIdempotentMsg(h http.Handler, key, body string) (int, *messages.AsyncSentMsg) {
+	req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var reply messages.AsyncSentMsg
+	json.Unmarshal(rec.Body.Bytes(), &reply)
+	return rec.Code, &reply
+}
+
+func TestIdempotencyKeyHeaderRepeat(t *testing.T) {
+	assert := assert.New(t)
+
+	_, handler, receipts, h := newTestIdempotentWebhooks(kvstore.NewMockKV(nil))
+
+	status, first := postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(200, status)
+	assert.True(first.Sent)
+	assert.Equal(1, handler.sent)
+
+	status, repeat := postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(200, status)
+	assert.Equal(first.Request, repeat.Request)
+	assert.Nil(repeat.Receipt)
+	assert.Equal(1, handler.sent)
+
+	receipts.AddReceipt(first.Request, &map[string]interface{}{
+		"_id":             first.Request,
+		"transactionHash": "0x12345",
+	})
+	status, repeat = postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(200, status)
+	assert.Equal(first.Request, repeat.Request)
+	assert.Equal("0x12345", repeat.Receipt["transactionHash"])
+	assert.Equal(1, handler.sent)
+
+	status, other := postIdempotentMsg(h, "key2", testIdempotentMsg)
+	assert.Equal(200, status)
+	assert.NotEqual(first.Request, other.Request)
+	assert.Equal(2, handler.sent)
+}
+
+func TestIdempotencyKeyFromMsgID(t *testing.T) {
+	assert := assert.New(t)
+
+	_, handler, _, h := newTestIdempotentWebhooks(kvstore.NewMockKV(nil))
+	msg := strings.Replace(testIdempotentMsg, `"type"`, `"id":"key1","type"`, 1)
+
+	_, first := postIdempotentMsg(h, "", msg)
+	assert.NotEqual("key1", first.Request)
+	_, repeat := postIdempotentMsg(h, "", msg)
+	assert.Equal(first.Request, repeat.Request)
+	assert.Equal(1, handler.sent)
+
+	// Without a key every request is submitted
+	postIdempotentMsg(h, "", testIdempotentMsg)
+	postIdempotentMsg(h, "", testIdempotentMsg)
+	assert.Equal(3, handler.sent)
+}
+
+func TestIdempotencyKeyScopedToIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	headers := map[string]interface{}{"id": "key1"}
+	assert.Equal("key1", idempotencyKey(context.Background(), headers))
+
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+
+	ctx, _ := auth.WithAuthContext(context.Background(), "testat")
+	assert.Equal("verified/key1", idempotencyKey(ctx, headers))
+	ctx = context.WithValue(ctx, idempotencyKeyContextKey{}, "key2")
+	assert.Equal("verified/key2", idempotencyKey(ctx, headers))
+	assert.Equal("", idempotencyKey(context.Background(), map[string]interface{}{}))
+}
+
+func TestIdempotencyKeyExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	expired, _ := json.Marshal(&idempotencyRecord{
+		Created: time.Now().UTC().Add(-2 * time.Minute),
+		Reply:   &messages.AsyncSentMsg{Sent: true, Request: "old1"},
+	})
+	db.Put("key1", expired)
+	db.Put("key2", expired)
+	db.Put("key3", []byte("!json"))
+	_, handler, _, h := newTestIdempotentWebhooks(db)
+	assert.Empty(db.KVS)
+
+	db.Put("key1", expired)
+	_, reply := postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.NotEqual("old1", reply.Request)
+	assert.Equal(1, handler.sent)
+}
+
+func TestIdempotencyKeyFailedNotRecorded(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	_, handler, _, h := newTestIdempotentWebhooks(db)
+	handler.sendErr = fmt.Errorf("pop")
+
+	status, _ := postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(500, status)
+	assert.Empty(db.KVS)
+
+	handler.sendErr = nil
+	status, _ = postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(200, status)
+	assert.Equal(1, handler.sent)
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	w, handler, _, h := newTestIdempotentWebhooks(kvstore.NewMockKV(nil))
+	_, _, err := w.idempotency.begin("key1")
+	assert.NoError(err)
+
+	status, _ := postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(409, status)
+	assert.Equal(0, handler.sent)
+}
+
+func TestIdempotencyKeyLookupFail(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	_, handler, _, h := newTestIdempotentWebhooks(db)
+	db.LoadErr = fmt.Errorf("pop")
+
+	status, _ := postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(500, status)
+	assert.Equal(0, handler.sent)
+}
+
+func TestIdempotencyStoreFail(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	_, handler, _, h := newTestIdempotentWebhooks(db)
+	db.StoreErr = fmt.Errorf("pop")
+
+	status, _ := postIdempotentMsg(h, "key1", testIdempotentMsg)
+	assert.Equal(200, status)
+	assert.Equal(1, handler.sent)
+}
+
+func TestIdempotencyReceiptLookupFail(t *testing.T) {
+	assert := assert.New(t)
+
+	receipts, _ := newReceiptsErrTestServer(fmt.Errorf("pop"))
+	i := newIdempotency(kvstore.NewMockKV(nil), 0, receipts)
+	assert.Equal(defaultIdempotencyWindowSec*time.Second, i.window)
+
+	reply := i.withReceipt(&messages.AsyncSentMsg{Sent: true, Request: "req1"})
+	assert.Equal("req1", reply.Request)
+	assert.Nil(reply.Receipt)
+}
+
+func TestOpenIdempotency(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "fly")
+	defer os.RemoveAll(dir)
+	i, err := openIdempotency(&IdempotencyConf{LevelDBPath: path.Join(dir, "db")}, nil)
+	assert.NoError(err)
+	i.close()
+
+	ioutil.WriteFile(path.Join(dir, "file"), []byte("not a dir"), 0644)
+	_, err = openIdempotency(&IdempotencyConf{LevelDBPath: path.Join(dir, "file")}, nil)
+	assert.Regexp("Failed to open idempotency DB", err)
+}
+
+func TestIdempotencyPurgeInBackground(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	w, _, _, h := newTestIdempotentWebhooks(db)
+	expired, _ := json.Marshal(&idempotencyRecord{
+		Created: time.Now().UTC().Add(-2 * time.Minute),
+		Reply:   &messages.AsyncSentMsg{Sent: true, Request: "old1"},
+	})
+	db.Put("key2", expired)
+	w.idempotency.lastPurge = time.Now().Add(-2 * time.Minute)
+
+	postIdempotentMsg(h, "key1", testIdempotentMsg)
+	w.idempotency.purging.Wait()
+	assert.Contains(db.KVS, "key1")
+	assert.NotContains(db.KVS, "key2")
+}
+
+func TestIdempotencySyncRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	w, _, _, _ := newTestIdempotentWebhooks(kvstore.NewMockKV(nil))
+	g := &RESTGateway{webhooks: w}
+
+	existing, _, complete, err := g.BeginIdempotent(context.Background())
+	assert.NoError(err)
+	assert.Nil(existing)
+	assert.Nil(complete)
+
+	ctx := context.WithValue(context.Background(), idempotencyKeyContextKey{}, "key1")
+	existing, _, complete, err = g.BeginIdempotent(ctx)
+	assert.NoError(err)
+	assert.Nil(existing)
+	assert.NotNil(complete)
+
+	_, status, _, err := g.BeginIdempotent(ctx)
+	assert.Equal(409, status)
+	assert.Regexp("key1", err)
+
+	complete(&messages.AsyncSentMsg{
+		Sent:    true,
+		Request: "request1",
+		Receipt: map[string]interface{}{"transactionHash": "0x12345"},
+	})
+	existing, status, complete, err = g.BeginIdempotent(ctx)
+	assert.NoError(err)
+	assert.Equal(200, status)
+	assert.Nil(complete)
+	assert.Equal("request1", existing.Request)
+	assert.Equal("0x12345", existing.Receipt["transactionHash"])
+}
@@ -77,10 +77,30 @@ func (m *memoryReceipts) AddReceipt(requestID string, receipt *map[string]interf
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
+	m.pushReceipt(receipt)
+	return nil
+}
+
+func (m *memoryReceipts) pushReceipt(receipt *map[string]interface{}) {
 	curLen := m.receipts.Len()
 	if curLen > 0 && curLen >= m.conf.MaxDocs {
 		m.receipts.Remove(m.receipts.Back())
 	}
 	m.receipts.PushFront(receipt)
+}
+
+// UpdateReceipt replaces the receipt with the same ID, or adds it if it is no longer in the list
+func (m *memoryReceipts) UpdateReceipt(requestID string, receipt *map[string]interface{}) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for curElem := m.receipts.Front(); curElem != nil; curElem = curElem.Next() {
+		r := *curElem.Value.(*map[string]interface{})
+		if id, exists := r["_id"]; exists && id == requestID {
+			curElem.Value = receipt
+			return nil
+		}
+	}
+	m.pushReceipt(receipt)
 	return nil
 }
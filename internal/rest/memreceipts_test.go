@@ -45,6 +45,33 @@ func TestMemReceiptsWrapping(t *testing.T) {
 	}
 }
 
+func TestMemReceiptsUpdateReceipt(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := &ReceiptStoreConf{
+		MaxDocs: 50,
+	}
+	r := newMemoryReceipts(conf)
+
+	for i := 0; i < 3; i++ {
+		receipt := map[string]interface{}{"_id": fmt.Sprintf("receipt_%d", i)}
+		r.AddReceipt("key", &receipt)
+	}
+
+	updated := map[string]interface{}{"_id": "receipt_1", "key": "updated"}
+	err := r.UpdateReceipt("receipt_1", &updated)
+	assert.NoError(err)
+	assert.Equal(3, r.receipts.Len())
+	receipt, _ := r.GetReceipt("receipt_1")
+	assert.Equal("updated", (*receipt)["key"])
+
+	added := map[string]interface{}{"_id": "receipt_3", "key": "added"}
+	err = r.UpdateReceipt("receipt_3", &added)
+	assert.NoError(err)
+	assert.Equal(4, r.receipts.Len())
+	assert.Equal("added", (*r.receipts.Front().Value.(*map[string]interface{}))["key"])
+}
+
 func TestMemReceiptsNoIDFilterImpl(t *testing.T) {
 	assert := assert.New(t)
 
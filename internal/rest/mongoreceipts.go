@@ -20,6 +20,7 @@ import (
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/utils"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -80,6 +81,24 @@ func (m *mongoReceipts) AddReceipt(requestID string, receipt *map[string]interfa
 	return m.collection.Insert(*receipt)
 }
 
+// UpdateReceipt replaces the receipt with the same ID, or adds it if it does not exist.
+// MongoDB rejects an update that grows a document in a capped collection, so in a capped
+// collection the update is added as a new document instead, with the ID from its own headers.
+// The original receipt is still returned for the request ID, and both are listed
+func (m *mongoReceipts) UpdateReceipt(requestID string, receipt *map[string]interface{}) (err error) {
+	if m.conf.MaxDocs > 0 {
+		update := make(map[string]interface{}, len(*receipt))
+		for k, v := range *receipt {
+			update[k] = v
+		}
+		if headers, ok := update["headers"].(map[string]interface{}); ok && utils.GetMapString(headers, "id") != "" {
+			update["_id"] = headers["id"]
+		}
+		return m.collection.Insert(update)
+	}
+	return m.collection.UpsertId(requestID, *receipt)
+}
+
 // GetReceipts Returns recent receipts with skip & limit
 func (m *mongoReceipts) GetReceipts(skip, limit int, ids []string, sinceEpochMS int64, from, to string) (*[]map[string]interface{}, error) {
 	filter := bson.M{}
@@ -46,6 +46,8 @@ func (m *mockMongo) GetCollection(database string, collection string) MongoColle
 type mockCollection struct {
 	inserted       map[string]interface{}
 	insertErr      error
+	upserted       map[string]interface{}
+	upsertErr      error
 	collInfo       *mgo.CollectionInfo
 	collErr        error
 	ensureIndexErr error
@@ -58,6 +60,11 @@ func (m *mockCollection) Insert(payloads ...interface{}) error {
 	return m.insertErr
 }
 
+func (m *mockCollection) UpsertId(id interface{}, update interface{}) error {
+	m.upserted = update.(map[string]interface{})
+	return m.upsertErr
+}
+
 func (m *mockCollection) Create(info *mgo.CollectionInfo) error {
 	m.collInfo = info
 	return m.collErr
@@ -212,6 +219,61 @@ func TestMongoReceiptsAddReceiptFailed(t *testing.T) {
 	assert.EqualError(err, "pop")
 }
 
+func TestMongoReceiptsUpdateReceiptOK(t *testing.T) {
+	assert := assert.New(t)
+
+	mgoMock := &mockMongo{}
+	r := &mongoReceipts{
+		conf: &MongoDBReceiptStoreConf{},
+		mgo:  mgoMock,
+	}
+
+	r.connect()
+	receipt := map[string]interface{}{"_id": "key"}
+	err := r.UpdateReceipt("key", &receipt)
+	assert.NoError(err)
+	assert.Equal("key", mgoMock.collection.upserted["_id"])
+}
+
+func TestMongoReceiptsUpdateReceiptCapped(t *testing.T) {
+	assert := assert.New(t)
+
+	mgoMock := &mockMongo{}
+	r := &mongoReceipts{
+		conf: &MongoDBReceiptStoreConf{
+			ReceiptStoreConf: ReceiptStoreConf{MaxDocs: 1000},
+		},
+		mgo: mgoMock,
+	}
+
+	r.connect()
+	receipt := map[string]interface{}{
+		"_id":     "key",
+		"headers": map[string]interface{}{"id": "notification1", "requestId": "key"},
+	}
+	err := r.UpdateReceipt("key", &receipt)
+	assert.NoError(err)
+	assert.Nil(mgoMock.collection.upserted)
+	assert.Equal("notification1", mgoMock.collection.inserted["_id"])
+	assert.Equal("key", receipt["_id"])
+}
+
+func TestMongoReceiptsUpdateReceiptFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	mgoMock := &mockMongo{}
+	mgoMock.collection.upsertErr = fmt.Errorf("pop")
+	r := &mongoReceipts{
+		conf: &MongoDBReceiptStoreConf{},
+		mgo:  mgoMock,
+	}
+
+	r.connect()
+	receipt := make(map[string]interface{})
+	err := r.UpdateReceipt("key", &receipt)
+	assert.EqualError(err, "pop")
+}
+
 func TestMongoReceiptsGetReceiptsOK(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,131 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/tx"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// nonces provides a REST API for operators to inspect and repair the nonce tracking
+// of the txn processor, without needing to restart it
+type nonces struct {
+	processor tx.TxnProcessor
+}
+
+func newNonces(processor tx.TxnProcessor) *nonces {
+	return &nonces{
+		processor: processor,
+	}
+}
+
+func (n *nonces) addRoutes(router *httprouter.Router) {
+	router.GET("/nonces", n.listNonces)
+	router.GET("/nonces/:address", n.getNonce)
+	router.POST("/nonces/:address/resync", n.resyncNonce)
+	router.POST("/nonces/:address/gapfill", n.fillNonceGap)
+}
+
+func (n *nonces) listNonces(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	sendRESTResult(res, req, n.processor.NonceStates())
+}
+
+func (n *nonces) getNonce(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	state, err := n.processor.NonceState(params.ByName("address"))
+	if err != nil {
+		sendRESTError(res, req, err, 404)
+		return
+	}
+	sendRESTResult(res, req, state)
+}
+
+func (n *nonces) resyncNonce(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	address := params.ByName("address")
+	if _, err := utils.StrToAddress("address", address); err != nil {
+		sendRESTError(res, req, err, 400)
+		return
+	}
+	state, err := n.processor.ResyncNonce(req.Context(), address)
+	if err != nil {
+		sendRESTError(res, req, err, 500)
+		return
+	}
+	sendRESTResult(res, req, state)
+}
+
+func (n *nonces) fillNonceGap(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	address := params.ByName("address")
+	if _, err := utils.StrToAddress("address", address); err != nil {
+		sendRESTError(res, req, err, 400)
+		return
+	}
+	msg, err := utils.YAMLorJSONPayload(req)
+	if err != nil {
+		sendRESTError(res, req, err, 400)
+		return
+	}
+	var gapFill messages.NonceGapFill
+	msgBytes, _ := json.Marshal(&msg)
+	if err := json.Unmarshal(msgBytes, &gapFill); err != nil {
+		sendRESTError(res, req, errors.Errorf(errors.TransactionNonceGapFillBadNonce, err), 400)
+		return
+	}
+	nonce, err := gapFill.Nonce.Int64()
+	if err != nil || nonce < 0 {
+		sendRESTError(res, req, errors.Errorf(errors.TransactionNonceGapFillBadNonce, gapFill.Nonce), 400)
+		return
+	}
+	pending, err := n.processor.FillNonceGap(req.Context(), address, nonce)
+	if err != nil {
+		status := 500
+		if tx.IsNonceInUse(err) {
+			status = 409
+		}
+		sendRESTError(res, req, err, status)
+		return
+	}
+	sendRESTResult(res, req, pending)
+}
@@ -0,0 +1,209 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/auth/authtest"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/tx"
+	"github.com/stretchr/testify/assert"
+)
+
+const testNonceAddr = "0xaa983ad2a0e0ed8ac639277f37be42f2a5d2618c"
+
+func newNoncesTestServer() (*mockProcessor, *httptest.Server) {
+	processor := &mockProcessor{
+		nonceStates: []*messages.NonceState{
+			{Address: testNonceAddr, HighestNonce: 5, InFlight: 2},
+		},
+		nonceState: &messages.NonceState{
+			Address:      testNonceAddr,
+			HighestNonce: 5,
+			InFlight:     1,
+			Transactions: []*messages.PendingTransaction{
+				{RequestID: "req1", From: testNonceAddr, Nonce: 5, TransactionHash: "0x222"},
+			},
+		},
+		pending: &messages.PendingTransaction{
+			From:            testNonceAddr,
+			Nonce:           3,
+			TransactionHash: "0x333",
+		},
+	}
+	router := &httprouter.Router{}
+	newNonces(processor).addRoutes(router)
+	return processor, httptest.NewServer(router)
+}
+
+func TestListNonces(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newNoncesTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testGETArray(ts, "/nonces")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(1, len(respJSON))
+	assert.Equal(testNonceAddr, respJSON[0]["address"])
+	assert.Equal(float64(5), respJSON[0]["highestNonce"])
+	assert.Equal(float64(2), respJSON[0]["inFlight"])
+}
+
+func TestGetNonce(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newNoncesTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testGETObject(ts, "/nonces/"+testNonceAddr)
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(float64(5), respJSON["highestNonce"])
+	txns := respJSON["transactions"].([]interface{})
+	assert.Equal("req1", txns[0].(map[string]interface{})["requestId"])
+}
+
+func TestGetNonceNotFound(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newNoncesTestServer()
+	defer ts.Close()
+	processor.nonceErr = fmt.Errorf("not found")
+
+	status, respJSON, httpErr := testGETObject(ts, "/nonces/"+testNonceAddr)
+	assert.NoError(httpErr)
+	assert.Equal(404, status)
+	assert.Equal("not found", respJSON["error"])
+}
+
+func TestResyncNonce(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newNoncesTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/nonces/"+testNonceAddr+"/resync", "")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(float64(5), respJSON["highestNonce"])
+}
+
+func TestResyncNonceBadAddress(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newNoncesTestServer()
+	defer ts.Close()
+
+	status, _, httpErr := testPOSTObject(ts, "/nonces/badness/resync", "")
+	assert.NoError(httpErr)
+	assert.Equal(400, status)
+}
+
+func TestResyncNonceFails(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newNoncesTestServer()
+	defer ts.Close()
+	processor.nonceErr = fmt.Errorf("pop")
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/nonces/"+testNonceAddr+"/resync", "")
+	assert.NoError(httpErr)
+	assert.Equal(500, status)
+	assert.Equal("pop", respJSON["error"])
+}
+
+func TestFillNonceGap(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newNoncesTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/nonces/"+testNonceAddr+"/gapfill", `{"nonce": "3"}`)
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(int64(3), processor.gapFillNonce)
+	assert.Equal("0x333", respJSON["transactionHash"])
+}
+
+func TestFillNonceGapBadRequest(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newNoncesTestServer()
+	defer ts.Close()
+
+	status, _, httpErr := testPOSTObject(ts, "/nonces/badness/gapfill", `{"nonce": 3}`)
+	assert.NoError(httpErr)
+	assert.Equal(400, status)
+
+	status, _, httpErr = testPOSTObject(ts, "/nonces/"+testNonceAddr+"/gapfill", "badness")
+	assert.NoError(httpErr)
+	assert.Equal(400, status)
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/nonces/"+testNonceAddr+"/gapfill", `{"nonce": {}}`)
+	assert.NoError(httpErr)
+	assert.Equal(400, status)
+	assert.Regexp("Invalid nonce for gap-fill", respJSON["error"])
+
+	status, respJSON, httpErr = testPOSTObject(ts, "/nonces/"+testNonceAddr+"/gapfill", `{}`)
+	assert.NoError(httpErr)
+	assert.Equal(400, status)
+	assert.Regexp("Invalid nonce for gap-fill", respJSON["error"])
+}
+
+func TestFillNonceGapFails(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newNoncesTestServer()
+	defer ts.Close()
+	processor.nonceErr = fmt.Errorf("pop")
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/nonces/"+testNonceAddr+"/gapfill", `{"nonce": 3}`)
+	assert.NoError(httpErr)
+	assert.Equal(500, status)
+	assert.Equal("pop", respJSON["error"])
+}
+
+func TestFillNonceGapInUse(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newNoncesTestServer()
+	defer ts.Close()
+	processor.nonceErr = &tx.NonceInUseError{Err: fmt.Errorf("in use")}
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/nonces/"+testNonceAddr+"/gapfill", `{"nonce": 3}`)
+	assert.NoError(httpErr)
+	assert.Equal(409, status)
+	assert.Equal("in use", respJSON["error"])
+}
+
+func TestNoncesUnauthorized(t *testing.T) {
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+
+	assert := assert.New(t)
+	_, ts := newNoncesTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testGETObject(ts, "/nonces/"+testNonceAddr)
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+	assert.Equal("Unauthorized", respJSON["error"])
+
+	status, _, httpErr = testPOSTObject(ts, "/nonces/"+testNonceAddr+"/resync", "")
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+
+	status, _, httpErr = testPOSTObject(ts, "/nonces/"+testNonceAddr+"/gapfill", `{"nonce": 3}`)
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+
+	auth.RegisterSecurityModule(nil)
+}
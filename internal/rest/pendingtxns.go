@@ -0,0 +1,135 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/tx"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// pendingTxns provides a REST API to query, cancel and replace transactions
+// that have been submitted by the txn processor, but are not yet mined
+type pendingTxns struct {
+	processor tx.TxnProcessor
+}
+
+func newPendingTxns(processor tx.TxnProcessor) *pendingTxns {
+	return &pendingTxns{
+		processor: processor,
+	}
+}
+
+func (p *pendingTxns) addRoutes(router *httprouter.Router) {
+	router.GET("/transactions/:id", p.getPendingTxn)
+	router.POST("/transactions/:id/cancel", p.cancelPendingTxn)
+	router.POST("/transactions/:id/replace", p.replacePendingTxn)
+}
+
+func (p *pendingTxns) getPendingTxn(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	pending, err := p.processor.PendingTransaction(params.ByName("id"))
+	if err != nil {
+		sendRESTError(res, req, err, 404)
+		return
+	}
+	sendRESTResult(res, req, pending)
+}
+
+func (p *pendingTxns) cancelPendingTxn(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	id := params.ByName("id")
+	if _, err := p.processor.PendingTransaction(id); err != nil {
+		sendRESTError(res, req, err, 404)
+		return
+	}
+	pending, err := p.processor.CancelTransaction(req.Context(), id)
+	if err != nil {
+		sendRESTError(res, req, err, 500)
+		return
+	}
+	sendRESTResult(res, req, pending)
+}
+
+func (p *pendingTxns) replacePendingTxn(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	// An empty body replaces the transaction with the fees increased by the configured percentage
+	msg, err := utils.YAMLorJSONPayload(req)
+	if err != nil {
+		sendRESTError(res, req, err, 400)
+		return
+	}
+	var fees messages.TransactionFees
+	msgBytes, _ := json.Marshal(&msg)
+	if err := json.Unmarshal(msgBytes, &fees); err != nil {
+		sendRESTError(res, req, errors.Errorf(errors.TransactionReplaceBadFees, err), 400)
+		return
+	}
+
+	id := params.ByName("id")
+	if _, err := p.processor.PendingTransaction(id); err != nil {
+		sendRESTError(res, req, err, 404)
+		return
+	}
+	pending, err := p.processor.ReplaceTransaction(req.Context(), id, &fees)
+	if err != nil {
+		sendRESTError(res, req, err, 500)
+		return
+	}
+	sendRESTResult(res, req, pending)
+}
+
+// authPendingTransactions checks the request is authorized to manage the transactions held
+// by the txn processor, replying with a 401 if not
+func authPendingTransactions(res http.ResponseWriter, req *http.Request) bool {
+	if err := auth.AuthPendingTransactions(req.Context()); err != nil {
+		log.Errorf("Error authorizing %s %s: %s", req.Method, req.URL, err)
+		sendRESTError(res, req, errors.Errorf(errors.Unauthorized), 401)
+		return false
+	}
+	return true
+}
+
+// sendRESTResult replies with the result serialized as JSON
+func sendRESTResult(res http.ResponseWriter, req *http.Request, result interface{}) {
+	resBytes, _ := json.MarshalIndent(result, "", "  ")
+	status := 200
+	log.Infof("<-- %s %s [%d]", req.Method, req.URL, status)
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	res.Write(resBytes)
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/auth/authtest"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPendingTxnsTestServer() (*mockProcessor, *httptest.Server) {
+	processor := &mockProcessor{
+		pending: &messages.PendingTransaction{
+			RequestID:       "req1",
+			From:            "0xaa983ad2a0e0ed8ac639277f37be42f2a5d2618c",
+			Nonce:           5,
+			TransactionHash: "0x222",
+			SubmittedHashes: []string{"0x111", "0x222"},
+		},
+	}
+	router := &httprouter.Router{}
+	newPendingTxns(processor).addRoutes(router)
+	return processor, httptest.NewServer(router)
+}
+
+func testPOSTObject(ts *httptest.Server, path string, body string) (int, map[string]interface{}, error) {
+	url := fmt.Sprintf("%s%s", ts.URL, path)
+	resp, httpErr := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if httpErr != nil {
+		return 0, nil, httpErr
+	}
+	respJSON := make(map[string]interface{})
+	err := json.NewDecoder(resp.Body).Decode(&respJSON)
+	return resp.StatusCode, respJSON, err
+}
+
+func TestGetPendingTxn(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testGETObject(ts, "/transactions/req1")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal("req1", respJSON["requestId"])
+	assert.Equal("0x222", respJSON["transactionHash"])
+	assert.Equal(float64(5), respJSON["nonce"])
+}
+
+func TestGetPendingTxnNotFound(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+	processor.pendingErr = fmt.Errorf("not found")
+
+	status, respJSON, httpErr := testGETObject(ts, "/transactions/req1")
+	assert.NoError(httpErr)
+	assert.Equal(404, status)
+	assert.Equal("not found", respJSON["error"])
+}
+
+func TestCancelPendingTxn(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+	processor.pending.Cancelling = true
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/transactions/req1/cancel", "")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(true, respJSON["cancelling"])
+}
+
+func TestCancelPendingTxnNotFound(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+	processor.pendingErr = fmt.Errorf("not found")
+
+	status, _, httpErr := testPOSTObject(ts, "/transactions/req1/cancel", "")
+	assert.NoError(httpErr)
+	assert.Equal(404, status)
+}
+
+func TestCancelPendingTxnFails(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+	processor.updateErr = fmt.Errorf("pop")
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/transactions/req1/cancel", "")
+	assert.NoError(httpErr)
+	assert.Equal(500, status)
+	assert.Equal("pop", respJSON["error"])
+}
+
+func TestReplacePendingTxn(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+
+	status, _, httpErr := testPOSTObject(ts, "/transactions/req1/replace", `{"gasPrice": 1000, "gas": "60000"}`)
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal("1000", processor.capturedFees.GasPrice.String())
+	assert.Equal("60000", processor.capturedFees.Gas.String())
+}
+
+func TestReplacePendingTxnEmptyBody(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+
+	status, _, httpErr := testPOSTObject(ts, "/transactions/req1/replace", "")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(messages.TransactionFees{}, *processor.capturedFees)
+}
+
+func TestReplacePendingTxnBadBody(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+
+	status, _, httpErr := testPOSTObject(ts, "/transactions/req1/replace", "badness")
+	assert.NoError(httpErr)
+	assert.Equal(400, status)
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/transactions/req1/replace", `{"gasPrice": "lots"}`)
+	assert.NoError(httpErr)
+	assert.Equal(400, status)
+	assert.Regexp("Invalid fees for transaction replacement", respJSON["error"])
+}
+
+func TestReplacePendingTxnNotFound(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+	processor.pendingErr = fmt.Errorf("not found")
+
+	status, _, httpErr := testPOSTObject(ts, "/transactions/req1/replace", "")
+	assert.NoError(httpErr)
+	assert.Equal(404, status)
+}
+
+func TestReplacePendingTxnFails(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+	processor.updateErr = fmt.Errorf("pop")
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/transactions/req1/replace", "")
+	assert.NoError(httpErr)
+	assert.Equal(500, status)
+	assert.Equal("pop", respJSON["error"])
+}
+
+func TestPendingTxnsUnauthorized(t *testing.T) {
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+
+	assert := assert.New(t)
+	_, ts := newPendingTxnsTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testGETObject(ts, "/transactions/req1")
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+	assert.Equal("Unauthorized", respJSON["error"])
+
+	status, _, httpErr = testPOSTObject(ts, "/transactions/req1/cancel", "")
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+
+	status, _, httpErr = testPOSTObject(ts, "/transactions/req1/replace", "")
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+
+	auth.RegisterSecurityModule(nil)
+}
@@ -43,6 +43,7 @@ type ReceiptStorePersistence interface {
 	GetReceipts(skip, limit int, ids []string, sinceEpochMS int64, from, to string) (*[]map[string]interface{}, error)
 	GetReceipt(requestID string) (*map[string]interface{}, error)
 	AddReceipt(requestID string, receipt *map[string]interface{}) error
+	UpdateReceipt(requestID string, receipt *map[string]interface{}) error
 }
 
 type receiptStore struct {
@@ -123,18 +124,94 @@ func (r *receiptStore) processReply(msgBytes []byte) {
 			log.Errorf("Failed to parse message as transaction receipt: %s", err)
 		}
 	}
+	if r.smartContractGW != nil && parsedMsg["logs"] != nil {
+		r.decodeLogs(parsedMsg, msgBytes)
+	}
 
 	parsedMsg["receivedAt"] = time.Now().UnixNano() / int64(time.Millisecond)
 	parsedMsg["_id"] = requestID
 
 	// Insert the receipt into persistence - captures errors
 	if requestID != "" && r.persistence != nil {
-		r.writeReceipt(requestID, parsedMsg)
+		switch msgType {
+		case messages.MsgTypeTransactionReorged:
+			r.updateReceipt(requestID, parsedMsg)
+		case messages.MsgTypeTransactionScheduled:
+			// Not stored, so the receipt or error is stored in the same way as any other when the
+			// transaction is released. The scheduler reports the transactions that are waiting
+			if r.smartContractGW != nil {
+				r.smartContractGW.SendReply(parsedMsg)
+			}
+		default:
+			r.writeReceipt(requestID, parsedMsg)
+		}
 	}
 
 }
 
+// decodeLogs replaces the logs in a receipt with those decoded by the smart contract gateway,
+// for the contracts it knows the ABI of. The receipt is stored with the raw logs on failure
+func (r *receiptStore) decodeLogs(parsedMsg map[string]interface{}, msgBytes []byte) {
+	var receipt messages.TransactionReceipt
+	if err := json.Unmarshal(msgBytes, &receipt); err != nil {
+		log.Errorf("Failed to parse message as transaction receipt: %s", err)
+		return
+	}
+	r.smartContractGW.DecodeLogs(&receipt)
+	// The logs are stored as generic JSON, in the same way as the rest of the receipt
+	var decodedLogs []interface{}
+	logBytes, _ := json.Marshal(receipt.Logs)
+	if err := json.Unmarshal(logBytes, &decodedLogs); err != nil {
+		log.Errorf("Failed to store decoded logs: %s", err)
+		return
+	}
+	parsedMsg["logs"] = decodedLogs
+}
+
 func (r *receiptStore) writeReceipt(requestID string, receipt map[string]interface{}) {
+	timeRetrying, err := r.retryWrite(requestID, "addReceipt", func() error {
+		return r.persistence.AddReceipt(requestID, &receipt)
+	}, func() bool {
+		// Check if the reason is that there is a receipt already
+		existing, qErr := r.persistence.GetReceipt(requestID)
+		if qErr == nil && existing != nil {
+			log.Warnf("%s: exiting   receipt: %+v", requestID, *existing)
+			log.Warnf("%s: duplicate receipt: %+v", requestID, receipt)
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		log.Infof("%s: receipt: %+v", requestID, receipt)
+		log.Panicf("%s: Failed to insert into receipt store after %.2fs: %s", requestID, timeRetrying.Seconds(), err)
+	}
+	log.Infof("%s: Inserted receipt into receipt store", receipt["_id"])
+	if r.smartContractGW != nil {
+		r.smartContractGW.SendReply(receipt)
+	}
+}
+
+// updateReceipt replaces the receipt for a transaction that was affected by a reorg.
+// It is retried in the same way as the original receipt, but the notification is not
+// critical in the same way, so a failure after the retry timeout is logged
+func (r *receiptStore) updateReceipt(requestID string, receipt map[string]interface{}) {
+	timeRetrying, err := r.retryWrite(requestID, "updateReceipt", func() error {
+		return r.persistence.UpdateReceipt(requestID, &receipt)
+	}, nil)
+	if err != nil {
+		log.Errorf("%s: Failed to update receipt in receipt store after %.2fs: %s", requestID, timeRetrying.Seconds(), err)
+	} else {
+		log.Infof("%s: Updated receipt in receipt store", requestID)
+	}
+	if r.smartContractGW != nil {
+		r.smartContractGW.SendReply(receipt)
+	}
+}
+
+// retryWrite attempts a write to the receipt store until it succeeds, backing off between
+// attempts, or until the retry timeout. The optional written check is called after each failed
+// attempt, to stop retrying if the write is found to have been made already
+func (r *receiptStore) retryWrite(requestID, action string, write func() error, written func() bool) (time.Duration, error) {
 	startTime := time.Now()
 	delay := time.Duration(r.conf.RetryInitialDelayMS) * time.Millisecond
 	attempt := 0
@@ -147,31 +224,22 @@ func (r *receiptStore) writeReceipt(requestID string, receipt map[string]interfa
 			delay = time.Duration(float64(delay) * backoffFactor)
 		}
 		attempt++
-		err := r.persistence.AddReceipt(requestID, &receipt)
+		err := write()
 		if err == nil {
-			log.Infof("%s: Inserted receipt into receipt store", receipt["_id"])
-			break
+			return time.Since(startTime), nil
 		}
 
-		log.Errorf("%s: addReceipt attempt: %d failed, err: %s", requestID, attempt, err)
+		log.Errorf("%s: %s attempt: %d failed, err: %s", requestID, action, attempt, err)
 
-		// Check if the reason is that there is a receipt already
-		existing, qErr := r.persistence.GetReceipt(requestID)
-		if qErr == nil && existing != nil {
-			log.Warnf("%s: exiting   receipt: %+v", requestID, *existing)
-			log.Warnf("%s: duplicate receipt: %+v", requestID, receipt)
-			break
+		if written != nil && written() {
+			return time.Since(startTime), nil
 		}
 
 		timeRetrying := time.Since(startTime)
 		if timeRetrying > retryTimeout {
-			log.Infof("%s: receipt: %+v", requestID, receipt)
-			log.Panicf("%s: Failed to insert into receipt store after %.2fs: %s", requestID, timeRetrying.Seconds(), err)
+			return timeRetrying, err
 		}
 	}
-	if r.smartContractGW != nil {
-		r.smartContractGW.SendReply(receipt)
-	}
 }
 
 func (r *receiptStore) marshalAndReply(res http.ResponseWriter, req *http.Request, result interface{}) {
@@ -33,11 +33,14 @@ import (
 )
 
 type mockReceiptErrs struct {
-	getReceiptsErr   error
-	getReceiptVal    *map[string]interface{}
-	getReceiptErr    error
-	addReceiptCalled bool
-	addReceiptErr    error
+	getReceiptsErr        error
+	getReceiptVal         *map[string]interface{}
+	getReceiptErr         error
+	addReceiptCalled      bool
+	addReceiptErr         error
+	updateReceiptCalled   bool
+	updateReceiptAttempts int
+	updateReceiptErr      error
 }
 
 func (m *mockReceiptErrs) GetReceipts(skip, limit int, ids []string, sinceEpochMS int64, from, to string) (*[]map[string]interface{}, error) {
@@ -53,6 +56,12 @@ func (m *mockReceiptErrs) AddReceipt(requestID string, receipt *map[string]inter
 	return m.addReceiptErr
 }
 
+func (m *mockReceiptErrs) UpdateReceipt(requestID string, receipt *map[string]interface{}) error {
+	m.updateReceiptCalled = true
+	m.updateReceiptAttempts++
+	return m.updateReceiptErr
+}
+
 func newReceiptsErrTestServer(err error) (*receiptStore, *httptest.Server) {
 	r := newReceiptStore(&ReceiptStoreConf{
 		RetryTimeoutMS:      1,
@@ -161,6 +170,61 @@ func TestReplyProcessorWithContractGWFailure(t *testing.T) {
 
 }
 
+func TestReplyProcessorWithContractGWDecodeLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	r, p := newReceiptsTestStore(nil)
+	r.smartContractGW = &mockContractGW{
+		decodedEvent: "Transfer",
+	}
+
+	replyMsg := &messages.TransactionReceipt{}
+	replyMsg.Headers.MsgType = messages.MsgTypeTransactionSuccess
+	replyMsg.Headers.ID = utils.UUIDv4()
+	replyMsg.Headers.ReqID = utils.UUIDv4()
+	txHash := ethbind.API.HexToHash("0x02587104e9879911bea3d5bf6ccd7e1a6cb9a03145b8a1141804cebd6aa67c5c")
+	replyMsg.TransactionHash = &txHash
+	addr := ethbind.API.HexToAddress("0x0123456789AbcdeF0123456789abCdef0123456")
+	replyMsg.Logs = []*messages.TransactionLog{
+		{Address: &addr, LogIndex: "0", Data: "0x"},
+	}
+	replyMsgBytes, _ := json.Marshal(&replyMsg)
+
+	r.processReply(replyMsgBytes)
+
+	assert.Equal(1, p.receipts.Len())
+	front := *p.receipts.Front().Value.(*map[string]interface{})
+	logs := front["logs"].([]interface{})
+	assert.Len(logs, 1)
+	assert.Equal("Transfer", logs[0].(map[string]interface{})["event"])
+	assert.Equal("0x", logs[0].(map[string]interface{})["data"])
+}
+
+func TestReplyProcessorWithContractGWBadLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	r, p := newReceiptsTestStore(nil)
+	r.smartContractGW = &mockContractGW{
+		decodedEvent: "Transfer",
+	}
+
+	replyMsg := map[string]interface{}{
+		"headers": map[string]interface{}{
+			"type":      messages.MsgTypeTransactionSuccess,
+			"requestId": "123",
+		},
+		"transactionHash": "0x02587104e9879911bea3d5bf6ccd7e1a6cb9a03145b8a1141804cebd6aa67c5c",
+		"logs":            "not an array",
+	}
+	replyMsgBytes, _ := json.Marshal(&replyMsg)
+
+	r.processReply(replyMsgBytes)
+
+	assert.Equal(1, p.receipts.Len())
+	front := *p.receipts.Front().Value.(*map[string]interface{})
+	assert.Equal("not an array", front["logs"])
+}
+
 func TestReplyProcessorWithContractGWBadReceipt(t *testing.T) {
 	r, _ := newReceiptsTestStore(nil)
 	r.smartContractGW = &mockContractGW{}
@@ -177,6 +241,96 @@ func TestReplyProcessorWithContractGWBadReceipt(t *testing.T) {
 	r.processReply(replyMsgBytes)
 }
 
+func TestReplyProcessorWithReorgedReply(t *testing.T) {
+	assert := assert.New(t)
+
+	var sent interface{}
+	r, p := newReceiptsTestStore(func(message interface{}) { sent = message })
+
+	reqID := utils.UUIDv4()
+	replyMsg := &messages.TransactionReceipt{}
+	replyMsg.Headers.MsgType = messages.MsgTypeTransactionSuccess
+	replyMsg.Headers.ID = utils.UUIDv4()
+	replyMsg.Headers.ReqID = reqID
+	txHash := ethbind.API.HexToHash("0x02587104e9879911bea3d5bf6ccd7e1a6cb9a03145b8a1141804cebd6aa67c5c")
+	replyMsg.TransactionHash = &txHash
+	replyMsg.BlockNumberStr = "12345"
+	replyMsgBytes, _ := json.Marshal(&replyMsg)
+	r.processReply(replyMsgBytes)
+
+	reorgMsg := &messages.TransactionReorged{}
+	reorgMsg.TransactionReceipt = *replyMsg
+	reorgMsg.Headers.MsgType = messages.MsgTypeTransactionReorged
+	reorgMsg.Headers.ID = utils.UUIDv4()
+	reorgMsg.BlockNumberStr = "12346"
+	reorgMsg.PreviousBlockNumberStr = "12345"
+	reorgMsgBytes, _ := json.Marshal(&reorgMsg)
+	r.processReply(reorgMsgBytes)
+
+	assert.Equal(1, p.receipts.Len())
+	front := *p.receipts.Front().Value.(*map[string]interface{})
+	assert.Equal(reqID, front["_id"])
+	assert.Equal(messages.MsgTypeTransactionReorged, front["headers"].(map[string]interface{})["type"])
+	assert.Equal("12346", front["blockNumber"])
+	assert.Equal("12345", front["previousBlockNumber"])
+	assert.Equal(reqID, sent.(map[string]interface{})["_id"])
+}
+
+func TestReplyProcessorWithScheduledReply(t *testing.T) {
+	assert := assert.New(t)
+
+	r, p := newReceiptsTestStore(nil)
+
+	reqID := utils.UUIDv4()
+	scheduledMsg := &messages.TransactionScheduled{NotBeforeBlock: "12345"}
+	scheduledMsg.Headers.MsgType = messages.MsgTypeTransactionScheduled
+	scheduledMsg.Headers.ID = utils.UUIDv4()
+	scheduledMsg.Headers.ReqID = reqID
+	scheduledMsgBytes, _ := json.Marshal(&scheduledMsg)
+	r.processReply(scheduledMsgBytes)
+	assert.Equal(0, p.receipts.Len())
+
+	replyMsg := &messages.TransactionReceipt{}
+	replyMsg.Headers.MsgType = messages.MsgTypeTransactionSuccess
+	replyMsg.Headers.ID = utils.UUIDv4()
+	replyMsg.Headers.ReqID = reqID
+	replyMsg.Headers.Scheduled = true
+	txHash := ethbind.API.HexToHash("0x02587104e9879911bea3d5bf6ccd7e1a6cb9a03145b8a1141804cebd6aa67c5c")
+	replyMsg.TransactionHash = &txHash
+	replyMsg.BlockNumberStr = "12346"
+	replyMsgBytes, _ := json.Marshal(&replyMsg)
+	r.processReply(replyMsgBytes)
+
+	assert.Equal(1, p.receipts.Len())
+	front := *p.receipts.Front().Value.(*map[string]interface{})
+	assert.Equal(reqID, front["_id"])
+	assert.Equal(messages.MsgTypeTransactionSuccess, front["headers"].(map[string]interface{})["type"])
+	assert.Equal("12346", front["blockNumber"])
+}
+
+func TestReplyProcessorWithReorgedReplyUpdateError(t *testing.T) {
+	assert := assert.New(t)
+
+	persistence := &mockReceiptErrs{updateReceiptErr: fmt.Errorf("pop")}
+	r := newReceiptStore(&ReceiptStoreConf{
+		RetryTimeoutMS:      5,
+		RetryInitialDelayMS: 1,
+	}, persistence, nil)
+
+	reorgMsg := &messages.TransactionReorged{}
+	reorgMsg.Headers.MsgType = messages.MsgTypeTransactionReorged
+	reorgMsg.Headers.ReqID = utils.UUIDv4()
+	txHash := ethbind.API.HexToHash("0x02587104e9879911bea3d5bf6ccd7e1a6cb9a03145b8a1141804cebd6aa67c5c")
+	reorgMsg.TransactionHash = &txHash
+	reorgMsg.Removed = true
+	reorgMsgBytes, _ := json.Marshal(&reorgMsg)
+	r.processReply(reorgMsgBytes)
+
+	assert.True(persistence.updateReceiptCalled)
+	assert.True(persistence.updateReceiptAttempts > 1)
+	assert.False(persistence.addReceiptCalled)
+}
+
 func TestReplyProcessorWithInvalidReplySwallowsErr(t *testing.T) {
 	r, _ := newReceiptsTestStore(nil)
 	r.processReply([]byte("!json"))
@@ -0,0 +1,89 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/tx"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// recoveredTxnContext delivers the reply for a transaction that was recovered
+// by the txn processor after a restart, into the receipt store
+type recoveredTxnContext struct {
+	receipts     *receiptStore
+	headers      *messages.CommonHeaders
+	timeReceived time.Time
+}
+
+func newRecoveredTxnContextFactory(receipts *receiptStore) tx.RecoveredTxnContextFactory {
+	return func(headers *messages.CommonHeaders, timeReceived time.Time) tx.TxnContext {
+		return &recoveredTxnContext{
+			receipts:     receipts,
+			headers:      headers,
+			timeReceived: timeReceived,
+		}
+	}
+}
+
+func (t *recoveredTxnContext) Context() context.Context {
+	return context.Background()
+}
+
+func (t *recoveredTxnContext) Headers() *messages.CommonHeaders {
+	return t.headers
+}
+
+func (t *recoveredTxnContext) Unmarshal(msg interface{}) error {
+	return errors.Errorf(errors.TransactionSendRecoveredNoRequest)
+}
+
+func (t *recoveredTxnContext) SendErrorReply(status int, err error) {
+	t.SendErrorReplyWithTX(status, err, "")
+}
+
+func (t *recoveredTxnContext) SendErrorReplyWithGapFill(status int, err error, gapFillTxHash string, gapFillSucceeded bool) {
+	t.SendErrorReplyWithTX(status, err, "")
+}
+
+func (t *recoveredTxnContext) SendErrorReplyWithTX(status int, err error, txHash string) {
+	log.Warnf("Failed to process recovered transaction %s: %s", t, err)
+	errMsg := messages.NewErrorReply(err, []byte{})
+	errMsg.TXHash = txHash
+	t.Reply(errMsg)
+}
+
+func (t *recoveredTxnContext) Reply(replyMessage messages.ReplyWithHeaders) {
+	replyHeaders := replyMessage.ReplyHeaders()
+	replyHeaders.ID = utils.UUIDv4()
+	replyHeaders.Context = t.headers.Context
+	replyHeaders.ReqID = t.headers.ID
+	replyHeaders.Received = t.timeReceived.UTC().Format(time.RFC3339Nano)
+	replyTime := time.Now().UTC()
+	replyHeaders.Elapsed = replyTime.Sub(t.timeReceived).Seconds()
+	msgBytes, _ := json.Marshal(&replyMessage)
+	t.receipts.processReply(msgBytes)
+}
+
+func (t *recoveredTxnContext) String() string {
+	return fmt.Sprintf("Recovered[%s/%s]", t.headers.MsgType, t.headers.ID)
+}
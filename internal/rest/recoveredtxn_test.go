@@ -0,0 +1,72 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRecoveredTxnContext() (*recoveredTxnContext, *memoryReceipts) {
+	p := newMemoryReceipts(&ReceiptStoreConf{MaxDocs: 10})
+	r := newReceiptStore(&ReceiptStoreConf{}, p, nil)
+	factory := newRecoveredTxnContextFactory(r)
+	c := factory(&messages.CommonHeaders{
+		ID:      "req1",
+		MsgType: messages.MsgTypeSendTransaction,
+	}, time.Now().UTC())
+	return c.(*recoveredTxnContext), p
+}
+
+func TestRecoveredTxnContextReply(t *testing.T) {
+	assert := assert.New(t)
+
+	c, p := newTestRecoveredTxnContext()
+	assert.NotNil(c.Context())
+	assert.Equal("req1", c.Headers().ID)
+	assert.Equal("Recovered[SendTransaction/req1]", c.String())
+	var msg messages.SendTransaction
+	assert.Regexp("recovered", c.Unmarshal(&msg))
+
+	txHash := ethbind.API.HexToHash("0xe2215336b09f9b5b82e36e1144ed64f40a42e61b68fdaca82549fd98b8531a89")
+	reply := &messages.TransactionReceipt{TransactionHash: &txHash}
+	reply.Headers.MsgType = messages.MsgTypeTransactionSuccess
+	c.Reply(reply)
+
+	receipt, err := p.GetReceipt("req1")
+	assert.NoError(err)
+	assert.Equal(messages.MsgTypeTransactionSuccess, (*receipt)["headers"].(map[string]interface{})["type"])
+}
+
+func TestRecoveredTxnContextErrorReply(t *testing.T) {
+	assert := assert.New(t)
+
+	c, p := newTestRecoveredTxnContext()
+	c.SendErrorReplyWithTX(408, fmt.Errorf("pop"), "0x12345")
+
+	receipt, err := p.GetReceipt("req1")
+	assert.NoError(err)
+	assert.Equal(messages.MsgTypeError, (*receipt)["headers"].(map[string]interface{})["type"])
+	assert.Equal("pop", (*receipt)["errorMessage"])
+	assert.Equal("0x12345", (*receipt)["transactionHash"])
+
+	c.SendErrorReply(500, fmt.Errorf("pop"))
+	c.SendErrorReplyWithGapFill(500, fmt.Errorf("pop"), "", false)
+}
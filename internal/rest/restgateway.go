@@ -66,11 +66,12 @@ type MongoDBReceiptStoreConf struct {
 
 // RESTGatewayConf defines the YAML config structure for a webhooks bridge instance
 type RESTGatewayConf struct {
-	Kafka    kafka.KafkaCommonConf              `json:"kafka"`
-	MongoDB  MongoDBReceiptStoreConf            `json:"mongodb"`
-	MemStore ReceiptStoreConf                   `json:"memstore"`
-	OpenAPI  contracts.SmartContractGatewayConf `json:"openapi"`
-	HTTP     struct {
+	Kafka       kafka.KafkaCommonConf              `json:"kafka"`
+	MongoDB     MongoDBReceiptStoreConf            `json:"mongodb"`
+	MemStore    ReceiptStoreConf                   `json:"memstore"`
+	OpenAPI     contracts.SmartContractGatewayConf `json:"openapi"`
+	Idempotency IdempotencyConf                    `json:"idempotency"`
+	HTTP        struct {
 		LocalAddr string          `json:"localAddr"`
 		Port      int             `json:"port"`
 		TLS       utils.TLSConfig `json:"tls"`
@@ -92,6 +93,7 @@ type RESTGateway struct {
 	webhooks        *webhooks
 	smartContractGW contracts.SmartContractGateway
 	ws              ws.WebSocketServer
+	rpc             eth.RPCClient
 }
 
 // Conf gets the config for this bridge
@@ -113,7 +115,7 @@ func (g *RESTGateway) ValidateConf() (err error) {
 	if g.conf.MongoDB.QueryLimit < 1 {
 		g.conf.MongoDB.QueryLimit = 100
 	}
-	if g.conf.OpenAPI.StoragePath != "" && g.conf.RPC.URL == "" {
+	if g.conf.OpenAPI.StoragePath != "" && !g.conf.RPC.Configured() {
 		err = errors.Errorf(errors.ConfigRESTGatewayRequiredRPC)
 		return
 	}
@@ -176,11 +178,14 @@ func (g *RESTGateway) CobraInit(cmdName string) (cmd *cobra.Command) {
 	cmd.Flags().IntVarP(&g.conf.MongoDB.QueryLimit, "mongodb-query-limit", "Q", utils.DefInt("MONGODB_QUERYLIM", 0), "Maximum docs to return on a rest call (cap on limit)")
 	cmd.Flags().IntVarP(&g.conf.MemStore.MaxDocs, "memstore-receipt-maxdocs", "v", utils.DefInt("MEMSTORE_MAXDOCS", 10), "In-memory receipt store capped size")
 	cmd.Flags().IntVarP(&g.conf.MemStore.QueryLimit, "memstore-query-limit", "V", utils.DefInt("MEMSTORE_QUERYLIM", 0), "In-memory maximum docs to return on a rest call")
+	cmd.Flags().StringVar(&g.conf.Idempotency.LevelDBPath, "idempotency-db", os.Getenv("IDEMPOTENCY_DB"), "Level DB location for idempotency keys, to detect retried requests")
+	cmd.Flags().IntVar(&g.conf.Idempotency.WindowSec, "idempotency-window", utils.DefInt("IDEMPOTENCY_WINDOW", defaultIdempotencyWindowSec), "Time in seconds a request is remembered for its idempotency key")
 	return
 }
 
 type statusMsg struct {
-	OK bool `json:"ok"`
+	OK           bool                   `json:"ok"`
+	RPCEndpoints []eth.RPCEndpointStats `json:"rpcEndpoints,omitempty"`
 }
 
 type errMsg struct {
@@ -188,7 +193,11 @@ type errMsg struct {
 }
 
 func (g *RESTGateway) statusHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	reply, _ := json.Marshal(&statusMsg{OK: true})
+	status := &statusMsg{OK: true}
+	if stats, ok := g.rpc.(eth.RPCClientStats); ok {
+		status.RPCEndpoints = stats.EndpointStats()
+	}
+	reply, _ := json.Marshal(status)
 	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(200)
 	res.Write(reply)
@@ -203,10 +212,16 @@ func (g *RESTGateway) sendError(res http.ResponseWriter, msg string, code int) {
 	return
 }
 
-// DispatchMsgAsync is the rest2eth interface method for async dispatching of messages (via our webhook logic)
-func (g *RESTGateway) DispatchMsgAsync(ctx context.Context, msg map[string]interface{}, ack bool) (*messages.AsyncSentMsg, error) {
-	reply, _, err := g.webhooks.processMsg(ctx, msg, ack)
-	return reply, err
+// DispatchMsgAsync is the rest2eth interface method for async dispatching of messages (via our webhook logic).
+// The HTTP status is returned with any error, such as 409 for a retry of a request that is still in progress
+func (g *RESTGateway) DispatchMsgAsync(ctx context.Context, msg map[string]interface{}, ack bool) (*messages.AsyncSentMsg, int, error) {
+	return g.webhooks.processMsg(ctx, msg, ack)
+}
+
+// BeginIdempotent is the rest2eth interface method to detect retries of synchronous requests,
+// with the same idempotency keys as requests dispatched asynchronously
+func (g *RESTGateway) BeginIdempotent(ctx context.Context) (*messages.AsyncSentMsg, int, func(reply *messages.AsyncSentMsg), error) {
+	return g.webhooks.beginIdempotent(ctx, nil)
 }
 
 func (g *RESTGateway) newAccessTokenContextHandler(parent http.Handler) http.Handler {
@@ -247,13 +262,16 @@ func (g *RESTGateway) Start() (err error) {
 
 	var processor tx.TxnProcessor
 	var rpcClient eth.RPCClient
-	if g.conf.RPC.URL != "" || g.conf.OpenAPI.StoragePath != "" {
+	if g.conf.RPC.Configured() || g.conf.OpenAPI.StoragePath != "" {
 		rpcClient, err = eth.RPCConnect(&g.conf.RPC)
 		if err != nil {
 			return err
 		}
+		g.rpc = rpcClient
 		processor = tx.NewTxnProcessor(&g.conf.TxnProcessorConf, &g.conf.RPCConf)
-		processor.Init(rpcClient)
+		if err = processor.Init(rpcClient); err != nil {
+			return err
+		}
 	}
 
 	g.ws.AddRoutes(router)
@@ -284,6 +302,16 @@ func (g *RESTGateway) Start() (err error) {
 	router.GET("/status", g.statusHandler)
 	g.receipts = newReceiptStore(receiptStoreConf, receiptStorePersistence, g.smartContractGW)
 	g.receipts.addRoutes(router)
+	if processor != nil {
+		// Replies for transactions recovered from the journal, and notifications of reorgs
+		// for receipts already delivered, go directly to the receipt store
+		processor.Resume(newRecoveredTxnContextFactory(g.receipts))
+		processor.WatchReorgs(newRecoveredTxnContextFactory(g.receipts))
+		processor.ReleaseScheduled(newRecoveredTxnContextFactory(g.receipts))
+		newPendingTxns(processor).addRoutes(router)
+		newNonces(processor).addRoutes(router)
+		newScheduled(processor).addRoutes(router)
+	}
 	if len(g.conf.Kafka.Brokers) > 0 {
 		wk := newWebhooksKafka(&g.conf.Kafka, g.receipts)
 		g.webhooks = newWebhooks(wk, g.smartContractGW)
@@ -291,12 +319,17 @@ func (g *RESTGateway) Start() (err error) {
 		wd := newWebhooksDirect(&g.conf.WebhooksDirectConf, processor, g.receipts)
 		g.webhooks = newWebhooks(wd, g.smartContractGW)
 	}
+	if g.conf.Idempotency.LevelDBPath != "" {
+		if g.webhooks.idempotency, err = openIdempotency(&g.conf.Idempotency, g.receipts); err != nil {
+			return err
+		}
+	}
 	g.webhooks.addRoutes(router)
 
 	g.srv = &http.Server{
 		Addr:           fmt.Sprintf("%s:%d", g.conf.HTTP.LocalAddr, g.conf.HTTP.Port),
 		TLSConfig:      tlsConfig,
-		Handler:        g.newAccessTokenContextHandler(router),
+		Handler:        g.newAccessTokenContextHandler(withIdempotencyKey(router)),
 		MaxHeaderBytes: MaxHeaderSize,
 	}
 
@@ -342,6 +375,12 @@ func (g *RESTGateway) Start() (err error) {
 	if g.smartContractGW != nil {
 		g.smartContractGW.Shutdown()
 	}
+	if g.webhooks.idempotency != nil {
+		g.webhooks.idempotency.close()
+	}
+	if processor != nil {
+		processor.Close()
+	}
 	log.Infof("Shutting down HTTP server")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	g.srv.Shutdown(ctx)
@@ -28,6 +28,8 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/kaleido-io/ethconnect/internal/auth"
 	"github.com/kaleido-io/ethconnect/internal/auth/authtest"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -326,6 +328,52 @@ func TestDispatchMsgAsyncPassesThroughToWebhooks(t *testing.T) {
 	g.webhooks = newWebhooks(fakeHandler, nil)
 
 	var fakeMsg map[string]interface{}
-	_, err := g.DispatchMsgAsync(context.Background(), fakeMsg, true)
+	_, status, err := g.DispatchMsgAsync(context.Background(), fakeMsg, true)
+	assert.Equal(400, status)
 	assert.EqualError(err, "Invalid message - missing 'headers' (or not an object)")
 }
+
+func TestDispatchMsgAsyncIdempotencyKeyInProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	var printYAML = true
+	g := NewRESTGateway(&printYAML)
+	fakeHandler := &mockHandler{}
+	g.webhooks = newWebhooks(fakeHandler, nil)
+	g.webhooks.idempotency = newIdempotency(kvstore.NewMockKV(nil), 60, nil)
+	g.webhooks.idempotency.begin("key1")
+
+	msg := map[string]interface{}{
+		"headers": map[string]interface{}{"type": "SendTransaction", "id": "key1"},
+		"from":    "0x2b8c0ECc76d0759a8F50b2E14A6881367D805832",
+	}
+	_, status, err := g.DispatchMsgAsync(context.Background(), msg, true)
+	assert.Equal(409, status)
+	assert.EqualError(err, "A request with idempotency key 'key1' is already being processed")
+	assert.Equal(0, fakeHandler.sent)
+}
+
+type testRPCStats struct {
+	eth.MockRPCClient
+}
+
+func (r *testRPCStats) EndpointStats() []eth.RPCEndpointStats {
+	return []eth.RPCEndpointStats{{URL: "http://node0:8545", Healthy: true, BlockNumber: 12345}}
+}
+
+func TestStatusWithRPCEndpointStats(t *testing.T) {
+	assert := assert.New(t)
+	var printYAML = false
+	g := NewRESTGateway(&printYAML)
+	g.rpc = &testRPCStats{}
+
+	res := httptest.NewRecorder()
+	g.statusHandler(res, httptest.NewRequest("GET", "/status", nil), nil)
+	assert.Equal(200, res.Code)
+	var status statusMsg
+	err := json.Unmarshal(res.Body.Bytes(), &status)
+	assert.NoError(err)
+	assert.True(status.OK)
+	assert.Equal("http://node0:8545", status.RPCEndpoints[0].URL)
+	assert.Equal(uint64(12345), status.RPCEndpoints[0].BlockNumber)
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/tx"
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduled provides a REST API to list the transactions held by the scheduler
+// until they are due, and to cancel them before they are submitted
+type scheduled struct {
+	processor tx.TxnProcessor
+}
+
+func newScheduled(processor tx.TxnProcessor) *scheduled {
+	return &scheduled{
+		processor: processor,
+	}
+}
+
+func (s *scheduled) addRoutes(router *httprouter.Router) {
+	router.GET("/scheduled", s.listScheduled)
+	router.GET("/scheduled/:id", s.getScheduled)
+	router.POST("/scheduled/:id/cancel", s.cancelScheduled)
+}
+
+func (s *scheduled) listScheduled(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	sendRESTResult(res, req, s.processor.ScheduledTransactions())
+}
+
+func (s *scheduled) getScheduled(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	status, err := s.processor.ScheduledTransaction(params.ByName("id"))
+	if err != nil {
+		sendRESTError(res, req, err, 404)
+		return
+	}
+	sendRESTResult(res, req, status)
+}
+
+func (s *scheduled) cancelScheduled(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	if !authPendingTransactions(res, req) {
+		return
+	}
+
+	status, err := s.processor.CancelScheduled(params.ByName("id"))
+	if err != nil {
+		sendRESTError(res, req, err, 404)
+		return
+	}
+	sendRESTResult(res, req, status)
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/auth/authtest"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+const testScheduledFromAddr = "0x2b8c0ecc76d0759a8f50b2e14a6881367d805832"
+
+func newScheduledTestServer() (*mockProcessor, *httptest.Server) {
+	processor := &mockProcessor{
+		scheduled: &messages.ScheduledTransaction{
+			RequestID:      "req1",
+			Type:           messages.MsgTypeSendTransaction,
+			From:           testScheduledFromAddr,
+			NotBeforeBlock: "12345",
+		},
+	}
+	router := &httprouter.Router{}
+	newScheduled(processor).addRoutes(router)
+	return processor, httptest.NewServer(router)
+}
+
+func TestListScheduled(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newScheduledTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testGETArray(ts, "/scheduled")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(1, len(respJSON))
+	assert.Equal("req1", respJSON[0]["requestId"])
+	assert.Equal("12345", respJSON[0]["notBeforeBlock"])
+}
+
+func TestGetScheduled(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newScheduledTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testGETObject(ts, "/scheduled/req1")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal(testScheduledFromAddr, respJSON["from"])
+}
+
+func TestGetScheduledNotFound(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newScheduledTestServer()
+	defer ts.Close()
+	processor.scheduledErr = fmt.Errorf("not found")
+
+	status, respJSON, httpErr := testGETObject(ts, "/scheduled/req1")
+	assert.NoError(httpErr)
+	assert.Equal(404, status)
+	assert.Equal("not found", respJSON["error"])
+}
+
+func TestCancelScheduled(t *testing.T) {
+	assert := assert.New(t)
+	_, ts := newScheduledTestServer()
+	defer ts.Close()
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/scheduled/req1/cancel", "")
+	assert.NoError(httpErr)
+	assert.Equal(200, status)
+	assert.Equal("req1", respJSON["requestId"])
+}
+
+func TestCancelScheduledNotFound(t *testing.T) {
+	assert := assert.New(t)
+	processor, ts := newScheduledTestServer()
+	defer ts.Close()
+	processor.scheduledErr = fmt.Errorf("not found")
+
+	status, respJSON, httpErr := testPOSTObject(ts, "/scheduled/req1/cancel", "")
+	assert.NoError(httpErr)
+	assert.Equal(404, status)
+	assert.Equal("not found", respJSON["error"])
+}
+
+func TestScheduledUnauthorized(t *testing.T) {
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+
+	assert := assert.New(t)
+	_, ts := newScheduledTestServer()
+	defer ts.Close()
+
+	status, _, httpErr := testGETArray(ts, "/scheduled")
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+
+	status, respJSON, httpErr := testGETObject(ts, "/scheduled/req1")
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+	assert.Equal("Unauthorized", respJSON["error"])
+
+	status, _, httpErr = testPOSTObject(ts, "/scheduled/req1/cancel", "")
+	assert.NoError(httpErr)
+	assert.Equal(401, status)
+
+	auth.RegisterSecurityModule(nil)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/kaleido-io/ethconnect/internal/contracts"
 	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
 	"github.com/kaleido-io/ethconnect/internal/messages"
 	"github.com/kaleido-io/ethconnect/internal/utils"
 	log "github.com/sirupsen/logrus"
@@ -38,6 +39,7 @@ type webhooksHandler interface {
 type webhooks struct {
 	smartContractGW contracts.SmartContractGateway
 	handler         webhooksHandler
+	idempotency     *idempotency
 }
 
 func newWebhooks(handler webhooksHandler, smartContractGW contracts.SmartContractGateway) *webhooks {
@@ -75,6 +77,7 @@ func (w *webhooks) addRoutes(router *httprouter.Router) {
 	router.POST("/", w.webhookHandlerNoAck) // Default on base URL
 	router.POST("/hook", w.webhookHandlerWithAck)
 	router.POST("/fasthook", w.webhookHandlerNoAck)
+	router.POST("/transactions", w.rawTransactionHandler)
 }
 
 func (w *webhooks) webhookHandlerWithAck(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
@@ -102,7 +105,32 @@ func (w *webhooks) webhookHandler(res http.ResponseWriter, req *http.Request, ac
 	w.msgSentReply(res, req, reply)
 }
 
-func (w *webhooks) processMsg(ctx context.Context, msg map[string]interface{}, ack bool) (*messages.AsyncSentMsg, int, error) {
+// rawTransactionHandler accepts a transaction signed offline, without the message headers
+// required on the other webhooks. The receipt is delivered asynchronously in the same way
+func (w *webhooks) rawTransactionHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	msg, err := utils.YAMLorJSONPayload(req)
+	if err != nil {
+		w.hookErrReply(res, req, err, 400)
+		return
+	}
+	headers, ok := msg["headers"].(map[string]interface{})
+	if !ok {
+		headers = make(map[string]interface{})
+		msg["headers"] = headers
+	}
+	headers["type"] = messages.MsgTypeSendRawTransaction
+
+	reply, statusCode, err := w.processMsg(req.Context(), msg, false)
+	if err != nil {
+		w.hookErrReply(res, req, err, statusCode)
+		return
+	}
+	w.msgSentReply(res, req, reply)
+}
+
+func (w *webhooks) processMsg(ctx context.Context, msg map[string]interface{}, ack bool) (reply *messages.AsyncSentMsg, statusCode int, err error) {
 	// Check we understand the type, and can get the key.
 	// The rest of the validation is performed by the bridge listening to Kafka
 	headers, exists := msg["headers"]
@@ -122,20 +150,58 @@ func (w *webhooks) processMsg(ctx context.Context, msg map[string]interface{}, a
 		}
 		key = from.(string)
 		break
+	case messages.MsgTypeSendRawTransaction:
+		// The sender is recovered from the signature, to key the message in the same way
+		rawTX, exists := msg["rawTransaction"]
+		if !exists || reflect.TypeOf(rawTX).Kind() != reflect.String {
+			return nil, 400, errors.Errorf(errors.WebhooksInvalidMsgRawTxnMissing)
+		}
+		_, from, err := eth.DecodeRawTransaction(rawTX.(string))
+		if err != nil {
+			return nil, 400, err
+		}
+		key = from.Hex()
+		break
+	case messages.MsgTypeBatch:
+		// Every item is from the sender of the batch, so they share the key
+		from, exists := msg["from"]
+		if !exists || reflect.TypeOf(from).Kind() != reflect.String {
+			return nil, 400, errors.Errorf(errors.WebhooksInvalidMsgFromMissing)
+		}
+		items, exists := msg["items"]
+		if !exists || reflect.TypeOf(items).Kind() != reflect.Slice {
+			return nil, 400, errors.Errorf(errors.WebhooksInvalidMsgBatchItems)
+		}
+		key = from.(string)
+		break
 	default:
 		return nil, 400, errors.Errorf(errors.WebhooksInvalidMsgType, msgType)
 	}
 
-	// We always generate the ID. It cannot be set by the user
+	// A retry of a request with the same idempotency key is not submitted again
+	existing, status, complete, err := w.beginIdempotent(ctx, headers.(map[string]interface{}))
+	if err != nil || existing != nil {
+		return existing, status, err
+	}
+	if complete != nil {
+		defer func() { complete(reply) }()
+	}
+
+	// We always generate the ID. It cannot be set by the user (any ID the user
+	// supplies is only used as the idempotency key)
 	msgID := utils.UUIDv4()
 	headers.(map[string]interface{})["id"] = msgID
 
 	if w.smartContractGW != nil && msgType == messages.MsgTypeDeployContract {
-		var err error
 		if msg, err = w.contractGWHandler(msg); err != nil {
 			return nil, 500, err
 		}
 	}
+	if w.smartContractGW != nil && msgType == messages.MsgTypeBatch {
+		if err := w.batchContractGWHandler(msg); err != nil {
+			return nil, 500, err
+		}
+	}
 
 	// Pass to the handler
 	log.Infof("Webhook accepted message. MsgID: %s Type: %s", msgID, msgType)
@@ -150,6 +216,24 @@ func (w *webhooks) processMsg(ctx context.Context, msg map[string]interface{}, a
 	}, 200, nil
 }
 
+// beginIdempotent returns the reply to an earlier request with the same idempotency key, if
+// there was one. Otherwise, when the request has a key, it is reserved and the returned function
+// must be called once the request completes, with the reply if it was submitted or nil if not
+func (w *webhooks) beginIdempotent(ctx context.Context, headers map[string]interface{}) (*messages.AsyncSentMsg, int, func(reply *messages.AsyncSentMsg), error) {
+	key := idempotencyKey(ctx, headers)
+	if w.idempotency == nil || key == "" {
+		return nil, 200, nil, nil
+	}
+	existing, status, err := w.idempotency.begin(key)
+	if err != nil || existing != nil {
+		if existing != nil {
+			log.Infof("Request with idempotency key '%s' already accepted. MsgID: %s", key, existing.Request)
+		}
+		return existing, status, nil, err
+	}
+	return nil, 200, func(reply *messages.AsyncSentMsg) { w.idempotency.complete(key, reply) }, nil
+}
+
 func (w *webhooks) contractGWHandler(msg map[string]interface{}) (map[string]interface{}, error) {
 	// We have to fully parse, then re-serialize, the message in the case of a contract deployment
 	// where we are performing OpenAPI gateway processing
@@ -171,6 +255,28 @@ func (w *webhooks) contractGWHandler(msg map[string]interface{}) (map[string]int
 	return newMsg, nil
 }
 
+// batchContractGWHandler performs the OpenAPI gateway processing for each contract
+// deployment in a batch, in the same way as for an individual deployment
+func (w *webhooks) batchContractGWHandler(msg map[string]interface{}) error {
+	items := msg["items"].([]interface{})
+	for i, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		headers, _ := itemMap["headers"].(map[string]interface{})
+		if utils.GetMapString(headers, "type") != messages.MsgTypeDeployContract {
+			continue
+		}
+		deployMsg, err := w.contractGWHandler(itemMap)
+		if err != nil {
+			return err
+		}
+		items[i] = deployMsg
+	}
+	return nil
+}
+
 func (w *webhooks) run() error {
 	return w.handler.run()
 }
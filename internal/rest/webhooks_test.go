@@ -19,11 +19,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
 	"github.com/kaleido-io/ethconnect/internal/messages"
 	"github.com/stretchr/testify/assert"
 )
@@ -37,6 +39,7 @@ func (r *popReader) Read(b []byte) (n int, err error) {
 type mockContractGW struct {
 	preDeployErr  error
 	postDeployErr error
+	decodedEvent  string
 	testValue     interface{}
 	replyCallback func(message interface{})
 }
@@ -45,6 +48,12 @@ func (m *mockContractGW) PreDeploy(*messages.DeployContract) error { return m.pr
 
 func (m *mockContractGW) PostDeploy(*messages.TransactionReceipt) error { return m.postDeployErr }
 
+func (m *mockContractGW) DecodeLogs(msg *messages.TransactionReceipt) {
+	for _, l := range msg.Logs {
+		l.Event = m.decodedEvent
+	}
+}
+
 func (m *mockContractGW) AddRoutes(*httprouter.Router) {}
 
 func (m *mockContractGW) SendReply(message interface{}) {
@@ -55,9 +64,20 @@ func (m *mockContractGW) SendReply(message interface{}) {
 
 func (m *mockContractGW) Shutdown() {}
 
-type mockHandler struct{}
+type mockHandler struct {
+	capturedKey string
+	capturedMsg map[string]interface{}
+	sent        int
+	sendErr     error
+}
 
-func (*mockHandler) sendWebhookMsg(ctx context.Context, key, msgID string, msg map[string]interface{}, ack bool) (msgAck string, statusCode int, err error) {
+func (m *mockHandler) sendWebhookMsg(ctx context.Context, key, msgID string, msg map[string]interface{}, ack bool) (msgAck string, statusCode int, err error) {
+	m.capturedKey = key
+	m.capturedMsg = msg
+	if m.sendErr != nil {
+		return "", 500, m.sendErr
+	}
+	m.sent++
 	return "", 200, nil
 }
 
@@ -145,3 +165,180 @@ func TestContractGWHandlerUnmarshalFail(t *testing.T) {
 	})
 	assert.EqualError(err, "unexpected end of JSON input")
 }
+
+func TestWebhookHandlerBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	msgBytes, _ := json.Marshal(map[string]interface{}{
+		"headers": map[string]interface{}{"type": messages.MsgTypeBatch},
+		"from":    "0xb480F96c0a3d6E9e9a263e4665a39bFa6c4d01E8",
+		"items": []interface{}{
+			map[string]interface{}{
+				"headers":  map[string]interface{}{"type": messages.MsgTypeDeployContract},
+				"solidity": "contract t {}",
+			},
+			map[string]interface{}{
+				"headers":    map[string]interface{}{"type": messages.MsgTypeSendTransaction},
+				"to":         "0xe1a078b9e2b145d0a7387f09277c6ae1d9470771",
+				"methodName": "set",
+			},
+		},
+	})
+	req, _ := http.NewRequest("POST", "/any", bytes.NewReader(msgBytes))
+	handler := &mockHandler{}
+	w := &webhooks{
+		smartContractGW: &mockContractGW{},
+		handler:         handler,
+	}
+	rec := httptest.NewRecorder()
+	w.webhookHandler(rec, req, false)
+	assert.Equal(200, rec.Result().StatusCode)
+	assert.Equal("0xb480F96c0a3d6E9e9a263e4665a39bFa6c4d01E8", handler.capturedKey)
+	items := handler.capturedMsg["items"].([]interface{})
+	assert.Len(items, 2)
+	// The deployment has been through the contract gateway, and the transaction is unchanged
+	assert.Equal("contract t {}", items[0].(map[string]interface{})["solidity"])
+	assert.Contains(items[0], "gasPrice")
+	assert.NotContains(items[1], "gasPrice")
+}
+
+func TestWebhookHandlerBatchInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		msg         map[string]interface{}
+		expectedErr string
+	}{
+		{map[string]interface{}{"items": []interface{}{}}, "missing 'from'"},
+		{map[string]interface{}{"from": "0xb480F96c0a3d6E9e9a263e4665a39bFa6c4d01E8"}, "missing 'items'"},
+		{map[string]interface{}{"from": "0xb480F96c0a3d6E9e9a263e4665a39bFa6c4d01E8", "items": "bad"}, "missing 'items'"},
+	}
+	for _, test := range tests {
+		test.msg["headers"] = map[string]interface{}{"type": messages.MsgTypeBatch}
+		msgBytes, _ := json.Marshal(test.msg)
+		req, _ := http.NewRequest("POST", "/any", bytes.NewReader(msgBytes))
+		w := &webhooks{handler: &mockHandler{}}
+		rec := httptest.NewRecorder()
+		w.webhookHandler(rec, req, false)
+		assert.Equal(400, rec.Result().StatusCode)
+		assert.Regexp(test.expectedErr, rec.Body.String())
+	}
+}
+
+func TestWebhookHandlerBatchContractGWFail(t *testing.T) {
+	assert := assert.New(t)
+
+	msgBytes, _ := json.Marshal(map[string]interface{}{
+		"headers": map[string]interface{}{"type": messages.MsgTypeBatch},
+		"from":    "0xb480F96c0a3d6E9e9a263e4665a39bFa6c4d01E8",
+		"items": []interface{}{
+			"not an object",
+			map[string]interface{}{
+				"headers": map[string]interface{}{"type": messages.MsgTypeDeployContract},
+			},
+		},
+	})
+	req, _ := http.NewRequest("POST", "/any", bytes.NewReader(msgBytes))
+	w := &webhooks{
+		smartContractGW: &mockContractGW{
+			preDeployErr: fmt.Errorf("pop"),
+		},
+		handler: &mockHandler{},
+	}
+	rec := httptest.NewRecorder()
+	w.webhookHandler(rec, req, false)
+	assert.Equal(500, rec.Result().StatusCode)
+}
+
+func newTestRawTxn(t *testing.T) (string, string) {
+	key, err := ethbind.API.HexToECDSA("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	assert.NoError(t, err)
+	to := ethbind.API.HexToAddress("0x2b8c0ECc76d0759a8F50b2E14A6881367D805832")
+	etx := ethbind.API.NewTransaction(1, to, big.NewInt(0), 50000, big.NewInt(1000), nil)
+	signed, err := ethbind.API.SignTx(etx, ethbind.API.NewEIP155Signer(big.NewInt(1337)), key)
+	assert.NoError(t, err)
+	raw, _ := signed.MarshalBinary()
+	return ethbind.API.HexEncode(raw), ethbind.API.PubkeyToAddress(key.PublicKey).Hex()
+}
+
+func TestWebhookHandlerRawTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	rawHex, from := newTestRawTxn(t)
+	msgBytes, _ := json.Marshal(map[string]interface{}{
+		"headers":        map[string]interface{}{"type": messages.MsgTypeSendRawTransaction},
+		"rawTransaction": rawHex,
+	})
+	req, _ := http.NewRequest("POST", "/any", bytes.NewReader(msgBytes))
+	handler := &mockHandler{}
+	w := &webhooks{handler: handler}
+	rec := httptest.NewRecorder()
+	w.webhookHandler(rec, req, false)
+	assert.Equal(200, rec.Result().StatusCode)
+	assert.Equal(from, handler.capturedKey)
+}
+
+func TestWebhookHandlerRawTransactionMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	msgBytes, _ := json.Marshal(map[string]interface{}{
+		"headers": map[string]interface{}{"type": messages.MsgTypeSendRawTransaction},
+	})
+	req, _ := http.NewRequest("POST", "/any", bytes.NewReader(msgBytes))
+	w := &webhooks{handler: &mockHandler{}}
+	rec := httptest.NewRecorder()
+	w.webhookHandler(rec, req, false)
+	assert.Equal(400, rec.Result().StatusCode)
+	assert.Regexp("missing 'rawTransaction'", rec.Body.String())
+}
+
+func TestWebhookHandlerRawTransactionBad(t *testing.T) {
+	assert := assert.New(t)
+
+	msgBytes, _ := json.Marshal(map[string]interface{}{
+		"headers":        map[string]interface{}{"type": messages.MsgTypeSendRawTransaction},
+		"rawTransaction": "0xf8",
+	})
+	req, _ := http.NewRequest("POST", "/any", bytes.NewReader(msgBytes))
+	w := &webhooks{handler: &mockHandler{}}
+	rec := httptest.NewRecorder()
+	w.webhookHandler(rec, req, false)
+	assert.Equal(400, rec.Result().StatusCode)
+	assert.Regexp("Failed to decode raw transaction", rec.Body.String())
+}
+
+func TestRawTransactionHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	rawHex, from := newTestRawTxn(t)
+	msgBytes, _ := json.Marshal(map[string]interface{}{
+		"rawTransaction": rawHex,
+	})
+	handler := &mockHandler{}
+	w := &webhooks{handler: handler}
+	router := &httprouter.Router{}
+	w.addRoutes(router)
+	req := httptest.NewRequest("POST", "/transactions", bytes.NewReader(msgBytes))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(200, rec.Result().StatusCode)
+	assert.Equal(from, handler.capturedKey)
+	headers := handler.capturedMsg["headers"].(map[string]interface{})
+	assert.Equal(messages.MsgTypeSendRawTransaction, headers["type"])
+	assert.NotEmpty(headers["id"])
+}
+
+func TestRawTransactionHandlerBadRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	w := &webhooks{handler: &mockHandler{}}
+	req, _ := http.NewRequest("POST", "/transactions", &popReader{})
+	rec := httptest.NewRecorder()
+	w.rawTransactionHandler(rec, req, nil)
+	assert.Equal(400, rec.Result().StatusCode)
+
+	req, _ = http.NewRequest("POST", "/transactions", bytes.NewReader([]byte(`{"rawTransaction":"0xf8"}`)))
+	rec = httptest.NewRecorder()
+	w.rawTransactionHandler(rec, req, nil)
+	assert.Equal(400, rec.Result().StatusCode)
+}
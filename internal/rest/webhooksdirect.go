@@ -65,6 +65,7 @@ type msgContext struct {
 	msgID        string
 	msg          map[string]interface{}
 	headers      *messages.CommonHeaders
+	rateLimitErr error
 }
 
 func (t *msgContext) Context() context.Context {
@@ -83,6 +84,12 @@ func (t *msgContext) Unmarshal(msg interface{}) error {
 	return json.Unmarshal(msgBytes, msg)
 }
 
+// NewItemContext delivers the replies for an item of a batch into the receipt store. The
+// batch remains in-flight until it is replied to, once every item has been replied to
+func (t *msgContext) NewItemContext(headers *messages.CommonHeaders) tx.TxnContext {
+	return newRecoveredTxnContextFactory(t.w.receipts)(headers, t.timeReceived)
+}
+
 func (t *msgContext) SendErrorReply(status int, err error) {
 	t.SendErrorReplyWithGapFill(status, err, "", false)
 }
@@ -93,6 +100,14 @@ func (t *msgContext) SendErrorReplyWithGapFill(status int, err error, gapFillTxH
 
 func (t *msgContext) SendErrorReplyWithTX(status int, err error, txHash string) {
 	log.Warnf("Failed to process message %s: %s", t, err)
+	if tx.IsRateLimited(err) {
+		// Rejected synchronously while dispatching, so we return a 429 rather than storing a receipt
+		t.w.inFlightMutex.Lock()
+		t.rateLimitErr = err
+		delete(t.w.inFlight, t.msgID)
+		t.w.inFlightMutex.Unlock()
+		return
+	}
 	origBytes, _ := json.Marshal(t.msg)
 	errMsg := messages.NewErrorReply(err, origBytes)
 	errMsg.TXHash = txHash
@@ -154,11 +169,14 @@ func (w *webhooksDirect) sendWebhookMsg(ctx context.Context, key, msgID string,
 	w.inFlightMutex.Unlock()
 
 	w.processor.OnMessage(msgContext)
+	if msgContext.rateLimitErr != nil {
+		return "", 429, msgContext.rateLimitErr
+	}
 	return "", 200, nil
 }
 
 func validateWebhooksDirectConf(conf *WebhooksDirectConf) error {
-	if conf.RPC.URL == "" {
+	if !conf.RPC.Configured() {
 		return errors.Errorf(errors.ConfigWebhooksDirectRPC)
 	}
 	if conf.MaxTXWaitTime < 10 {
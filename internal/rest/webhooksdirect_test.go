@@ -33,14 +33,62 @@ import (
 )
 
 type mockProcessor struct {
-	capturedCtx *msgContext
+	capturedCtx  *msgContext
+	rejectErr    error
+	pending      *messages.PendingTransaction
+	pendingErr   error
+	updateErr    error
+	capturedFees *messages.TransactionFees
+	nonceStates  []*messages.NonceState
+	nonceState   *messages.NonceState
+	nonceErr     error
+	gapFillNonce int64
+	scheduled    *messages.ScheduledTransaction
+	scheduledErr error
 }
 
 func (p *mockProcessor) ResolveAddress(from string) (string, error) { return "", nil }
 func (p *mockProcessor) OnMessage(ctx tx.TxnContext) {
 	p.capturedCtx = ctx.(*msgContext)
+	if p.rejectErr != nil {
+		ctx.SendErrorReply(429, p.rejectErr)
+	}
+}
+func (p *mockProcessor) Init(eth.RPCClient) error                  { return nil }
+func (p *mockProcessor) Resume(tx.RecoveredTxnContextFactory)      {}
+func (p *mockProcessor) WatchReorgs(tx.RecoveredTxnContextFactory) {}
+func (p *mockProcessor) PendingTransaction(id string) (*messages.PendingTransaction, error) {
+	return p.pending, p.pendingErr
+}
+func (p *mockProcessor) CancelTransaction(ctx context.Context, id string) (*messages.PendingTransaction, error) {
+	return p.pending, p.updateErr
+}
+func (p *mockProcessor) ReplaceTransaction(ctx context.Context, id string, fees *messages.TransactionFees) (*messages.PendingTransaction, error) {
+	p.capturedFees = fees
+	return p.pending, p.updateErr
+}
+func (p *mockProcessor) NonceStates() []*messages.NonceState { return p.nonceStates }
+func (p *mockProcessor) NonceState(address string) (*messages.NonceState, error) {
+	return p.nonceState, p.nonceErr
+}
+func (p *mockProcessor) ResyncNonce(ctx context.Context, address string) (*messages.NonceState, error) {
+	return p.nonceState, p.nonceErr
+}
+func (p *mockProcessor) FillNonceGap(ctx context.Context, address string, nonce int64) (*messages.PendingTransaction, error) {
+	p.gapFillNonce = nonce
+	return p.pending, p.nonceErr
+}
+func (p *mockProcessor) ReleaseScheduled(tx.RecoveredTxnContextFactory) {}
+func (p *mockProcessor) ScheduledTransactions() []*messages.ScheduledTransaction {
+	return []*messages.ScheduledTransaction{p.scheduled}
+}
+func (p *mockProcessor) ScheduledTransaction(id string) (*messages.ScheduledTransaction, error) {
+	return p.scheduled, p.scheduledErr
+}
+func (p *mockProcessor) CancelScheduled(id string) (*messages.ScheduledTransaction, error) {
+	return p.scheduled, p.scheduledErr
 }
-func (p *mockProcessor) Init(eth.RPCClient) {}
+func (p *mockProcessor) Close() {}
 
 func newTestWebhooksDirect(maxMsgs int) (*webhooksDirect, *memoryReceipts, *mockProcessor) {
 	rsc := &ReceiptStoreConf{}
@@ -154,6 +202,71 @@ func TestWebhooksDirectMsgLimit(t *testing.T) {
 
 }
 
+func TestWebhooksDirectBatchItemReplies(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, ts, r, p := newTestWebhooksDirectServer(1)
+	defer ts.Close()
+
+	item := newTestMsg()
+	itemBytes, _ := json.Marshal(&item)
+	msgBytes, _ := json.Marshal(map[string]interface{}{
+		"headers": map[string]interface{}{"type": messages.MsgTypeBatch},
+		"from":    item.From,
+		"items":   []json.RawMessage{itemBytes},
+	})
+	resp, err := http.Post(fmt.Sprintf("%s/hook", ts.URL), "application/json", bytes.NewReader(msgBytes))
+	assert.NoError(err)
+	assert.Equal(200, resp.StatusCode)
+
+	batchCtx, ok := interface{}(p.capturedCtx).(tx.BatchTxnContext)
+	assert.True(ok)
+	itemCtx := batchCtx.NewItemContext(&messages.CommonHeaders{
+		ID:      p.capturedCtx.msgID + "-0",
+		MsgType: messages.MsgTypeSendTransaction,
+		BatchID: p.capturedCtx.msgID,
+	})
+	itemCtx.SendErrorReply(400, fmt.Errorf("pop"))
+
+	// The item reply is stored, but the batch remains in-flight until it is replied to
+	receipt, _ := r.GetReceipt(p.capturedCtx.msgID + "-0")
+	assert.NotNil(receipt)
+	assert.Equal("pop", (*receipt)["errorMessage"])
+	assert.Len(wd.inFlight, 1)
+
+	batchReply := &messages.BatchComplete{}
+	batchReply.Headers.MsgType = messages.MsgTypeBatchComplete
+	p.capturedCtx.Reply(batchReply)
+	receipt, _ = r.GetReceipt(p.capturedCtx.msgID)
+	assert.NotNil(receipt)
+	assert.Empty(wd.inFlight)
+}
+
+func TestWebhooksDirectRateLimited(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, ts, r, p := newTestWebhooksDirectServer(1)
+	defer ts.Close()
+	p.rejectErr = &tx.RateLimitError{Err: fmt.Errorf("pop")}
+
+	msg := newTestMsg()
+	msgBytes, _ := json.Marshal(&msg)
+	url := fmt.Sprintf("%s/hook", ts.URL)
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(msgBytes))
+	assert.NoError(err)
+	assert.Equal(429, resp.StatusCode)
+	replyBytes, _ := ioutil.ReadAll(resp.Body)
+	reply := hookErrMsg{}
+	json.Unmarshal(replyBytes, &reply)
+	assert.False(reply.Sent)
+	assert.Equal("pop", reply.Message)
+
+	receipt, _ := r.GetReceipt(p.capturedCtx.msgID)
+	assert.Nil(receipt)
+	assert.Empty(wd.inFlight)
+}
+
 func TestWebhooksDirectSendWebhooksMsgBadHeaders(t *testing.T) {
 	assert := assert.New(t)
 	wd, _, _ := newTestWebhooksDirect(1)
@@ -0,0 +1,300 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// batch tracks the replies to the items of a Batch message. The batch itself is replied
+// to once every item has been replied to, which is when the transport can release it.
+// The dispatched channel is closed once every item has been submitted, or the batch stopped
+type batch struct {
+	txnContext TxnContext
+	from       string // normalized to 0x prefix and lower case
+	dispatched chan struct{}
+	lock       sync.Mutex
+	remaining  int
+	results    []*messages.BatchItemResult
+}
+
+// batchItemContext is the context for an item of a batch. It unmarshals the item from
+// the batch, and delivers the reply through a context built by the transport for the item
+type batchItemContext struct {
+	batch   *batch
+	index   int
+	msg     []byte
+	headers *messages.CommonHeaders
+	replyTo TxnContext
+}
+
+// OnBatchMessage dispatches each item of a batch in order, one at a time. Other transactions
+// from the sender wait until the batch is dispatched, so the items are assigned consecutive
+// nonces. If an item fails, the remaining items are not submitted
+func (p *txnProcessor) OnBatchMessage(txnContext TxnContext, msg *messages.Batch) {
+
+	batchContext, ok := txnContext.(BatchTxnContext)
+	if !ok {
+		txnContext.SendErrorReply(400, errors.Errorf(errors.TransactionBatchUnsupported))
+		return
+	}
+	b := &batch{
+		txnContext: txnContext,
+		dispatched: make(chan struct{}),
+		remaining:  len(msg.Items),
+		results:    make([]*messages.BatchItemResult, len(msg.Items)),
+	}
+	items, err := b.newItemContexts(batchContext, msg)
+	if err == nil {
+		b.from, err = p.batchSender(msg.From)
+	}
+	if err != nil {
+		txnContext.SendErrorReply(400, err)
+		return
+	}
+	for _, item := range items {
+		item.replyTo = batchContext.NewItemContext(item.headers)
+	}
+
+	p.startBatch(b)
+	defer p.endBatch(b)
+	log.Infof("Dispatching %d items of batch %s", len(items), txnContext.Headers().ID)
+	for i, item := range items {
+		p.OnMessage(item)
+		if b.itemFailed(i) {
+			for _, skipped := range items[i+1:] {
+				skipped.SendErrorReply(400, errors.Errorf(errors.TransactionBatchItemSkipped, skipped.index, i))
+			}
+			return
+		}
+	}
+}
+
+// batchSender resolves the sender of the batch. The node assigns the nonces for transactions
+// it signs, unless we always manage the nonces, and it cannot be relied on to assign the items
+// consecutive nonces when other transactions from the sender are being submitted
+func (p *txnProcessor) batchSender(from string) (string, error) {
+	signer, err := p.resolveSigner(from)
+	if err != nil {
+		return "", err
+	}
+	if signer != nil {
+		from = signer.Address()
+	} else if !p.conf.AlwaysManageNonce {
+		return "", errors.Errorf(errors.TransactionBatchNodeNonce, from)
+	}
+	address, err := utils.StrToAddress("from", from)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(address.Hex()), nil
+}
+
+// startBatch waits for any batch from the same sender to be dispatched, and then
+// records this batch as the one being dispatched for the sender
+func (p *txnProcessor) startBatch(b *batch) {
+	p.inflightTxnsLock.Lock()
+	for dispatching, exists := p.batches[b.from]; exists; dispatching, exists = p.batches[b.from] {
+		p.inflightTxnsLock.Unlock()
+		<-dispatching.dispatched
+		p.inflightTxnsLock.Lock()
+	}
+	p.batches[b.from] = b
+	p.inflightTxnsLock.Unlock()
+}
+
+func (p *txnProcessor) endBatch(b *batch) {
+	p.inflightTxnsLock.Lock()
+	delete(p.batches, b.from)
+	p.inflightTxnsLock.Unlock()
+	close(b.dispatched)
+}
+
+// waitForBatch is called with the in-flight lock held, before a nonce is assigned to a
+// transaction. If a batch from the sender is being dispatched, and the transaction is not
+// one of its items, the lock is released until the batch is dispatched
+func (p *txnProcessor) waitForBatch(from string, txnContext TxnContext) {
+	for p.batchBlocks(from, txnContext) {
+		dispatching := p.batches[from]
+		p.inflightTxnsLock.Unlock()
+		<-dispatching.dispatched
+		p.inflightTxnsLock.Lock()
+	}
+}
+
+// batchBlocks is true if a batch from the sender is being dispatched, and the transaction
+// is not one of its items. Called with the in-flight lock held
+func (p *txnProcessor) batchBlocks(from string, txnContext TxnContext) bool {
+	dispatching, exists := p.batches[from]
+	if !exists {
+		return false
+	}
+	item, isItem := txnContext.(*batchItemContext)
+	return !isItem || item.batch != dispatching
+}
+
+// newItemContexts validates every item before any are dispatched, so the batch is
+// rejected as a whole if any item is invalid. Items without a sender inherit the
+// sender of the batch, and each item is assigned a request ID derived from the batch
+func (b *batch) newItemContexts(batchContext BatchTxnContext, msg *messages.Batch) ([]*batchItemContext, error) {
+	if msg.From == "" {
+		return nil, errors.Errorf(errors.TransactionBatchFromMissing)
+	}
+	if len(msg.Items) == 0 {
+		return nil, errors.Errorf(errors.TransactionBatchEmpty)
+	}
+	batchHeaders := batchContext.Headers()
+	items := make([]*batchItemContext, len(msg.Items))
+	for i, itemBytes := range msg.Items {
+		var item map[string]interface{}
+		if err := json.Unmarshal(itemBytes, &item); err != nil {
+			return nil, errors.Errorf(errors.TransactionBatchItemInvalid, i, err)
+		}
+		itemHeaders, _ := item["headers"].(map[string]interface{})
+		msgType := utils.GetMapString(itemHeaders, "type")
+		if msgType != messages.MsgTypeSendTransaction && msgType != messages.MsgTypeDeployContract {
+			return nil, errors.Errorf(errors.TransactionBatchItemType, i, msgType)
+		}
+		if item["notBefore"] != nil || item["notBeforeBlock"] != nil {
+			return nil, errors.Errorf(errors.TransactionBatchItemScheduled, i)
+		}
+		if item["nonce"] != nil {
+			return nil, errors.Errorf(errors.TransactionBatchItemNonce, i)
+		}
+		if item["simulate"] == true || item["prepare"] == true {
+			return nil, errors.Errorf(errors.TransactionBatchItemNotSubmitted, i)
+		}
+		from := utils.GetMapString(item, "from")
+		if from == "" {
+			item["from"] = msg.From
+		} else if !strings.EqualFold(from, msg.From) {
+			return nil, errors.Errorf(errors.TransactionBatchItemFrom, i, from, msg.From)
+		}
+		headers := &messages.CommonHeaders{
+			ID:      fmt.Sprintf("%s-%d", batchHeaders.ID, i),
+			MsgType: msgType,
+			Account: batchHeaders.Account,
+			Context: batchHeaders.Context,
+			BatchID: batchHeaders.ID,
+		}
+		item["headers"] = headers
+		itemBytes, _ = json.Marshal(item)
+		items[i] = &batchItemContext{
+			batch:   b,
+			index:   i,
+			msg:     itemBytes,
+			headers: headers,
+		}
+	}
+	return items, nil
+}
+
+// itemFailed is true if an item has been replied to with an error
+func (b *batch) itemFailed(index int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.results[index] != nil && b.results[index].Type == messages.MsgTypeError
+}
+
+// itemReplied records the first reply to an item, and replies to the batch once
+// every item has been replied to
+func (b *batch) itemReplied(index int, requestID string, reply messages.ReplyWithHeaders) {
+	result := &messages.BatchItemResult{
+		RequestID: requestID,
+		Type:      reply.ReplyHeaders().MsgType,
+	}
+	if receipt := reply.IsReceipt(); receipt != nil && receipt.TransactionHash != nil {
+		result.TransactionHash = receipt.TransactionHash.Hex()
+	} else if errReply, ok := reply.(*messages.ErrorReply); ok {
+		result.TransactionHash = errReply.TXHash
+		result.ErrorMessage = errReply.ErrorMessage
+	}
+
+	b.lock.Lock()
+	if b.results[index] != nil {
+		b.lock.Unlock()
+		return
+	}
+	b.results[index] = result
+	b.remaining--
+	complete := b.remaining == 0
+	b.lock.Unlock()
+
+	if complete {
+		batchReply := &messages.BatchComplete{
+			Items: b.results,
+		}
+		batchReply.Headers.MsgType = messages.MsgTypeBatchComplete
+		batchReply.Headers.BatchID = b.txnContext.Headers().ID
+		b.txnContext.Reply(batchReply)
+	}
+}
+
+func (c *batchItemContext) Context() context.Context {
+	return c.batch.txnContext.Context()
+}
+
+func (c *batchItemContext) Headers() *messages.CommonHeaders {
+	return c.headers
+}
+
+func (c *batchItemContext) Unmarshal(msg interface{}) error {
+	return json.Unmarshal(c.msg, msg)
+}
+
+// Backpressure is inherited from the transport that delivered the batch
+func (c *batchItemContext) Backpressure() bool {
+	bpCtx, ok := c.batch.txnContext.(BackpressureTxnContext)
+	return ok && bpCtx.Backpressure()
+}
+
+func (c *batchItemContext) SendErrorReply(status int, err error) {
+	c.SendErrorReplyWithTX(status, err, "")
+}
+
+func (c *batchItemContext) SendErrorReplyWithGapFill(status int, err error, gapFillTxHash string, gapFillSucceeded bool) {
+	log.Warnf("Failed to process batch item %s: %s", c, err)
+	errMsg := messages.NewErrorReply(err, c.msg)
+	errMsg.GapFillTxHash = gapFillTxHash
+	var bGap = gapFillSucceeded
+	errMsg.GapFillSucceeded = &bGap
+	c.Reply(errMsg)
+}
+
+func (c *batchItemContext) SendErrorReplyWithTX(status int, err error, txHash string) {
+	log.Warnf("Failed to process batch item %s: %s", c, err)
+	errMsg := messages.NewErrorReply(err, c.msg)
+	errMsg.TXHash = txHash
+	c.Reply(errMsg)
+}
+
+func (c *batchItemContext) Reply(replyMessage messages.ReplyWithHeaders) {
+	replyMessage.ReplyHeaders().BatchID = c.headers.BatchID
+	c.replyTo.Reply(replyMessage)
+	c.batch.itemReplied(c.index, c.headers.ID, replyMessage)
+}
+
+func (c *batchItemContext) String() string {
+	return fmt.Sprintf("BatchItem[%s/%s]", c.headers.MsgType, c.headers.ID)
+}
@@ -0,0 +1,277 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBatchTxnContext struct {
+	testTxnContext
+	lock         sync.Mutex
+	itemContexts []*testTxnContext
+	itemHeaders  []*messages.CommonHeaders
+}
+
+func (c *testBatchTxnContext) NewItemContext(headers *messages.CommonHeaders) TxnContext {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	itemContext := &testTxnContext{}
+	c.itemContexts = append(c.itemContexts, itemContext)
+	c.itemHeaders = append(c.itemHeaders, headers)
+	return itemContext
+}
+
+func (c *testBatchTxnContext) Reply(replyMsg messages.ReplyWithHeaders) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.testTxnContext.Reply(replyMsg)
+}
+
+func (c *testBatchTxnContext) waitForReply(t *testing.T) messages.ReplyWithHeaders {
+	for i := 0; i < 1000; i++ {
+		c.lock.Lock()
+		replied := len(c.replies) > 0
+		c.lock.Unlock()
+		if replied {
+			return c.replies[0]
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for batch reply")
+	return nil
+}
+
+func newTestBatchTxnContext(items ...string) *testBatchTxnContext {
+	batchContext := &testBatchTxnContext{}
+	batchContext.jsonMsg = "{" +
+		"  \"headers\":{\"type\": \"Batch\", \"id\": \"batch1\"}," +
+		"  \"from\":\"" + testFromAddr + "\"," +
+		"  \"items\":[" + strings.Join(items, ",") + "]" +
+		"}"
+	return batchContext
+}
+
+const testBatchSendItem = "{" +
+	"  \"headers\":{\"type\": \"SendTransaction\"}," +
+	"  \"to\":\"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832\"," +
+	"  \"gas\":\"50000\"," +
+	"  \"method\":{\"name\":\"test\"}" +
+	"}"
+
+func TestOnBatchMessageConsecutiveNonces(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		AlwaysManageNonce: true,
+		MaxTXWaitTime:     1,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	itemFromLowerCase := strings.Replace(testBatchSendItem, "{", "{\"from\":\""+strings.ToLower(testFromAddr)+"\",", 1)
+	batchContext := newTestBatchTxnContext(testBatchSendItem, itemFromLowerCase)
+	testRPC := goodMessageRPC()
+	testRPC.ethGetTransactionCountResult = 10
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(batchContext)
+	reply := batchContext.waitForReply(t).(*messages.BatchComplete)
+
+	assert.Equal(messages.MsgTypeBatchComplete, reply.Headers.MsgType)
+	assert.Equal("batch1", reply.Headers.BatchID)
+	assert.Len(reply.Items, 2)
+	for i, expectedID := range []string{"batch1-0", "batch1-1"} {
+		assert.Equal(expectedID, reply.Items[i].RequestID)
+		assert.Equal(messages.MsgTypeTransactionSuccess, reply.Items[i].Type)
+		assert.Equal("0xe2215336b09f9b5b82e36e1144ed64f40a42e61b68fdaca82549fd98b8531a89", reply.Items[i].TransactionHash)
+
+		assert.Equal(expectedID, batchContext.itemHeaders[i].ID)
+		assert.Equal(messages.MsgTypeSendTransaction, batchContext.itemHeaders[i].MsgType)
+		itemContext := batchContext.itemContexts[i]
+		assert.Len(itemContext.replies, 1)
+		receipt := itemContext.replies[0].(*messages.TransactionReceipt)
+		assert.Equal("batch1", receipt.Headers.BatchID)
+	}
+
+	var nonces []uint64
+	for i, method := range testRPC.calls {
+		if method == "eth_sendTransaction" {
+			nonces = append(nonces, uint64(*testRPC.params[i][0].(*eth.SendTXArgs).Nonce))
+		}
+	}
+	assert.Equal([]uint64{10, 11}, nonces)
+}
+
+func TestOnBatchMessageItemError(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		AlwaysManageNonce: true,
+		SendConcurrency:   10,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	badToItem := strings.Replace(testBatchSendItem, "0x2b8c0ECc76d0759a8F50b2E14A6881367D805832", "!bad", 1)
+	batchContext := newTestBatchTxnContext(badToItem, testBatchSendItem)
+	testRPC := goodMessageRPC()
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(batchContext)
+	reply := batchContext.waitForReply(t).(*messages.BatchComplete)
+
+	assert.Len(reply.Items, 2)
+	assert.Equal(messages.MsgTypeError, reply.Items[0].Type)
+	assert.Regexp("Supplied value for 'to' is not a valid hex address", reply.Items[0].ErrorMessage)
+	errReply := batchContext.itemContexts[0].replies[0].(*messages.ErrorReply)
+	assert.Equal("batch1", errReply.Headers.BatchID)
+	assert.Regexp("!bad", errReply.OriginalMessage)
+
+	// The rest of the batch is not submitted
+	assert.Equal(messages.MsgTypeError, reply.Items[1].Type)
+	assert.Equal("Batch item 1 was not submitted, as batch item 0 failed", reply.Items[1].ErrorMessage)
+	assert.NotContains(testRPC.calls, "eth_sendTransaction")
+	assert.Empty(txnProcessor.inflightTxns)
+	assert.Empty(txnProcessor.batches)
+}
+
+func TestOnBatchMessageOtherTransactionsWait(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		AlwaysManageNonce: true,
+		MaxTXWaitTime:     1,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(goodMessageRPC())
+
+	b := &batch{
+		from:       strings.ToLower(testFromAddr),
+		dispatched: make(chan struct{}),
+	}
+	txnProcessor.startBatch(b)
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	done := make(chan bool)
+	go func() {
+		txnProcessor.OnMessage(testTxnContext)
+		close(done)
+	}()
+	select {
+	case <-done:
+		assert.Fail("Sent while the batch was dispatching")
+	case <-time.After(10 * time.Millisecond):
+	}
+	txnProcessor.inflightTxnsLock.Lock()
+	assert.Empty(txnProcessor.inflightTxns)
+	txnProcessor.inflightTxnsLock.Unlock()
+
+	txnProcessor.endBatch(b)
+	<-done
+	assert.Empty(testTxnContext.errorReplies)
+	assert.Empty(txnProcessor.batches)
+}
+
+func TestOnBatchMessageWaitingTransactionsReleaseCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		AlwaysManageNonce: true,
+		MaxTXWaitTime:     1,
+		RateLimit: RateLimitConf{
+			MaxInFlightPerAddress: 1,
+			QueueTimeoutMS:        60000,
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(goodMessageRPC())
+	from := strings.ToLower(testFromAddr)
+
+	// The transaction waits for capacity, and a batch starts in the meantime
+	err := txnProcessor.rateLimiter.acquire(from, false)
+	assert.NoError(err)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	done := make(chan bool)
+	go func() {
+		txnProcessor.OnMessage(testTxnContext)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b := &batch{
+		from:       from,
+		dispatched: make(chan struct{}),
+	}
+	txnProcessor.startBatch(b)
+	txnProcessor.rateLimiter.release(from)
+
+	// Capacity is given back while the transaction waits for the batch, so the items can take it
+	time.Sleep(10 * time.Millisecond)
+	err = txnProcessor.rateLimiter.acquire(from, false)
+	assert.NoError(err)
+	txnProcessor.rateLimiter.release(from)
+
+	txnProcessor.endBatch(b)
+	<-done
+	assert.Empty(testTxnContext.errorReplies)
+}
+
+func TestOnBatchMessageUnsupportedTransport(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &newTestBatchTxnContext(testBatchSendItem).testTxnContext
+	txnProcessor.Init(&testRPC{})
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.Regexp("Batch messages are not supported on this transport", testTxnContext.errorReplies[0].err)
+}
+
+func TestOnBatchMessageInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(&testRPC{})
+
+	noFrom := newTestBatchTxnContext(testBatchSendItem)
+	noFrom.jsonMsg = strings.Replace(noFrom.jsonMsg, testFromAddr, "", 1)
+	tests := []struct {
+		batchContext *testBatchTxnContext
+		expectedErr  string
+	}{
+		{noFrom, "Batch must specify 'from'"},
+		{newTestBatchTxnContext(), "Batch must contain at least one item"},
+		{newTestBatchTxnContext(testBatchSendItem, "12345"), "Batch item 1 is invalid"},
+		{newTestBatchTxnContext("{\"headers\":{\"type\":\"SendRawTransaction\"}}"), "Batch item 0 has type 'SendRawTransaction'"},
+		{newTestBatchTxnContext("{}"), "Batch item 0 has type ''"},
+		{newTestBatchTxnContext(strings.Replace(testBatchSendItem, "{", "{\"notBeforeBlock\":\"100\",", 1)), "Batch item 0 cannot set notBefore or notBeforeBlock"},
+		{newTestBatchTxnContext(strings.Replace(testBatchSendItem, "{", "{\"from\":\"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832\",", 1)), "Batch item 0 is from '0x2b8c0ECc76d0759a8F50b2E14A6881367D805832'"},
+		{newTestBatchTxnContext(strings.Replace(testBatchSendItem, "{", "{\"nonce\":\"1\",", 1)), "Batch item 0 cannot set a nonce"},
+		{newTestBatchTxnContext(strings.Replace(testBatchSendItem, "{", "{\"simulate\":true,", 1)), "Batch item 0 cannot set simulate or prepare"},
+		{newTestBatchTxnContext(strings.Replace(testBatchSendItem, "{", "{\"prepare\":true,", 1)), "Batch item 0 cannot set simulate or prepare"},
+		{newTestBatchTxnContext(testBatchSendItem), "cannot be assigned consecutive nonces, as the node assigns the nonces"},
+	}
+	for _, test := range tests {
+		txnProcessor.OnMessage(test.batchContext)
+		assert.Len(test.batchContext.errorReplies, 1)
+		assert.Equal(400, test.batchContext.errorReplies[0].status)
+		assert.Regexp(test.expectedErr, test.batchContext.errorReplies[0].err)
+		assert.Empty(test.batchContext.itemContexts)
+	}
+	assert.Empty(txnProcessor.inflightTxns)
+}
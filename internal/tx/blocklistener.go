@@ -0,0 +1,219 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultNewHeadsRetryDelayMS = 5000
+)
+
+// NewHeadsConf configures the newHeads subscription, that is made when the RPC URL is a
+// websocket. While subscribed, the receipts of in-flight transactions are checked when each
+// new block arrives, rather than after the delays estimated by the TxnDelayTracker
+type NewHeadsConf struct {
+	Disabled     bool `json:"disabled"`
+	RetryDelayMS int  `json:"retryDelayMS"`
+}
+
+// blockHeader contains the fields we use from each newHeads notification
+type blockHeader struct {
+	Number *ethbinding.HexBigInt `json:"number"`
+	Hash   *ethbinding.Hash      `json:"hash"`
+}
+
+// blockListener tracks the head of the chain from the newHeads subscription. The newBlock
+// channel is closed, and replaced, each time a block arrives - waking all the waiters.
+// The receipt poller is told about each block, so it polls without waiting for its interval
+type blockListener struct {
+	conf       *NewHeadsConf
+	rpc        eth.RPCClientAsync
+	receipts   *receiptPoller
+	mux        sync.Mutex
+	subscribed bool
+	headBlock  uint64
+	newBlock   chan struct{}
+	closed     bool
+	closing    chan struct{}
+	done       chan struct{}
+}
+
+func newBlockListener(conf *NewHeadsConf, receipts *receiptPoller) *blockListener {
+	if conf.RetryDelayMS <= 0 {
+		conf.RetryDelayMS = defaultNewHeadsRetryDelayMS
+	}
+	return &blockListener{
+		conf:     conf,
+		receipts: receipts,
+		newBlock: make(chan struct{}),
+		closing:  make(chan struct{}),
+	}
+}
+
+// start subscribes to newHeads if one of the RPC endpoints is a websocket. Otherwise
+// receipts continue to be polled on a timer
+func (bl *blockListener) start(rpc eth.RPCClient, rpcURLs []string) {
+	if bl.conf.Disabled {
+		return
+	}
+	websocket := false
+	for _, rpcURL := range rpcURLs {
+		if u, err := url.Parse(rpcURL); err == nil {
+			scheme := strings.ToLower(u.Scheme)
+			websocket = websocket || scheme == "ws" || scheme == "wss"
+		}
+	}
+	if !websocket {
+		return
+	}
+	asyncRPC, ok := rpc.(eth.RPCClientAsync)
+	if !ok {
+		return
+	}
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	if bl.closed {
+		return
+	}
+	bl.rpc = asyncRPC
+	bl.done = make(chan struct{})
+	go bl.listen()
+}
+
+// listen processes the newHeads notifications, and subscribes again after a delay if
+// the subscription fails. Receipts are polled on a timer while unsubscribed
+func (bl *blockListener) listen() {
+	defer close(bl.done)
+	retryDelay := time.Duration(bl.conf.RetryDelayMS) * time.Millisecond
+	for {
+		heads := make(chan *blockHeader)
+		sub, err := bl.rpc.Subscribe(auth.NewSystemAuthContext(), "eth", heads, "newHeads")
+		if err != nil {
+			log.Errorf("Failed to subscribe to newHeads: %s", err)
+			if !bl.retryAfter(retryDelay) {
+				return
+			}
+			continue
+		}
+		log.Infof("Subscribed to newHeads. Receipts will be checked when each block arrives")
+		bl.setSubscribed(true)
+		stopped := false
+		for subscribed := true; subscribed; {
+			select {
+			case head := <-heads:
+				bl.blockAdded(head)
+			case err := <-sub.Err():
+				log.Errorf("newHeads subscription failed. Falling back to polling for receipts: %s", err)
+				subscribed = false
+			case <-bl.closing:
+				subscribed = false
+				stopped = true
+			}
+		}
+		sub.Unsubscribe()
+		bl.setSubscribed(false)
+		if stopped || !bl.retryAfter(retryDelay) {
+			return
+		}
+	}
+}
+
+// retryAfter waits for the delay before subscribing again. Returns false if closed meanwhile
+func (bl *blockListener) retryAfter(delay time.Duration) bool {
+	select {
+	case <-time.After(delay):
+		return true
+	case <-bl.closing:
+		return false
+	}
+}
+
+// close stops listening for new blocks, and waits for the subscription to be unsubscribed.
+// Waiters fall back to polling for receipts
+func (bl *blockListener) close() {
+	bl.mux.Lock()
+	if !bl.closed {
+		bl.closed = true
+		close(bl.closing)
+	}
+	done := bl.done
+	bl.mux.Unlock()
+	if done != nil {
+		<-done
+	}
+	log.Debugf("Block listener stopped")
+}
+
+func (bl *blockListener) setSubscribed(subscribed bool) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	bl.subscribed = subscribed
+	if !subscribed {
+		// Wake the waiters, so they can fall back to polling
+		close(bl.newBlock)
+		bl.newBlock = make(chan struct{})
+	}
+}
+
+func (bl *blockListener) blockAdded(head *blockHeader) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	if head != nil && head.Number != nil {
+		bl.headBlock = head.Number.ToInt().Uint64()
+		log.Debugf("New head block %d", bl.headBlock)
+	}
+	close(bl.newBlock)
+	bl.newBlock = make(chan struct{})
+	if bl.receipts != nil {
+		bl.receipts.blockAdded()
+	}
+}
+
+// nextBlock returns a channel that is closed when the next block arrives, if subscribed
+func (bl *blockListener) nextBlock() (<-chan struct{}, bool) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	return bl.newBlock, bl.subscribed
+}
+
+// head returns the latest block number, if subscribed and a block has arrived
+func (bl *blockListener) head() (uint64, bool) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	return bl.headBlock, bl.subscribed && bl.headBlock > 0
+}
+
+// waitForBlock waits for the next block while subscribed to newHeads, or sleeps for the
+// delay when polling. Never waits beyond the deadline
+func (bl *blockListener) waitForBlock(delay time.Duration, deadline time.Time) {
+	if newBlock, subscribed := bl.nextBlock(); subscribed {
+		select {
+		case <-newBlock:
+		case <-time.After(time.Until(deadline)):
+		}
+		return
+	}
+	time.Sleep(delay)
+}
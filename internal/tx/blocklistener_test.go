@@ -0,0 +1,260 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNewHeadsRPC delivers the newHeads notifications sent by the test
+type testNewHeadsRPC struct {
+	*testRPC
+	mux           sync.Mutex
+	subscribeErrs []error
+	subscriptions []*testNewHeadsSub
+	subscribed    chan *testNewHeadsSub
+}
+
+type testNewHeadsSub struct {
+	namespace    string
+	args         []interface{}
+	heads        chan *blockHeader
+	errChan      chan error
+	unsubscribed bool
+}
+
+func newTestNewHeadsRPC(rpc *testRPC) *testNewHeadsRPC {
+	return &testNewHeadsRPC{
+		testRPC:    rpc,
+		subscribed: make(chan *testNewHeadsSub, 10),
+	}
+}
+
+func (r *testNewHeadsRPC) Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (eth.RPCClientSubscription, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if len(r.subscribeErrs) > 0 {
+		err := r.subscribeErrs[0]
+		r.subscribeErrs = r.subscribeErrs[1:]
+		return nil, err
+	}
+	sub := &testNewHeadsSub{
+		namespace: namespace,
+		args:      args,
+		heads:     channel.(chan *blockHeader),
+		errChan:   make(chan error),
+	}
+	r.subscriptions = append(r.subscriptions, sub)
+	r.subscribed <- sub
+	return sub, nil
+}
+
+func (s *testNewHeadsSub) Err() <-chan error {
+	return s.errChan
+}
+
+func (s *testNewHeadsSub) Unsubscribe() {
+	s.unsubscribed = true
+}
+
+func (s *testNewHeadsSub) sendHead(number int64) {
+	n := ethbinding.HexBigInt(*big.NewInt(number))
+	s.heads <- &blockHeader{Number: &n}
+}
+
+func waitSubscribed(bl *blockListener, subscribed bool) {
+	for {
+		if _, s := bl.nextBlock(); s == subscribed {
+			return
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+func TestBlockListenerNotWebsocket(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := newTestNewHeadsRPC(goodMessageRPC())
+	for _, rpcURLs := range [][]string{{"http://localhost:8545"}, {}, {":::"}} {
+		bl := newBlockListener(&NewHeadsConf{}, nil)
+		bl.start(rpc, rpcURLs)
+		assert.Nil(bl.rpc)
+	}
+
+	bl := newBlockListener(&NewHeadsConf{Disabled: true}, nil)
+	bl.start(rpc, []string{"ws://localhost:8546"})
+	assert.Nil(bl.rpc)
+
+	bl = newBlockListener(&NewHeadsConf{}, nil)
+	bl.start(goodMessageRPC(), []string{"ws://localhost:8546"})
+	assert.Nil(bl.rpc)
+	assert.Equal(defaultNewHeadsRetryDelayMS, bl.conf.RetryDelayMS)
+
+	_, subscribed := bl.head()
+	assert.False(subscribed)
+	start := time.Now()
+	bl.waitForBlock(1*time.Millisecond, start.Add(1*time.Hour))
+	assert.True(time.Since(start) < 1*time.Hour)
+}
+
+func TestBlockListenerHeadsAndResubscribe(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := newTestNewHeadsRPC(goodMessageRPC())
+	rpc.subscribeErrs = []error{fmt.Errorf("pop")}
+	rp := newReceiptPoller(&ReceiptPollConf{})
+	bl := newBlockListener(&NewHeadsConf{RetryDelayMS: 1}, rp)
+	bl.start(rpc, []string{"http://localhost:8545", "WSS://localhost:8546"})
+
+	sub := <-rpc.subscribed
+	assert.Equal("eth", sub.namespace)
+	assert.Equal([]interface{}{"newHeads"}, sub.args)
+	waitSubscribed(bl, true)
+
+	_, hasHead := bl.head()
+	assert.False(hasHead)
+	newBlock, _ := bl.nextBlock()
+	sub.sendHead(12345)
+	<-newBlock
+	headBlock, hasHead := bl.head()
+	assert.True(hasHead)
+	assert.Equal(uint64(12345), headBlock)
+	// The receipt poller is told about the block
+	<-rp.newBlock
+
+	// Waiters are woken by a block, or the deadline
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+				sub.sendHead(12346)
+			}
+		}
+	}()
+	bl.waitForBlock(1*time.Hour, time.Now().Add(1*time.Hour))
+	close(done)
+	bl.waitForBlock(1*time.Hour, time.Now())
+
+	// Waiters are woken when the subscription fails, and we subscribe again
+	newBlock, _ = bl.nextBlock()
+	sub.errChan <- fmt.Errorf("pop")
+	<-newBlock
+	sub2 := <-rpc.subscribed
+	assert.True(sub.unsubscribed)
+	waitSubscribed(bl, true)
+	sub2.sendHead(12347)
+	for headBlock, _ = bl.head(); headBlock != 12347; headBlock, _ = bl.head() {
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+func TestBlockListenerClose(t *testing.T) {
+	assert := assert.New(t)
+
+	rpc := newTestNewHeadsRPC(goodMessageRPC())
+	bl := newBlockListener(&NewHeadsConf{}, nil)
+	bl.start(rpc, []string{"ws://localhost:8546"})
+	sub := <-rpc.subscribed
+	waitSubscribed(bl, true)
+
+	newBlock, _ := bl.nextBlock()
+	bl.close()
+	<-newBlock
+	assert.True(sub.unsubscribed)
+	_, subscribed := bl.nextBlock()
+	assert.False(subscribed)
+	bl.close()
+
+	// Not started once closed
+	bl = newBlockListener(&NewHeadsConf{}, nil)
+	bl.close()
+	bl.start(rpc, []string{"ws://localhost:8546"})
+	assert.Nil(bl.rpc)
+}
+
+func TestBlockListenerCloseWhileRetrying(t *testing.T) {
+	rpc := newTestNewHeadsRPC(goodMessageRPC())
+	rpc.subscribeErrs = []error{fmt.Errorf("pop")}
+	bl := newBlockListener(&NewHeadsConf{RetryDelayMS: 3600000}, nil)
+	bl.start(rpc, []string{"ws://localhost:8546"})
+	for {
+		rpc.mux.Lock()
+		failed := len(rpc.subscribeErrs) == 0
+		rpc.mux.Unlock()
+		if failed {
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	bl.close()
+}
+
+func TestOnSendTransactionMessageNewHeads(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	rpc := newTestNewHeadsRPC(testRPC)
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 60,
+		Confirmations: 2,
+	}, &eth.RPCConf{RPC: eth.RPCConnOpts{URL: "ws://localhost:8546"}}).(*txnProcessor)
+	txnProcessor.Init(rpc)
+	sub := <-rpc.subscribed
+	waitSubscribed(txnProcessor.blocks, true)
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	inflight := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0]
+
+	// The receipt is in block 12345, so is confirmed when block 12346 arrives
+	done := make(chan bool)
+	go func() {
+		for blockNumber := int64(12345); ; blockNumber++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+				sub.sendHead(blockNumber)
+			}
+		}
+	}()
+	inflight.wg.Wait()
+	close(done)
+
+	assert.Equal(1, len(testTxnContext.replies))
+	assert.Equal(messages.MsgTypeTransactionSuccess, testTxnContext.replies[0].ReplyHeaders().MsgType)
+	assert.NotContains(testRPC.calls, "eth_blockNumber")
+
+	txnProcessor.Close()
+	assert.True(sub.unsubscribed)
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+)
+
+const (
+	// gasEstimateConstructorKey is the method key used for overrides on contract deployment
+	gasEstimateConstructorKey = "constructor"
+)
+
+// GasEstimateConf configures the gas estimation policy for all transactions, with overrides
+// by method name (or full signature such as "set(uint256)"), and by ABI.
+// ABIs are keyed by their ID in the contract gateway, which is set as abiId on the transactions
+// and deployments submitted through the gateway. Only the non-zero fields of an override
+// replace the values from the base policy
+type GasEstimateConf struct {
+	eth.GasEstimatePolicy
+	Methods map[string]eth.GasEstimatePolicy `json:"methods,omitempty"`
+	ABIs    map[string]eth.GasEstimatePolicy `json:"abis,omitempty"`
+}
+
+// policyFor merges the overrides that apply to a transaction, with the most specific last
+func (conf *GasEstimateConf) policyFor(abiID, methodName, methodSig string) *eth.GasEstimatePolicy {
+	policy := conf.GasEstimatePolicy
+	if override, ok := conf.ABIs[abiID]; ok && abiID != "" {
+		mergeGasEstimatePolicy(&policy, &override)
+	}
+	if override, ok := conf.Methods[methodName]; ok && methodName != "" {
+		mergeGasEstimatePolicy(&policy, &override)
+	}
+	if override, ok := conf.Methods[methodSig]; ok && methodSig != "" && methodSig != methodName {
+		mergeGasEstimatePolicy(&policy, &override)
+	}
+	return &policy
+}
+
+func mergeGasEstimatePolicy(policy, override *eth.GasEstimatePolicy) {
+	if override.Multiplier > 0 {
+		policy.Multiplier = override.Multiplier
+	}
+	if override.Floor > 0 {
+		policy.Floor = override.Floor
+	}
+	if override.Ceiling > 0 {
+		policy.Ceiling = override.Ceiling
+	}
+	if override.FailAbove > 0 {
+		policy.FailAbove = override.FailAbove
+	}
+	if override.TimeoutSec > 0 {
+		policy.TimeoutSec = override.TimeoutSec
+	}
+}
+
+func (conf *GasEstimateConf) policyForSend(msg *messages.SendTransaction, tx *eth.Txn) *eth.GasEstimatePolicy {
+	methodName := msg.MethodName
+	if msg.Method != nil && msg.Method.Name != "" {
+		methodName = msg.Method.Name
+	}
+	return conf.policyFor(msg.ABIID, methodName, tx.MethodSig)
+}
+
+func (conf *GasEstimateConf) policyForDeploy(msg *messages.DeployContract) *eth.GasEstimatePolicy {
+	return conf.policyFor(msg.ABIID, gasEstimateConstructorKey, "")
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGasEstimatePolicyForMergesOverrides(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := &GasEstimateConf{
+		GasEstimatePolicy: eth.GasEstimatePolicy{
+			Multiplier: 1.5,
+			Ceiling:    1000000,
+		},
+		ABIs: map[string]eth.GasEstimatePolicy{
+			"abi1": {Floor: 50000},
+			"abi2": {FailAbove: 5000000},
+		},
+		Methods: map[string]eth.GasEstimatePolicy{
+			"set":            {Multiplier: 2},
+			"set(uint256)":   {Ceiling: 2000000},
+			"constructor":    {TimeoutSec: 60},
+			"unused(string)": {Multiplier: 3},
+		},
+	}
+
+	policy := conf.policyFor("abi1", "set", "set(uint256)")
+	assert.Equal(eth.GasEstimatePolicy{
+		Multiplier: 2,
+		Floor:      50000,
+		Ceiling:    2000000,
+	}, *policy)
+
+	policy = conf.policyFor("", "get", "get()")
+	assert.Equal(conf.GasEstimatePolicy, *policy)
+
+	policy = conf.policyFor("abi2", gasEstimateConstructorKey, "")
+	assert.Equal(eth.GasEstimatePolicy{
+		Multiplier: 1.5,
+		Ceiling:    1000000,
+		FailAbove:  5000000,
+		TimeoutSec: 60,
+	}, *policy)
+}
+
+func TestOnSendTransactionMessageGasEstimateInReceipt(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		GasEstimate: GasEstimateConf{
+			Methods: map[string]eth.GasEstimatePolicy{
+				"test": {Multiplier: 1.5},
+			},
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSONWithoutGas
+	testRPC := goodMessageRPC()
+	testRPC.ethEstimateGasResult = 1000
+	assert.NoError(txnProcessor.Init(testRPC))
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(0, len(testTxnContext.errorReplies))
+
+	replyMsgBytes, _ := json.Marshal(testTxnContext.replies[0])
+	var replyMsgMap map[string]interface{}
+	json.Unmarshal(replyMsgBytes, &replyMsgMap)
+	assert.Equal("1500", replyMsgMap["gas"])
+	assert.Equal("1000", replyMsgMap["gasEstimate"])
+}
+
+func TestOnSendTransactionMessageGasEstimateExceedsLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		GasEstimate: GasEstimateConf{
+			GasEstimatePolicy: eth.GasEstimatePolicy{FailAbove: 500},
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSONWithoutGas
+	testRPC := goodMessageRPC()
+	testRPC.ethEstimateGasResult = 1000
+	assert.NoError(txnProcessor.Init(testRPC))
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Equal(1, len(testTxnContext.errorReplies))
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.EqualError(testTxnContext.errorReplies[0].err, "Estimated gas 1000 exceeds the limit of 500")
+}
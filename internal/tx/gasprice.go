@@ -0,0 +1,346 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// GasPriceStrategyNone leaves the gas price for the node to choose
+	GasPriceStrategyNone = "none"
+	// GasPriceStrategyFixed uses a configured gas price
+	GasPriceStrategyFixed = "fixed"
+	// GasPriceStrategyNode uses eth_gasPrice from the node
+	GasPriceStrategyNode = "node"
+	// GasPriceStrategyFeeHistory uses the base fee, plus a percentile of the priority fees, from eth_feeHistory
+	GasPriceStrategyFeeHistory = "feeHistory"
+	// GasPriceStrategyOracle queries an external HTTP gas price oracle
+	GasPriceStrategyOracle = "oracle"
+
+	defaultFeeHistoryBlockCount = 10
+	defaultFeeHistoryPercentile = 50
+	defaultGasOraclePriceProp   = "gasPrice"
+	defaultGasPriceCacheMS      = 5000
+)
+
+// GasPriceConf configures how the gas price is chosen for transactions that do not specify
+// their fees. The strategy can be overridden on each request with gasPriceStrategy.
+// Each price is cached until a new block arrives on the newHeads subscription or, while
+// not subscribed, for CacheMS
+type GasPriceConf struct {
+	Strategy    string             `json:"strategy"`
+	FixedPrice  string             `json:"fixedPrice,omitempty"`
+	Multiplier  float64            `json:"multiplier,omitempty"`
+	MaxGasPrice string             `json:"maxGasPrice,omitempty"`
+	CacheMS     int                `json:"cacheMS,omitempty"`
+	FeeHistory  GasFeeHistoryConf  `json:"feeHistory"`
+	Oracle      GasPriceOracleConf `json:"oracle"`
+}
+
+// GasFeeHistoryConf configures the eth_feeHistory strategy
+type GasFeeHistoryConf struct {
+	BlockCount int     `json:"blockCount"`
+	Percentile float64 `json:"percentile"`
+}
+
+// GasPriceOracleConf configures an external oracle, queried with a GET
+type GasPriceOracleConf struct {
+	utils.HTTPRequesterConf
+	URL      string `json:"url"`
+	PropName string `json:"propName"`
+	Units    string `json:"units"`
+}
+
+// gasPricer chooses the gas price for a transaction, with the price from each strategy
+// cached until a new block is seen. The lock is not held while a price is fetched, and
+// concurrent requests for the same strategy wait for the fetch in progress
+type gasPricer struct {
+	conf        *GasPriceConf
+	hr          *utils.HTTPRequester
+	blocks      *blockListener
+	fixedPrice  *big.Int
+	maxGasPrice *big.Int
+	unitsWei    *big.Float
+	mux         sync.Mutex
+	cache       map[string]*cachedGasPrice
+	fetching    map[string]chan struct{}
+}
+
+type cachedGasPrice struct {
+	blockNumber uint64 // zero if the head block was not known when fetched
+	fetched     time.Time
+	price       *big.Int
+}
+
+// feeHistory is the result of eth_feeHistory
+type feeHistory struct {
+	BaseFeePerGas []ethbinding.HexBigInt   `json:"baseFeePerGas"`
+	Reward        [][]ethbinding.HexBigInt `json:"reward"`
+}
+
+func newGasPricer(conf *GasPriceConf) *gasPricer {
+	return &gasPricer{
+		conf:     conf,
+		hr:       utils.NewHTTPRequester("Gas price oracle", &conf.Oracle.HTTPRequesterConf),
+		unitsWei: big.NewFloat(1),
+		cache:    make(map[string]*cachedGasPrice),
+		fetching: make(map[string]chan struct{}),
+	}
+}
+
+// init validates the configuration, and applies defaults
+func (gp *gasPricer) init() error {
+	conf := gp.conf
+	if conf.Strategy != "" {
+		if err := validGasPriceStrategy(conf.Strategy); err != nil {
+			return err
+		}
+	}
+	var ok bool
+	if conf.FixedPrice != "" {
+		if gp.fixedPrice, ok = new(big.Int).SetString(conf.FixedPrice, 10); !ok {
+			return errors.Errorf(errors.GasPriceBadConfigValue, "fixedPrice", conf.FixedPrice)
+		}
+	}
+	if conf.MaxGasPrice != "" {
+		if gp.maxGasPrice, ok = new(big.Int).SetString(conf.MaxGasPrice, 10); !ok {
+			return errors.Errorf(errors.GasPriceBadConfigValue, "maxGasPrice", conf.MaxGasPrice)
+		}
+	}
+	switch strings.ToLower(conf.Oracle.Units) {
+	case "", "wei":
+	case "gwei":
+		gp.unitsWei = big.NewFloat(1e9)
+	default:
+		return errors.Errorf(errors.GasPriceBadConfigValue, "units", conf.Oracle.Units)
+	}
+	if conf.FeeHistory.BlockCount <= 0 {
+		conf.FeeHistory.BlockCount = defaultFeeHistoryBlockCount
+	}
+	if conf.FeeHistory.Percentile <= 0 {
+		conf.FeeHistory.Percentile = defaultFeeHistoryPercentile
+	}
+	if conf.Oracle.PropName == "" {
+		conf.Oracle.PropName = defaultGasOraclePriceProp
+	}
+	if conf.CacheMS <= 0 {
+		conf.CacheMS = defaultGasPriceCacheMS
+	}
+	return nil
+}
+
+func validGasPriceStrategy(strategy string) error {
+	switch strategy {
+	case GasPriceStrategyNone, GasPriceStrategyFixed, GasPriceStrategyNode, GasPriceStrategyFeeHistory, GasPriceStrategyOracle:
+		return nil
+	default:
+		return errors.Errorf(errors.GasPriceBadStrategy, strategy)
+	}
+}
+
+// applyGasPrice sets the gas price on a transaction that does not specify any fees,
+// using the strategy from the request, or the one configured for the gateway.
+// Dynamic-fee transactions are left for the node to price
+func (gp *gasPricer) applyGasPrice(ctx context.Context, rpc eth.RPCClient, msg *messages.TransactionCommon) error {
+	strategy := msg.GasPriceStrategy
+	if strategy == "" {
+		strategy = gp.conf.Strategy
+	}
+	if strategy == "" || strategy == GasPriceStrategyNone {
+		return nil
+	}
+	if err := validGasPriceStrategy(strategy); err != nil {
+		return err
+	}
+	if msg.GasPrice != "" || msg.MaxFeePerGas != "" || msg.MaxPriorityFeePerGas != "" {
+		return nil
+	}
+	if txType, err := msg.TxType.Int64(); err == nil && txType == ethbinding.DynamicFeeTxType {
+		return nil
+	}
+	price, err := gp.gasPrice(ctx, rpc, strategy)
+	if err != nil {
+		return err
+	}
+	msg.GasPrice = json.Number(price.Text(10))
+	log.Debugf("Gas price %s from strategy '%s'", msg.GasPrice, strategy)
+	return nil
+}
+
+// gasPrice returns the price for the strategy, from the cache if it is still fresh
+func (gp *gasPricer) gasPrice(ctx context.Context, rpc eth.RPCClient, strategy string) (*big.Int, error) {
+	if strategy == GasPriceStrategyFixed {
+		if gp.fixedPrice == nil {
+			return nil, errors.Errorf(errors.GasPriceStrategyNotConfigured, strategy)
+		}
+		return gp.fixedPrice, nil
+	}
+	if strategy == GasPriceStrategyOracle && gp.conf.Oracle.URL == "" {
+		return nil, errors.Errorf(errors.GasPriceStrategyNotConfigured, strategy)
+	}
+
+	for {
+		headBlock := gp.headBlock()
+		gp.mux.Lock()
+		if cached, ok := gp.cache[strategy]; ok && gp.fresh(cached, headBlock) {
+			gp.mux.Unlock()
+			return cached.price, nil
+		}
+		if fetching, ok := gp.fetching[strategy]; ok {
+			// Check the cache again once the fetch in progress is complete
+			gp.mux.Unlock()
+			<-fetching
+			continue
+		}
+		fetched := make(chan struct{})
+		gp.fetching[strategy] = fetched
+		gp.mux.Unlock()
+
+		price, err := gp.fetchGasPrice(ctx, rpc, strategy)
+
+		gp.mux.Lock()
+		delete(gp.fetching, strategy)
+		close(fetched)
+		if err == nil {
+			gp.cache[strategy] = &cachedGasPrice{
+				blockNumber: headBlock,
+				fetched:     time.Now(),
+				price:       price,
+			}
+		}
+		gp.mux.Unlock()
+		return price, err
+	}
+}
+
+// headBlock returns the latest block from the newHeads subscription, or zero if not subscribed
+func (gp *gasPricer) headBlock() uint64 {
+	if gp.blocks != nil {
+		if head, ok := gp.blocks.head(); ok {
+			return head
+		}
+	}
+	return 0
+}
+
+// fresh is true if no block has arrived since the price was fetched. When the head block is
+// not known, as we are not subscribed to newHeads, the price is fresh for the configured time
+func (gp *gasPricer) fresh(cached *cachedGasPrice, headBlock uint64) bool {
+	if headBlock > 0 && cached.blockNumber > 0 {
+		return cached.blockNumber == headBlock
+	}
+	return time.Since(cached.fetched) < time.Duration(gp.conf.CacheMS)*time.Millisecond
+}
+
+func (gp *gasPricer) fetchGasPrice(ctx context.Context, rpc eth.RPCClient, strategy string) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var price *big.Int
+	var err error
+	switch strategy {
+	case GasPriceStrategyNode:
+		price, err = gp.nodeGasPrice(ctx, rpc)
+	case GasPriceStrategyFeeHistory:
+		price, err = gp.feeHistoryGasPrice(ctx, rpc)
+	default:
+		price, err = gp.oracleGasPrice()
+	}
+	if err != nil {
+		return nil, err
+	}
+	price = gp.adjust(price)
+	log.Infof("Gas price using '%s': %s", strategy, price.Text(10))
+	return price, nil
+}
+
+// adjust applies the multiplier, and the cap, to a price
+func (gp *gasPricer) adjust(price *big.Int) *big.Int {
+	if gp.conf.Multiplier > 0 && gp.conf.Multiplier != 1 {
+		price, _ = new(big.Float).Mul(new(big.Float).SetInt(price), big.NewFloat(gp.conf.Multiplier)).Int(nil)
+	}
+	if gp.maxGasPrice != nil && price.Cmp(gp.maxGasPrice) > 0 {
+		price = gp.maxGasPrice
+	}
+	return price
+}
+
+func (gp *gasPricer) nodeGasPrice(ctx context.Context, rpc eth.RPCClient) (*big.Int, error) {
+	var gasPrice ethbinding.HexBigInt
+	if err := rpc.CallContext(ctx, &gasPrice, "eth_gasPrice"); err != nil {
+		return nil, errors.Errorf(errors.RPCCallReturnedError, "eth_gasPrice", err)
+	}
+	return gasPrice.ToInt(), nil
+}
+
+// feeHistoryGasPrice is the base fee for the next block, plus the average over recent
+// blocks of the priority fee paid at the configured percentile
+func (gp *gasPricer) feeHistoryGasPrice(ctx context.Context, rpc eth.RPCClient) (*big.Int, error) {
+	conf := &gp.conf.FeeHistory
+	var history feeHistory
+	if err := rpc.CallContext(ctx, &history, "eth_feeHistory", ethbinding.HexUint64(conf.BlockCount), "latest", []float64{conf.Percentile}); err != nil {
+		return nil, errors.Errorf(errors.RPCCallReturnedError, "eth_feeHistory", err)
+	}
+	if len(history.BaseFeePerGas) == 0 {
+		return nil, errors.Errorf(errors.GasPriceFeeHistoryEmpty)
+	}
+	price := new(big.Int).Set(history.BaseFeePerGas[len(history.BaseFeePerGas)-1].ToInt())
+	tips := new(big.Int)
+	var count int64
+	for _, blockRewards := range history.Reward {
+		if len(blockRewards) > 0 {
+			tips.Add(tips, blockRewards[0].ToInt())
+			count++
+		}
+	}
+	if count > 0 {
+		price.Add(price, tips.Div(tips, big.NewInt(count)))
+	}
+	return price, nil
+}
+
+// oracleGasPrice queries the external oracle, which can return the price as a number or a string
+func (gp *gasPricer) oracleGasPrice() (*big.Int, error) {
+	conf := &gp.conf.Oracle
+	body, err := gp.hr.DoRequest("GET", conf.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var price *big.Float
+	switch v := body[conf.PropName].(type) {
+	case float64:
+		price = big.NewFloat(v)
+	case string:
+		price, _ = new(big.Float).SetString(v)
+	}
+	if price == nil || price.Sign() <= 0 {
+		return nil, errors.Errorf(errors.GasPriceOracleBadResponse, conf.PropName)
+	}
+	wei, _ := price.Mul(price, gp.unitsWei).Int(nil)
+	return wei, nil
+}
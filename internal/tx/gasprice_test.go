@@ -0,0 +1,429 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+type gasPriceRPC struct {
+	gasPrice int64
+	history  feeHistory
+	errs     map[string]error
+	calls    []string
+	args     [][]interface{}
+}
+
+func (r *gasPriceRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	r.calls = append(r.calls, method)
+	r.args = append(r.args, args)
+	if err := r.errs[method]; err != nil {
+		return err
+	}
+	switch method {
+	case "eth_gasPrice":
+		result.(*ethbinding.HexBigInt).ToInt().SetInt64(r.gasPrice)
+	case "eth_feeHistory":
+		*(result.(*feeHistory)) = r.history
+	}
+	return nil
+}
+
+func hexBigInts(vals ...int64) []ethbinding.HexBigInt {
+	res := make([]ethbinding.HexBigInt, len(vals))
+	for i, v := range vals {
+		res[i] = ethbinding.HexBigInt(*big.NewInt(v))
+	}
+	return res
+}
+
+func newTestGasPricer(t *testing.T, conf *GasPriceConf) *gasPricer {
+	gp := newGasPricer(conf)
+	assert.NoError(t, gp.init())
+	return gp
+}
+
+func TestGasPricerInitDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{})
+	assert.Equal(defaultFeeHistoryBlockCount, gp.conf.FeeHistory.BlockCount)
+	assert.Equal(float64(defaultFeeHistoryPercentile), gp.conf.FeeHistory.Percentile)
+	assert.Equal(defaultGasOraclePriceProp, gp.conf.Oracle.PropName)
+	assert.Equal(defaultGasPriceCacheMS, gp.conf.CacheMS)
+}
+
+func TestGasPricerInitBadConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	err := newGasPricer(&GasPriceConf{Strategy: "guess"}).init()
+	assert.EqualError(err, "Unknown gas price strategy 'guess'")
+
+	err = newGasPricer(&GasPriceConf{FixedPrice: "lots"}).init()
+	assert.EqualError(err, "Invalid fixedPrice in gas price configuration: 'lots'")
+
+	err = newGasPricer(&GasPriceConf{MaxGasPrice: "lots"}).init()
+	assert.EqualError(err, "Invalid maxGasPrice in gas price configuration: 'lots'")
+
+	err = newGasPricer(&GasPriceConf{Oracle: GasPriceOracleConf{Units: "ether"}}).init()
+	assert.EqualError(err, "Invalid units in gas price configuration: 'ether'")
+}
+
+func TestApplyGasPriceFixed(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		Strategy:   GasPriceStrategyFixed,
+		FixedPrice: "1000",
+	})
+	rpc := &gasPriceRPC{}
+	msg := &messages.TransactionCommon{}
+	err := gp.applyGasPrice(context.Background(), rpc, msg)
+	assert.NoError(err)
+	assert.Equal("1000", msg.GasPrice.String())
+	assert.Empty(rpc.calls)
+}
+
+func TestApplyGasPriceNotFixedWithoutConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{})
+	msg := &messages.TransactionCommon{}
+	msg.GasPriceStrategy = GasPriceStrategyFixed
+	err := gp.applyGasPrice(context.Background(), &gasPriceRPC{}, msg)
+	assert.EqualError(err, "Gas price strategy 'fixed' is not configured")
+
+	msg.GasPriceStrategy = GasPriceStrategyOracle
+	err = gp.applyGasPrice(context.Background(), &gasPriceRPC{}, msg)
+	assert.EqualError(err, "Gas price strategy 'oracle' is not configured")
+}
+
+func TestApplyGasPriceNoStrategy(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{})
+	rpc := &gasPriceRPC{}
+	msg := &messages.TransactionCommon{}
+	err := gp.applyGasPrice(context.Background(), rpc, msg)
+	assert.NoError(err)
+	assert.Empty(msg.GasPrice)
+	assert.Empty(rpc.calls)
+}
+
+func TestApplyGasPriceRequestOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		Strategy:   GasPriceStrategyFixed,
+		FixedPrice: "1000",
+	})
+	rpc := &gasPriceRPC{gasPrice: 500}
+	msg := &messages.TransactionCommon{}
+	msg.GasPriceStrategy = GasPriceStrategyNode
+	err := gp.applyGasPrice(context.Background(), rpc, msg)
+	assert.NoError(err)
+	assert.Equal("500", msg.GasPrice.String())
+
+	msg = &messages.TransactionCommon{}
+	msg.GasPriceStrategy = GasPriceStrategyNone
+	err = gp.applyGasPrice(context.Background(), rpc, msg)
+	assert.NoError(err)
+	assert.Empty(msg.GasPrice)
+
+	msg.GasPriceStrategy = "guess"
+	err = gp.applyGasPrice(context.Background(), rpc, msg)
+	assert.EqualError(err, "Unknown gas price strategy 'guess'")
+}
+
+func TestApplyGasPriceFeesSupplied(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		Strategy:   GasPriceStrategyFixed,
+		FixedPrice: "1000",
+	})
+
+	msg := &messages.TransactionCommon{}
+	msg.GasPrice = "20"
+	assert.NoError(gp.applyGasPrice(context.Background(), &gasPriceRPC{}, msg))
+	assert.Equal("20", msg.GasPrice.String())
+
+	msg = &messages.TransactionCommon{}
+	msg.MaxFeePerGas = "20"
+	assert.NoError(gp.applyGasPrice(context.Background(), &gasPriceRPC{}, msg))
+	assert.Empty(msg.GasPrice)
+
+	msg = &messages.TransactionCommon{}
+	msg.TxType = "2"
+	assert.NoError(gp.applyGasPrice(context.Background(), &gasPriceRPC{}, msg))
+	assert.Empty(msg.GasPrice)
+}
+
+func TestGasPriceNodeMultiplierCapAndCache(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		Strategy:    GasPriceStrategyNode,
+		Multiplier:  1.5,
+		MaxGasPrice: "2000",
+	})
+	gp.blocks = newBlockListener(&NewHeadsConf{}, nil)
+	gp.blocks.subscribed = true
+	gp.blocks.headBlock = 10
+	rpc := &gasPriceRPC{gasPrice: 1000}
+
+	price, err := gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+	assert.NoError(err)
+	assert.Equal("1500", price.String())
+
+	// Cached for the same block
+	rpc.gasPrice = 2000
+	price, err = gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+	assert.NoError(err)
+	assert.Equal("1500", price.String())
+	assert.Equal([]string{"eth_gasPrice"}, rpc.calls)
+
+	// Refreshed, and capped, on a new block
+	gp.blocks.headBlock = 11
+	price, err = gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+	assert.NoError(err)
+	assert.Equal("2000", price.String())
+	assert.Equal([]string{"eth_gasPrice", "eth_gasPrice"}, rpc.calls)
+}
+
+func TestGasPriceCacheExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		CacheMS: 10,
+	})
+	rpc := &gasPriceRPC{gasPrice: 1000}
+
+	price, err := gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+	assert.NoError(err)
+	assert.Equal("1000", price.String())
+
+	// Cached while not subscribed to newHeads, until it expires
+	rpc.gasPrice = 2000
+	price, err = gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+	assert.NoError(err)
+	assert.Equal("1000", price.String())
+
+	time.Sleep(20 * time.Millisecond)
+	price, err = gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+	assert.NoError(err)
+	assert.Equal("2000", price.String())
+	assert.Equal([]string{"eth_gasPrice", "eth_gasPrice"}, rpc.calls)
+}
+
+func TestGasPriceFetchInProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{})
+	fetching := make(chan struct{})
+	gp.fetching[GasPriceStrategyNode] = fetching
+	rpc := &gasPriceRPC{gasPrice: 1000}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		price, err := gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+		assert.NoError(err)
+		assert.Equal("500", price.String())
+	}()
+
+	// Complete the fetch in progress, without holding the lock while waiting
+	time.Sleep(10 * time.Millisecond)
+	gp.mux.Lock()
+	gp.cache[GasPriceStrategyNode] = &cachedGasPrice{fetched: time.Now(), price: big.NewInt(500)}
+	delete(gp.fetching, GasPriceStrategyNode)
+	close(fetching)
+	gp.mux.Unlock()
+
+	<-done
+	assert.Empty(rpc.calls)
+}
+
+func TestGasPriceNodeErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{})
+	rpc := &gasPriceRPC{errs: map[string]error{"eth_gasPrice": fmt.Errorf("pop")}}
+	_, err := gp.gasPrice(context.Background(), rpc, GasPriceStrategyNode)
+	assert.EqualError(err, "eth_gasPrice returned: pop")
+	assert.Empty(gp.cache)
+}
+
+func TestGasPriceFeeHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		FeeHistory: GasFeeHistoryConf{
+			BlockCount: 3,
+			Percentile: 75,
+		},
+	})
+	rpc := &gasPriceRPC{
+		history: feeHistory{
+			BaseFeePerGas: hexBigInts(100, 110, 120, 130),
+			Reward: [][]ethbinding.HexBigInt{
+				hexBigInts(10),
+				hexBigInts(20),
+				{},
+				hexBigInts(30),
+			},
+		},
+	}
+	price, err := gp.gasPrice(context.Background(), rpc, GasPriceStrategyFeeHistory)
+	assert.NoError(err)
+	assert.Equal("150", price.String())
+	assert.Equal(ethbinding.HexUint64(3), rpc.args[0][0])
+	assert.Equal("latest", rpc.args[0][1])
+	assert.Equal([]float64{75}, rpc.args[0][2])
+}
+
+func TestGasPriceFeeHistoryErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	gp := newTestGasPricer(t, &GasPriceConf{})
+	_, err := gp.gasPrice(context.Background(), &gasPriceRPC{}, GasPriceStrategyFeeHistory)
+	assert.EqualError(err, "No fee history returned by the node")
+
+	rpc := &gasPriceRPC{errs: map[string]error{"eth_feeHistory": fmt.Errorf("pop")}}
+	_, err = gp.gasPrice(context.Background(), rpc, GasPriceStrategyFeeHistory)
+	assert.EqualError(err, "eth_feeHistory returned: pop")
+}
+
+func TestGasPriceOracle(t *testing.T) {
+	assert := assert.New(t)
+
+	body := `{"fast": 12.5}`
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal("GET", req.Method)
+		res.WriteHeader(200)
+		res.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		Strategy: GasPriceStrategyOracle,
+		Oracle: GasPriceOracleConf{
+			URL:      server.URL,
+			PropName: "fast",
+			Units:    "gwei",
+		},
+	})
+	price, err := gp.gasPrice(context.Background(), &gasPriceRPC{}, GasPriceStrategyOracle)
+	assert.NoError(err)
+	assert.Equal("12500000000", price.String())
+
+	body = `{"fast": "13"}`
+	delete(gp.cache, GasPriceStrategyOracle)
+	price, err = gp.gasPrice(context.Background(), &gasPriceRPC{}, GasPriceStrategyOracle)
+	assert.NoError(err)
+	assert.Equal("13000000000", price.String())
+
+	body = `{"slow": "13"}`
+	delete(gp.cache, GasPriceStrategyOracle)
+	_, err = gp.gasPrice(context.Background(), &gasPriceRPC{}, GasPriceStrategyOracle)
+	assert.EqualError(err, "Gas price oracle response does not contain a valid 'fast'")
+
+	body = `{"fast": "lots"}`
+	_, err = gp.gasPrice(context.Background(), &gasPriceRPC{}, GasPriceStrategyOracle)
+	assert.EqualError(err, "Gas price oracle response does not contain a valid 'fast'")
+}
+
+func TestGasPriceOracleFails(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(500)
+		res.Write([]byte(`{"errorMessage": "pop"}`))
+	}))
+	defer server.Close()
+
+	gp := newTestGasPricer(t, &GasPriceConf{
+		Oracle: GasPriceOracleConf{
+			URL: server.URL,
+		},
+	})
+	_, err := gp.gasPrice(context.Background(), &gasPriceRPC{}, GasPriceStrategyOracle)
+	assert.Regexp("pop", err)
+}
+
+func TestOnSendTransactionMessageGasPriceStrategy(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		GasPrice: GasPriceConf{
+			Strategy:   GasPriceStrategyFixed,
+			FixedPrice: "12345",
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	testRPC := goodMessageRPC()
+	assert.NoError(txnProcessor.Init(testRPC))
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+
+	assert.Equal("eth_sendTransaction", testRPC.calls[0])
+	sendTX := testRPC.params[0][0].(*eth.SendTXArgs)
+	assert.Equal("12345", sendTX.GasPrice.ToInt().String())
+}
+
+func TestOnSendTransactionMessageBadGasPriceStrategy(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = "{" +
+		"  \"headers\":{\"type\": \"SendTransaction\"}," +
+		"  \"from\":\"" + testFromAddr + "\"," +
+		"  \"gas\":\"123\"," +
+		"  \"gasPriceStrategy\":\"guess\"," +
+		"  \"method\":{\"name\":\"test\"}" +
+		"}"
+	testRPC := goodMessageRPC()
+	assert.NoError(txnProcessor.Init(testRPC))
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Equal(1, len(testTxnContext.errorReplies))
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.EqualError(testTxnContext.errorReplies[0].err, "Unknown gas price strategy 'guess'")
+	assert.Empty(testRPC.calls)
+	_, inMap := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)]
+	assert.False(inMap)
+}
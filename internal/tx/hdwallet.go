@@ -15,7 +15,6 @@
 package tx
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"math/big"
 	"net/url"
@@ -46,6 +45,8 @@ type HDWalletConf struct {
 	URLTemplate string                `json:"urlTemplate"`
 	ChainID     string                `json:"chainID"`
 	PropNames   HDWalletConfPropNames `json:"propNames"`
+	// Local derives the keys in this process, when no URLTemplate is configured
+	Local LocalHDWalletConf `json:"local"`
 }
 
 // HDWalletConfPropNames prop names for processing JSON responses
@@ -153,9 +154,12 @@ func (s *hdwalletSigner) Address() string {
 }
 
 func (s *hdwalletSigner) Sign(tx *ethbinding.Transaction) ([]byte, error) {
-	ethSigner := ethbind.API.NewEIP155Signer(s.chainID)
-	signedTX, _ := ethbind.API.SignTx(tx, ethSigner, s.key)
-	signedRLP := new(bytes.Buffer)
-	signedTX.EncodeRLP(signedRLP)
-	return signedRLP.Bytes(), nil
+	// The London signer handles both EIP-155 legacy and EIP-1559 dynamic-fee transactions
+	ethSigner := ethbind.API.NewLondonSigner(s.chainID)
+	signedTX, err := ethbind.API.SignTx(tx, ethSigner, s.key)
+	if err != nil {
+		return nil, errors.Errorf(errors.HDWalletSignTxFailed, err)
+	}
+	// Binary encoding gives the typed transaction envelope for non-legacy transactions
+	return signedTX.MarshalBinary()
 }
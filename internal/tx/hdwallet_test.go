@@ -86,6 +86,57 @@ func TestHDWalletSignOK(t *testing.T) {
 	assert.Equal(addr, sender)
 }
 
+func TestHDWalletSignDynamicFeeOK(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := ethbind.API.GenerateKey()
+	addr := ethbind.API.PubkeyToAddress(key.PublicKey)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(`
+    {
+      "address": "` + addr.String() + `",
+      "privateKey": "` + hex.EncodeToString(ethbind.API.FromECDSA(key)) + `"
+    }`))
+	}))
+	defer svr.Close()
+
+	hdr := IsHDWalletRequest("hd-testinst-testwallet-1234")
+	assert.NotNil(hdr)
+
+	hd := newHDWallet(&HDWalletConf{
+		URLTemplate: svr.URL,
+		ChainID:     "12345",
+	}).(*hdWallet)
+
+	s, err := hd.SignerFor(hdr)
+	assert.NoError(err)
+
+	tx := ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+		Nonce:     12345,
+		Value:     big.NewInt(0),
+		Gas:       21000,
+		GasFeeCap: big.NewInt(200),
+		GasTipCap: big.NewInt(2),
+		Data:      []byte("hello world"),
+	})
+
+	signed, err := s.Sign(tx)
+	assert.NoError(err)
+	assert.Equal(byte(ethbinding.DynamicFeeTxType), signed[0])
+
+	london := ethbind.API.NewLondonSigner(big.NewInt(12345))
+	tx2 := &ethbinding.Transaction{}
+	err = tx2.UnmarshalBinary(signed)
+	assert.NoError(err)
+	assert.Equal(uint8(ethbinding.DynamicFeeTxType), tx2.Type())
+	assert.Equal(int64(12345), tx2.ChainId().Int64())
+	sender, err := london.Sender(tx2)
+	assert.NoError(err)
+	assert.Equal(addr, sender)
+}
+
 func TestHDWalletSignerForRequestFail(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,320 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed" // for the BIP-39 word list
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/template"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	defaultHDWalletDerivationPath = "m/44'/60'/0'/0/{{.Index}}"
+	hardenedKeyStart              = uint32(0x80000000)
+)
+
+// secp256k1N is the order of the secp256k1 curve
+var secp256k1N, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+
+// bip39English is the BIP-39 English word list, one word per line in index order
+//
+//go:embed bip39_english.txt
+var bip39English string
+
+var bip39WordIndex = func() map[string]int64 {
+	words := strings.Fields(bip39English)
+	index := make(map[string]int64, len(words))
+	for i, word := range words {
+		index[word] = int64(i)
+	}
+	return index
+}()
+
+// LocalHDWalletConf configures deriving keys in this process with BIP-32, from a BIP-39 mnemonic
+// or a hex seed read from a file or environment variable. DerivationPath is a go template
+// such as "m/44'/60'/0'/0/{{.Index}}", and DerivationPaths overrides it for individual
+// wallets, keyed by "<instance>-<wallet>"
+type LocalHDWalletConf struct {
+	MnemonicFile    string            `json:"mnemonicFile,omitempty"`
+	MnemonicEnv     string            `json:"mnemonicEnv,omitempty"`
+	PassphraseFile  string            `json:"passphraseFile,omitempty"`
+	PassphraseEnv   string            `json:"passphraseEnv,omitempty"`
+	SeedFile        string            `json:"seedFile,omitempty"`
+	SeedEnv         string            `json:"seedEnv,omitempty"`
+	DerivationPath  string            `json:"derivationPath,omitempty"`
+	DerivationPaths map[string]string `json:"derivationPaths,omitempty"`
+}
+
+type localHDWallet struct {
+	conf    *HDWalletConf
+	chainID big.Int
+	master  *hdKey
+	path    *template.Template
+	paths   map[string]*template.Template
+	mux     sync.Mutex
+	signers map[string]*hdwalletSigner
+}
+
+// hdKey is an extended private key
+type hdKey struct {
+	key       *big.Int
+	chainCode []byte
+}
+
+func (c *LocalHDWalletConf) configured() bool {
+	return c.MnemonicFile != "" || c.MnemonicEnv != "" || c.SeedFile != "" || c.SeedEnv != ""
+}
+
+// newLocalHDWallet reads the mnemonic or seed, and computes the master key
+func newLocalHDWallet(conf *HDWalletConf) (HDWallet, error) {
+	localConf := &conf.Local
+	w := &localHDWallet{
+		conf:    conf,
+		paths:   make(map[string]*template.Template),
+		signers: make(map[string]*hdwalletSigner),
+	}
+	if _, ok := w.chainID.SetString(conf.ChainID, 0); !ok {
+		return nil, errors.Errorf(errors.HDWalletLocalBadChainID, conf.ChainID)
+	}
+	if localConf.DerivationPath == "" {
+		localConf.DerivationPath = defaultHDWalletDerivationPath
+	}
+	var err error
+	if w.path, err = parseDerivationPathTemplate(localConf.DerivationPath); err != nil {
+		return nil, err
+	}
+	for walletName, pathTemplate := range localConf.DerivationPaths {
+		if w.paths[strings.ToLower(walletName)], err = parseDerivationPathTemplate(pathTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	seed, err := localHDWalletSeed(localConf)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	i := mac.Sum(nil)
+	w.master = &hdKey{
+		key:       new(big.Int).SetBytes(i[:32]),
+		chainCode: i[32:],
+	}
+	if w.master.key.Sign() == 0 || w.master.key.Cmp(secp256k1N) >= 0 {
+		return nil, errors.Errorf(errors.HDWalletLocalBadSeed, "invalid master key")
+	}
+	log.Infof("Local HD Wallet initialized with derivation path %s", localConf.DerivationPath)
+	return w, nil
+}
+
+func parseDerivationPathTemplate(pathTemplate string) (*template.Template, error) {
+	t, err := template.New("derivationPath").Parse(pathTemplate)
+	if err != nil {
+		return nil, errors.Errorf(errors.HDWalletLocalBadTemplate, pathTemplate, err)
+	}
+	return t, nil
+}
+
+// localHDWalletSeed computes the BIP-39 seed from the mnemonic, or reads the seed directly
+func localHDWalletSeed(conf *LocalHDWalletConf) ([]byte, error) {
+	mnemonic, err := readHDWalletSecret("mnemonic", conf.MnemonicFile, conf.MnemonicEnv)
+	if err != nil {
+		return nil, err
+	}
+	if mnemonic != "" {
+		passphrase, err := readHDWalletSecret("passphrase", conf.PassphraseFile, conf.PassphraseEnv)
+		if err != nil {
+			return nil, err
+		}
+		// Words are separated by a single space. Only the (ASCII) English word list is
+		// supported, so the NFKD normalization of the BIP-39 spec has no effect
+		words := strings.Fields(mnemonic)
+		if err := validateMnemonic(words); err != nil {
+			return nil, err
+		}
+		mnemonic = strings.Join(words, " ")
+		return pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+	}
+	seedHex, err := readHDWalletSecret("seed", conf.SeedFile, conf.SeedEnv)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimPrefix(seedHex, "0x"))
+	if err != nil {
+		return nil, errors.Errorf(errors.HDWalletLocalBadSeed, err)
+	}
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.Errorf(errors.HDWalletLocalBadSeed, "seed must be between 16 and 64 bytes")
+	}
+	return seed, nil
+}
+
+// validateMnemonic checks the words are from the BIP-39 English word list, and that the
+// checksum in the final word matches the entropy. The words are not included in errors
+func validateMnemonic(words []string) error {
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return errors.Errorf(errors.HDWalletLocalBadMnemonic, "must have 12, 15, 18, 21 or 24 words")
+	}
+	bits := new(big.Int)
+	for i, word := range words {
+		index, ok := bip39WordIndex[word]
+		if !ok {
+			return errors.Errorf(errors.HDWalletLocalBadMnemonic, "word "+strconv.Itoa(i+1)+" is not in the BIP-39 English word list")
+		}
+		bits.Lsh(bits, 11).Or(bits, big.NewInt(index))
+	}
+	// Each 3 words hold 32 bits of entropy and 1 bit of checksum
+	checksumBits := uint(len(words) / 3)
+	checksum := new(big.Int).And(bits, big.NewInt(1<<checksumBits-1)).Uint64()
+	entropy := make([]byte, checksumBits*4)
+	new(big.Int).Rsh(bits, checksumBits).FillBytes(entropy)
+	hash := sha256.Sum256(entropy)
+	if uint64(hash[0]>>(8-checksumBits)) != checksum {
+		return errors.Errorf(errors.HDWalletLocalBadMnemonic, "checksum does not match")
+	}
+	return nil
+}
+
+// readHDWalletSecret reads a secret from a file, with surrounding whitespace removed,
+// or from an environment variable. Empty if neither is configured
+func readHDWalletSecret(name, file, env string) (string, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Errorf(errors.HDWalletLocalReadFailed, name, file, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	if env != "" {
+		secret, ok := os.LookupEnv(env)
+		if !ok {
+			return "", errors.Errorf(errors.HDWalletLocalEnvNotSet, name, env)
+		}
+		return strings.TrimSpace(secret), nil
+	}
+	return "", nil
+}
+
+func (w *localHDWallet) SignerFor(request *HDWalletRequest) (eth.TXSigner, error) {
+	pathTemplate, exists := w.paths[strings.ToLower(request.InstanceID+"-"+request.WalletID)]
+	if !exists {
+		pathTemplate = w.path
+	}
+	pathBuilder := &strings.Builder{}
+	if err := pathTemplate.Execute(pathBuilder, request); err != nil {
+		return nil, errors.Errorf(errors.HDWalletLocalBadPath, err)
+	}
+	path := pathBuilder.String()
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if signer, cached := w.signers[path]; cached {
+		return signer, nil
+	}
+	key, err := w.deriveKey(path)
+	if err != nil {
+		return nil, err
+	}
+	signer := &hdwalletSigner{
+		address: ethbind.API.PubkeyToAddress(key.PublicKey),
+		key:     key,
+		chainID: &w.chainID,
+	}
+	w.signers[path] = signer
+	log.Debugf("Derived HD Wallet key for %s: %s", path, signer.address.Hex())
+	return signer, nil
+}
+
+// deriveKey follows a path such as m/44'/60'/0'/0/1 from the master key
+func (w *localHDWallet) deriveKey(path string) (*ecdsa.PrivateKey, error) {
+	segments := strings.Split(path, "/")
+	if segments[0] != "m" {
+		return nil, errors.Errorf(errors.HDWalletLocalBadPath, path)
+	}
+	k := w.master
+	for _, segment := range segments[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(segment, "'") || strings.HasSuffix(segment, "h") || strings.HasSuffix(segment, "H") {
+			offset = hardenedKeyStart
+			segment = segment[:len(segment)-1]
+		}
+		index, err := strconv.ParseUint(segment, 10, 32)
+		if err != nil || uint32(index) >= hardenedKeyStart {
+			return nil, errors.Errorf(errors.HDWalletLocalBadPath, path)
+		}
+		if k = k.child(uint32(index) + offset); k == nil {
+			return nil, errors.Errorf(errors.HDWalletLocalDeriveFailed, path)
+		}
+	}
+	key, err := ethbind.API.HexToECDSA(k.keyHex())
+	if err != nil {
+		return nil, errors.Errorf(errors.HDWalletLocalDeriveFailed, path)
+	}
+	return key, nil
+}
+
+func (k *hdKey) keyHex() string {
+	return hex.EncodeToString(k.key.FillBytes(make([]byte, 32)))
+}
+
+// child derives the private child key at an index, with indexes from 2^31 being hardened.
+// Returns nil for the indexes that BIP-32 defines as invalid
+func (k *hdKey) child(index uint32) *hdKey {
+	var data []byte
+	if index >= hardenedKeyStart {
+		data = append([]byte{0}, k.key.FillBytes(make([]byte, 32))...)
+	} else {
+		key, err := ethbind.API.HexToECDSA(k.keyHex())
+		if err != nil {
+			return nil
+		}
+		// Compressed public key
+		data = append([]byte{byte(2 + key.PublicKey.Y.Bit(0))}, key.PublicKey.X.FillBytes(make([]byte, 32))...)
+	}
+	data = append(data, byte(index>>24), byte(index>>16), byte(index>>8), byte(index))
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	i := mac.Sum(nil)
+	il := new(big.Int).SetBytes(i[:32])
+	if il.Cmp(secp256k1N) >= 0 {
+		return nil
+	}
+	childKey := il.Add(il, k.key)
+	childKey.Mod(childKey, secp256k1N)
+	if childKey.Sign() == 0 {
+		return nil
+	}
+	return &hdKey{
+		key:       childKey,
+		chainCode: i[32:],
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMnemonic = "test test test test test test test test test test test junk"
+
+func TestLocalHDWalletBIP32TestVector(t *testing.T) {
+	assert := assert.New(t)
+
+	// Test vector 1 from BIP-32
+	os.Setenv("TEST_HD_SEED", "000102030405060708090a0b0c0d0e0f")
+	defer os.Unsetenv("TEST_HD_SEED")
+	hd, err := newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{SeedEnv: "TEST_HD_SEED"},
+	})
+	assert.NoError(err)
+	w := hd.(*localHDWallet)
+
+	for path, expected := range map[string]string{
+		"m":                      "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35",
+		"m/0'":                   "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+		"m/0h/1":                 "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+		"m/0H/1/2'":              "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca",
+		"m/0'/1/2'/2":            "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4",
+		"m/0'/1/2'/2/1000000000": "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8",
+	} {
+		key, err := w.deriveKey(path)
+		assert.NoError(err)
+		assert.Equal(expected, hex.EncodeToString(ethbind.API.FromECDSA(key)), path)
+	}
+}
+
+func TestLocalHDWalletMnemonicSignOK(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "hdwallet")
+	defer os.RemoveAll(dir)
+	mnemonicFile := path.Join(dir, "mnemonic")
+	ioutil.WriteFile(mnemonicFile, []byte(testMnemonic+"\n"), 0600)
+
+	hd, err := newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{MnemonicFile: mnemonicFile},
+	})
+	assert.NoError(err)
+	w := hd.(*localHDWallet)
+	assert.Equal(defaultHDWalletDerivationPath, w.conf.Local.DerivationPath)
+
+	s, err := hd.SignerFor(IsHDWalletRequest("hd-testinst-testwallet-0"))
+	assert.NoError(err)
+	assert.Equal("HD Wallet", s.Type())
+	assert.Equal("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", s.Address())
+
+	s1, err := hd.SignerFor(IsHDWalletRequest("hd-otherinst-otherwallet-1"))
+	assert.NoError(err)
+	assert.Equal("0x70997970C51812dc3A010C7d01b50e0d17dc79C8", s1.Address())
+
+	// Cached
+	s2, err := hd.SignerFor(IsHDWalletRequest("hd-testinst-testwallet-1"))
+	assert.NoError(err)
+	assert.True(s1 == s2)
+	assert.Equal(2, len(w.signers))
+
+	tx := ethbind.API.NewContractCreation(12345, big.NewInt(0), 0, big.NewInt(0), []byte("hello world"))
+	signed, err := s.Sign(tx)
+	assert.NoError(err)
+
+	eip155 := ethbind.API.NewEIP155Signer(big.NewInt(12345))
+	tx2 := &ethbinding.Transaction{}
+	err = tx2.DecodeRLP(ethbind.API.NewStream(bytes.NewReader(signed), 0))
+	assert.NoError(err)
+	sender, err := eip155.Sender(tx2)
+	assert.NoError(err)
+	assert.Equal(s.Address(), sender.String())
+}
+
+func TestLocalHDWalletDerivationPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("TEST_HD_MNEMONIC", testMnemonic)
+	defer os.Unsetenv("TEST_HD_MNEMONIC")
+
+	hd, err := newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local: LocalHDWalletConf{
+			MnemonicEnv:    "TEST_HD_MNEMONIC",
+			DerivationPath: "m/44'/60'/{{.WalletID}}'/0/{{.Index}}",
+			DerivationPaths: map[string]string{
+				"TestInst-Main": "m/44'/60'/0'/0/{{.Index}}",
+			},
+		},
+	})
+	assert.NoError(err)
+
+	s, err := hd.SignerFor(IsHDWalletRequest("hd-testinst-main-0"))
+	assert.NoError(err)
+	assert.Equal("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", s.Address())
+
+	s, err = hd.SignerFor(IsHDWalletRequest("hd-testinst-1-0"))
+	assert.NoError(err)
+	assert.NotEqual("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", s.Address())
+
+	_, err = hd.SignerFor(IsHDWalletRequest("hd-testinst-notanumber-0"))
+	assert.EqualError(err, "Invalid HD Wallet derivation path 'm/44'/60'/notanumber'/0/0'")
+}
+
+func TestLocalHDWalletPassphrase(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("TEST_HD_MNEMONIC", testMnemonic)
+	defer os.Unsetenv("TEST_HD_MNEMONIC")
+	os.Setenv("TEST_HD_PASSPHRASE", "secret")
+	defer os.Unsetenv("TEST_HD_PASSPHRASE")
+
+	hd, err := newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local: LocalHDWalletConf{
+			MnemonicEnv:   "TEST_HD_MNEMONIC",
+			PassphraseEnv: "TEST_HD_PASSPHRASE",
+		},
+	})
+	assert.NoError(err)
+	s, err := hd.SignerFor(IsHDWalletRequest("hd-testinst-testwallet-0"))
+	assert.NoError(err)
+	assert.NotEqual("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", s.Address())
+
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local: LocalHDWalletConf{
+			MnemonicEnv:    "TEST_HD_MNEMONIC",
+			PassphraseFile: "/path/does/not/exist",
+		},
+	})
+	assert.Regexp("Failed to read HD Wallet passphrase from /path/does/not/exist", err)
+}
+
+func TestLocalHDWalletBIP39TestVector(t *testing.T) {
+	assert := assert.New(t)
+
+	// Test vector from the BIP-39 reference implementation, with passphrase "TREZOR"
+	os.Setenv("TEST_HD_MNEMONIC", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about")
+	defer os.Unsetenv("TEST_HD_MNEMONIC")
+	os.Setenv("TEST_HD_PASSPHRASE", "TREZOR")
+	defer os.Unsetenv("TEST_HD_PASSPHRASE")
+	seed, err := localHDWalletSeed(&LocalHDWalletConf{
+		MnemonicEnv:   "TEST_HD_MNEMONIC",
+		PassphraseEnv: "TEST_HD_PASSPHRASE",
+	})
+	assert.NoError(err)
+	assert.Equal("c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04", hex.EncodeToString(seed))
+}
+
+func TestValidateMnemonic(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, mnemonic := range []string{
+		testMnemonic,
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo when",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+		"hamster diagram private dutch cause delay private meat slide toddler razor book happy fancy gospel tennis maple dilemma loan word shrug inflict delay length",
+	} {
+		assert.NoError(validateMnemonic(strings.Fields(mnemonic)), mnemonic)
+	}
+
+	err := validateMnemonic(strings.Fields("abandon abandon abandon"))
+	assert.EqualError(err, "Invalid HD Wallet mnemonic: must have 12, 15, 18, 21 or 24 words")
+
+	err = validateMnemonic(strings.Fields("test test test test test test test test test test test junks"))
+	assert.EqualError(err, "Invalid HD Wallet mnemonic: word 12 is not in the BIP-39 English word list")
+
+	err = validateMnemonic(strings.Fields("Test test test test test test test test test test test junk"))
+	assert.EqualError(err, "Invalid HD Wallet mnemonic: word 1 is not in the BIP-39 English word list")
+
+	err = validateMnemonic(strings.Fields("test test test test test test test test test test test test"))
+	assert.EqualError(err, "Invalid HD Wallet mnemonic: checksum does not match")
+}
+
+func TestLocalHDWalletBadConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newLocalHDWallet(&HDWalletConf{
+		ChainID: "not a number",
+		Local:   LocalHDWalletConf{SeedEnv: "TEST_HD_SEED"},
+	})
+	assert.EqualError(err, "Invalid chain ID 'not a number' for HD Wallet signing")
+
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{MnemonicFile: "/path/does/not/exist"},
+	})
+	assert.Regexp("Failed to read HD Wallet mnemonic from /path/does/not/exist", err)
+
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{SeedEnv: "TEST_HD_SEED_UNSET"},
+	})
+	assert.EqualError(err, "No HD Wallet seed in environment variable TEST_HD_SEED_UNSET")
+
+	os.Setenv("TEST_HD_SEED", "!hex")
+	defer os.Unsetenv("TEST_HD_SEED")
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{SeedEnv: "TEST_HD_SEED"},
+	})
+	assert.Regexp("Invalid HD Wallet seed", err)
+
+	os.Setenv("TEST_HD_SEED", "0x0001020304")
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{SeedEnv: "TEST_HD_SEED"},
+	})
+	assert.EqualError(err, "Invalid HD Wallet seed: seed must be between 16 and 64 bytes")
+
+	os.Setenv("TEST_HD_MNEMONIC", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon")
+	defer os.Unsetenv("TEST_HD_MNEMONIC")
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{MnemonicEnv: "TEST_HD_MNEMONIC"},
+	})
+	assert.EqualError(err, "Invalid HD Wallet mnemonic: checksum does not match")
+
+	os.Setenv("TEST_HD_SEED", "000102030405060708090a0b0c0d0e0f")
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local:   LocalHDWalletConf{SeedEnv: "TEST_HD_SEED", DerivationPath: "m/{{"},
+	})
+	assert.Regexp("Invalid HD Wallet derivation path template 'm/{{'", err)
+
+	_, err = newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local: LocalHDWalletConf{
+			SeedEnv:         "TEST_HD_SEED",
+			DerivationPaths: map[string]string{"inst-wallet": "m/{{"},
+		},
+	})
+	assert.Regexp("Invalid HD Wallet derivation path template 'm/{{'", err)
+}
+
+func TestLocalHDWalletBadPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("TEST_HD_SEED", "000102030405060708090a0b0c0d0e0f")
+	defer os.Unsetenv("TEST_HD_SEED")
+	hd, err := newLocalHDWallet(&HDWalletConf{
+		ChainID: "12345",
+		Local: LocalHDWalletConf{
+			SeedEnv:        "TEST_HD_SEED",
+			DerivationPath: "m/{{.Missing}}",
+		},
+	})
+	assert.NoError(err)
+	w := hd.(*localHDWallet)
+
+	_, err = hd.SignerFor(IsHDWalletRequest("hd-testinst-testwallet-0"))
+	assert.Regexp("Invalid HD Wallet derivation path", err)
+
+	_, err = w.deriveKey("x/0")
+	assert.EqualError(err, "Invalid HD Wallet derivation path 'x/0'")
+
+	_, err = w.deriveKey("m/0/")
+	assert.EqualError(err, "Invalid HD Wallet derivation path 'm/0/'")
+
+	_, err = w.deriveKey("m/2147483648")
+	assert.EqualError(err, "Invalid HD Wallet derivation path 'm/2147483648'")
+
+	_, err = w.deriveKey("m/4294967296'")
+	assert.EqualError(err, "Invalid HD Wallet derivation path 'm/4294967296''")
+}
+
+func TestLocalHDWalletInvalidChild(t *testing.T) {
+	assert := assert.New(t)
+
+	k := &hdKey{key: new(big.Int).Set(secp256k1N), chainCode: make([]byte, 32)}
+	assert.Nil(k.child(0))
+}
+
+func TestLocalHDWalletResolveAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("TEST_HD_MNEMONIC", testMnemonic)
+	defer os.Unsetenv("TEST_HD_MNEMONIC")
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		HDWalletConf: HDWalletConf{
+			ChainID: "12345",
+			Local:   LocalHDWalletConf{MnemonicEnv: "TEST_HD_MNEMONIC"},
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.NoError(err)
+
+	from, err := txnProcessor.ResolveAddress("hd-testinst-testwallet-1")
+	assert.NoError(err)
+	assert.Equal("0x70997970C51812dc3A010C7d01b50e0d17dc79C8", from)
+}
+
+func TestLocalHDWalletInitFail(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		HDWalletConf: HDWalletConf{
+			ChainID: "12345",
+			Local:   LocalHDWalletConf{MnemonicEnv: "TEST_HD_MNEMONIC_UNSET"},
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.EqualError(err, "No HD Wallet mnemonic in environment variable TEST_HD_MNEMONIC_UNSET")
+}
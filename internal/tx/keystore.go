@@ -0,0 +1,176 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeystoreConf configures local signing with the keys in a directory of encrypted V3 keystore
+// files, which are all unlocked into memory at startup. The password for each file is read from
+// the file with the same name in passwordsDir, or else from passwordFile, or else from the
+// environment variable named by passwordEnv
+type KeystoreConf struct {
+	Path         string `json:"path"`
+	PasswordsDir string `json:"passwordsDir,omitempty"`
+	PasswordFile string `json:"passwordFile,omitempty"`
+	PasswordEnv  string `json:"passwordEnv,omitempty"`
+	ChainID      string `json:"chainID"`
+}
+
+// keystoreV3 is the part of the Web3 Secret Storage format read directly, so the
+// decrypted key can be checked against the address recorded in the file
+type keystoreV3 struct {
+	Address string `json:"address"`
+}
+
+type keystore struct {
+	conf    *KeystoreConf
+	chainID big.Int
+	signers map[string]*keystoreSigner
+}
+
+type keystoreSigner struct {
+	address ethbinding.Address
+	key     *ecdsa.PrivateKey
+	chainID *big.Int
+}
+
+// newKeystore unlocks every keystore file in the configured directory
+func newKeystore(conf *KeystoreConf) (*keystore, error) {
+	ks := &keystore{
+		conf:    conf,
+		signers: make(map[string]*keystoreSigner),
+	}
+	if _, ok := ks.chainID.SetString(conf.ChainID, 0); !ok {
+		return nil, errors.Errorf(errors.KeystoreBadChainID, conf.ChainID)
+	}
+
+	files, err := ioutil.ReadDir(conf.Path)
+	if err != nil {
+		return nil, errors.Errorf(errors.KeystoreReadDir, conf.Path, err)
+	}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		signer, err := ks.unlock(file.Name())
+		if err != nil {
+			return nil, err
+		}
+		ks.signers[strings.ToLower(signer.address.Hex())] = signer
+		log.Infof("Unlocked keystore file %s for address %s", file.Name(), signer.address.Hex())
+	}
+	return ks, nil
+}
+
+func (ks *keystore) unlock(fileName string) (*keystoreSigner, error) {
+	filePath := path.Join(ks.conf.Path, fileName)
+	keyJSON, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Errorf(errors.KeystoreReadFile, filePath, err)
+	}
+	password, err := ks.password(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var keyFile keystoreV3
+	if err = json.Unmarshal(keyJSON, &keyFile); err != nil {
+		return nil, errors.Errorf(errors.KeystoreUnlockFailed, filePath, errors.Errorf(errors.KeystoreBadFile, err))
+	}
+	key, address, err := ethbind.API.DecryptKey(keyJSON, password)
+	if err != nil {
+		return nil, errors.Errorf(errors.KeystoreUnlockFailed, filePath, err)
+	}
+	fileAddress := strings.ToLower(keyFile.Address)
+	if !strings.HasPrefix(fileAddress, "0x") {
+		fileAddress = "0x" + fileAddress
+	}
+	if fileAddress != strings.ToLower(address.Hex()) {
+		return nil, errors.Errorf(errors.KeystoreUnlockFailed, filePath, errors.Errorf(errors.KeystoreAddressMismatch, keyFile.Address, address.Hex()))
+	}
+	return &keystoreSigner{
+		address: address,
+		key:     key,
+		chainID: &ks.chainID,
+	}, nil
+}
+
+// password finds the password for a keystore file. Trailing new lines are removed from password files
+func (ks *keystore) password(fileName string) (string, error) {
+	passwordFile := ""
+	if ks.conf.PasswordsDir != "" {
+		if _, err := os.Stat(path.Join(ks.conf.PasswordsDir, fileName)); err == nil {
+			passwordFile = path.Join(ks.conf.PasswordsDir, fileName)
+		}
+	}
+	if passwordFile == "" {
+		passwordFile = ks.conf.PasswordFile
+	}
+	if passwordFile != "" {
+		password, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return "", errors.Errorf(errors.KeystoreReadFile, passwordFile, err)
+		}
+		return strings.TrimRight(string(password), "\r\n"), nil
+	}
+	if ks.conf.PasswordEnv != "" {
+		if password, ok := os.LookupEnv(ks.conf.PasswordEnv); ok {
+			return password, nil
+		}
+	}
+	return "", errors.Errorf(errors.KeystoreNoPassword, fileName)
+}
+
+// signerFor returns the signer for an address, or nil if there is no key for the address
+func (ks *keystore) signerFor(from string) eth.TXSigner {
+	from = strings.ToLower(from)
+	if !strings.HasPrefix(from, "0x") {
+		from = "0x" + from
+	}
+	if signer, exists := ks.signers[from]; exists {
+		return signer
+	}
+	return nil
+}
+
+func (s *keystoreSigner) Type() string {
+	return "Keystore"
+}
+
+func (s *keystoreSigner) Address() string {
+	return s.address.String()
+}
+
+func (s *keystoreSigner) Sign(tx *ethbinding.Transaction) ([]byte, error) {
+	ethSigner := ethbind.API.NewLondonSigner(s.chainID)
+	signedTX, err := ethbind.API.SignTx(tx, ethSigner, s.key)
+	if err != nil {
+		return nil, errors.Errorf(errors.KeystoreSignTxFailed, err)
+	}
+	return signedTX.MarshalBinary()
+}
@@ -0,0 +1,294 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKeystoreAddr     = "0x008AeEda4D805471dF9b2A5B0f38A0C3bCBA786b"
+	testKeystorePassword = "testpassword"
+
+	// Web3 Secret Storage test vector, with the pbkdf2 iterations reduced
+	testKeystorePBKDF2 = `{
+		"address": "008aeeda4d805471df9b2a5b0f38a0c3bcba786b",
+		"crypto": {
+			"cipher": "aes-128-ctr",
+			"cipherparams": {"iv": "6087dab2f9fdbbfaddc31a909735c1e6"},
+			"ciphertext": "25cbf65a0bf20f0158f56fd25b136839f1d60536dd8fa61eb83a5e27bd6c29f0",
+			"kdf": "pbkdf2",
+			"kdfparams": {
+				"c": 2,
+				"dklen": 32,
+				"prf": "hmac-sha256",
+				"salt": "ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19"
+			},
+			"mac": "00f92e591c1a17e587f4e66cbcd171f267f580317f639bd388f050c52f06eefb"
+		},
+		"id": "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+		"version": 3
+	}`
+
+	// Same key with scrypt, and the older "Crypto" property name
+	testKeystoreScrypt = `{
+		"address": "008aeeda4d805471df9b2a5b0f38a0c3bcba786b",
+		"Crypto": {
+			"cipher": "aes-128-ctr",
+			"cipherparams": {"iv": "6087dab2f9fdbbfaddc31a909735c1e6"},
+			"ciphertext": "24cf6a3ce4c9b3ea04bf829cbacd33adafe4212f491d4b9e7477f78b86714083",
+			"kdf": "scrypt",
+			"kdfparams": {
+				"dklen": 32,
+				"n": 2,
+				"r": 8,
+				"p": 1,
+				"salt": "ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19"
+			},
+			"mac": "6c87fb4fd39c3d67e6c3feef0521e6b2f2487e54d3582efccd103400e3b8597e"
+		},
+		"id": "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+		"version": 3
+	}`
+)
+
+func newTestKeystoreDir(t *testing.T, keyJSON string) string {
+	dir, _ := ioutil.TempDir("", "keystore")
+	err := ioutil.WriteFile(path.Join(dir, "key1.json"), []byte(keyJSON), 0600)
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestKeystorePasswordFileOK(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystorePBKDF2)
+	defer os.RemoveAll(dir)
+	os.Mkdir(path.Join(dir, "subdir"), 0700)
+	ioutil.WriteFile(path.Join(dir, ".hidden"), []byte("not a key"), 0600)
+	passwordFile := path.Join(dir, ".password")
+	ioutil.WriteFile(passwordFile, []byte(testKeystorePassword+"\n"), 0600)
+
+	ks, err := newKeystore(&KeystoreConf{
+		Path:         dir,
+		PasswordFile: passwordFile,
+		ChainID:      "12345",
+	})
+	assert.NoError(err)
+	assert.Equal(1, len(ks.signers))
+
+	s := ks.signerFor(testKeystoreAddr)
+	assert.NotNil(s)
+	assert.Equal("Keystore", s.Type())
+	assert.Equal(testKeystoreAddr, s.Address())
+	assert.NotNil(ks.signerFor("008aeeda4d805471df9b2a5b0f38a0c3bcba786b"))
+	assert.Nil(ks.signerFor(testFromAddr))
+
+	tx := ethbind.API.NewContractCreation(12345, big.NewInt(0), 0, big.NewInt(0), []byte("hello world"))
+	signed, err := s.Sign(tx)
+	assert.NoError(err)
+
+	eip155 := ethbind.API.NewEIP155Signer(big.NewInt(12345))
+	tx2 := &ethbinding.Transaction{}
+	err = tx2.DecodeRLP(ethbind.API.NewStream(bytes.NewReader(signed), 0))
+	assert.NoError(err)
+	sender, err := eip155.Sender(tx2)
+	assert.NoError(err)
+	assert.Equal(testKeystoreAddr, sender.Hex())
+}
+
+func TestKeystorePasswordsDirOK(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystoreScrypt)
+	defer os.RemoveAll(dir)
+	passwordsDir, _ := ioutil.TempDir("", "keystorepasswords")
+	defer os.RemoveAll(passwordsDir)
+	ioutil.WriteFile(path.Join(passwordsDir, "key1.json"), []byte(testKeystorePassword), 0600)
+
+	ks, err := newKeystore(&KeystoreConf{
+		Path:         dir,
+		ChainID:      "12345",
+		PasswordsDir: passwordsDir,
+		PasswordFile: path.Join(passwordsDir, "missing"),
+	})
+	assert.NoError(err)
+	assert.NotNil(ks.signerFor(testKeystoreAddr))
+}
+
+func TestKeystorePasswordEnvOK(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystorePBKDF2)
+	defer os.RemoveAll(dir)
+	os.Setenv("TEST_KEYSTORE_PASSWORD", testKeystorePassword)
+	defer os.Unsetenv("TEST_KEYSTORE_PASSWORD")
+
+	ks, err := newKeystore(&KeystoreConf{
+		Path:         dir,
+		ChainID:      "12345",
+		PasswordsDir: "/path/does/not/exist",
+		PasswordEnv:  "TEST_KEYSTORE_PASSWORD",
+	})
+	assert.NoError(err)
+	assert.NotNil(ks.signerFor(testKeystoreAddr))
+}
+
+func TestKeystoreNoPassword(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystorePBKDF2)
+	defer os.RemoveAll(dir)
+
+	_, err := newKeystore(&KeystoreConf{
+		Path:        dir,
+		ChainID:     "12345",
+		PasswordEnv: "TEST_KEYSTORE_PASSWORD_UNSET",
+	})
+	assert.EqualError(err, "No password configured for keystore file key1.json")
+}
+
+func TestKeystoreBadPasswordFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystorePBKDF2)
+	defer os.RemoveAll(dir)
+
+	_, err := newKeystore(&KeystoreConf{
+		Path:         dir,
+		ChainID:      "12345",
+		PasswordFile: path.Join(dir, "missing"),
+	})
+	assert.Regexp("Failed to read .*missing", err)
+}
+
+func TestKeystoreIncorrectPassword(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystoreScrypt)
+	defer os.RemoveAll(dir)
+	os.Setenv("TEST_KEYSTORE_PASSWORD", "wrong")
+	defer os.Unsetenv("TEST_KEYSTORE_PASSWORD")
+
+	_, err := newKeystore(&KeystoreConf{
+		Path:        dir,
+		ChainID:     "12345",
+		PasswordEnv: "TEST_KEYSTORE_PASSWORD",
+	})
+	assert.Regexp("Failed to unlock keystore file .*key1.json", err)
+}
+
+func TestKeystoreBadDir(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newKeystore(&KeystoreConf{
+		Path:    "/path/does/not/exist",
+		ChainID: "12345",
+	})
+	assert.Regexp("Failed to read keystore directory /path/does/not/exist", err)
+}
+
+func TestKeystoreBadJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, "!json")
+	defer os.RemoveAll(dir)
+	os.Setenv("TEST_KEYSTORE_PASSWORD", testKeystorePassword)
+	defer os.Unsetenv("TEST_KEYSTORE_PASSWORD")
+
+	_, err := newKeystore(&KeystoreConf{
+		Path:        dir,
+		ChainID:     "12345",
+		PasswordEnv: "TEST_KEYSTORE_PASSWORD",
+	})
+	assert.Regexp("Failed to unlock keystore file .*key1.json: Invalid keystore file", err)
+}
+
+func TestKeystoreAddressMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, strings.Replace(testKeystorePBKDF2, "008aeeda4d805471df9b2a5b0f38a0c3bcba786b", strings.TrimPrefix(strings.ToLower(testFromAddr), "0x"), 1))
+	defer os.RemoveAll(dir)
+	os.Setenv("TEST_KEYSTORE_PASSWORD", testKeystorePassword)
+	defer os.Unsetenv("TEST_KEYSTORE_PASSWORD")
+
+	_, err := newKeystore(&KeystoreConf{
+		Path:        dir,
+		PasswordEnv: "TEST_KEYSTORE_PASSWORD",
+		ChainID:     "12345",
+	})
+	assert.Regexp("Failed to unlock keystore file .*key1.json: Keystore file address .* does not match the decrypted key address "+testKeystoreAddr, err)
+}
+
+func TestKeystoreBadChainID(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newKeystore(&KeystoreConf{
+		Path:    "/path/does/not/exist",
+		ChainID: "not a number",
+	})
+	assert.EqualError(err, "Invalid chain ID 'not a number' for keystore signing")
+}
+
+func TestKeystoreInitAndResolveSigner(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystorePBKDF2)
+	defer os.RemoveAll(dir)
+	os.Setenv("TEST_KEYSTORE_PASSWORD", testKeystorePassword)
+	defer os.Unsetenv("TEST_KEYSTORE_PASSWORD")
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		KeystoreConf: KeystoreConf{
+			Path:        dir,
+			ChainID:     "12345",
+			PasswordEnv: "TEST_KEYSTORE_PASSWORD",
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.NoError(err)
+
+	signer, err := txnProcessor.resolveSigner(testKeystoreAddr)
+	assert.NoError(err)
+	assert.Equal(testKeystoreAddr, signer.Address())
+
+	signer, err = txnProcessor.resolveSigner(testFromAddr)
+	assert.NoError(err)
+	assert.Nil(signer)
+}
+
+func TestKeystoreInitFail(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		KeystoreConf: KeystoreConf{
+			Path:    "/path/does/not/exist",
+			ChainID: "12345",
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.Regexp("Failed to read keystore directory", err)
+}
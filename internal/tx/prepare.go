@@ -0,0 +1,67 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"strconv"
+	"strings"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+)
+
+// prepareTransaction replies with the unsigned transaction, and the hash to sign, for signing
+// by an external wallet. The nonce is chosen as if the transaction were sent now, but is not
+// reserved. The signed transaction is submitted and tracked with a SendRawTransaction message
+func (p *txnProcessor) prepareTransaction(txnContext TxnContext, msg *messages.TransactionCommon, to string, buildTX func(signer eth.TXSigner) (*eth.Txn, error)) {
+	ctx := txnContext.Context()
+	tx, rpc, err := p.buildUnsubmitted(ctx, msg, buildTX)
+	if err != nil {
+		txnContext.SendErrorReply(400, err)
+		return
+	}
+	prepared, err := tx.Prepare(ctx, rpc)
+	if err != nil {
+		txnContext.SendErrorReply(400, err)
+		return
+	}
+
+	etx := tx.EthTX
+	reply := &messages.TransactionPrepared{
+		From:                strings.ToLower(tx.From.Hex()),
+		To:                  strings.ToLower(to),
+		ChainIDStr:          prepared.ChainID.String(),
+		TxTypeStr:           strconv.FormatUint(uint64(etx.Type()), 10),
+		NonceStr:            strconv.FormatUint(etx.Nonce(), 10),
+		GasStr:              strconv.FormatUint(etx.Gas(), 10),
+		ValueStr:            etx.Value().String(),
+		Data:                ethbind.API.HexEncode(etx.Data()),
+		UnsignedTransaction: ethbind.API.HexEncode(prepared.Unsigned),
+		SigningHash:         prepared.SigningHash.Hex(),
+	}
+	reply.Headers.MsgType = messages.MsgTypeTransactionPrepared
+	if tx.GasEstimate > 0 {
+		reply.GasEstimateStr = strconv.FormatUint(tx.GasEstimate, 10)
+	}
+	if etx.Type() == ethbinding.DynamicFeeTxType {
+		reply.MaxFeePerGasStr = etx.GasFeeCap().String()
+		reply.MaxPriorityFeePerGasStr = etx.GasTipCap().String()
+	} else {
+		reply.GasPriceStr = etx.GasPrice().String()
+	}
+	txnContext.Reply(reply)
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+var prepareSendTxnJSON = "{" +
+	"  \"headers\":{\"type\": \"SendTransaction\"}," +
+	"  \"from\":\"" + testFromAddr + "\"," +
+	"  \"to\":\"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832\"," +
+	"  \"gasPrice\":\"1000\"," +
+	"  \"prepare\":true," +
+	"  \"method\":{\"name\":\"test\"}" +
+	"}"
+
+func TestOnSendTransactionMessagePrepare(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = prepareSendTxnJSON
+	testRPC := &testRPC{
+		ethGetTransactionCountResult: 12,
+		ethEstimateGasResult:         21000,
+		ethChainIDResult:             1337,
+	}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.errorReplies)
+	assert.Len(testTxnContext.replies, 1)
+	reply := testTxnContext.replies[0].(*messages.TransactionPrepared)
+	assert.Equal(messages.MsgTypeTransactionPrepared, reply.Headers.MsgType)
+	assert.Equal(strings.ToLower(testFromAddr), reply.From)
+	assert.Equal("0x2b8c0ecc76d0759a8f50b2e14a6881367d805832", reply.To)
+	assert.Equal("1337", reply.ChainIDStr)
+	assert.Equal("0", reply.TxTypeStr)
+	assert.Equal("12", reply.NonceStr)
+	assert.Equal("25200", reply.GasStr)
+	assert.Equal("21000", reply.GasEstimateStr)
+	assert.Equal("1000", reply.GasPriceStr)
+	assert.Empty(reply.MaxFeePerGasStr)
+	assert.Equal("0xf8a8fd6d", reply.Data)
+	assert.Regexp("^0x[0-9a-f]+$", reply.UnsignedTransaction)
+	assert.Regexp("^0x[0-9a-f]{64}$", reply.SigningHash)
+
+	assert.Equal([]string{"eth_getTransactionCount", "eth_estimateGas", "eth_chainId"}, testRPC.calls)
+	assert.Empty(txnProcessor.inflightTxns)
+}
+
+func TestOnSendTransactionMessagePrepareDynamicFee(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = "{" +
+		"  \"headers\":{\"type\": \"SendTransaction\"}," +
+		"  \"from\":\"" + testFromAddr + "\"," +
+		"  \"to\":\"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832\"," +
+		"  \"nonce\":\"5\"," +
+		"  \"gas\":\"50000\"," +
+		"  \"maxFeePerGas\":\"200\"," +
+		"  \"maxPriorityFeePerGas\":\"10\"," +
+		"  \"prepare\":true," +
+		"  \"method\":{\"name\":\"test\"}" +
+		"}"
+	testRPC := &testRPC{
+		ethChainIDResult: 1337,
+	}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.errorReplies)
+	reply := testTxnContext.replies[0].(*messages.TransactionPrepared)
+	assert.Equal("2", reply.TxTypeStr)
+	assert.Equal("5", reply.NonceStr)
+	assert.Equal("50000", reply.GasStr)
+	assert.Empty(reply.GasEstimateStr)
+	assert.Empty(reply.GasPriceStr)
+	assert.Equal("200", reply.MaxFeePerGasStr)
+	assert.Equal("10", reply.MaxPriorityFeePerGasStr)
+	assert.True(strings.HasPrefix(reply.UnsignedTransaction, "0x02"))
+
+	assert.Equal([]string{"eth_chainId"}, testRPC.calls)
+}
+
+func TestOnSendTransactionMessagePreparePrivate(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = "{" +
+		"  \"headers\":{\"type\": \"SendTransaction\"}," +
+		"  \"from\":\"" + testFromAddr + "\"," +
+		"  \"to\":\"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832\"," +
+		"  \"nonce\":\"5\"," +
+		"  \"gas\":\"50000\"," +
+		"  \"privateFor\":[\"member1\"]," +
+		"  \"prepare\":true," +
+		"  \"method\":{\"name\":\"test\"}" +
+		"}"
+	txnProcessor.Init(&testRPC{})
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.replies)
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.Regexp("Private transactions cannot be prepared", testTxnContext.errorReplies[0].err)
+}
+
+func TestOnSendTransactionMessagePrepareBadNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = strings.Replace(prepareSendTxnJSON, "\"prepare\":true", "\"prepare\":true, \"nonce\":\"1.5\"", 1)
+	txnProcessor.Init(&testRPC{})
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.replies)
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.Regexp("nonce", testTxnContext.errorReplies[0].err)
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// RateLimitConf caps the transactions submitted, globally and for each from address.
+// Requests over the limits wait for capacity for up to QueueTimeoutMS, and are then rejected.
+// Requests from transports that apply backpressure, such as Kafka, wait for as long as it takes
+type RateLimitConf struct {
+	MaxInFlight           int     `json:"maxInFlight"`
+	MaxInFlightPerAddress int     `json:"maxInFlightPerAddress"`
+	TPSPerAddress         float64 `json:"tpsPerAddress"`
+	QueueTimeoutMS        int     `json:"queueTimeoutMS"`
+}
+
+// RateLimitError is returned when a request is rejected by the submission rate limits
+type RateLimitError struct {
+	Err error
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Err.Error()
+}
+
+// IsRateLimited is true if the error was returned because a request exceeded the
+// submission rate limits, so the caller can retry later (HTTP 429)
+func IsRateLimited(err error) bool {
+	_, limited := err.(*RateLimitError)
+	return limited
+}
+
+type addrRateLimit struct {
+	inflight int
+	nextSlot time.Time
+}
+
+// rateLimiter tracks the transactions in-flight, and the next submission slot for each
+// address. The released channel is closed, and replaced, each time capacity is freed up -
+// waking all the waiters
+type rateLimiter struct {
+	conf     *RateLimitConf
+	mux      sync.Mutex
+	inflight int
+	byAddr   map[string]*addrRateLimit
+	released chan struct{}
+}
+
+func newRateLimiter(conf *RateLimitConf) *rateLimiter {
+	return &rateLimiter{
+		conf:     conf,
+		byAddr:   make(map[string]*addrRateLimit),
+		released: make(chan struct{}),
+	}
+}
+
+func (rl *rateLimiter) enabled() bool {
+	return rl.conf.MaxInFlight > 0 || rl.conf.MaxInFlightPerAddress > 0 || rl.conf.TPSPerAddress > 0
+}
+
+// checkCapacity returns an error describing the limit that has been reached, if any
+// * Caller holds the mutex *
+func (rl *rateLimiter) checkCapacity(from string) error {
+	if rl.conf.MaxInFlight > 0 && rl.inflight >= rl.conf.MaxInFlight {
+		return errors.Errorf(errors.TransactionSendRateLimitedInFlight, rl.conf.MaxInFlight)
+	}
+	if al, exists := rl.byAddr[from]; exists && rl.conf.MaxInFlightPerAddress > 0 && al.inflight >= rl.conf.MaxInFlightPerAddress {
+		return errors.Errorf(errors.TransactionSendRateLimitedAddrInFlight, from, rl.conf.MaxInFlightPerAddress)
+	}
+	return nil
+}
+
+// acquire waits until a transaction can be submitted from the address, and takes an in-flight
+// slot that must be released when the transaction completes.
+// When block is false, the request is rejected if it cannot be submitted before the queue timeout
+func (rl *rateLimiter) acquire(from string, block bool) error {
+	deadline := time.Now().Add(time.Duration(rl.conf.QueueTimeoutMS) * time.Millisecond)
+
+	rl.mux.Lock()
+	for {
+		limitErr := rl.checkCapacity(from)
+		if limitErr == nil {
+			break
+		}
+		released := rl.released
+		rl.mux.Unlock()
+		if block {
+			log.Debugf("Waiting to submit transaction from %s: %s", from, limitErr)
+			<-released
+		} else {
+			timeout := time.Until(deadline)
+			if timeout <= 0 {
+				log.Warnf("Rejected transaction from %s: %s", from, limitErr)
+				return &RateLimitError{Err: limitErr}
+			}
+			select {
+			case <-released:
+			case <-time.After(timeout):
+			}
+		}
+		rl.mux.Lock()
+	}
+
+	al, exists := rl.byAddr[from]
+	if !exists {
+		al = &addrRateLimit{}
+		rl.byAddr[from] = al
+	}
+	rl.inflight++
+	al.inflight++
+
+	// Reserve the next submission slot for the address, spaced according to the TPS limit
+	var wait time.Duration
+	if rl.conf.TPSPerAddress > 0 {
+		now := time.Now()
+		slot := al.nextSlot
+		if slot.Before(now) {
+			slot = now
+		}
+		if !block && slot.After(now) && slot.After(deadline) {
+			rl.releaseLocked(from)
+			rl.mux.Unlock()
+			limitErr := errors.Errorf(errors.TransactionSendRateLimitedTPS, from, rl.conf.TPSPerAddress)
+			log.Warnf("Rejected transaction from %s: %s", from, limitErr)
+			return &RateLimitError{Err: limitErr}
+		}
+		al.nextSlot = slot.Add(time.Duration(float64(time.Second) / rl.conf.TPSPerAddress))
+		wait = slot.Sub(now)
+	}
+	rl.mux.Unlock()
+
+	if wait > 0 {
+		log.Debugf("Delaying transaction from %s by %.2fs for the rate limit", from, wait.Seconds())
+		time.Sleep(wait)
+	}
+	return nil
+}
+
+// release frees up the in-flight slot taken by acquire
+func (rl *rateLimiter) release(from string) {
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+	rl.releaseLocked(from)
+}
+
+func (rl *rateLimiter) releaseLocked(from string) {
+	rl.inflight--
+	if al, exists := rl.byAddr[from]; exists {
+		al.inflight--
+		if al.inflight <= 0 && !al.nextSlot.After(time.Now()) {
+			delete(rl.byAddr, from)
+		}
+	}
+	close(rl.released)
+	rl.released = make(chan struct{})
+}
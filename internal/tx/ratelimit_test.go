@@ -0,0 +1,213 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBackpressureTxnContext struct {
+	*testTxnContext
+}
+
+func (c *testBackpressureTxnContext) Backpressure() bool {
+	return true
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(&RateLimitConf{QueueTimeoutMS: 1000})
+	assert.False(rl.enabled())
+	assert.False(IsRateLimited(fmt.Errorf("pop")))
+}
+
+func TestRateLimiterMaxInFlight(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(&RateLimitConf{MaxInFlight: 1})
+	assert.True(rl.enabled())
+
+	err := rl.acquire("0xaaaa", false)
+	assert.NoError(err)
+	err = rl.acquire("0xbbbb", false)
+	assert.True(IsRateLimited(err))
+	assert.Regexp("Too many transactions in-flight \\(limit 1\\)", err)
+
+	rl.release("0xaaaa")
+	err = rl.acquire("0xbbbb", false)
+	assert.NoError(err)
+	rl.release("0xbbbb")
+	assert.Equal(0, rl.inflight)
+	assert.Empty(rl.byAddr)
+}
+
+func TestRateLimiterMaxInFlightPerAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(&RateLimitConf{MaxInFlightPerAddress: 1})
+
+	err := rl.acquire("0xaaaa", false)
+	assert.NoError(err)
+	err = rl.acquire("0xaaaa", false)
+	assert.True(IsRateLimited(err))
+	assert.Regexp("Too many transactions in-flight for 0xaaaa \\(limit 1\\)", err)
+	err = rl.acquire("0xbbbb", false)
+	assert.NoError(err)
+	assert.Equal(2, rl.inflight)
+}
+
+func TestRateLimiterQueueUntilReleased(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(&RateLimitConf{MaxInFlightPerAddress: 1, QueueTimeoutMS: 60000})
+
+	err := rl.acquire("0xaaaa", false)
+	assert.NoError(err)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rl.release("0xaaaa")
+	}()
+	err = rl.acquire("0xaaaa", false)
+	assert.NoError(err)
+	assert.Equal(1, rl.byAddr["0xaaaa"].inflight)
+}
+
+func TestRateLimiterQueueTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(&RateLimitConf{MaxInFlight: 1, QueueTimeoutMS: 10})
+
+	err := rl.acquire("0xaaaa", false)
+	assert.NoError(err)
+	start := time.Now()
+	err = rl.acquire("0xbbbb", false)
+	assert.True(IsRateLimited(err))
+	assert.True(time.Since(start) >= 10*time.Millisecond)
+}
+
+func TestRateLimiterBlockUntilReleased(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(&RateLimitConf{MaxInFlight: 1})
+
+	err := rl.acquire("0xaaaa", true)
+	assert.NoError(err)
+	acquired := make(chan error)
+	go func() {
+		acquired <- rl.acquire("0xbbbb", true)
+	}()
+	select {
+	case <-acquired:
+		assert.Fail("acquired while at capacity")
+	case <-time.After(10 * time.Millisecond):
+	}
+	rl.release("0xaaaa")
+	assert.NoError(<-acquired)
+}
+
+func TestRateLimiterTPSPerAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(&RateLimitConf{TPSPerAddress: 20})
+
+	err := rl.acquire("0xaaaa", false)
+	assert.NoError(err)
+	err = rl.acquire("0xaaaa", false)
+	assert.True(IsRateLimited(err))
+	assert.Regexp("Transactions from 0xaaaa exceed the rate limit of 20 per second", err)
+	assert.Equal(1, rl.inflight)
+
+	// Other addresses are not affected, and we wait for the next slot when queuing
+	err = rl.acquire("0xbbbb", false)
+	assert.NoError(err)
+	rl.conf.QueueTimeoutMS = 60000
+	start := time.Now()
+	err = rl.acquire("0xaaaa", false)
+	assert.NoError(err)
+	err = rl.acquire("0xaaaa", true)
+	assert.NoError(err)
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(3, rl.byAddr["0xaaaa"].inflight)
+}
+
+func TestOnSendTransactionMessageRateLimited(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		RateLimit: RateLimitConf{
+			MaxInFlightPerAddress: 1,
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(goodMessageRPC())
+
+	from := strings.ToLower(testFromAddr)
+	txnProcessor.rateLimiter.acquire(from, false)
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.replies)
+	assert.Equal(1, len(testTxnContext.errorReplies))
+	assert.Equal(429, testTxnContext.errorReplies[0].status)
+	assert.True(IsRateLimited(testTxnContext.errorReplies[0].err))
+	assert.Regexp("Too many transactions in-flight for "+from, testTxnContext.errorReplies[0].err)
+	assert.Empty(txnProcessor.inflightTxns)
+}
+
+func TestOnSendTransactionMessageRateLimitBackpressure(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		RateLimit: RateLimitConf{
+			MaxInFlight: 1,
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(goodMessageRPC())
+
+	txnProcessor.rateLimiter.acquire("0xaaaa", false)
+
+	testTxnContext := &testBackpressureTxnContext{&testTxnContext{}}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	dispatched := make(chan bool)
+	go func() {
+		txnProcessor.OnMessage(testTxnContext)
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+		assert.Fail("dispatched while at capacity")
+	case <-time.After(10 * time.Millisecond):
+	}
+	txnProcessor.rateLimiter.release("0xaaaa")
+	<-dispatched
+
+	inflight := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0]
+	inflight.wg.Wait()
+
+	assert.Equal(1, len(testTxnContext.replies))
+	assert.Equal(messages.MsgTypeTransactionSuccess, testTxnContext.replies[0].ReplyHeaders().MsgType)
+	assert.Equal(0, txnProcessor.rateLimiter.inflight)
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReceiptPollIntervalMS = 100
+	defaultReceiptPollBatchSize  = 100
+)
+
+// ReceiptPollConf configures the central receipt poller. The receipt checks requested by all
+// in-flight transactions are sent to each node as batched JSON-RPC requests, at most once per
+// interval, unless a new block arrives sooner
+type ReceiptPollConf struct {
+	IntervalMS int `json:"intervalMS"`
+	BatchSize  int `json:"batchSize"`
+}
+
+// receiptPoller gathers the receipt checks of the in-flight transactions. Each transaction
+// still chooses when to check, based on the TxnDelayTracker, and waits for the next poll
+type receiptPoller struct {
+	conf     *ReceiptPollConf
+	mux      sync.Mutex
+	pending  []*receiptRequest
+	started  bool
+	wake     chan bool
+	newBlock chan bool
+}
+
+type receiptRequest struct {
+	ctx     context.Context
+	rpc     eth.RPCClient
+	tx      *eth.Txn
+	isMined bool
+	err     error
+	done    chan bool
+}
+
+func newReceiptPoller(conf *ReceiptPollConf) *receiptPoller {
+	if conf.IntervalMS <= 0 {
+		conf.IntervalMS = defaultReceiptPollIntervalMS
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultReceiptPollBatchSize
+	}
+	return &receiptPoller{
+		conf:     conf,
+		wake:     make(chan bool, 1),
+		newBlock: make(chan bool, 1),
+	}
+}
+
+// getReceipt queues a receipt check for the next poll, and waits for the result. The transaction
+// must not be changed by anything else until this returns. If the context is done before the poll
+// starts the check is removed from the queue, otherwise the poll in progress is waited for
+func (rp *receiptPoller) getReceipt(ctx context.Context, rpc eth.RPCClient, tx *eth.Txn) (bool, error) {
+	req := &receiptRequest{
+		ctx:  ctx,
+		rpc:  rpc,
+		tx:   tx,
+		done: make(chan bool),
+	}
+	rp.mux.Lock()
+	rp.pending = append(rp.pending, req)
+	if !rp.started {
+		rp.started = true
+		go rp.pollLoop()
+	}
+	rp.mux.Unlock()
+	select {
+	case rp.wake <- true:
+	default:
+	}
+	select {
+	case <-req.done:
+	case <-ctx.Done():
+		if rp.cancel(req) {
+			return false, errors.Errorf(errors.RPCCallReturnedError, "eth_getTransactionReceipt", ctx.Err())
+		}
+		<-req.done
+	}
+	return req.isMined, req.err
+}
+
+// cancel removes a request that has not yet been taken by a poll
+func (rp *receiptPoller) cancel(req *receiptRequest) bool {
+	rp.mux.Lock()
+	defer rp.mux.Unlock()
+	for i, pending := range rp.pending {
+		if pending == req {
+			rp.pending = append(rp.pending[:i], rp.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// blockAdded polls for the pending receipts straight away, rather than at the end of the interval
+func (rp *receiptPoller) blockAdded() {
+	select {
+	case rp.newBlock <- true:
+	default:
+	}
+}
+
+func (rp *receiptPoller) pollLoop() {
+	interval := time.Duration(rp.conf.IntervalMS) * time.Millisecond
+	var lastPoll time.Time
+	for {
+		<-rp.wake
+		if wait := interval - time.Now().Sub(lastPoll); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-rp.newBlock:
+			}
+		}
+		select {
+		case <-rp.newBlock:
+		default:
+		}
+		lastPoll = time.Now()
+		rp.pollPending()
+	}
+}
+
+// pollPending checks all the pending receipts, with the batches to each node sent in parallel
+func (rp *receiptPoller) pollPending() {
+	rp.mux.Lock()
+	pending := rp.pending
+	rp.pending = nil
+	rp.mux.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	var rpcs []eth.RPCClient
+	byRPC := make(map[eth.RPCClient][]*receiptRequest)
+	for _, req := range pending {
+		if _, exists := byRPC[req.rpc]; !exists {
+			rpcs = append(rpcs, req.rpc)
+		}
+		byRPC[req.rpc] = append(byRPC[req.rpc], req)
+	}
+	var wg sync.WaitGroup
+	for _, rpc := range rpcs {
+		wg.Add(1)
+		go func(rpc eth.RPCClient, reqs []*receiptRequest) {
+			defer wg.Done()
+			for len(reqs) > 0 {
+				batchSize := len(reqs)
+				if batchSize > rp.conf.BatchSize {
+					batchSize = rp.conf.BatchSize
+				}
+				rp.pollBatch(rpc, reqs[:batchSize])
+				reqs = reqs[batchSize:]
+			}
+		}(rpc, byRPC[rpc])
+	}
+	wg.Wait()
+}
+
+// pollBatch checks a set of receipts on one node. Each request is authorized with the context of the
+// transaction before the batch is sent. Private transactions, and nodes that do not support batches,
+// are checked individually
+func (rp *receiptPoller) pollBatch(rpc eth.RPCClient, reqs []*receiptRequest) {
+	batchRPC, canBatch := rpc.(eth.RPCClientBatch)
+	var batch []*receiptRequest
+	var batchTxns []*eth.Txn
+	for _, req := range reqs {
+		if !canBatch || req.tx.PrivacyGroupID != "" {
+			req.isMined, req.err = req.tx.GetTXReceipt(req.ctx, rpc)
+			close(req.done)
+		} else if err := auth.AuthRPC(req.ctx, "eth_getTransactionReceipt", req.tx.Hash); err != nil {
+			log.Errorf("JSON/RPC eth_getTransactionReceipt - not authorized: %s", err)
+			req.err = errors.Errorf(errors.RPCCallReturnedError, "eth_getTransactionReceipt", errors.Errorf(errors.Unauthorized))
+			close(req.done)
+		} else {
+			batch = append(batch, req)
+			batchTxns = append(batchTxns, req.tx)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	isMined, errs := eth.GetTXReceipts(auth.NewSystemAuthContext(), batchRPC, batchTxns)
+	for i, req := range batch {
+		req.isMined, req.err = isMined[i], errs[i]
+		close(req.done)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/auth/authtest"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+// testBatchRPC answers each call in a batch as the testRPC would, and records the hashes in each batch
+type testBatchRPC struct {
+	*testRPC
+	batches [][]string
+}
+
+func (r *testBatchRPC) BatchCallContext(ctx context.Context, b []ethbinding.BatchElem) error {
+	var hashes []string
+	for i := range b {
+		hashes = append(hashes, b[i].Args[0].(string))
+		b[i].Error = r.CallContext(ctx, b[i].Result, b[i].Method, b[i].Args...)
+	}
+	r.batches = append(r.batches, hashes)
+	return nil
+}
+
+func waitReceiptQueued(rp *receiptPoller) {
+	for {
+		rp.mux.Lock()
+		queued := len(rp.pending)
+		rp.mux.Unlock()
+		if queued > 0 {
+			return
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+func newTestReceiptRequest(rpc eth.RPCClient, tx *eth.Txn) *receiptRequest {
+	return &receiptRequest{
+		ctx:  context.Background(),
+		rpc:  rpc,
+		tx:   tx,
+		done: make(chan bool),
+	}
+}
+
+func TestReceiptPollerDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	rp := newReceiptPoller(&ReceiptPollConf{})
+	assert.Equal(defaultReceiptPollIntervalMS, rp.conf.IntervalMS)
+	assert.Equal(defaultReceiptPollBatchSize, rp.conf.BatchSize)
+
+	rp.pollPending()
+}
+
+func TestReceiptPollerBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	batchRPC := &testBatchRPC{testRPC: goodMessageRPC()}
+	otherBatchRPC := &testBatchRPC{testRPC: goodMessageRPC()}
+	otherBatchRPC.ethGetTransactionReceiptResult = eth.TxnReceipt{}
+	singleRPC := goodMessageRPC()
+
+	rp := newReceiptPoller(&ReceiptPollConf{BatchSize: 2})
+	reqs := []*receiptRequest{
+		newTestReceiptRequest(batchRPC, &eth.Txn{Hash: "0x1"}),
+		newTestReceiptRequest(batchRPC, &eth.Txn{Hash: "0x2"}),
+		newTestReceiptRequest(otherBatchRPC, &eth.Txn{Hash: "0x3"}),
+		newTestReceiptRequest(batchRPC, &eth.Txn{Hash: "0x4"}),
+		newTestReceiptRequest(batchRPC, &eth.Txn{Hash: "0x5", PrivacyGroupID: "group1", PrivateFrom: "me"}),
+		newTestReceiptRequest(singleRPC, &eth.Txn{Hash: "0x6"}),
+	}
+	rp.pending = reqs
+	rp.pollPending()
+
+	assert.Equal([][]string{{"0x1", "0x2"}, {"0x4"}}, batchRPC.batches)
+	assert.Equal([][]string{{"0x3"}}, otherBatchRPC.batches)
+	assert.Equal([]string{"eth_getTransactionReceipt", "eth_getTransactionReceipt", "eth_getTransactionReceipt", "priv_getTransactionReceipt", "eth_getTransactionReceipt"}, batchRPC.calls)
+	assert.Equal([]string{"eth_getTransactionReceipt"}, singleRPC.calls)
+	for i, req := range reqs {
+		<-req.done
+		assert.NoError(req.err)
+		assert.Equal(i != 2, req.isMined)
+	}
+	assert.Equal(batchRPC.ethGetTransactionReceiptResult.BlockHash, reqs[0].tx.Receipt.BlockHash)
+	assert.Empty(rp.pending)
+}
+
+func TestReceiptPollerUnauthorized(t *testing.T) {
+	assert := assert.New(t)
+
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+
+	batchRPC := &testBatchRPC{testRPC: goodMessageRPC()}
+	rp := newReceiptPoller(&ReceiptPollConf{})
+	unauthorized := newTestReceiptRequest(batchRPC, &eth.Txn{Hash: "0x1"})
+	authorized := newTestReceiptRequest(batchRPC, &eth.Txn{Hash: "0x2"})
+	authorized.ctx = auth.NewSystemAuthContext()
+	rp.pending = []*receiptRequest{unauthorized, authorized}
+	rp.pollPending()
+
+	<-unauthorized.done
+	assert.EqualError(unauthorized.err, "eth_getTransactionReceipt returned: Unauthorized")
+	<-authorized.done
+	assert.NoError(authorized.err)
+	assert.True(authorized.isMined)
+	assert.Equal([][]string{{"0x2"}}, batchRPC.batches)
+}
+
+func TestReceiptPollerGetReceiptNewBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	batchRPC := &testBatchRPC{testRPC: goodMessageRPC()}
+	rp := newReceiptPoller(&ReceiptPollConf{IntervalMS: 3600000})
+
+	// Polled straight away, as there has been no poll in the interval
+	isMined, err := rp.getReceipt(context.Background(), batchRPC, &eth.Txn{Hash: "0x1"})
+	assert.NoError(err)
+	assert.True(isMined)
+
+	// Waits for the new block
+	done := make(chan bool)
+	go func() {
+		isMined, err := rp.getReceipt(context.Background(), batchRPC, &eth.Txn{Hash: "0x2"})
+		assert.NoError(err)
+		assert.True(isMined)
+		close(done)
+	}()
+	waitReceiptQueued(rp)
+	rp.blockAdded()
+	<-done
+	assert.Equal([][]string{{"0x1"}, {"0x2"}}, batchRPC.batches)
+}
+
+func TestReceiptPollerGetReceiptContextDone(t *testing.T) {
+	assert := assert.New(t)
+
+	batchRPC := &testBatchRPC{testRPC: goodMessageRPC()}
+	rp := newReceiptPoller(&ReceiptPollConf{IntervalMS: 3600000})
+	rp.getReceipt(context.Background(), batchRPC, &eth.Txn{Hash: "0x1"})
+
+	// Removed from the queue, as the poll has not started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := rp.getReceipt(ctx, batchRPC, &eth.Txn{Hash: "0x2"})
+	assert.EqualError(err, "eth_getTransactionReceipt returned: context canceled")
+	rp.mux.Lock()
+	assert.Empty(rp.pending)
+	rp.mux.Unlock()
+
+	// Not removed once the poll has taken the request
+	req := newTestReceiptRequest(batchRPC, &eth.Txn{Hash: "0x3"})
+	assert.False(rp.cancel(req))
+	assert.Equal([][]string{{"0x1"}}, batchRPC.batches)
+}
+
+func TestPollReceiptReplacedWhilePolling(t *testing.T) {
+	assert := assert.New(t)
+
+	batchRPC := &testBatchRPC{testRPC: goodMessageRPC()}
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		ReceiptPoll: ReceiptPollConf{IntervalMS: 3600000},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(batchRPC)
+	txnProcessor.receiptPoller.getReceipt(context.Background(), batchRPC, &eth.Txn{Hash: "0x0"})
+
+	inflight := &inflightTxn{
+		txnContext: &testTxnContext{},
+		tx:         &eth.Txn{Hash: "0x1"},
+	}
+	type result struct {
+		isMined bool
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		isMined, err := txnProcessor.pollReceipt(inflight)
+		inflight.txLock.Unlock()
+		done <- result{isMined, err}
+	}()
+	waitReceiptQueued(txnProcessor.receiptPoller)
+
+	// The lock is not held while waiting for the poll, so the transaction can be replaced
+	inflight.txLock.Lock()
+	inflight.tx.SubmittedHashes = []string{"0x1", "0x2"}
+	inflight.tx.Hash = "0x2"
+	inflight.txLock.Unlock()
+
+	txnProcessor.receiptPoller.blockAdded()
+	r := <-done
+	assert.NoError(r.err)
+	assert.False(r.isMined)
+	assert.Equal("0x2", inflight.tx.Hash)
+	assert.Nil(inflight.tx.Receipt.BlockNumber)
+
+	// Checked on the next pass with the new hash
+	go func() {
+		isMined, err := txnProcessor.pollReceipt(inflight)
+		inflight.txLock.Unlock()
+		done <- result{isMined, err}
+	}()
+	waitReceiptQueued(txnProcessor.receiptPoller)
+	txnProcessor.receiptPoller.blockAdded()
+	r = <-done
+	assert.NoError(r.err)
+	assert.True(r.isMined)
+	assert.Equal("0x2", inflight.tx.Hash)
+	assert.NotNil(inflight.tx.Receipt.BlockNumber)
+	assert.Equal([][]string{{"0x0"}, {"0x1"}, {"0x2", "0x1"}}, batchRPC.batches)
+}
+
+func TestOnSendTransactionMessageBatchedReceipt(t *testing.T) {
+	assert := assert.New(t)
+
+	batchRPC := &testBatchRPC{testRPC: goodMessageRPC()}
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(batchRPC)
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+
+	assert.Equal(1, len(testTxnContext.replies))
+	assert.Equal(messages.MsgTypeTransactionSuccess, testTxnContext.replies[0].ReplyHeaders().MsgType)
+	assert.Equal([][]string{{batchRPC.ethSendTransactionResult}}, batchRPC.batches)
+}
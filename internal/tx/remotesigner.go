@@ -0,0 +1,176 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"strings"
+	"sync/atomic"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// RemoteSignerConf configures signing by JSON-RPC signing services that implement
+// eth_signTransaction, such as Web3Signer or EthSigner. Transactions from an address
+// routed to a signing service are signed by it, then submitted with eth_sendRawTransaction
+type RemoteSignerConf struct {
+	Endpoints []RemoteSignerEndpointConf `json:"endpoints"`
+}
+
+// RemoteSignerEndpointConf configures a signing service, and the from addresses routed to it.
+// One endpoint can be configured without addresses, to sign for any other address
+type RemoteSignerEndpointConf struct {
+	utils.HTTPRequesterConf
+	URL       string          `json:"url"`
+	TLS       utils.TLSConfig `json:"tls"`
+	Addresses []string        `json:"addresses,omitempty"`
+}
+
+type remoteSigners struct {
+	routes          map[string]*remoteSignerEndpoint
+	defaultEndpoint *remoteSignerEndpoint
+}
+
+type remoteSignerEndpoint struct {
+	url       string
+	hr        *utils.HTTPRequester
+	requestID int64
+}
+
+type remoteSigner struct {
+	endpoint *remoteSignerEndpoint
+	address  ethbinding.Address
+}
+
+// newRemoteSigners builds the routing table from address to signing service
+func newRemoteSigners(conf *RemoteSignerConf) (*remoteSigners, error) {
+	rs := &remoteSigners{
+		routes: make(map[string]*remoteSignerEndpoint),
+	}
+	for i := range conf.Endpoints {
+		endpointConf := &conf.Endpoints[i]
+		if endpointConf.URL == "" {
+			return nil, errors.Errorf(errors.RemoteSignerNoURL, i)
+		}
+		tlsConfig, err := utils.CreateTLSConfiguration(&endpointConf.TLS)
+		if err != nil {
+			return nil, errors.Errorf(errors.RemoteSignerTLSConfig, i, err)
+		}
+		endpoint := &remoteSignerEndpoint{
+			url: endpointConf.URL,
+			hr:  utils.NewHTTPRequesterWithTLS("Remote signer", &endpointConf.HTTPRequesterConf, tlsConfig),
+		}
+		if len(endpointConf.Addresses) == 0 {
+			if rs.defaultEndpoint != nil {
+				return nil, errors.Errorf(errors.RemoteSignerMultipleDefaults)
+			}
+			rs.defaultEndpoint = endpoint
+			log.Infof("Remote signer %d signing for all addresses not routed to another signer", i)
+			continue
+		}
+		for _, address := range endpointConf.Addresses {
+			if !ethbind.API.IsHexAddress(address) {
+				return nil, errors.Errorf(errors.RemoteSignerBadAddress, address, i)
+			}
+			rs.routes[strings.ToLower(ethbind.API.HexToAddress(address).Hex())] = endpoint
+		}
+		log.Infof("Remote signer %d signing for %d addresses", i, len(endpointConf.Addresses))
+	}
+	return rs, nil
+}
+
+// signerFor returns the signer for an address, or nil if the address is not routed to a signing service
+func (rs *remoteSigners) signerFor(from string) eth.TXSigner {
+	if !ethbind.API.IsHexAddress(from) {
+		return nil
+	}
+	address := ethbind.API.HexToAddress(from)
+	endpoint, exists := rs.routes[strings.ToLower(address.Hex())]
+	if !exists {
+		endpoint = rs.defaultEndpoint
+	}
+	if endpoint == nil {
+		return nil
+	}
+	return &remoteSigner{
+		endpoint: endpoint,
+		address:  address,
+	}
+}
+
+func (s *remoteSigner) Type() string {
+	return "Remote signer"
+}
+
+func (s *remoteSigner) Address() string {
+	return s.address.String()
+}
+
+// Sign passes the fields of the unsigned transaction to eth_signTransaction on the signing service
+func (s *remoteSigner) Sign(tx *ethbinding.Transaction) ([]byte, error) {
+	nonce := ethbinding.HexUint64(tx.Nonce())
+	gas := ethbinding.HexUint64(tx.Gas())
+	data := ethbinding.HexBytes(tx.Data())
+	txArgs := &eth.SendTXArgs{
+		Nonce: &nonce,
+		From:  s.address.Hex(),
+		Gas:   &gas,
+		Value: ethbinding.HexBigInt(*tx.Value()),
+		Data:  &data,
+	}
+	if tx.To() != nil {
+		txArgs.To = tx.To().Hex()
+	}
+	if tx.Type() == ethbinding.DynamicFeeTxType {
+		txArgs.MaxFeePerGas = (*ethbinding.HexBigInt)(tx.GasFeeCap())
+		txArgs.MaxPriorityFeePerGas = (*ethbinding.HexBigInt)(tx.GasTipCap())
+	} else {
+		txArgs.GasPrice = (*ethbinding.HexBigInt)(tx.GasPrice())
+	}
+
+	res, err := s.endpoint.hr.DoRequest("POST", s.endpoint.url, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      atomic.AddInt64(&s.endpoint.requestID, 1),
+		"method":  "eth_signTransaction",
+		"params":  []interface{}{txArgs},
+	})
+	if err != nil {
+		return nil, errors.Errorf(errors.RemoteSignerSignTxFailed, err)
+	}
+	if rpcErr, ok := res["error"].(map[string]interface{}); ok {
+		return nil, errors.Errorf(errors.RemoteSignerSignTxFailed, rpcErr["message"])
+	}
+	raw, ok := res["result"].(string)
+	if !ok {
+		// Geth style signers return the raw transaction within an object
+		if result, isObject := res["result"].(map[string]interface{}); isObject {
+			raw, ok = result["raw"].(string)
+		}
+	}
+	if !ok {
+		log.Errorf("Missing signed transaction in remote signer response: %+v", res)
+		return nil, errors.Errorf(errors.RemoteSignerBadResponse)
+	}
+	signed, err := ethbind.API.HexDecode(raw)
+	if err != nil || len(signed) == 0 {
+		log.Errorf("Bad signed transaction in remote signer response '%s': %v", raw, err)
+		return nil, errors.Errorf(errors.RemoteSignerBadResponse)
+	}
+	return signed, nil
+}
@@ -0,0 +1,373 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRemoteSignerAddr = "0xaA26b57fFe07AA2da34D4AC25Bbc0C23A8b8C3BA"
+
+type remoteSignerRequest struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      int64            `json:"id"`
+	Method  string           `json:"method"`
+	Params  []eth.SendTXArgs `json:"params"`
+}
+
+// newRemoteSignerStub returns a signing service that captures each request, and replies with the supplied body
+func newRemoteSignerStub(t *testing.T, status int, resBody string, requests *[]*remoteSignerRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "POST", req.Method)
+		var rpcReq remoteSignerRequest
+		err := json.NewDecoder(req.Body).Decode(&rpcReq)
+		assert.NoError(t, err)
+		*requests = append(*requests, &rpcReq)
+		res.WriteHeader(status)
+		res.Write([]byte(resBody))
+	}))
+}
+
+func TestRemoteSignerSignLegacyOK(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []*remoteSignerRequest
+	svr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal("secret", req.Header.Get("authorization"))
+		var rpcReq remoteSignerRequest
+		json.NewDecoder(req.Body).Decode(&rpcReq)
+		requests = append(requests, &rpcReq)
+		res.WriteHeader(200)
+		res.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xf86c0a85"}`))
+	}))
+	defer svr.Close()
+
+	rs, err := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{
+			{
+				HTTPRequesterConf: utils.HTTPRequesterConf{
+					Headers: map[string][]string{"authorization": {"secret"}},
+				},
+				URL:       svr.URL,
+				Addresses: []string{strings.ToLower(testRemoteSignerAddr)},
+			},
+		},
+	})
+	assert.NoError(err)
+
+	s := rs.signerFor(testRemoteSignerAddr)
+	assert.NotNil(s)
+	assert.Equal("Remote signer", s.Type())
+	assert.Equal(testRemoteSignerAddr, s.Address())
+
+	to := ethbind.API.HexToAddress(testFromAddr)
+	tx := ethbind.API.NewTransaction(10, to, big.NewInt(100), 21000, big.NewInt(2000), []byte("hello world"))
+	signed, err := s.Sign(tx)
+	assert.NoError(err)
+	assert.Equal("0xf86c0a85", ethbind.API.HexEncode(signed))
+
+	assert.Equal(1, len(requests))
+	assert.Equal("2.0", requests[0].JSONRPC)
+	assert.Equal(int64(1), requests[0].ID)
+	assert.Equal("eth_signTransaction", requests[0].Method)
+	txArgs := requests[0].Params[0]
+	assert.Equal(testRemoteSignerAddr, txArgs.From)
+	assert.Equal(testFromAddr, txArgs.To)
+	assert.Equal(uint64(10), uint64(*txArgs.Nonce))
+	assert.Equal(uint64(21000), uint64(*txArgs.Gas))
+	assert.Equal("2000", txArgs.GasPrice.ToInt().String())
+	assert.Equal("100", txArgs.Value.ToInt().String())
+	assert.Equal("hello world", string(*txArgs.Data))
+	assert.Nil(txArgs.MaxFeePerGas)
+	assert.Nil(txArgs.MaxPriorityFeePerGas)
+}
+
+func TestRemoteSignerSignDynamicFeeOK(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []*remoteSignerRequest
+	svr := newRemoteSignerStub(t, 200, `{"jsonrpc":"2.0","id":1,"result":{"raw":"0x02f8","tx":{}}}`, &requests)
+	defer svr.Close()
+
+	rs, err := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{{URL: svr.URL}},
+	})
+	assert.NoError(err)
+
+	tx := ethbind.API.NewTx(&ethbinding.DynamicFeeTx{
+		ChainID:   big.NewInt(12345),
+		Nonce:     5,
+		GasTipCap: big.NewInt(10),
+		GasFeeCap: big.NewInt(200),
+		Gas:       50000,
+		Value:     big.NewInt(0),
+		Data:      []byte("deploy"),
+	})
+	signed, err := rs.signerFor(testRemoteSignerAddr).Sign(tx)
+	assert.NoError(err)
+	assert.Equal("0x02f8", ethbind.API.HexEncode(signed))
+
+	txArgs := requests[0].Params[0]
+	assert.Empty(txArgs.To)
+	assert.Nil(txArgs.GasPrice)
+	assert.Equal("200", txArgs.MaxFeePerGas.ToInt().String())
+	assert.Equal("10", txArgs.MaxPriorityFeePerGas.ToInt().String())
+}
+
+func TestRemoteSignerSignRPCError(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []*remoteSignerRequest
+	svr := newRemoteSignerStub(t, 200, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"pop"}}`, &requests)
+	defer svr.Close()
+
+	rs, _ := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{{URL: svr.URL}},
+	})
+	tx := ethbind.API.NewContractCreation(0, big.NewInt(0), 0, big.NewInt(0), []byte{})
+	_, err := rs.signerFor(testRemoteSignerAddr).Sign(tx)
+	assert.EqualError(err, "Remote signer failed to sign transaction: pop")
+}
+
+func TestRemoteSignerSignHTTPError(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []*remoteSignerRequest
+	svr := newRemoteSignerStub(t, 500, `{"errorMessage":"pop"}`, &requests)
+	defer svr.Close()
+
+	rs, _ := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{{URL: svr.URL}},
+	})
+	tx := ethbind.API.NewContractCreation(0, big.NewInt(0), 0, big.NewInt(0), []byte{})
+	_, err := rs.signerFor(testRemoteSignerAddr).Sign(tx)
+	assert.EqualError(err, "Remote signer failed to sign transaction: Remote signer returned [500]: pop")
+}
+
+func TestRemoteSignerSignBadResponses(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, resBody := range []string{
+		`{"jsonrpc":"2.0","id":1}`,
+		`{"jsonrpc":"2.0","id":1,"result":{}}`,
+		`{"jsonrpc":"2.0","id":1,"result":"!hex"}`,
+		`{"jsonrpc":"2.0","id":1,"result":"0x"}`,
+	} {
+		var requests []*remoteSignerRequest
+		svr := newRemoteSignerStub(t, 200, resBody, &requests)
+
+		rs, _ := newRemoteSigners(&RemoteSignerConf{
+			Endpoints: []RemoteSignerEndpointConf{{URL: svr.URL}},
+		})
+		tx := ethbind.API.NewContractCreation(0, big.NewInt(0), 0, big.NewInt(0), []byte{})
+		_, err := rs.signerFor(testRemoteSignerAddr).Sign(tx)
+		assert.EqualError(err, "Unexpected response from remote signer", resBody)
+		svr.Close()
+	}
+}
+
+func TestRemoteSignerSignNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []*remoteSignerRequest
+	svr := newRemoteSignerStub(t, 404, "", &requests)
+	defer svr.Close()
+
+	rs, _ := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{{URL: svr.URL}},
+	})
+	tx := ethbind.API.NewContractCreation(0, big.NewInt(0), 0, big.NewInt(0), []byte{})
+	_, err := rs.signerFor(testRemoteSignerAddr).Sign(tx)
+	assert.EqualError(err, "Unexpected response from remote signer")
+}
+
+func TestRemoteSignerTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xf86c"}`))
+	}))
+	defer svr.Close()
+
+	rs, err := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{
+			{
+				URL: svr.URL,
+				TLS: utils.TLSConfig{
+					Enabled:            true,
+					InsecureSkipVerify: true,
+				},
+			},
+		},
+	})
+	assert.NoError(err)
+	tx := ethbind.API.NewContractCreation(0, big.NewInt(0), 0, big.NewInt(0), []byte{})
+	signed, err := rs.signerFor(testRemoteSignerAddr).Sign(tx)
+	assert.NoError(err)
+	assert.Equal("0xf86c", ethbind.API.HexEncode(signed))
+}
+
+func TestRemoteSignerRouting(t *testing.T) {
+	assert := assert.New(t)
+
+	rs, err := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{
+			{URL: "http://signer1", Addresses: []string{testRemoteSignerAddr}},
+			{URL: "http://signer2", Addresses: []string{strings.TrimPrefix(testFromAddr, "0x")}},
+		},
+	})
+	assert.NoError(err)
+
+	assert.Equal("http://signer1", rs.signerFor(strings.ToLower(testRemoteSignerAddr)).(*remoteSigner).endpoint.url)
+	assert.Equal("http://signer2", rs.signerFor(testFromAddr).(*remoteSigner).endpoint.url)
+	assert.Nil(rs.signerFor("0x0000000000000000000000000000000000000001"))
+	assert.Nil(rs.signerFor("hd-testinst-testwallet-1234"))
+
+	rs, err = newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{
+			{URL: "http://signer1", Addresses: []string{testRemoteSignerAddr}},
+			{URL: "http://default"},
+		},
+	})
+	assert.NoError(err)
+	assert.Equal("http://signer1", rs.signerFor(testRemoteSignerAddr).(*remoteSigner).endpoint.url)
+	assert.Equal("http://default", rs.signerFor(testFromAddr).(*remoteSigner).endpoint.url)
+}
+
+func TestRemoteSignerBadConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{{}},
+	})
+	assert.EqualError(err, "No URL configured for remote signer 0")
+
+	_, err = newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{
+			{URL: "http://signer1", Addresses: []string{"badness"}},
+		},
+	})
+	assert.EqualError(err, "Invalid address 'badness' configured for remote signer 0")
+
+	_, err = newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{
+			{URL: "http://signer1"},
+			{URL: "http://signer2"},
+		},
+	})
+	assert.EqualError(err, "Only one remote signer can be configured without addresses")
+
+	_, err = newRemoteSigners(&RemoteSignerConf{
+		Endpoints: []RemoteSignerEndpointConf{
+			{URL: "http://signer1", TLS: utils.TLSConfig{ClientCertsFile: "cert.pem"}},
+		},
+	})
+	assert.Regexp("Invalid TLS configuration for remote signer 0", err)
+}
+
+func TestRemoteSignerInitFail(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		RemoteSignerConf: RemoteSignerConf{
+			Endpoints: []RemoteSignerEndpointConf{{}},
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.EqualError(err, "No URL configured for remote signer 0")
+}
+
+func TestRemoteSignerResolveSignerKeystoreFirst(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := newTestKeystoreDir(t, testKeystorePBKDF2)
+	defer os.RemoveAll(dir)
+	passwordFile := path.Join(dir, ".password")
+	ioutil.WriteFile(passwordFile, []byte(testKeystorePassword), 0600)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		KeystoreConf: KeystoreConf{
+			Path:         dir,
+			PasswordFile: passwordFile,
+			ChainID:      "12345",
+		},
+		RemoteSignerConf: RemoteSignerConf{
+			Endpoints: []RemoteSignerEndpointConf{{URL: "http://default"}},
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.NoError(err)
+
+	signer, err := txnProcessor.resolveSigner(testKeystoreAddr)
+	assert.NoError(err)
+	assert.Equal("Keystore", signer.Type())
+
+	signer, err = txnProcessor.resolveSigner(testFromAddr)
+	assert.NoError(err)
+	assert.Equal("Remote signer", signer.Type())
+}
+
+func TestOnSendTransactionMessageRemoteSigner(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []*remoteSignerRequest
+	svr := newRemoteSignerStub(t, 200, `{"jsonrpc":"2.0","id":1,"result":"0xf86c0a85"}`, &requests)
+	defer svr.Close()
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		RemoteSignerConf: RemoteSignerConf{
+			Endpoints: []RemoteSignerEndpointConf{
+				{URL: svr.URL, Addresses: []string{testFromAddr}},
+			},
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+
+	testRPC := goodMessageRPC()
+	err := txnProcessor.Init(testRPC)
+	assert.NoError(err)
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(0, len(testTxnContext.errorReplies))
+	assert.Equal(1, len(testTxnContext.replies))
+
+	assert.Equal(1, len(requests))
+	assert.Equal(testFromAddr, requests[0].Params[0].From)
+	assert.Contains(testRPC.calls, "eth_sendRawTransaction")
+	assert.NotContains(testRPC.calls, "eth_sendTransaction")
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"sync"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/auth"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReorgWatchIntervalMS = 5000
+)
+
+// ReorgWatchConf configures re-checking of the receipts that have been delivered, until
+// the block containing the transaction is the configured number of blocks behind the head
+// of the chain. Disabled if blocks is zero
+type ReorgWatchConf struct {
+	Blocks     int `json:"blocks"`
+	IntervalMS int `json:"intervalMS"`
+}
+
+// reorgWatcher keeps the delivered receipts that are still within the window, and
+// periodically checks each against the canonical chain. The receipts are fetched in
+// batches, in the same way as the receipt poller
+type reorgWatcher struct {
+	conf          *ReorgWatchConf
+	hexValues     bool
+	rpc           eth.RPCClient
+	batchSize     int
+	newTxnContext RecoveredTxnContextFactory
+	mux           sync.Mutex
+	watched       []*watchedReceipt
+	closed        bool
+	closing       chan struct{}
+}
+
+type watchedReceipt struct {
+	inflightID   int
+	headers      messages.CommonHeaders
+	timeReceived time.Time
+	reply        messages.TransactionReceipt
+	tx           *eth.Txn
+	blockHash    *ethbinding.Hash // nil while the transaction is not in the chain
+	blockNumber  uint64
+}
+
+func newReorgWatcher(conf *ReorgWatchConf, hexValues bool) *reorgWatcher {
+	return &reorgWatcher{
+		conf:      conf,
+		hexValues: hexValues,
+		closing:   make(chan struct{}),
+	}
+}
+
+// WatchReorgs starts re-checking the delivered receipts, if enabled, using the supplied factory
+// to build the context that delivers a TransactionReorged notification for each one that changes
+func (p *txnProcessor) WatchReorgs(newTxnContext RecoveredTxnContextFactory) {
+	w := p.reorgs
+	if w.conf.Blocks <= 0 {
+		return
+	}
+	if w.conf.IntervalMS <= 0 {
+		w.conf.IntervalMS = defaultReorgWatchIntervalMS
+	}
+	w.mux.Lock()
+	w.rpc = p.rpc
+	w.batchSize = p.receiptPoller.conf.BatchSize
+	w.newTxnContext = newTxnContext
+	w.mux.Unlock()
+	log.Infof("Watching delivered receipts for reorgs for %d blocks, every %dms", w.conf.Blocks, w.conf.IntervalMS)
+	go w.watchLoop()
+}
+
+func (w *reorgWatcher) watchLoop() {
+	interval := time.Duration(w.conf.IntervalMS) * time.Millisecond
+	for {
+		select {
+		case <-time.After(interval):
+		case <-w.closing:
+			log.Debugf("Reorg watcher stopped")
+			return
+		}
+		w.checkReceipts()
+	}
+}
+
+// close stops the watch loop. Receipts that are still being watched are no longer checked
+func (w *reorgWatcher) close() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+	}
+}
+
+// watch adds a receipt that has just been delivered. The notifications report
+// the time the receipt was delivered as the received time
+func (w *reorgWatcher) watch(inflight *inflightTxn, reply *messages.TransactionReceipt) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.newTxnContext == nil || reply.BlockHash == nil {
+		return
+	}
+	headers := inflight.txnContext.Headers()
+	if headers.ID == "" {
+		return
+	}
+	w.watched = append(w.watched, &watchedReceipt{
+		inflightID:   inflight.id,
+		headers:      *headers,
+		timeReceived: time.Now().UTC(),
+		reply:        *reply,
+		tx: &eth.Txn{
+			Hash:             reply.TransactionHash.String(),
+			OrionPrivateAPIS: inflight.tx.OrionPrivateAPIS,
+			PrivateFrom:      inflight.tx.PrivateFrom,
+			PrivacyGroupID:   inflight.tx.PrivacyGroupID,
+		},
+		blockHash:   reply.BlockHash,
+		blockNumber: inflight.tx.Receipt.BlockNumber.ToInt().Uint64(),
+	})
+}
+
+// checkReceipts checks each watched receipt against the chain, and stops watching those
+// that are far enough behind the head block. Receipts added while the check is
+// in progress are kept for the next check
+func (w *reorgWatcher) checkReceipts() {
+	w.mux.Lock()
+	watched := w.watched
+	w.watched = nil
+	w.mux.Unlock()
+	if len(watched) == 0 {
+		return
+	}
+
+	remaining := make([]*watchedReceipt, 0, len(watched))
+	headBlock, err := eth.GetBlockNumber(auth.NewSystemAuthContext(), w.rpc)
+	if err != nil {
+		log.Warnf("Failed to get head block to check %d receipts for reorgs: %s", len(watched), err)
+		remaining = watched
+	} else {
+		isMined, errs := w.getReceipts(watched)
+		for i, wr := range watched {
+			if errs[i] != nil {
+				log.Warnf("Failed to check receipt of in-flight %d for reorgs: %s", wr.inflightID, errs[i])
+			} else {
+				w.checkReceipt(wr, isMined[i])
+			}
+			if headBlock < wr.blockNumber+uint64(w.conf.Blocks) {
+				remaining = append(remaining, wr)
+			} else {
+				log.Debugf("Stopped watching receipt of in-flight %d in block %d (head=%d)", wr.inflightID, wr.blockNumber, headBlock)
+			}
+		}
+	}
+
+	w.mux.Lock()
+	w.watched = append(remaining, w.watched...)
+	w.mux.Unlock()
+}
+
+// getReceipts gets the receipts of the watched transactions again. Public transactions are
+// fetched in batches, if the node supports them, and private transactions individually
+func (w *reorgWatcher) getReceipts(watched []*watchedReceipt) ([]bool, []error) {
+	ctx := auth.NewSystemAuthContext()
+	isMined := make([]bool, len(watched))
+	errs := make([]error, len(watched))
+	batchRPC, canBatch := w.rpc.(eth.RPCClientBatch)
+	var batch []int
+	var batchTxns []*eth.Txn
+	sendBatch := func() {
+		batchMined, batchErrs := eth.GetTXReceipts(ctx, batchRPC, batchTxns)
+		for j, i := range batch {
+			isMined[i], errs[i] = batchMined[j], batchErrs[j]
+		}
+		batch, batchTxns = nil, nil
+	}
+	for i, wr := range watched {
+		// The receipt is not updated by the node when the transaction is not found
+		wr.tx.Receipt = eth.TxnReceipt{}
+		if !canBatch || wr.tx.PrivacyGroupID != "" {
+			isMined[i], errs[i] = wr.tx.GetTXReceipt(ctx, w.rpc)
+			continue
+		}
+		batch = append(batch, i)
+		batchTxns = append(batchTxns, wr.tx)
+		if len(batch) >= w.batchSize {
+			sendBatch()
+		}
+	}
+	if len(batch) > 0 {
+		sendBatch()
+	}
+	return isMined, errs
+}
+
+// checkReceipt sends a notification if the transaction has moved to a different block,
+// or is no longer in the chain, since its receipt was delivered
+func (w *reorgWatcher) checkReceipt(wr *watchedReceipt, isMined bool) {
+	var blockHash *ethbinding.Hash
+	if isMined {
+		blockHash = wr.tx.Receipt.BlockHash
+	}
+	if (blockHash == nil && wr.blockHash == nil) ||
+		(blockHash != nil && wr.blockHash != nil && *blockHash == *wr.blockHash) {
+		return
+	}
+
+	var notification messages.TransactionReorged
+	notification.TransactionReceipt = wr.reply
+	notification.Headers = messages.ReplyHeaders{}
+	notification.Headers.MsgType = messages.MsgTypeTransactionReorged
+	notification.PreviousBlockHash = wr.blockHash
+	notification.PreviousBlockNumberStr = wr.reply.BlockNumberStr
+	if isMined {
+		setReceiptFields(&notification.TransactionReceipt, &wr.tx.Receipt, w.hexValues)
+		wr.blockNumber = wr.tx.Receipt.BlockNumber.ToInt().Uint64()
+		log.Warnf("Receipt of in-flight %d reorged. TX=%s moved to block %d (%s)", wr.inflightID, wr.tx.Hash, wr.blockNumber, blockHash.Hex())
+	} else {
+		notification.BlockHash = nil
+		notification.BlockNumberStr = ""
+		notification.BlockNumberHex = nil
+		notification.Logs = nil
+		notification.Removed = true
+		log.Warnf("Receipt of in-flight %d reorged. TX=%s removed from block %d", wr.inflightID, wr.tx.Hash, wr.blockNumber)
+	}
+	wr.reply = notification.TransactionReceipt
+	wr.blockHash = blockHash
+
+	w.newTxnContext(&wr.headers, wr.timeReceived).Reply(&notification)
+}
@@ -0,0 +1,253 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+// newReorgTestProcessor completes a transaction, so its receipt is watched
+func newReorgTestProcessor(t *testing.T, conf ReorgWatchConf) (*txnProcessor, *testRPC, *testTxnContext) {
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		ReorgWatch:    conf,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testRPC := goodMessageRPC()
+	txnProcessor.Init(testRPC)
+
+	notifyContext := &testTxnContext{}
+	txnProcessor.WatchReorgs(func(headers *messages.CommonHeaders, timeReceived time.Time) TxnContext {
+		assert.Equal(t, "req1", headers.ID)
+		return notifyContext
+	})
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSONWithID
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(t, 1, len(testTxnContext.replies))
+	return txnProcessor, testRPC, notifyContext
+}
+
+func TestReorgWatchDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, _, notifyContext := newReorgTestProcessor(t, ReorgWatchConf{})
+	assert.Nil(txnProcessor.reorgs.newTxnContext)
+	assert.Empty(txnProcessor.reorgs.watched)
+	assert.Empty(notifyContext.replies)
+}
+
+func TestReorgWatchNoRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		ReorgWatch:    ReorgWatchConf{Blocks: 5, IntervalMS: 3600000},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(goodMessageRPC())
+	txnProcessor.WatchReorgs(func(headers *messages.CommonHeaders, timeReceived time.Time) TxnContext {
+		return &testTxnContext{}
+	})
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(1, len(testTxnContext.replies))
+	assert.Empty(txnProcessor.reorgs.watched)
+}
+
+func TestReorgWatchBlockChanged(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, notifyContext := newReorgTestProcessor(t, ReorgWatchConf{Blocks: 5, IntervalMS: 3600000})
+	w := txnProcessor.reorgs
+	assert.Equal(1, len(w.watched))
+	prevBlockHash := *testRPC.ethGetTransactionReceiptResult.BlockHash
+
+	// Unchanged receipt
+	testRPC.ethBlockNumberResults = []uint64{12345}
+	w.checkReceipts()
+	assert.Empty(notifyContext.replies)
+	assert.Equal(1, len(w.watched))
+
+	// Mined in a different block
+	newBlockHash := ethbind.API.HexToHash("0x4b1e1aa5e2b3ba1e86d6e4b5bcc1ba7c0ae0fcb1d8e1dbd8e2c1b04e3cbd9f36")
+	newBlockNumber := ethbinding.HexBigInt(*big.NewInt(12346))
+	testRPC.ethGetTransactionReceiptResult.BlockHash = &newBlockHash
+	testRPC.ethGetTransactionReceiptResult.BlockNumber = &newBlockNumber
+	testRPC.ethBlockNumberResults = []uint64{12346}
+	w.checkReceipts()
+	assert.Equal(1, len(notifyContext.replies))
+	notification := notifyContext.replies[0].(*messages.TransactionReorged)
+	assert.Equal(messages.MsgTypeTransactionReorged, notification.Headers.MsgType)
+	assert.Equal(newBlockHash, *notification.BlockHash)
+	assert.Equal("12346", notification.BlockNumberStr)
+	assert.Equal(prevBlockHash, *notification.PreviousBlockHash)
+	assert.Equal("12345", notification.PreviousBlockNumberStr)
+	assert.False(notification.Removed)
+	assert.Equal("1", notification.StatusStr)
+	assert.Equal("0", notification.NonceStr)
+	assert.Equal("123", notification.GasStr)
+	assert.Equal(1, len(w.watched))
+
+	// No further change
+	w.checkReceipts()
+	assert.Equal(1, len(notifyContext.replies))
+	assert.Equal(1, len(w.watched))
+
+	// Deep enough in the chain from the new block
+	testRPC.ethBlockNumberResults = []uint64{12351}
+	w.checkReceipts()
+	assert.Equal(1, len(notifyContext.replies))
+	assert.Empty(w.watched)
+}
+
+func TestReorgWatchRemovedAndRemined(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, notifyContext := newReorgTestProcessor(t, ReorgWatchConf{Blocks: 5, IntervalMS: 3600000})
+	w := txnProcessor.reorgs
+	minedReceipt := testRPC.ethGetTransactionReceiptResult
+
+	testRPC.ethGetTransactionReceiptResult = eth.TxnReceipt{}
+	testRPC.ethBlockNumberResults = []uint64{12346}
+	w.checkReceipts()
+	assert.Equal(1, len(notifyContext.replies))
+	notification := notifyContext.replies[0].(*messages.TransactionReorged)
+	assert.True(notification.Removed)
+	assert.Nil(notification.BlockHash)
+	assert.Empty(notification.BlockNumberStr)
+	assert.Equal(*minedReceipt.BlockHash, *notification.PreviousBlockHash)
+	assert.Equal("12345", notification.PreviousBlockNumberStr)
+	assert.Equal(*minedReceipt.TransactionHash, *notification.TransactionHash)
+	assert.Equal(1, len(w.watched))
+
+	testRPC.ethGetTransactionReceiptResult = minedReceipt
+	w.checkReceipts()
+	assert.Equal(2, len(notifyContext.replies))
+	notification = notifyContext.replies[1].(*messages.TransactionReorged)
+	assert.False(notification.Removed)
+	assert.Equal(*minedReceipt.BlockHash, *notification.BlockHash)
+	assert.Equal("12345", notification.BlockNumberStr)
+	assert.Nil(notification.PreviousBlockHash)
+	assert.Empty(notification.PreviousBlockNumberStr)
+	assert.Equal(1, len(w.watched))
+}
+
+func TestReorgWatchBlockNumberFail(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, notifyContext := newReorgTestProcessor(t, ReorgWatchConf{Blocks: 5, IntervalMS: 3600000})
+	w := txnProcessor.reorgs
+
+	testRPC.ethBlockNumberResults = []uint64{12351}
+	testRPC.ethBlockNumberErr = fmt.Errorf("pop")
+	w.checkReceipts()
+	assert.Empty(notifyContext.replies)
+	assert.Equal(1, len(w.watched))
+	assert.Equal("eth_blockNumber", testRPC.calls[len(testRPC.calls)-1])
+}
+
+func TestReorgWatchReceiptFail(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, notifyContext := newReorgTestProcessor(t, ReorgWatchConf{Blocks: 5, IntervalMS: 3600000})
+	w := txnProcessor.reorgs
+
+	testRPC.ethBlockNumberResults = []uint64{12346}
+	testRPC.ethGetTransactionReceiptErr = fmt.Errorf("pop")
+	w.checkReceipts()
+	assert.Empty(notifyContext.replies)
+	assert.Equal(1, len(w.watched))
+}
+
+func TestReorgWatchLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, notifyContext := newReorgTestProcessor(t, ReorgWatchConf{Blocks: 5})
+	assert.Equal(defaultReorgWatchIntervalMS, txnProcessor.conf.ReorgWatch.IntervalMS)
+
+	// Removed, and then no longer watched as the head block is past the window
+	testRPC.ethGetTransactionReceiptResult = eth.TxnReceipt{}
+	testRPC.ethBlockNumberResults = []uint64{12350}
+	txnProcessor.conf.ReorgWatch.IntervalMS = 1
+	go txnProcessor.reorgs.watchLoop()
+	for len(notifyContext.replies) == 0 {
+		time.Sleep(1 * time.Millisecond)
+	}
+	assert.True(notifyContext.replies[0].(*messages.TransactionReorged).Removed)
+	assert.Equal(1, len(notifyContext.replies))
+	txnProcessor.Close()
+}
+
+func TestReorgWatchBatched(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, notifyContext := newReorgTestProcessor(t, ReorgWatchConf{Blocks: 5, IntervalMS: 3600000})
+	w := txnProcessor.reorgs
+	batchRPC := &testBatchRPC{testRPC: testRPC}
+	w.rpc = batchRPC
+	w.batchSize = 2
+	for i := 0; i < 2; i++ {
+		wr := *w.watched[0]
+		wr.tx = &eth.Txn{Hash: wr.tx.Hash}
+		w.watched = append(w.watched, &wr)
+	}
+	minedReceipt := testRPC.ethGetTransactionReceiptResult
+
+	testRPC.ethGetTransactionReceiptResult = eth.TxnReceipt{}
+	testRPC.ethBlockNumberResults = []uint64{12346}
+	w.checkReceipts()
+	assert.Equal(3, len(notifyContext.replies))
+	assert.True(notifyContext.replies[0].(*messages.TransactionReorged).Removed)
+	assert.Equal(2, len(batchRPC.batches))
+	assert.Equal(2, len(batchRPC.batches[0]))
+	assert.Equal(1, len(batchRPC.batches[1]))
+	assert.Equal(minedReceipt.TransactionHash.String(), batchRPC.batches[0][0])
+	assert.Equal(3, len(w.watched))
+}
+
+func TestReorgWatchClose(t *testing.T) {
+	txnProcessor, _, _ := newReorgTestProcessor(t, ReorgWatchConf{Blocks: 5, IntervalMS: 3600000})
+
+	stopped := make(chan struct{})
+	go func() {
+		txnProcessor.reorgs.watchLoop()
+		close(stopped)
+	}()
+	txnProcessor.Close()
+	txnProcessor.Close()
+	<-stopped
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"strings"
+
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// revertReasonModeCall replays failed transactions with eth_call at the parent block
+	revertReasonModeCall = "call"
+	// revertReasonModeTrace uses the callTracer of debug_traceTransaction, falling back to eth_call if the node does not support it
+	revertReasonModeTrace = "trace"
+)
+
+// RevertReasonConf configures finding the reason for transactions that are mined, but fail.
+// The reason is added to the TransactionFailure reply, and so stored with it in the receipt store.
+// Disabled unless the mode is set to "call" or "trace"
+type RevertReasonConf struct {
+	Mode string `json:"mode"`
+}
+
+func (conf *RevertReasonConf) enabled() bool {
+	mode := strings.ToLower(conf.Mode)
+	return mode == revertReasonModeCall || mode == revertReasonModeTrace
+}
+
+// addRevertReason replays or traces a failed transaction, and adds the reason to the reply.
+// Errors are logged rather than returned, as the failure reply must still be sent
+func (p *txnProcessor) addRevertReason(inflight *inflightTxn, reply *messages.TransactionReceipt) {
+	useTrace := strings.ToLower(p.conf.RevertReason.Mode) == revertReasonModeTrace
+	revert, revertData, err := inflight.tx.MinedRevertReason(inflight.txnContext.Context(), p.rpc, useTrace)
+	if err != nil {
+		log.Warnf("Unable to find the revert reason for %s: %s", inflight, err)
+		return
+	}
+	reply.RevertData = revertData
+	if revert != nil {
+		reply.RevertReason = revert.Error()
+		reply.Revert = revert.Details
+	}
+	log.Infof("Revert reason for %s: '%s'", inflight, reply.RevertReason)
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func runFailedSendTxn(t *testing.T, conf *TxnProcessorConf, testRPC *testRPC) *messages.TransactionReceipt {
+	txnProcessor := NewTxnProcessor(conf, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	failStatus := ethbinding.HexBigInt(*big.NewInt(0))
+	testRPC.ethGetTransactionReceiptResult.Status = &failStatus
+	txnProcessor.Init(testRPC)
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Len(t, testTxnContext.replies, 1)
+	return testTxnContext.replies[0].(*messages.TransactionReceipt)
+}
+
+func TestRevertReasonDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	testRPC.ethCallResult = testSimulateRevertData
+	reply := runFailedSendTxn(t, &TxnProcessorConf{MaxTXWaitTime: 1}, testRPC)
+
+	assert.Equal(messages.MsgTypeTransactionFailure, reply.Headers.MsgType)
+	assert.Equal("", reply.RevertReason)
+	assert.NotContains(testRPC.calls, "eth_call")
+}
+
+func TestRevertReasonCall(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	testRPC.ethCallResult = testSimulateRevertData
+	reply := runFailedSendTxn(t, &TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		RevertReason:  RevertReasonConf{Mode: "call"},
+	}, testRPC)
+
+	assert.Equal(messages.MsgTypeTransactionFailure, reply.Headers.MsgType)
+	assert.Equal("Muppetry detected", reply.RevertReason)
+	assert.Equal(testSimulateRevertData, reply.RevertData)
+	assert.Equal("Error", reply.Revert.Name)
+	assert.NotContains(testRPC.calls, "debug_traceTransaction")
+
+	// Replayed at the parent of the block the transaction was mined in
+	for i, method := range testRPC.calls {
+		if method == "eth_call" {
+			assert.Equal("0x3038", testRPC.params[i][1])
+		}
+	}
+}
+
+func TestRevertReasonTrace(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	testRPC.debugTraceOutput = testSimulateRevertData
+	reply := runFailedSendTxn(t, &TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		RevertReason:  RevertReasonConf{Mode: "trace"},
+	}, testRPC)
+
+	assert.Equal("Muppetry detected", reply.RevertReason)
+	assert.Equal(testSimulateRevertData, reply.RevertData)
+	assert.Contains(testRPC.calls, "debug_traceTransaction")
+	assert.NotContains(testRPC.calls, "eth_call")
+}
+
+func TestRevertReasonTraceFallbackToCall(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	testRPC.debugTraceErr = fmt.Errorf("the method debug_traceTransaction does not exist")
+	testRPC.ethCallResult = testSimulateRevertData
+	reply := runFailedSendTxn(t, &TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		RevertReason:  RevertReasonConf{Mode: "trace"},
+	}, testRPC)
+
+	assert.Equal("Muppetry detected", reply.RevertReason)
+	assert.Contains(testRPC.calls, "debug_traceTransaction")
+	assert.Contains(testRPC.calls, "eth_call")
+}
+
+func TestRevertReasonCallFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	testRPC.ethCallErr = fmt.Errorf("pop")
+	reply := runFailedSendTxn(t, &TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		RevertReason:  RevertReasonConf{Mode: "call"},
+	}, testRPC)
+
+	assert.Equal(messages.MsgTypeTransactionFailure, reply.Headers.MsgType)
+	assert.Equal("", reply.RevertReason)
+	assert.Nil(reply.Revert)
+}
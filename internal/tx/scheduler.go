@@ -0,0 +1,481 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scheduleKeyPrefix          = "scheduled/"
+	defaultSchedulerIntervalMS = 1000
+)
+
+// SchedulerConf configures holding transactions with a notBefore time or block number
+// until they are due. Disabled if no DB is configured
+type SchedulerConf struct {
+	LevelDBPath string `json:"levelDB,omitempty"`
+	IntervalMS  int    `json:"intervalMS"`
+}
+
+// scheduledEntry is the persisted record of a message held by the scheduler. The message
+// is stored without the notBefore fields, so it is processed normally when released.
+// Submitting is set just before the released transaction is sent
+type scheduledEntry struct {
+	Headers        messages.CommonHeaders `json:"headers"`
+	From           string                 `json:"from"`
+	Scheduled      time.Time              `json:"scheduled"`
+	NotBefore      *time.Time             `json:"notBefore,omitempty"`
+	NotBeforeBlock *uint64                `json:"notBeforeBlock,omitempty"`
+	Submitting     bool                   `json:"submitting,omitempty"`
+	Msg            json.RawMessage        `json:"msg"`
+}
+
+// scheduler holds messages in a DB until they are due, so the schedule survives a restart.
+// Once the transport supplies the factory for the contexts that deliver their replies, due
+// messages are periodically released to the txn processor
+type scheduler struct {
+	conf          *SchedulerConf
+	db            kvstore.KVStore
+	mux           sync.Mutex
+	entries       map[string]*scheduledEntry
+	submitting    []*scheduledEntry
+	newTxnContext RecoveredTxnContextFactory
+}
+
+// scheduledTxnContext is the context for a message released by the scheduler, as the context
+// of the original request has already been replied to. Replies are delivered through a
+// context built by the transport
+type scheduledTxnContext struct {
+	scheduler  *scheduler
+	entry      *scheduledEntry
+	headers    *messages.CommonHeaders
+	replyTo    TxnContext
+	forgetOnce sync.Once
+}
+
+func newScheduler(conf *SchedulerConf, db kvstore.KVStore) *scheduler {
+	s := &scheduler{
+		conf:    conf,
+		db:      db,
+		entries: make(map[string]*scheduledEntry),
+	}
+	s.recover()
+	return s
+}
+
+// recover loads the messages that were scheduled before a restart. Those that were being
+// submitted are not released again, as they might already have been sent
+func (s *scheduler) recover() {
+	it := s.db.NewIterator()
+	defer it.Release()
+	for it.Next() {
+		if !strings.HasPrefix(it.Key(), scheduleKeyPrefix) {
+			continue
+		}
+		var entry scheduledEntry
+		if err := json.Unmarshal(it.Value(), &entry); err != nil {
+			log.Errorf("Failed to recover scheduled transaction '%s': %s", it.Key(), err)
+			continue
+		}
+		if entry.Submitting {
+			s.submitting = append(s.submitting, &entry)
+		} else {
+			s.entries[entry.Headers.ID] = &entry
+		}
+	}
+	if len(s.entries) > 0 {
+		log.Infof("Recovered %d scheduled transactions", len(s.entries))
+	}
+}
+
+func (s *scheduler) add(entry *scheduledEntry) error {
+	b, _ := json.Marshal(entry)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.db.Put(scheduleKeyPrefix+entry.Headers.ID, b); err != nil {
+		return errors.Errorf(errors.TransactionScheduleStore, err)
+	}
+	s.entries[entry.Headers.ID] = entry
+	return nil
+}
+
+// remove takes an entry out of the schedule, and deletes it from the DB
+func (s *scheduler) remove(id string) *scheduledEntry {
+	entry := s.take(id)
+	if entry != nil {
+		s.forget(id)
+	}
+	return entry
+}
+
+// take takes an entry out of the schedule, leaving it in the DB. Only one caller can take
+// an entry, so a message cannot be both released and cancelled
+func (s *scheduler) take(id string) *scheduledEntry {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entry, exists := s.entries[id]
+	if !exists {
+		return nil
+	}
+	delete(s.entries, id)
+	return entry
+}
+
+// markSubmitting records that a released entry is about to be sent
+func (s *scheduler) markSubmitting(entry *scheduledEntry) error {
+	entry.Submitting = true
+	b, _ := json.Marshal(entry)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.db.Put(scheduleKeyPrefix+entry.Headers.ID, b); err != nil {
+		return errors.Errorf(errors.TransactionScheduleStore, err)
+	}
+	return nil
+}
+
+// forget deletes an entry from the DB
+func (s *scheduler) forget(id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.db.Delete(scheduleKeyPrefix + id); err != nil {
+		log.Errorf("Failed to delete scheduled transaction %s: %s", id, err)
+	}
+}
+
+// due returns the entries that are due, in the order they were scheduled, so transactions
+// from the same sender that fall due together are submitted in the order they were requested
+func (s *scheduler) due(now time.Time, headBlock func() (uint64, error)) []*scheduledEntry {
+	s.mux.Lock()
+	var waitingForTime, waitingForBlock []*scheduledEntry
+	for _, entry := range s.entries {
+		if entry.NotBefore != nil && now.Before(*entry.NotBefore) {
+			continue
+		}
+		if entry.NotBeforeBlock != nil {
+			waitingForBlock = append(waitingForBlock, entry)
+		} else {
+			waitingForTime = append(waitingForTime, entry)
+		}
+	}
+	s.mux.Unlock()
+
+	due := waitingForTime
+	if len(waitingForBlock) > 0 {
+		if head, err := headBlock(); err != nil {
+			log.Warnf("Failed to get head block for %d scheduled transactions: %s", len(waitingForBlock), err)
+		} else {
+			for _, entry := range waitingForBlock {
+				if head >= *entry.NotBeforeBlock {
+					due = append(due, entry)
+				}
+			}
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Scheduled.Before(due[j].Scheduled)
+	})
+	return due
+}
+
+func (s *scheduler) list() []*messages.ScheduledTransaction {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entries := make([]*scheduledEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Scheduled.Before(entries[j].Scheduled)
+	})
+	statuses := make([]*messages.ScheduledTransaction, len(entries))
+	for i, entry := range entries {
+		statuses[i] = entry.status()
+	}
+	return statuses
+}
+
+func (s *scheduler) get(id string) *scheduledEntry {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.entries[id]
+}
+
+func (e *scheduledEntry) status() *messages.ScheduledTransaction {
+	status := &messages.ScheduledTransaction{
+		RequestID: e.Headers.ID,
+		Type:      e.Headers.MsgType,
+		From:      e.From,
+		Scheduled: e.Scheduled.UTC().Format(time.RFC3339Nano),
+	}
+	if e.NotBefore != nil {
+		status.NotBefore = e.NotBefore.UTC().Format(time.RFC3339Nano)
+	}
+	if e.NotBeforeBlock != nil {
+		status.NotBeforeBlock = strconv.FormatUint(*e.NotBeforeBlock, 10)
+	}
+	return status
+}
+
+// newScheduledEntry parses the notBefore fields of a message. Returns nil if the
+// message has neither field, or only a notBefore time that has already passed
+func newScheduledEntry(headers *messages.CommonHeaders, msg *messages.TransactionCommon) (*scheduledEntry, error) {
+	if msg.NotBefore == "" && msg.NotBeforeBlock == "" {
+		return nil, nil
+	}
+	entry := &scheduledEntry{
+		Headers:   *headers,
+		From:      msg.From,
+		Scheduled: time.Now().UTC(),
+	}
+	if msg.NotBefore != "" {
+		notBefore, err := time.Parse(time.RFC3339Nano, msg.NotBefore)
+		if err != nil {
+			return nil, errors.Errorf(errors.TransactionScheduleBadNotBefore, msg.NotBefore)
+		}
+		entry.NotBefore = &notBefore
+	}
+	if msg.NotBeforeBlock != "" {
+		notBeforeBlock, err := strconv.ParseUint(msg.NotBeforeBlock.String(), 10, 64)
+		if err != nil {
+			return nil, errors.Errorf(errors.TransactionScheduleBadNotBeforeBlock, msg.NotBeforeBlock)
+		}
+		entry.NotBeforeBlock = &notBeforeBlock
+	} else if entry.Scheduled.After(*entry.NotBefore) {
+		return nil, nil
+	}
+	if entry.Headers.ID == "" {
+		entry.Headers.ID = utils.UUIDv4()
+	}
+	return entry, nil
+}
+
+// schedule holds a message with a notBefore time or block number in the scheduler, replying
+// to the request to say it is scheduled. Returns false if the message should be processed now
+func (p *txnProcessor) schedule(txnContext TxnContext, common *messages.TransactionCommon, msg interface{}) bool {
+	entry, err := newScheduledEntry(txnContext.Headers(), common)
+	if err != nil {
+		txnContext.SendErrorReply(400, err)
+		return true
+	}
+	if entry == nil {
+		return false
+	}
+	if p.scheduler == nil {
+		txnContext.SendErrorReply(400, errors.Errorf(errors.TransactionScheduleNotEnabled))
+		return true
+	}
+
+	reply := &messages.TransactionScheduled{
+		NotBefore:      common.NotBefore,
+		NotBeforeBlock: common.NotBeforeBlock.String(),
+	}
+	reply.Headers.MsgType = messages.MsgTypeTransactionScheduled
+	common.NotBefore = ""
+	common.NotBeforeBlock = ""
+	entry.Msg, _ = json.Marshal(msg)
+	if err := p.scheduler.add(entry); err != nil {
+		txnContext.SendErrorReply(500, err)
+		return true
+	}
+	log.Infof("Scheduled %s notBefore=%s notBeforeBlock=%s", txnContext, reply.NotBefore, reply.NotBeforeBlock)
+	txnContext.Reply(reply)
+	return true
+}
+
+// ReleaseScheduled starts releasing scheduled transactions when they are due, if enabled,
+// using the supplied factory to build the context that delivers the replies for each one
+func (p *txnProcessor) ReleaseScheduled(newTxnContext RecoveredTxnContextFactory) {
+	s := p.scheduler
+	if s == nil {
+		return
+	}
+	if s.conf.IntervalMS <= 0 {
+		s.conf.IntervalMS = defaultSchedulerIntervalMS
+	}
+	s.mux.Lock()
+	s.newTxnContext = newTxnContext
+	submitting := s.submitting
+	s.submitting = nil
+	s.mux.Unlock()
+	for _, entry := range submitting {
+		log.Warnf("Scheduled %s/%s was being submitted before a restart", entry.Headers.MsgType, entry.Headers.ID)
+		s.newReleasedContext(entry).SendErrorReply(500, errors.Errorf(errors.TransactionScheduledOutcomeUnknown))
+	}
+	log.Infof("Releasing scheduled transactions when due, checking every %dms", s.conf.IntervalMS)
+	go p.releaseLoop()
+}
+
+func (p *txnProcessor) releaseLoop() {
+	interval := time.Duration(p.scheduler.conf.IntervalMS) * time.Millisecond
+	for {
+		time.Sleep(interval)
+		p.releaseDue()
+	}
+}
+
+// releaseDue dispatches each due message to the txn processor, as if it had just arrived.
+// Each message stays in the DB until its transaction is journaled or it is replied to, so
+// a message released just before a restart is released again rather than lost, unless
+// it was already being submitted
+func (p *txnProcessor) releaseDue() {
+	s := p.scheduler
+	due := s.due(time.Now().UTC(), p.headBlock)
+	for _, entry := range due {
+		if s.take(entry.Headers.ID) == nil {
+			continue
+		}
+		log.Infof("Releasing scheduled %s/%s", entry.Headers.MsgType, entry.Headers.ID)
+		p.OnMessage(s.newReleasedContext(entry))
+	}
+}
+
+// headBlock returns the latest block number from the newHeads subscription, if active
+func (p *txnProcessor) headBlock() (uint64, error) {
+	if head, ok := p.blocks.head(); ok {
+		return head, nil
+	}
+	return eth.GetBlockNumber(context.Background(), p.rpc)
+}
+
+// newReleasedContext returns nil if the transport has not yet supplied the context factory
+func (s *scheduler) newReleasedContext(entry *scheduledEntry) *scheduledTxnContext {
+	s.mux.Lock()
+	newTxnContext := s.newTxnContext
+	s.mux.Unlock()
+	if newTxnContext == nil {
+		return nil
+	}
+	headers := entry.Headers
+	return &scheduledTxnContext{
+		scheduler: s,
+		entry:     entry,
+		headers:   &headers,
+		replyTo:   newTxnContext(&headers, entry.Scheduled),
+	}
+}
+
+// ScheduledTransactions returns the transactions held by the scheduler, in the order they were scheduled
+func (p *txnProcessor) ScheduledTransactions() []*messages.ScheduledTransaction {
+	if p.scheduler == nil {
+		return []*messages.ScheduledTransaction{}
+	}
+	return p.scheduler.list()
+}
+
+// ScheduledTransaction returns a transaction held by the scheduler, by request ID
+func (p *txnProcessor) ScheduledTransaction(id string) (*messages.ScheduledTransaction, error) {
+	var entry *scheduledEntry
+	if p.scheduler != nil {
+		entry = p.scheduler.get(id)
+	}
+	if entry == nil {
+		return nil, errors.Errorf(errors.TransactionScheduledNotFound, id)
+	}
+	return entry.status(), nil
+}
+
+// CancelScheduled removes a transaction from the scheduler before it is due. The request
+// is replied to with an error, if the scheduler has been started
+func (p *txnProcessor) CancelScheduled(id string) (*messages.ScheduledTransaction, error) {
+	var entry *scheduledEntry
+	if p.scheduler != nil {
+		entry = p.scheduler.remove(id)
+	}
+	if entry == nil {
+		return nil, errors.Errorf(errors.TransactionScheduledNotFound, id)
+	}
+	log.Infof("Cancelled scheduled %s/%s", entry.Headers.MsgType, id)
+	if txnContext := p.scheduler.newReleasedContext(entry); txnContext != nil {
+		txnContext.SendErrorReply(410, errors.Errorf(errors.TransactionScheduledCancelled))
+	}
+	return entry.status(), nil
+}
+
+func (c *scheduledTxnContext) Context() context.Context {
+	return c.replyTo.Context()
+}
+
+func (c *scheduledTxnContext) Headers() *messages.CommonHeaders {
+	return c.headers
+}
+
+func (c *scheduledTxnContext) Unmarshal(msg interface{}) error {
+	return json.Unmarshal(c.entry.Msg, msg)
+}
+
+func (c *scheduledTxnContext) SendErrorReply(status int, err error) {
+	c.SendErrorReplyWithTX(status, err, "")
+}
+
+func (c *scheduledTxnContext) SendErrorReplyWithGapFill(status int, err error, gapFillTxHash string, gapFillSucceeded bool) {
+	log.Warnf("Failed to process scheduled %s: %s", c, err)
+	errMsg := messages.NewErrorReply(err, []byte(c.entry.Msg))
+	errMsg.GapFillTxHash = gapFillTxHash
+	var bGap = gapFillSucceeded
+	errMsg.GapFillSucceeded = &bGap
+	c.Reply(errMsg)
+}
+
+func (c *scheduledTxnContext) SendErrorReplyWithTX(status int, err error, txHash string) {
+	log.Warnf("Failed to process scheduled %s: %s", c, err)
+	errMsg := messages.NewErrorReply(err, []byte(c.entry.Msg))
+	errMsg.TXHash = txHash
+	c.Reply(errMsg)
+}
+
+func (c *scheduledTxnContext) Reply(replyMessage messages.ReplyWithHeaders) {
+	replyMessage.ReplyHeaders().Scheduled = true
+	c.replyTo.Reply(replyMessage)
+	c.handedOff()
+}
+
+// Backpressure is true, so a released message waits for capacity under the submission rate
+// limits, as there is no sender to retry it if it is rejected
+func (c *scheduledTxnContext) Backpressure() bool {
+	return true
+}
+
+// submitting records in the DB that the transaction is about to be sent, so it is not
+// sent again if there is a restart before it is journaled or replied to
+func (c *scheduledTxnContext) submitting() error {
+	return c.scheduler.markSubmitting(c.entry)
+}
+
+// handedOff deletes the message from the DB, once its transaction is journaled or it has been
+// replied to, as a restart can no longer lose it
+func (c *scheduledTxnContext) handedOff() {
+	c.forgetOnce.Do(func() {
+		c.scheduler.forget(c.entry.Headers.ID)
+	})
+}
+
+func (c *scheduledTxnContext) String() string {
+	return fmt.Sprintf("Scheduled[%s/%s]", c.entry.Headers.MsgType, c.entry.Headers.ID)
+}
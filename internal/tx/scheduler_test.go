@@ -0,0 +1,397 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestScheduledSendTxnJSON(field, value string) string {
+	return strings.Replace(goodSendTxnJSON, "{", "{\""+field+"\":\""+value+"\",", 1)
+}
+
+func newTestSchedulerProcessor(db kvstore.KVStore, rpc *testRPC) *txnProcessor {
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		Scheduler: SchedulerConf{
+			IntervalMS: 60000,
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(rpc)
+	txnProcessor.scheduler = newScheduler(&txnProcessor.conf.Scheduler, db)
+	return txnProcessor
+}
+
+// testReleasedContexts records the contexts built to deliver the replies for released messages
+type testReleasedContexts struct {
+	headers  []*messages.CommonHeaders
+	contexts []*testTxnContext
+}
+
+func (r *testReleasedContexts) newTxnContext(headers *messages.CommonHeaders, timeReceived time.Time) TxnContext {
+	txnContext := &testTxnContext{}
+	r.headers = append(r.headers, headers)
+	r.contexts = append(r.contexts, txnContext)
+	return txnContext
+}
+
+func TestScheduleNotBeforeTimeReleased(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	testRPC := goodMessageRPC()
+	txnProcessor := newTestSchedulerProcessor(db, testRPC)
+	notBefore := time.Now().UTC().Add(1 * time.Hour).Format(time.RFC3339)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBefore", notBefore)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.errorReplies)
+	reply := testTxnContext.replies[0].(*messages.TransactionScheduled)
+	assert.Equal(messages.MsgTypeTransactionScheduled, reply.Headers.MsgType)
+	assert.Equal(notBefore, reply.NotBefore)
+	assert.Empty(testRPC.calls)
+	assert.Len(db.KVS, 1)
+
+	scheduled := txnProcessor.ScheduledTransactions()
+	assert.Len(scheduled, 1)
+	assert.Equal(messages.MsgTypeSendTransaction, scheduled[0].Type)
+	assert.Equal(testFromAddr, scheduled[0].From)
+	assert.Equal(notBefore, scheduled[0].NotBefore)
+	id := scheduled[0].RequestID
+	status, err := txnProcessor.ScheduledTransaction(id)
+	assert.NoError(err)
+	assert.Equal(id, status.RequestID)
+
+	released := &testReleasedContexts{}
+	txnProcessor.ReleaseScheduled(released.newTxnContext)
+	txnProcessor.releaseDue()
+	assert.Empty(released.contexts)
+
+	past := time.Now().UTC().Add(-1 * time.Second)
+	txnProcessor.scheduler.get(id).NotBefore = &past
+	txnProcessor.releaseDue()
+	assert.Empty(txnProcessor.ScheduledTransactions())
+	assert.Len(released.contexts, 1)
+	assert.Equal(id, released.headers[0].ID)
+
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	receipt := released.contexts[0].replies[0].(*messages.TransactionReceipt)
+	assert.Equal(messages.MsgTypeTransactionSuccess, receipt.Headers.MsgType)
+	assert.True(receipt.Headers.Scheduled)
+	assert.Equal("eth_sendTransaction", testRPC.calls[0])
+	assert.Empty(db.KVS)
+}
+
+func TestScheduleReleasedKeptUntilJournaled(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	testRPC := goodMessageRPC()
+	testRPC.ethGetTransactionReceiptErr = fmt.Errorf("not yet")
+	txnProcessor := newTestSchedulerProcessor(db, testRPC)
+	txnProcessor.journal = newTxnJournal(kvstore.NewMockKV(nil))
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBeforeBlock", "0")
+	txnProcessor.OnMessage(testTxnContext)
+	assert.Len(db.KVS, 1)
+
+	// Not deleted if the message is released before a restart, and not yet journaled
+	entry := txnProcessor.scheduler.take(txnProcessor.ScheduledTransactions()[0].RequestID)
+	assert.Len(db.KVS, 1)
+	restarted := newTestSchedulerProcessor(db, testRPC)
+	assert.Len(restarted.ScheduledTransactions(), 1)
+
+	// Deleted once journaled, before the receipt arrives
+	txnContext := txnProcessor.scheduler.newReleasedContext(entry)
+	assert.Nil(txnContext)
+	released := &testReleasedContexts{}
+	txnProcessor.ReleaseScheduled(released.newTxnContext)
+	txnContext = txnProcessor.scheduler.newReleasedContext(entry)
+	assert.True(txnContext.Backpressure())
+	txnProcessor.OnMessage(txnContext)
+	inflight := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0]
+	assert.NotNil(inflight.journaled)
+	assert.Empty(db.KVS)
+	assert.Empty(released.contexts[0].replies)
+
+	inflight.wg.Wait()
+}
+
+func TestScheduleSubmittingNotReleasedAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	testRPC := goodMessageRPC()
+	txnProcessor := newTestSchedulerProcessor(db, testRPC)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = strings.Replace(newTestScheduledSendTxnJSON("notBeforeBlock", "0"), "\"type\"", "\"id\":\"req1\",\"type\"", 1)
+	txnProcessor.OnMessage(testTxnContext)
+	err := txnProcessor.scheduler.markSubmitting(txnProcessor.scheduler.take("req1"))
+	assert.NoError(err)
+
+	restarted := newTestSchedulerProcessor(db, testRPC)
+	assert.Empty(restarted.ScheduledTransactions())
+	released := &testReleasedContexts{}
+	restarted.ReleaseScheduled(released.newTxnContext)
+	assert.Equal("req1", released.headers[0].ID)
+	assert.Regexp("Scheduled transaction was being submitted before a restart", released.contexts[0].replies[0].(*messages.ErrorReply).ErrorMessage)
+	assert.Empty(db.KVS)
+	assert.Empty(testRPC.calls)
+}
+
+func TestScheduleSubmittingStoreFail(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	testRPC := goodMessageRPC()
+	testRPC.ethBlockNumberResults = []uint64{0}
+	txnProcessor := newTestSchedulerProcessor(db, testRPC)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBeforeBlock", "0")
+	txnProcessor.OnMessage(testTxnContext)
+
+	released := &testReleasedContexts{}
+	txnProcessor.ReleaseScheduled(released.newTxnContext)
+	db.StoreErr = fmt.Errorf("pop")
+	txnProcessor.releaseDue()
+	assert.Regexp("Failed to store scheduled transaction: pop", released.contexts[0].replies[0].(*messages.ErrorReply).ErrorMessage)
+	for _, call := range testRPC.calls {
+		assert.NotEqual("eth_sendTransaction", call)
+	}
+}
+
+func TestScheduleNotBeforeBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	testRPC.ethBlockNumberResults = []uint64{99, 100}
+	txnProcessor := newTestSchedulerProcessor(kvstore.NewMockKV(nil), testRPC)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBeforeBlock", "100")
+
+	txnProcessor.OnMessage(testTxnContext)
+	reply := testTxnContext.replies[0].(*messages.TransactionScheduled)
+	assert.Equal("100", reply.NotBeforeBlock)
+
+	released := &testReleasedContexts{}
+	txnProcessor.ReleaseScheduled(released.newTxnContext)
+	txnProcessor.releaseDue()
+	assert.Empty(released.contexts)
+	txnProcessor.releaseDue()
+	assert.Len(released.contexts, 1)
+
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(messages.MsgTypeTransactionSuccess, released.contexts[0].replies[0].ReplyHeaders().MsgType)
+}
+
+func TestScheduleNotBeforeBlockHeadFails(t *testing.T) {
+	assert := assert.New(t)
+
+	testRPC := goodMessageRPC()
+	testRPC.ethBlockNumberResults = []uint64{0}
+	testRPC.ethBlockNumberErr = fmt.Errorf("pop")
+	txnProcessor := newTestSchedulerProcessor(kvstore.NewMockKV(nil), testRPC)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBeforeBlock", "100")
+	txnProcessor.OnMessage(testTxnContext)
+
+	released := &testReleasedContexts{}
+	txnProcessor.ReleaseScheduled(released.newTxnContext)
+	txnProcessor.releaseDue()
+	assert.Empty(released.contexts)
+	assert.Len(txnProcessor.ScheduledTransactions(), 1)
+}
+
+func TestScheduleNotBeforePassed(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	testRPC := goodMessageRPC()
+	txnProcessor := newTestSchedulerProcessor(db, testRPC)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBefore", "2020-01-01T00:00:00Z")
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(messages.MsgTypeTransactionSuccess, testTxnContext.replies[0].ReplyHeaders().MsgType)
+	assert.Empty(db.KVS)
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestSchedulerProcessor(kvstore.NewMockKV(nil), &testRPC{})
+	tests := []struct {
+		jsonMsg     string
+		expectedErr string
+	}{
+		{newTestScheduledSendTxnJSON("notBefore", "tomorrow"), "Invalid notBefore 'tomorrow'"},
+		{newTestScheduledSendTxnJSON("notBeforeBlock", "-1"), "Invalid notBeforeBlock '-1'"},
+	}
+	for _, test := range tests {
+		testTxnContext := &testTxnContext{}
+		testTxnContext.jsonMsg = test.jsonMsg
+		txnProcessor.OnMessage(testTxnContext)
+		assert.Equal(400, testTxnContext.errorReplies[0].status)
+		assert.Regexp(test.expectedErr, testTxnContext.errorReplies[0].err)
+	}
+	assert.Empty(txnProcessor.ScheduledTransactions())
+}
+
+func TestScheduleNotEnabled(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.Init(&testRPC{})
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBeforeBlock", "100")
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.Regexp("Scheduled transactions are not enabled", testTxnContext.errorReplies[0].err)
+	assert.Empty(txnProcessor.ScheduledTransactions())
+	_, err := txnProcessor.ScheduledTransaction("req1")
+	assert.Regexp("No scheduled transaction found for request 'req1'", err)
+	_, err = txnProcessor.CancelScheduled("req1")
+	assert.Regexp("No scheduled transaction found for request 'req1'", err)
+	txnProcessor.ReleaseScheduled(nil)
+}
+
+func TestScheduleStoreFail(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	txnProcessor := newTestSchedulerProcessor(db, &testRPC{})
+	db.StoreErr = fmt.Errorf("pop")
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = newTestScheduledSendTxnJSON("notBeforeBlock", "100")
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Equal(500, testTxnContext.errorReplies[0].status)
+	assert.Regexp("Failed to store scheduled transaction: pop", testTxnContext.errorReplies[0].err)
+	assert.Empty(txnProcessor.ScheduledTransactions())
+}
+
+func TestScheduleRecoveredAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	txnProcessor := newTestSchedulerProcessor(db, &testRPC{})
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = strings.Replace(newTestScheduledSendTxnJSON("notBeforeBlock", "100"), "\"type\"", "\"id\":\"req1\",\"type\"", 1)
+	txnProcessor.OnMessage(testTxnContext)
+	db.Put("other", []byte("{}"))
+	db.Put(scheduleKeyPrefix+"bad", []byte("!json"))
+
+	restarted := newTestSchedulerProcessor(db, &testRPC{})
+	scheduled := restarted.ScheduledTransactions()
+	assert.Len(scheduled, 1)
+	assert.Equal("req1", scheduled[0].RequestID)
+	assert.Equal("100", scheduled[0].NotBeforeBlock)
+
+	var msg messages.SendTransaction
+	err := json.Unmarshal(restarted.scheduler.get("req1").Msg, &msg)
+	assert.NoError(err)
+	assert.Empty(msg.NotBeforeBlock)
+	assert.Equal("test", msg.Method.Name)
+}
+
+func TestCancelScheduled(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	txnProcessor := newTestSchedulerProcessor(db, &testRPC{})
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = strings.Replace(newTestScheduledSendTxnJSON("notBeforeBlock", "100"), "\"type\"", "\"id\":\"req1\",\"type\"", 1)
+	txnProcessor.OnMessage(testTxnContext)
+
+	released := &testReleasedContexts{}
+	txnProcessor.ReleaseScheduled(released.newTxnContext)
+	status, err := txnProcessor.CancelScheduled("req1")
+	assert.NoError(err)
+	assert.Equal("req1", status.RequestID)
+	assert.Empty(db.KVS)
+	assert.Empty(txnProcessor.ScheduledTransactions())
+	assert.Regexp("Scheduled transaction was cancelled", released.contexts[0].replies[0].(*messages.ErrorReply).ErrorMessage)
+	assert.True(released.contexts[0].replies[0].ReplyHeaders().Scheduled)
+
+	_, err = txnProcessor.CancelScheduled("req1")
+	assert.Regexp("No scheduled transaction found for request 'req1'", err)
+}
+
+func TestScheduledTxnContextErrorReplies(t *testing.T) {
+	assert := assert.New(t)
+
+	entry := &scheduledEntry{
+		Headers: messages.CommonHeaders{ID: "req1", MsgType: messages.MsgTypeSendTransaction},
+		Msg:     json.RawMessage(`{"from":"0x12345"}`),
+	}
+	s := newScheduler(&SchedulerConf{}, kvstore.NewMockKV(nil))
+	s.newTxnContext = (&testReleasedContexts{}).newTxnContext
+	txnContext := s.newReleasedContext(entry)
+	replyTo := txnContext.replyTo.(*testTxnContext)
+	assert.Equal("Scheduled[SendTransaction/req1]", txnContext.String())
+	assert.NotNil(txnContext.Context())
+
+	txnContext.SendErrorReplyWithGapFill(500, fmt.Errorf("pop"), "0x11111", true)
+	txnContext.SendErrorReplyWithTX(500, fmt.Errorf("pop"), "0x22222")
+	gapFill := replyTo.replies[0].(*messages.ErrorReply)
+	assert.Equal("0x11111", gapFill.GapFillTxHash)
+	assert.True(*gapFill.GapFillSucceeded)
+	assert.Regexp("0x12345", gapFill.OriginalMessage)
+	assert.Equal("0x22222", replyTo.replies[1].(*messages.ErrorReply).TXHash)
+}
+
+func TestInitSchedulerDB(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "scheduler")
+	defer os.RemoveAll(dir)
+	p := NewTxnProcessor(&TxnProcessorConf{
+		Scheduler: SchedulerConf{LevelDBPath: path.Join(dir, "db")},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := p.Init(&testRPC{})
+	assert.NoError(err)
+	assert.NotNil(p.scheduler)
+	p.scheduler.db.Close()
+
+	ioutil.WriteFile(path.Join(dir, "file"), []byte("not a dir"), 0644)
+	p = NewTxnProcessor(&TxnProcessorConf{
+		Scheduler: SchedulerConf{LevelDBPath: path.Join(dir, "file")},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err = p.Init(&testRPC{})
+	assert.Regexp("Failed to open scheduled transaction DB", err)
+}
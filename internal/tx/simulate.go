@@ -0,0 +1,116 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	ethbinding "github.com/kaleido-io/ethbinding/pkg"
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/ethbind"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+)
+
+// simulateTransaction replies with the outcome of running the transaction against the pending
+// block, and the nonce it would be sent with. Nothing is signed or submitted, and the nonce is
+// not reserved. The method is used to decode the outputs, when it is known
+func (p *txnProcessor) simulateTransaction(txnContext TxnContext, msg *messages.TransactionCommon, to string, method *ethbinding.ABIElementMarshaling, buildTX func(signer eth.TXSigner) (*eth.Txn, error)) {
+	ctx := txnContext.Context()
+	tx, rpc, err := p.buildUnsubmitted(ctx, msg, buildTX)
+	if err != nil {
+		txnContext.SendErrorReply(400, err)
+		return
+	}
+	sim, err := tx.Simulate(ctx, rpc)
+	if err != nil {
+		txnContext.SendErrorReply(400, err)
+		return
+	}
+
+	reply := &messages.TransactionSimulation{
+		From:         strings.ToLower(tx.From.Hex()),
+		To:           strings.ToLower(to),
+		NonceStr:     msg.Nonce.String(),
+		Reverted:     sim.Reverted,
+		RevertReason: sim.RevertReason,
+		Revert:       sim.Revert,
+	}
+	reply.Headers.MsgType = messages.MsgTypeTransactionSimulation
+	if sim.GasEstimate > 0 {
+		reply.GasEstimateStr = strconv.FormatUint(sim.GasEstimate, 10)
+	}
+	if method != nil && sim.ReturnData != nil {
+		if methodABI, err := ethbind.API.ABIElementMarshalingToABIMethod(method); err == nil {
+			reply.Outputs = eth.ProcessRLPBytes(methodABI.Outputs, sim.ReturnData)
+		}
+	}
+	txnContext.Reply(reply)
+}
+
+// buildUnsubmitted builds a transaction that is not tracked in flight, such as one that is
+// simulated or prepared for external signing. The nonce and fees are chosen as if it were sent now
+func (p *txnProcessor) buildUnsubmitted(ctx context.Context, msg *messages.TransactionCommon, buildTX func(signer eth.TXSigner) (*eth.Txn, error)) (*eth.Txn, eth.RPCClient, error) {
+	signer, rpc, err := p.resolveSender(ctx, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	from, err := utils.StrToAddress("from", msg.From)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := p.unreservedNonce(ctx, rpc, signer, from, msg.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg.Nonce = json.Number(strconv.FormatInt(nonce, 10))
+	if err := p.gasPricer.applyGasPrice(ctx, rpc, msg); err != nil {
+		return nil, nil, err
+	}
+	tx, err := buildTX(signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, rpc, nil
+}
+
+// unreservedNonce returns the nonce a transaction would be sent with now, in the same way
+// as addInflightWrapper, but without reserving it
+func (p *txnProcessor) unreservedNonce(ctx context.Context, rpc eth.RPCClient, signer eth.TXSigner, from ethbinding.Address, suppliedNonce json.Number) (int64, error) {
+	if suppliedNonce != "" {
+		nonce, err := suppliedNonce.Int64()
+		if err != nil {
+			return 0, errors.Errorf(errors.TransactionSendBadNonce, err)
+		}
+		return nonce, nil
+	}
+	if signer != nil || p.conf.AlwaysManageNonce {
+		p.inflightTxnsLock.Lock()
+		highestNonce := int64(-1)
+		if inflightForAddr, exists := p.inflightTxns[strings.ToLower(from.Hex())]; exists {
+			highestNonce = inflightForAddr.highestNonce
+		}
+		p.inflightTxnsLock.Unlock()
+		if highestNonce >= 0 {
+			return highestNonce + 1, nil
+		}
+	}
+	// The node would assign the next nonce from its pending transaction count
+	return eth.GetTransactionCount(ctx, rpc, &from, "pending")
+}
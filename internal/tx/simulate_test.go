@@ -0,0 +1,199 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var simulateSendTxnJSON = "{" +
+	"  \"headers\":{\"type\": \"SendTransaction\"}," +
+	"  \"from\":\"" + testFromAddr + "\"," +
+	"  \"to\":\"0x2b8c0ECc76d0759a8F50b2E14A6881367D805832\"," +
+	"  \"simulate\":true," +
+	"  \"method\":{\"name\":\"test\",\"outputs\":[{\"name\":\"retval1\",\"type\":\"uint256\"}]}" +
+	"}"
+
+var simulateDeployTxnJSON = "{" +
+	"  \"headers\":{\"type\": \"DeployContract\"}," +
+	"  \"solidity\":\"pragma solidity >=0.4.22 <=0.7; contract t {constructor() public {}}\"," +
+	"  \"from\":\"" + testFromAddr + "\"," +
+	"  \"simulate\":true" +
+	"}"
+
+const testSimulateRevertData = "0x08c379a0000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000114d75707065747279206465746563746564000000000000000000000000000000"
+
+func TestOnSendTransactionMessageSimulate(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = simulateSendTxnJSON
+	testRPC := &testRPC{
+		ethGetTransactionCountResult: 12,
+		ethEstimateGasResult:         21000,
+		ethCallResult:                "0x0000000000000000000000000000000000000000000000000000000000000001",
+	}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.errorReplies)
+	assert.Len(testTxnContext.replies, 1)
+	reply := testTxnContext.replies[0].(*messages.TransactionSimulation)
+	assert.Equal(messages.MsgTypeTransactionSimulation, reply.Headers.MsgType)
+	assert.Equal(strings.ToLower(testFromAddr), reply.From)
+	assert.Equal("0x2b8c0ecc76d0759a8f50b2e14a6881367d805832", reply.To)
+	assert.Equal("12", reply.NonceStr)
+	assert.Equal("21000", reply.GasEstimateStr)
+	assert.False(reply.Reverted)
+	assert.Equal(map[string]interface{}{"retval1": "1"}, reply.Outputs)
+
+	assert.Equal([]string{"eth_getTransactionCount", "eth_estimateGas", "eth_call"}, testRPC.calls)
+	assert.Empty(txnProcessor.inflightTxns)
+}
+
+func TestOnSendTransactionMessageSimulateInflightNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		AlwaysManageNonce: true,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = simulateSendTxnJSON
+	testRPC := &testRPC{
+		ethEstimateGasResult: 21000,
+	}
+	txnProcessor.Init(testRPC)
+	txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] = &inflightTxnState{
+		highestNonce: 7,
+	}
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	require.Empty(t, testTxnContext.errorReplies)
+	require.Len(t, testTxnContext.replies, 1)
+	reply := testTxnContext.replies[0].(*messages.TransactionSimulation)
+	assert.Equal("8", reply.NonceStr)
+	assert.Nil(reply.Outputs)
+	assert.Equal([]string{"eth_estimateGas", "eth_call"}, testRPC.calls)
+}
+
+func TestOnSendTransactionMessageSimulateRevert(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = simulateSendTxnJSON
+	testRPC := &testRPC{
+		ethEstimateGasErr: fmt.Errorf("execution reverted"),
+		ethCallResult:     testSimulateRevertData,
+	}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	require.Empty(t, testTxnContext.errorReplies)
+	require.Len(t, testTxnContext.replies, 1)
+	reply := testTxnContext.replies[0].(*messages.TransactionSimulation)
+	assert.True(reply.Reverted)
+	assert.Equal("Muppetry detected", reply.RevertReason)
+	assert.Equal("", reply.GasEstimateStr)
+	assert.Nil(reply.Outputs)
+}
+
+func TestOnSendTransactionMessageSimulateCallFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = simulateSendTxnJSON
+	testRPC := &testRPC{
+		ethCallErr: fmt.Errorf("pop"),
+	}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.replies)
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.Regexp("pop", testTxnContext.errorReplies[0].err)
+}
+
+func TestOnSendTransactionMessageSimulateNonceFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = simulateSendTxnJSON
+	testRPC := &testRPC{
+		ethGetTransactionCountErr: fmt.Errorf("pop"),
+	}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.replies)
+	assert.Regexp("pop", testTxnContext.errorReplies[0].err)
+	assert.Equal([]string{"eth_getTransactionCount"}, testRPC.calls)
+}
+
+func TestOnSendTransactionMessageSimulateBadNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = strings.Replace(simulateSendTxnJSON, "\"simulate\":true,", "\"simulate\":true,\"nonce\":\"1.5\",", 1)
+	testRPC := &testRPC{}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Empty(testTxnContext.replies)
+	assert.Regexp("nonce", testTxnContext.errorReplies[0].err)
+	assert.Empty(testRPC.calls)
+}
+
+func TestOnDeployContractMessageSimulate(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = simulateDeployTxnJSON
+	testRPC := &testRPC{
+		ethGetTransactionCountResult: 3,
+		ethEstimateGasResult:         50000,
+		ethCallResult:                "0x6080",
+	}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	require.Empty(t, testTxnContext.errorReplies)
+	require.Len(t, testTxnContext.replies, 1)
+	reply := testTxnContext.replies[0].(*messages.TransactionSimulation)
+	assert.Equal("3", reply.NonceStr)
+	assert.Equal("50000", reply.GasEstimateStr)
+	assert.Equal("", reply.To)
+	assert.Nil(reply.Outputs)
+	assert.Equal([]string{"eth_getTransactionCount", "eth_estimateGas", "eth_call"}, testRPC.calls)
+}
@@ -0,0 +1,304 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/kaleido-io/ethconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// PendingTransaction returns the status of a transaction that has been submitted,
+// but not yet mined, by request ID or by any of its transaction hashes
+func (p *txnProcessor) PendingTransaction(id string) (*messages.PendingTransaction, error) {
+	inflight, err := p.lockPending(id)
+	if err != nil {
+		return nil, err
+	}
+	defer inflight.txLock.Unlock()
+	return inflight.pendingStatus(), nil
+}
+
+// CancelTransaction replaces a pending transaction with a zero value transfer to the sending
+// address, with the same nonce. The reply for the original request reports the cancellation
+// if the cancel transaction is the one that is mined
+func (p *txnProcessor) CancelTransaction(ctx context.Context, id string) (*messages.PendingTransaction, error) {
+	inflight, err := p.lockPending(id)
+	if err != nil {
+		return nil, err
+	}
+	defer inflight.txLock.Unlock()
+
+	if err := inflight.tx.Cancel(ctx, inflight.rpc, int64(p.conf.GasReplacement.BumpPercent), p.replaceMaxGasPrice); err != nil {
+		return nil, err
+	}
+	log.Infof("In-flight %d cancelled. TX=%s", inflight.id, inflight.tx.Hash)
+	p.adminReplaced(inflight)
+	return inflight.pendingStatus(), nil
+}
+
+// ReplaceTransaction re-submits a pending transaction with the same nonce, and the supplied gas settings.
+// If no fees are supplied, the fees are increased in the same way as for a stuck transaction
+func (p *txnProcessor) ReplaceTransaction(ctx context.Context, id string, fees *messages.TransactionFees) (*messages.PendingTransaction, error) {
+	inflight, err := p.lockPending(id)
+	if err != nil {
+		return nil, err
+	}
+	defer inflight.txLock.Unlock()
+
+	if fees.GasPrice == "" && fees.MaxFeePerGas == "" && fees.MaxPriorityFeePerGas == "" && fees.TxType == "" && fees.Gas == "" {
+		err = inflight.tx.Replace(ctx, inflight.rpc, int64(p.conf.GasReplacement.BumpPercent), p.replaceMaxGasPrice)
+	} else {
+		err = inflight.tx.ReplaceFees(ctx, inflight.rpc, fees, int64(p.conf.GasReplacement.BumpPercent), p.replaceMaxGasPrice)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("In-flight %d replaced on request. TX=%s", inflight.id, inflight.tx.Hash)
+	p.adminReplaced(inflight)
+	return inflight.pendingStatus(), nil
+}
+
+// adminReplaced records a replacement made on request, so the stuck transaction policy
+// waits a full interval before acting, and the journal has the new hash
+func (p *txnProcessor) adminReplaced(inflight *inflightTxn) {
+	inflight.lastSubmitted = time.Now().UTC()
+	inflight.replacements++
+	p.journalReplaced(inflight)
+}
+
+// lockPending finds a transaction that has been submitted and is not yet complete,
+// and returns it with its transaction lock held
+func (p *txnProcessor) lockPending(id string) (*inflightTxn, error) {
+	// Take a copy of the list, as we do not want to hold the processor lock while we
+	// wait for the lock on each transaction
+	p.inflightTxnsLock.Lock()
+	candidates := []*inflightTxn{}
+	for _, inflightForAddr := range p.inflightTxns {
+		candidates = append(candidates, inflightForAddr.txnsInFlight...)
+	}
+	p.inflightTxnsLock.Unlock()
+
+	for _, inflight := range candidates {
+		inflight.txLock.Lock()
+		if !inflight.complete && inflight.tx != nil && inflight.matches(id) {
+			return inflight, nil
+		}
+		inflight.txLock.Unlock()
+	}
+	return nil, errors.Errorf(errors.TransactionPendingNotFound, id)
+}
+
+func (i *inflightTxn) matches(id string) bool {
+	if i.requestID != "" && i.requestID == id {
+		return true
+	}
+	if i.tx.Hash == id {
+		return true
+	}
+	for _, hash := range i.tx.SubmittedHashes {
+		if hash == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *inflightTxn) pendingStatus() *messages.PendingTransaction {
+	pending := &messages.PendingTransaction{
+		RequestID: i.requestID,
+		From:      i.from,
+		Nonce:     i.nonce,
+	}
+	// The transaction is not set until it has been built and submitted
+	if i.tx != nil {
+		pending.TransactionHash = i.tx.Hash
+		pending.SubmittedHashes = i.tx.SubmittedHashes
+		pending.Cancelling = i.tx.IsCancelling()
+	}
+	return pending
+}
+
+// NonceStates returns the nonce tracking state of each address with transactions in-flight
+func (p *txnProcessor) NonceStates() []*messages.NonceState {
+	p.inflightTxnsLock.Lock()
+	defer p.inflightTxnsLock.Unlock()
+	states := make([]*messages.NonceState, 0, len(p.inflightTxns))
+	for addr, inflightForAddr := range p.inflightTxns {
+		states = append(states, &messages.NonceState{
+			Address:      addr,
+			HighestNonce: inflightForAddr.highestNonce,
+			InFlight:     len(inflightForAddr.txnsInFlight),
+		})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Address < states[j].Address })
+	return states
+}
+
+// NonceState returns the nonce tracking state of an address, with its in-flight transactions
+func (p *txnProcessor) NonceState(address string) (*messages.NonceState, error) {
+	from, err := utils.StrToAddress("address", address)
+	if err != nil {
+		return nil, err
+	}
+	addr := strings.ToLower(from.Hex())
+
+	p.inflightTxnsLock.Lock()
+	inflightForAddr, exists := p.inflightTxns[addr]
+	if !exists {
+		p.inflightTxnsLock.Unlock()
+		return nil, errors.Errorf(errors.TransactionNonceNotTracked, addr)
+	}
+	state := &messages.NonceState{
+		Address:      addr,
+		HighestNonce: inflightForAddr.highestNonce,
+		InFlight:     len(inflightForAddr.txnsInFlight),
+	}
+	// As in lockPending, we do not hold the processor lock while taking the lock on each transaction
+	inflights := append([]*inflightTxn{}, inflightForAddr.txnsInFlight...)
+	p.inflightTxnsLock.Unlock()
+
+	state.Transactions = make([]*messages.PendingTransaction, 0, len(inflights))
+	for _, inflight := range inflights {
+		inflight.txLock.Lock()
+		state.Transactions = append(state.Transactions, inflight.pendingStatus())
+		inflight.txLock.Unlock()
+	}
+	return state, nil
+}
+
+// ResyncNonce replaces the highest nonce tracked in memory for an address, with the one before
+// the next nonce reported by the node. For use when the nonces we assign get out of sync with
+// the node, such as when transactions from the address are submitted elsewhere
+func (p *txnProcessor) ResyncNonce(ctx context.Context, address string) (*messages.NonceState, error) {
+	from, err := utils.StrToAddress("address", address)
+	if err != nil {
+		return nil, err
+	}
+	addr := strings.ToLower(from.Hex())
+	rpc, err := p.rpcForAddress(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// We do not hold the lock while we query the node, so we note the highest nonce first.
+	// Any nonce assigned while we wait might not be counted by the node, so is not discarded
+	p.inflightTxnsLock.Lock()
+	before, beforeNonce := p.inflightTxns[addr], int64(-1)
+	if before != nil {
+		beforeNonce = before.highestNonce
+	}
+	p.inflightTxnsLock.Unlock()
+
+	nextNonce, err := eth.GetTransactionCount(ctx, rpc, &from, "pending")
+	if err != nil {
+		return nil, err
+	}
+	state := &messages.NonceState{
+		Address:      addr,
+		HighestNonce: nextNonce - 1,
+	}
+
+	p.inflightTxnsLock.Lock()
+	defer p.inflightTxnsLock.Unlock()
+	if inflightForAddr, exists := p.inflightTxns[addr]; exists {
+		assigned := inflightForAddr != before || inflightForAddr.highestNonce != beforeNonce
+		if assigned && inflightForAddr.highestNonce > state.HighestNonce {
+			state.HighestNonce = inflightForAddr.highestNonce
+		}
+		log.Infof("Nonce for %s resynced from node. highest=%d (was %d)", addr, state.HighestNonce, inflightForAddr.highestNonce)
+		inflightForAddr.highestNonce = state.HighestNonce
+		state.InFlight = len(inflightForAddr.txnsInFlight)
+	}
+	return state, nil
+}
+
+// NonceInUseError is returned when a gap-fill is requested for a nonce that belongs to
+// a transaction that is in-flight
+type NonceInUseError struct {
+	Err error
+}
+
+func (e *NonceInUseError) Error() string {
+	return e.Err.Error()
+}
+
+// IsNonceInUse is true if the error was returned because a gap-fill was requested for
+// the nonce of an in-flight transaction, which conflicts with the request (HTTP 409)
+func IsNonceInUse(err error) bool {
+	_, inUse := err.(*NonceInUseError)
+	return inUse
+}
+
+// FillNonceGap submits a gap-fill TX from the address with the supplied nonce, so that
+// transactions with higher nonces that are stuck behind a gap can be mined
+func (p *txnProcessor) FillNonceGap(ctx context.Context, address string, nonce int64) (*messages.PendingTransaction, error) {
+	from, err := utils.StrToAddress("address", address)
+	if err != nil {
+		return nil, err
+	}
+	addr := strings.ToLower(from.Hex())
+
+	// We do not replace an in-flight transaction - that is what cancel is for
+	p.inflightTxnsLock.Lock()
+	var inflights []*inflightTxn
+	if inflightForAddr, exists := p.inflightTxns[addr]; exists {
+		inflights = append(inflights, inflightForAddr.txnsInFlight...)
+	}
+	p.inflightTxnsLock.Unlock()
+	for _, inflight := range inflights {
+		inflight.txLock.Lock()
+		inUse := !inflight.complete && !inflight.nodeAssignNonce && inflight.nonce == nonce
+		inflight.txLock.Unlock()
+		if inUse {
+			return nil, &NonceInUseError{Err: errors.Errorf(errors.TransactionNonceGapFillInUse, nonce, addr, inflight.requestID)}
+		}
+	}
+
+	gapFill := &inflightTxn{
+		from:  addr,
+		nonce: nonce,
+	}
+	if gapFill.signer, err = p.resolveSigner(addr); err != nil {
+		return nil, err
+	}
+	if gapFill.rpc, err = p.rpcForAddress(ctx, addr); err != nil {
+		return nil, err
+	}
+	if err = p.sendGapFillTX(ctx, gapFill); err != nil {
+		return nil, err
+	}
+	return &messages.PendingTransaction{
+		From:            addr,
+		Nonce:           nonce,
+		TransactionHash: gapFill.gapFillTxHash,
+	}, nil
+}
+
+// rpcForAddress returns the RPC used to send transactions from the address, which
+// might be routed by the address book
+func (p *txnProcessor) rpcForAddress(ctx context.Context, addr string) (eth.RPCClient, error) {
+	if signer, _ := p.resolveSigner(addr); signer == nil && p.addressBook != nil {
+		return p.addressBook.lookup(ctx, addr)
+	}
+	return p.rpc, nil
+}
@@ -0,0 +1,347 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdminProcessor(t *testing.T) (*txnProcessor, *testRPC, *inflightTxn) {
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{
+		BumpPercent: 20,
+	})
+	txnProcessor.journal = newTxnJournal(kvstore.NewMockKV(nil))
+	testRPC := goodMessageRPC()
+	inflight := newTestStuckInflight(t, testRPC)
+	inflight.requestID = "req1"
+	txnProcessor.inflightTxns[inflight.from] = &inflightTxnState{
+		txnsInFlight: []*inflightTxn{inflight},
+		highestNonce: inflight.nonce,
+	}
+	txnProcessor.journalInflight(inflight, inflight.tx)
+	return txnProcessor, testRPC, inflight
+}
+
+func TestPendingTransactionByRequestIDOrHash(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, _, inflight := newTestAdminProcessor(t)
+	inflight.tx.SubmittedHashes = []string{"0x000", "0x111"}
+
+	for _, id := range []string{"req1", "0x111", "0x000"} {
+		pending, err := txnProcessor.PendingTransaction(id)
+		assert.NoError(err)
+		assert.Equal("req1", pending.RequestID)
+		assert.Equal(inflight.from, pending.From)
+		assert.Equal(int64(5), pending.Nonce)
+		assert.Equal("0x111", pending.TransactionHash)
+		assert.Equal([]string{"0x000", "0x111"}, pending.SubmittedHashes)
+		assert.False(pending.Cancelling)
+	}
+}
+
+func TestPendingTransactionNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, _, inflight := newTestAdminProcessor(t)
+
+	_, err := txnProcessor.PendingTransaction("req2")
+	assert.EqualError(err, "No pending transaction found for 'req2'")
+
+	// Once the transaction is mined, or we have stopped waiting for it, it cannot be changed
+	inflight.complete = true
+	_, err = txnProcessor.PendingTransaction("req1")
+	assert.EqualError(err, "No pending transaction found for 'req1'")
+}
+
+func TestCancelTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+
+	pending, err := txnProcessor.CancelTransaction(context.Background(), "req1")
+	assert.NoError(err)
+	assert.True(pending.Cancelling)
+	assert.Equal(testRPC.ethSendTransactionResult, pending.TransactionHash)
+	assert.Equal(1, inflight.replacements)
+
+	assert.Equal([]string{"eth_sendTransaction"}, testRPC.calls)
+	sendTX := testRPC.params[0][0].(*eth.SendTXArgs)
+	assert.Equal(uint64(5), uint64(*sendTX.Nonce))
+	assert.Equal("120", sendTX.GasPrice.ToInt().String())
+	assert.Equal(sendTX.From, sendTX.To)
+	assert.Empty(*sendTX.Data)
+
+	entries := txnProcessor.journal.recover()
+	assert.Equal(1, len(entries))
+	assert.Equal([]string{testRPC.ethSendTransactionResult}, entries[0].CancelHashes)
+	assert.Equal(pending.SubmittedHashes, entries[0].SubmittedHashes)
+}
+
+func TestCancelTransactionFails(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+	testRPC.ethSendTransactionErr = fmt.Errorf("pop")
+
+	_, err := txnProcessor.CancelTransaction(context.Background(), "req1")
+	assert.EqualError(err, "pop")
+	assert.False(inflight.tx.IsCancelling())
+	assert.Equal(0, inflight.replacements)
+
+	_, err = txnProcessor.CancelTransaction(context.Background(), "req2")
+	assert.EqualError(err, "No pending transaction found for 'req2'")
+}
+
+func TestReplaceTransactionWithFees(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+
+	pending, err := txnProcessor.ReplaceTransaction(context.Background(), "0x111", &messages.TransactionFees{
+		GasPrice: "500",
+	})
+	assert.NoError(err)
+	assert.False(pending.Cancelling)
+	assert.Equal([]string{"0x111", testRPC.ethSendTransactionResult}, pending.SubmittedHashes)
+	assert.Equal(1, inflight.replacements)
+
+	sendTX := testRPC.params[0][0].(*eth.SendTXArgs)
+	assert.Equal("500", sendTX.GasPrice.ToInt().String())
+	assert.Equal(uint64(123), uint64(*sendTX.Gas))
+
+	entries := txnProcessor.journal.recover()
+	assert.Equal(testRPC.ethSendTransactionResult, entries[0].TXHash)
+}
+
+func TestReplaceTransactionBump(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, _ := newTestAdminProcessor(t)
+
+	_, err := txnProcessor.ReplaceTransaction(context.Background(), "req1", &messages.TransactionFees{})
+	assert.NoError(err)
+
+	sendTX := testRPC.params[0][0].(*eth.SendTXArgs)
+	assert.Equal("120", sendTX.GasPrice.ToInt().String())
+}
+
+func TestReplaceTransactionNotReplaceable(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+	inflight.tx.PrivateFor = []string{"member1"}
+
+	_, err := txnProcessor.ReplaceTransaction(context.Background(), "req1", &messages.TransactionFees{GasPrice: "500"})
+	assert.Regexp("cannot be replaced", err)
+	assert.Empty(testRPC.calls)
+	assert.Equal(0, inflight.replacements)
+}
+
+func TestWaitForCompletionCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, _, inflight := newTestAdminProcessor(t)
+	_, err := txnProcessor.CancelTransaction(context.Background(), "req1")
+	assert.NoError(err)
+
+	inflight.wg.Add(1)
+	txnProcessor.waitForCompletion(inflight, 0)
+
+	txnContext := inflight.txnContext.(*testTxnContext)
+	assert.Equal(1, len(txnContext.replies))
+	assert.Equal(messages.MsgTypeTransactionCancelled, txnContext.replies[0].ReplyHeaders().MsgType)
+	assert.True(inflight.complete)
+
+	_, err = txnProcessor.CancelTransaction(context.Background(), "req1")
+	assert.EqualError(err, "No pending transaction found for 'req1'")
+}
+
+func TestNonceStates(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, _, inflight := newTestAdminProcessor(t)
+	txnProcessor.inflightTxns["0x0000000000000000000000000000000000000001"] = &inflightTxnState{
+		txnsInFlight: []*inflightTxn{},
+		highestNonce: 10,
+	}
+
+	states := txnProcessor.NonceStates()
+	assert.Equal(2, len(states))
+	assert.Equal("0x0000000000000000000000000000000000000001", states[0].Address)
+	assert.Equal(int64(10), states[0].HighestNonce)
+	assert.Equal(0, states[0].InFlight)
+	assert.Equal(inflight.from, states[1].Address)
+	assert.Equal(int64(5), states[1].HighestNonce)
+	assert.Equal(1, states[1].InFlight)
+	assert.Nil(states[1].Transactions)
+}
+
+func TestNonceState(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, _, inflight := newTestAdminProcessor(t)
+	// A transaction that is still being prepared has no hash yet
+	txnProcessor.inflightTxns[inflight.from].txnsInFlight = append(txnProcessor.inflightTxns[inflight.from].txnsInFlight, &inflightTxn{
+		from:      inflight.from,
+		nonce:     6,
+		requestID: "req2",
+	})
+
+	state, err := txnProcessor.NonceState(testFromAddr)
+	assert.NoError(err)
+	assert.Equal(inflight.from, state.Address)
+	assert.Equal(2, state.InFlight)
+	assert.Equal(2, len(state.Transactions))
+	assert.Equal("req1", state.Transactions[0].RequestID)
+	assert.Equal("0x111", state.Transactions[0].TransactionHash)
+	assert.Equal("req2", state.Transactions[1].RequestID)
+	assert.Equal(int64(6), state.Transactions[1].Nonce)
+	assert.Empty(state.Transactions[1].TransactionHash)
+}
+
+func TestNonceStateNotTracked(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, _, _ := newTestAdminProcessor(t)
+
+	_, err := txnProcessor.NonceState("0x0000000000000000000000000000000000000001")
+	assert.EqualError(err, "No in-flight transactions for address 0x0000000000000000000000000000000000000001")
+
+	_, err = txnProcessor.NonceState("badness")
+	assert.Regexp("address", err)
+}
+
+func TestResyncNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+	txnProcessor.rpc = testRPC
+	testRPC.ethGetTransactionCountResult = 3
+
+	state, err := txnProcessor.ResyncNonce(context.Background(), testFromAddr)
+	assert.NoError(err)
+	assert.Equal(int64(2), state.HighestNonce)
+	assert.Equal(1, state.InFlight)
+	assert.Equal(int64(2), txnProcessor.inflightTxns[inflight.from].highestNonce)
+	assert.Equal([]string{"eth_getTransactionCount"}, testRPC.calls)
+	assert.Equal("pending", testRPC.params[0][1])
+
+	// Addresses without transactions in-flight query the node on the next send anyway
+	state, err = txnProcessor.ResyncNonce(context.Background(), "0x0000000000000000000000000000000000000001")
+	assert.NoError(err)
+	assert.Equal(int64(2), state.HighestNonce)
+	assert.Equal(0, state.InFlight)
+	assert.Equal(1, len(txnProcessor.inflightTxns))
+}
+
+type resyncRaceRPC struct {
+	*testRPC
+	duringQuery func()
+}
+
+func (r *resyncRaceRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	r.duringQuery()
+	return r.testRPC.CallContext(ctx, result, method, args...)
+}
+
+func TestResyncNonceAssignedDuringQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+	testRPC.ethGetTransactionCountResult = 3
+	txnProcessor.rpc = &resyncRaceRPC{
+		testRPC: testRPC,
+		duringQuery: func() {
+			// The lock is not held during the query, and a nonce is assigned in the meantime
+			txnProcessor.inflightTxnsLock.Lock()
+			txnProcessor.inflightTxns[inflight.from].highestNonce = 6
+			txnProcessor.inflightTxnsLock.Unlock()
+		},
+	}
+
+	state, err := txnProcessor.ResyncNonce(context.Background(), testFromAddr)
+	assert.NoError(err)
+	assert.Equal(int64(6), state.HighestNonce)
+	assert.Equal(int64(6), txnProcessor.inflightTxns[inflight.from].highestNonce)
+}
+
+func TestResyncNonceFails(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+	txnProcessor.rpc = testRPC
+	testRPC.ethGetTransactionCountErr = fmt.Errorf("pop")
+
+	_, err := txnProcessor.ResyncNonce(context.Background(), testFromAddr)
+	assert.Regexp("pop", err)
+	assert.Equal(int64(5), txnProcessor.inflightTxns[inflight.from].highestNonce)
+
+	_, err = txnProcessor.ResyncNonce(context.Background(), "badness")
+	assert.Regexp("address", err)
+}
+
+func TestFillNonceGap(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, inflight := newTestAdminProcessor(t)
+	txnProcessor.rpc = testRPC
+
+	pending, err := txnProcessor.FillNonceGap(context.Background(), testFromAddr, 4)
+	assert.NoError(err)
+	assert.Equal(inflight.from, pending.From)
+	assert.Equal(int64(4), pending.Nonce)
+	assert.NotEmpty(pending.TransactionHash)
+
+	assert.Equal([]string{"eth_sendTransaction"}, testRPC.calls)
+	sendTX := testRPC.params[0][0].(*eth.SendTXArgs)
+	assert.Equal(uint64(4), uint64(*sendTX.Nonce))
+	assert.Equal(inflight.from, strings.ToLower(sendTX.To))
+}
+
+func TestFillNonceGapInUse(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, _ := newTestAdminProcessor(t)
+	txnProcessor.rpc = testRPC
+
+	_, err := txnProcessor.FillNonceGap(context.Background(), testFromAddr, 5)
+	assert.Regexp("Nonce 5 for .* is in use by in-flight transaction req1", err)
+	assert.True(IsNonceInUse(err))
+	assert.False(IsNonceInUse(fmt.Errorf("pop")))
+	assert.Empty(testRPC.calls)
+
+	_, err = txnProcessor.FillNonceGap(context.Background(), "badness", 5)
+	assert.Regexp("address", err)
+}
+
+func TestFillNonceGapFails(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor, testRPC, _ := newTestAdminProcessor(t)
+	txnProcessor.rpc = testRPC
+	testRPC.ethSendTransactionErr = fmt.Errorf("pop")
+
+	_, err := txnProcessor.FillNonceGap(context.Background(), testFromAddr, 4)
+	assert.Regexp("pop", err)
+}
@@ -40,3 +40,29 @@ type TxnContext interface {
 	// Get a string summary
 	String() string
 }
+
+// BackpressureTxnContext is implemented by transports that apply backpressure to the
+// sender, such as Kafka. Requests over the submission rate limits wait for capacity,
+// rather than being rejected
+type BackpressureTxnContext interface {
+	TxnContext
+	// True if the request should wait for capacity
+	Backpressure() bool
+}
+
+// BatchTxnContext is implemented by transports that can deliver a reply for each item
+// of a Batch message, in addition to the reply to the batch itself
+type BatchTxnContext interface {
+	TxnContext
+	// Build a context to deliver the replies for an item of the batch
+	NewItemContext(headers *messages.CommonHeaders) TxnContext
+}
+
+// RedeliveredTxnContext is implemented by transports that redeliver the requests that were
+// not complete before a restart, such as Kafka. The key is the same on each redelivery of
+// a request, even when the request was delivered without an ID
+type RedeliveredTxnContext interface {
+	TxnContext
+	// Get the key that identifies the request across redeliveries
+	RedeliveryKey() string
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	journalKeyPrefix = "inflight/"
+)
+
+// journalEntry is the persisted record of a submitted transaction that
+// is still waiting for a receipt
+type journalEntry struct {
+	Key             string                 `json:"key"`
+	Headers         messages.CommonHeaders `json:"headers"`
+	RedeliveryKey   string                 `json:"redeliveryKey,omitempty"`
+	Submitted       time.Time              `json:"submitted"`
+	From            string                 `json:"from"`
+	Nonce           int64                  `json:"nonce"`
+	NodeAssignNonce bool                   `json:"nodeAssignNonce,omitempty"`
+	PrivateFrom     string                 `json:"privateFrom,omitempty"`
+	PrivacyGroupID  string                 `json:"privacyGroupId,omitempty"`
+	RegisterAs      string                 `json:"registerAs,omitempty"`
+	Confirmations   int                    `json:"confirmations,omitempty"`
+	TXHash          string                 `json:"transactionHash"`
+	SubmittedHashes []string               `json:"submittedHashes,omitempty"`
+	CancelHashes    []string               `json:"cancelHashes,omitempty"`
+	Gas             uint64                 `json:"gas,omitempty"`
+	GasEstimate     uint64                 `json:"gasEstimate,omitempty"`
+}
+
+// txnJournal records each in-flight transaction after submission, so that
+// the nonce state can be rebuilt, and receipts obtained, after a restart
+type txnJournal struct {
+	db kvstore.KVStore
+}
+
+func newTxnJournal(db kvstore.KVStore) *txnJournal {
+	return &txnJournal{
+		db: db,
+	}
+}
+
+func (j *txnJournal) store(entry *journalEntry) error {
+	b, _ := json.Marshal(entry)
+	log.Debugf("Journal store %s: %s", entry.Key, b)
+	return j.db.Put(journalKeyPrefix+entry.Key, b)
+}
+
+func (j *txnJournal) remove(key string) {
+	log.Debugf("Journal remove %s", key)
+	j.db.Delete(journalKeyPrefix + key)
+}
+
+func (j *txnJournal) recover() []*journalEntry {
+	entries := []*journalEntry{}
+	it := j.db.NewIterator()
+	defer it.Release()
+	for it.Next() {
+		if !strings.HasPrefix(it.Key(), journalKeyPrefix) {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(it.Value(), &entry); err != nil {
+			log.Errorf("Failed to recover journal entry '%s': %s", it.Key(), err)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	return entries
+}
+
+// journalTxnContext is the placeholder context for a recovered transaction,
+// until it is claimed by a redelivered request or resumed
+type journalTxnContext struct {
+	headers      *messages.CommonHeaders
+	timeReceived time.Time
+}
+
+func (c *journalTxnContext) Context() context.Context {
+	return context.Background()
+}
+
+func (c *journalTxnContext) Headers() *messages.CommonHeaders {
+	return c.headers
+}
+
+func (c *journalTxnContext) Unmarshal(msg interface{}) error {
+	return errors.Errorf(errors.TransactionSendRecoveredNoRequest)
+}
+
+func (c *journalTxnContext) SendErrorReply(status int, err error) {
+	c.SendErrorReplyWithTX(status, err, "")
+}
+
+func (c *journalTxnContext) SendErrorReplyWithTX(status int, err error, txHash string) {
+	log.Warnf("Unable to deliver error reply for %s: %s", c, err)
+}
+
+func (c *journalTxnContext) SendErrorReplyWithGapFill(status int, err error, gapFillTxHash string, gapFillSucceeded bool) {
+	c.SendErrorReplyWithTX(status, err, "")
+}
+
+func (c *journalTxnContext) Reply(replyMsg messages.ReplyWithHeaders) {
+	log.Warnf("Unable to deliver %s reply for %s", replyMsg.ReplyHeaders().MsgType, c)
+}
+
+func (c *journalTxnContext) String() string {
+	return fmt.Sprintf("Recovered[%s/%s]", c.headers.MsgType, c.headers.ID)
+}
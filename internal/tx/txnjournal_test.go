@@ -0,0 +1,365 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+var goodSendTxnJSONWithID = "{" +
+	"  \"headers\":{\"type\": \"SendTransaction\", \"id\": \"req1\"}," +
+	"  \"from\":\"" + testFromAddr + "\"," +
+	"  \"gas\":\"123\"," +
+	"  \"method\":{\"name\":\"test\"}" +
+	"}"
+
+func testJournalEntry(key, reqID string, nonce int64) *journalEntry {
+	return &journalEntry{
+		Key: key,
+		Headers: messages.CommonHeaders{
+			ID:      reqID,
+			MsgType: messages.MsgTypeSendTransaction,
+		},
+		Submitted: time.Now().UTC(),
+		From:      strings.ToLower(testFromAddr),
+		Nonce:     nonce,
+		TXHash:    "0xe2215336b09f9b5b82e36e1144ed64f40a42e61b68fdaca82549fd98b8531a89",
+	}
+}
+
+func newTestJournalProcessor(db kvstore.KVStore) *txnProcessor {
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	txnProcessor.journal = newTxnJournal(db)
+	return txnProcessor
+}
+
+func TestJournalStoreRecoverRemove(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	db.Put("other", []byte("ignored"))
+	j := newTxnJournal(db)
+
+	err := j.store(testJournalEntry("key1", "req1", 10))
+	assert.NoError(err)
+	err = j.store(testJournalEntry("key2", "req2", 11))
+	assert.NoError(err)
+
+	entries := j.recover()
+	assert.Equal(2, len(entries))
+	assert.Equal("key1", entries[0].Key)
+	assert.Equal("req1", entries[0].Headers.ID)
+	assert.Equal(int64(10), entries[0].Nonce)
+	assert.Equal("key2", entries[1].Key)
+
+	j.remove("key1")
+	entries = j.recover()
+	assert.Equal(1, len(entries))
+	assert.Equal("key2", entries[0].Key)
+}
+
+func TestJournalRecoverBadEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	db.Put(journalKeyPrefix+"bad", []byte("!json"))
+	j := newTxnJournal(db)
+
+	entries := j.recover()
+	assert.Equal(0, len(entries))
+}
+
+func TestJournalStoreFail(t *testing.T) {
+	assert := assert.New(t)
+
+	j := newTxnJournal(kvstore.NewMockKV(fmt.Errorf("pop")))
+	err := j.store(testJournalEntry("key1", "req1", 10))
+	assert.EqualError(err, "pop")
+}
+
+func TestJournalTxnContext(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &journalTxnContext{
+		headers: &messages.CommonHeaders{ID: "req1", MsgType: messages.MsgTypeSendTransaction},
+	}
+	assert.NotNil(c.Context())
+	assert.Equal("req1", c.Headers().ID)
+	assert.Equal("Recovered[SendTransaction/req1]", c.String())
+	var msg messages.SendTransaction
+	err := c.Unmarshal(&msg)
+	assert.Regexp("recovered", err)
+	c.SendErrorReply(500, fmt.Errorf("pop"))
+	c.SendErrorReplyWithGapFill(500, fmt.Errorf("pop"), "", false)
+	c.Reply(&messages.TransactionReceipt{})
+}
+
+func TestInitJournalBadPath(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "txjournal")
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "badness")
+	ioutil.WriteFile(dbPath, []byte{}, 0644)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		JournalLevelDBPath: dbPath,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.Regexp("Failed to open in-flight transaction journal", err)
+}
+
+func TestInitJournalOK(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "txjournal")
+	defer os.RemoveAll(dir)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		JournalLevelDBPath: path.Join(dir, "db"),
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.NoError(err)
+	assert.NotNil(txnProcessor.journal)
+	txnProcessor.journal.db.Close()
+}
+
+func TestJournalRecoverRebuildsNonces(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	j := newTxnJournal(db)
+	j.store(testJournalEntry("key1", "req1", 10))
+	j.store(testJournalEntry("key2", "", 12))
+	j.store(testJournalEntry("key3", "req3", 11))
+
+	txnProcessor := newTestJournalProcessor(db)
+	txnProcessor.recoverJournal()
+
+	inflightForAddr := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)]
+	assert.Equal(3, len(inflightForAddr.txnsInFlight))
+	assert.Equal(int64(12), inflightForAddr.highestNonce)
+	assert.Equal(3, len(txnProcessor.recovered))
+	assert.NotNil(txnProcessor.recovered["req1"])
+	assert.NotNil(txnProcessor.recovered["key2"])
+	assert.NotNil(txnProcessor.recovered["req3"])
+}
+
+func TestJournalResumeRecovered(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	newTxnJournal(db).store(testJournalEntry("key1", "req1", 10))
+
+	txnProcessor := newTestJournalProcessor(db)
+	testRPC := goodMessageRPC()
+	txnProcessor.rpc = testRPC
+	txnProcessor.recoverJournal()
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+	inflight := txnProcessor.recovered["req1"]
+
+	testTxnContext := &testTxnContext{}
+	var resumedHeaders *messages.CommonHeaders
+	txnProcessor.Resume(func(headers *messages.CommonHeaders, timeReceived time.Time) TxnContext {
+		resumedHeaders = headers
+		return testTxnContext
+	})
+	inflight.wg.Wait()
+
+	assert.Equal("req1", resumedHeaders.ID)
+	assert.Equal(0, len(txnProcessor.recovered))
+	assert.Equal([]string{"eth_getTransactionReceipt"}, testRPC.calls)
+	assert.Equal(0, len(testTxnContext.errorReplies))
+	assert.Equal(1, len(testTxnContext.replies))
+	assert.Equal("TransactionSuccess", testTxnContext.replies[0].ReplyHeaders().MsgType)
+
+	_, exists := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)]
+	assert.False(exists)
+	assert.Equal(0, len(db.KVS))
+}
+
+func TestJournalClaimRecoveredOnRedelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	newTxnJournal(db).store(testJournalEntry("key1", "req1", 10))
+
+	txnProcessor := newTestJournalProcessor(db)
+	testRPC := goodMessageRPC()
+	txnProcessor.rpc = testRPC
+	txnProcessor.recoverJournal()
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+	inflight := txnProcessor.recovered["req1"]
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSONWithID
+	txnProcessor.OnMessage(testTxnContext)
+	inflight.wg.Wait()
+
+	assert.Equal(0, len(txnProcessor.recovered))
+	assert.Equal([]string{"eth_getTransactionReceipt"}, testRPC.calls)
+	assert.Equal(1, len(testTxnContext.replies))
+	assert.Equal(0, len(db.KVS))
+}
+
+// testRedeliveredTxnContext is a request from a transport that redelivers requests after a restart
+type testRedeliveredTxnContext struct {
+	*testTxnContext
+	key string
+}
+
+func (c *testRedeliveredTxnContext) RedeliveryKey() string {
+	return c.key
+}
+
+func TestJournalClaimRecoveredByRedeliveryKey(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	entry := testJournalEntry("key1", "generated1", 10)
+	entry.RedeliveryKey = "in:0:5"
+	newTxnJournal(db).store(entry)
+
+	txnProcessor := newTestJournalProcessor(db)
+	testRPC := goodMessageRPC()
+	txnProcessor.rpc = testRPC
+	txnProcessor.recoverJournal()
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+	inflight := txnProcessor.recovered["in:0:5"]
+
+	// The redelivered request has no ID, so is only matched on its offset
+	testTxnContext := &testRedeliveredTxnContext{testTxnContext: &testTxnContext{}, key: "in:0:5"}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	txnProcessor.OnMessage(testTxnContext)
+	inflight.wg.Wait()
+
+	assert.Equal(0, len(txnProcessor.recovered))
+	assert.Equal([]string{"eth_getTransactionReceipt"}, testRPC.calls)
+	assert.Equal(1, len(testTxnContext.replies))
+	assert.Equal(0, len(db.KVS))
+}
+
+func TestJournalRedeliveredAfterResume(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	entry := testJournalEntry("key1", "req1", 10)
+	entry.RedeliveryKey = "in:0:5"
+	newTxnJournal(db).store(entry)
+
+	txnProcessor := newTestJournalProcessor(db)
+	testRPC := goodMessageRPC()
+	txnProcessor.rpc = testRPC
+	txnProcessor.recoverJournal()
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+	inflight := txnProcessor.recovered["in:0:5"]
+
+	resumedContext := &testTxnContext{}
+	txnProcessor.Resume(func(headers *messages.CommonHeaders, timeReceived time.Time) TxnContext {
+		return resumedContext
+	})
+	inflight.wg.Wait()
+	assert.Equal(1, len(resumedContext.replies))
+
+	// A late redelivery completes the request, without submitting the transaction again
+	testTxnContext := &testRedeliveredTxnContext{testTxnContext: &testTxnContext{}, key: "in:0:5"}
+	testTxnContext.jsonMsg = goodSendTxnJSONWithID
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Equal([]string{"eth_getTransactionReceipt"}, testRPC.calls)
+	assert.Equal(409, testTxnContext.errorReplies[0].status)
+	assert.Regexp("already resumed", testTxnContext.errorReplies[0].err)
+	assert.Equal(entry.TXHash, testTxnContext.errorReplies[0].txHash)
+}
+
+func TestJournalWrittenWithRedeliveryKey(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	txnProcessor := newTestJournalProcessor(db)
+	testRPC := goodMessageRPC()
+	testRPC.ethGetTransactionReceiptErr = fmt.Errorf("not yet")
+	txnProcessor.Init(testRPC)
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	testTxnContext := &testRedeliveredTxnContext{testTxnContext: &testTxnContext{}, key: "in:0:5"}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	txnProcessor.OnMessage(testTxnContext)
+	inflight := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0]
+
+	entries := txnProcessor.journal.recover()
+	assert.Equal(1, len(entries))
+	assert.Equal("in:0:5", entries[0].RedeliveryKey)
+	inflight.wg.Wait()
+}
+
+func TestJournalWrittenOnSubmit(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	txnProcessor := newTestJournalProcessor(db)
+	testRPC := goodMessageRPC()
+	testRPC.ethGetTransactionReceiptErr = fmt.Errorf("not yet")
+	txnProcessor.Init(testRPC)
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSONWithID
+	txnProcessor.OnMessage(testTxnContext)
+	inflight := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0]
+
+	entries := txnProcessor.journal.recover()
+	assert.Equal(1, len(entries))
+	assert.Equal(inflight.journaled.Key, entries[0].Key)
+	assert.Equal("req1", entries[0].Headers.ID)
+	assert.Equal(testRPC.ethSendTransactionResult, entries[0].TXHash)
+
+	// Timing out removes the entry
+	inflight.wg.Wait()
+	assert.Equal(0, len(db.KVS))
+}
+
+func TestJournalWriteFailContinues(t *testing.T) {
+	assert := assert.New(t)
+
+	db := kvstore.NewMockKV(nil)
+	db.StoreErr = fmt.Errorf("pop")
+	txnProcessor := newTestJournalProcessor(db)
+	testRPC := goodMessageRPC()
+	txnProcessor.Init(testRPC)
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSONWithID
+	txnProcessor.OnMessage(testTxnContext)
+	inflight := txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0]
+	inflight.wg.Wait()
+
+	assert.Nil(inflight.journaled)
+	assert.Equal(1, len(testTxnContext.replies))
+}
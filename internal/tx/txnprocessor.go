@@ -15,8 +15,10 @@
 package tx
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +29,7 @@ import (
 	ethbinding "github.com/kaleido-io/ethbinding/pkg"
 	"github.com/kaleido-io/ethconnect/internal/errors"
 	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
 	"github.com/kaleido-io/ethconnect/internal/messages"
 	"github.com/kaleido-io/ethconnect/internal/utils"
 	log "github.com/sirupsen/logrus"
@@ -40,10 +43,29 @@ const (
 // for tracking all in-flight messages
 type TxnProcessor interface {
 	OnMessage(TxnContext)
-	Init(eth.RPCClient)
+	Init(eth.RPCClient) error
+	Resume(RecoveredTxnContextFactory)
+	WatchReorgs(RecoveredTxnContextFactory)
 	ResolveAddress(from string) (resolvedFrom string, err error)
+	PendingTransaction(id string) (*messages.PendingTransaction, error)
+	CancelTransaction(ctx context.Context, id string) (*messages.PendingTransaction, error)
+	ReplaceTransaction(ctx context.Context, id string, fees *messages.TransactionFees) (*messages.PendingTransaction, error)
+	NonceStates() []*messages.NonceState
+	NonceState(address string) (*messages.NonceState, error)
+	ResyncNonce(ctx context.Context, address string) (*messages.NonceState, error)
+	FillNonceGap(ctx context.Context, address string, nonce int64) (*messages.PendingTransaction, error)
+	ReleaseScheduled(RecoveredTxnContextFactory)
+	ScheduledTransactions() []*messages.ScheduledTransaction
+	ScheduledTransaction(id string) (*messages.ScheduledTransaction, error)
+	CancelScheduled(id string) (*messages.ScheduledTransaction, error)
+	Close()
 }
 
+// RecoveredTxnContextFactory builds a context to deliver a reply that is not associated with
+// an in-flight request. Such as for a transaction recovered from the journal, as the original
+// context does not survive a restart, or a notification for a receipt already delivered
+type RecoveredTxnContextFactory func(headers *messages.CommonHeaders, timeReceived time.Time) TxnContext
+
 var highestID = 1000000
 
 type inflightTxn struct {
@@ -61,6 +83,14 @@ type inflightTxn struct {
 	signer           eth.TXSigner
 	gapFillSucceeded bool
 	gapFillTxHash    string
+	journaled        *journalEntry
+	lastSubmitted    time.Time
+	replacements     int
+	requestID        string
+	txLock           sync.Mutex // held while checking for, or changing, the submitted transaction
+	complete         bool
+	confirmations    int  // blocks required, including the one containing the transaction
+	rateLimited      bool // holds a slot in the rate limiter, until cancelled
 }
 
 func (i *inflightTxn) nonceNumber() json.Number {
@@ -77,14 +107,27 @@ func (i *inflightTxn) String() string {
 
 // TxnProcessorConf configuration for the message processor
 type TxnProcessorConf struct {
-	AlwaysManageNonce  bool            `json:"alwaysManageNonce"`
-	AttemptGapFill     bool            `json:"attemptGapFill"`
-	MaxTXWaitTime      int             `json:"maxTXWaitTime"`
-	SendConcurrency    int             `json:"sendConcurrency"`
-	OrionPrivateAPIS   bool            `json:"orionPrivateAPIs"`
-	HexValuesInReceipt bool            `json:"hexValuesInReceipt"`
-	AddressBookConf    AddressBookConf `json:"addressBook"`
-	HDWalletConf       HDWalletConf    `json:"hdWallet"`
+	AlwaysManageNonce  bool               `json:"alwaysManageNonce"`
+	AttemptGapFill     bool               `json:"attemptGapFill"`
+	MaxTXWaitTime      int                `json:"maxTXWaitTime"`
+	SendConcurrency    int                `json:"sendConcurrency"`
+	OrionPrivateAPIS   bool               `json:"orionPrivateAPIs"`
+	HexValuesInReceipt bool               `json:"hexValuesInReceipt"`
+	AddressBookConf    AddressBookConf    `json:"addressBook"`
+	HDWalletConf       HDWalletConf       `json:"hdWallet"`
+	KeystoreConf       KeystoreConf       `json:"keystore"`
+	RemoteSignerConf   RemoteSignerConf   `json:"remoteSigner"`
+	JournalLevelDBPath string             `json:"journalDB,omitempty"`
+	GasReplacement     GasReplacementConf `json:"gasReplacement"`
+	GasPrice           GasPriceConf       `json:"gasPrice"`
+	GasEstimate        GasEstimateConf    `json:"gasEstimate"`
+	Confirmations      int                `json:"confirmations"`
+	ReorgWatch         ReorgWatchConf     `json:"reorgWatch"`
+	ReceiptPoll        ReceiptPollConf    `json:"receiptPoll"`
+	NewHeads           NewHeadsConf       `json:"newHeads"`
+	RateLimit          RateLimitConf      `json:"rateLimit"`
+	RevertReason       RevertReasonConf   `json:"revertReason"`
+	Scheduler          SchedulerConf      `json:"scheduler"`
 }
 
 type inflightTxnState struct {
@@ -100,9 +143,23 @@ type txnProcessor struct {
 	rpc                eth.RPCClient
 	addressBook        AddressBook
 	hdwallet           HDWallet
+	keystore           *keystore
+	remoteSigners      *remoteSigners
 	conf               *TxnProcessorConf
 	rpcConf            *eth.RPCConf
 	concurrencySlots   chan bool
+	journal            *txnJournal
+	recovered          map[string]*inflightTxn
+	resumed            map[string]string
+	replaceInterval    time.Duration
+	replaceMaxGasPrice *big.Int
+	gasPricer          *gasPricer
+	reorgs             *reorgWatcher
+	receiptPoller      *receiptPoller
+	blocks             *blockListener
+	rateLimiter        *rateLimiter
+	scheduler          *scheduler
+	batches            map[string]*batch
 }
 
 // NewTxnProcessor constructor for message procss
@@ -117,11 +174,27 @@ func NewTxnProcessor(conf *TxnProcessorConf, rpcConf *eth.RPCConf) TxnProcessor
 		conf:               conf,
 		rpcConf:            rpcConf,
 		concurrencySlots:   make(chan bool, conf.SendConcurrency),
+		recovered:          make(map[string]*inflightTxn),
+		resumed:            make(map[string]string),
+		batches:            make(map[string]*batch),
+		gasPricer:          newGasPricer(&conf.GasPrice),
+		reorgs:             newReorgWatcher(&conf.ReorgWatch, conf.HexValuesInReceipt),
+		receiptPoller:      newReceiptPoller(&conf.ReceiptPoll),
+		rateLimiter:        newRateLimiter(&conf.RateLimit),
 	}
+	p.blocks = newBlockListener(&conf.NewHeads, p.receiptPoller)
+	p.gasPricer.blocks = p.blocks
 	return p
 }
 
-func (p *txnProcessor) Init(rpc eth.RPCClient) {
+// Close stops the background processing started by the txn processor, such as watching
+// for reorgs and listening for new blocks. Transactions that are in-flight are not waited for
+func (p *txnProcessor) Close() {
+	p.reorgs.close()
+	p.blocks.close()
+}
+
+func (p *txnProcessor) Init(rpc eth.RPCClient) error {
 	p.rpc = rpc
 	p.maxTXWaitTime = time.Duration(p.conf.MaxTXWaitTime) * time.Second
 	if p.conf.AddressBookConf.AddressbookURLPrefix != "" {
@@ -129,6 +202,199 @@ func (p *txnProcessor) Init(rpc eth.RPCClient) {
 	}
 	if p.conf.HDWalletConf.URLTemplate != "" {
 		p.hdwallet = newHDWallet(&p.conf.HDWalletConf)
+	} else if p.conf.HDWalletConf.Local.configured() {
+		var err error
+		if p.hdwallet, err = newLocalHDWallet(&p.conf.HDWalletConf); err != nil {
+			return err
+		}
+	}
+	if p.conf.KeystoreConf.Path != "" {
+		var err error
+		if p.keystore, err = newKeystore(&p.conf.KeystoreConf); err != nil {
+			return err
+		}
+	}
+	if len(p.conf.RemoteSignerConf.Endpoints) > 0 {
+		var err error
+		if p.remoteSigners, err = newRemoteSigners(&p.conf.RemoteSignerConf); err != nil {
+			return err
+		}
+	}
+	if err := p.initGasReplacement(); err != nil {
+		return err
+	}
+	if err := p.gasPricer.init(); err != nil {
+		return err
+	}
+	if p.conf.JournalLevelDBPath != "" {
+		db, err := kvstore.NewLDBKeyValueStore(p.conf.JournalLevelDBPath)
+		if err != nil {
+			return errors.Errorf(errors.TransactionSendJournalLoad, p.conf.JournalLevelDBPath, err)
+		}
+		p.journal = newTxnJournal(db)
+		p.recoverJournal()
+	}
+	if p.conf.Scheduler.LevelDBPath != "" {
+		db, err := kvstore.NewLDBKeyValueStore(p.conf.Scheduler.LevelDBPath)
+		if err != nil {
+			return errors.Errorf(errors.TransactionScheduleDBLoad, p.conf.Scheduler.LevelDBPath, err)
+		}
+		p.scheduler = newScheduler(&p.conf.Scheduler, db)
+	}
+	p.blocks.start(rpc, p.rpcConf.RPC.Endpoints())
+	return nil
+}
+
+// recoverJournal rebuilds the in-flight list and nonce state for each address,
+// from the transactions that were submitted before a restart.
+// Tracking of receipts starts when the request is redelivered, or on Resume
+func (p *txnProcessor) recoverJournal() {
+	p.inflightTxnsLock.Lock()
+	defer p.inflightTxnsLock.Unlock()
+	for _, entry := range p.journal.recover() {
+		headers := entry.Headers
+		inflight := &inflightTxn{
+			id:              highestID,
+			from:            entry.From,
+			nodeAssignNonce: entry.NodeAssignNonce,
+			nonce:           entry.Nonce,
+			privacyGroupID:  entry.PrivacyGroupID,
+			registerAs:      entry.RegisterAs,
+			confirmations:   entry.Confirmations,
+			rpc:             p.rpc,
+			journaled:       entry,
+			requestID:       headers.ID,
+			tx: &eth.Txn{
+				Hash:             entry.TXHash,
+				SubmittedHashes:  entry.SubmittedHashes,
+				CancelHashes:     entry.CancelHashes,
+				NodeAssignNonce:  entry.NodeAssignNonce,
+				OrionPrivateAPIS: p.conf.OrionPrivateAPIS,
+				PrivateFrom:      entry.PrivateFrom,
+				PrivacyGroupID:   entry.PrivacyGroupID,
+				GasEstimate:      entry.GasEstimate,
+			},
+			txnContext: &journalTxnContext{headers: &headers, timeReceived: entry.Submitted},
+		}
+		highestID++
+		inflightForAddr, exists := p.inflightTxns[inflight.from]
+		if !exists {
+			inflightForAddr = &inflightTxnState{highestNonce: -1}
+			p.inflightTxns[inflight.from] = inflightForAddr
+		}
+		inflightForAddr.txnsInFlight = append(inflightForAddr.txnsInFlight, inflight)
+		if !inflight.nodeAssignNonce && inflight.nonce > inflightForAddr.highestNonce {
+			inflightForAddr.highestNonce = inflight.nonce
+		}
+		recoveredKey := entry.RedeliveryKey
+		if recoveredKey == "" {
+			recoveredKey = headers.ID
+		}
+		if recoveredKey == "" {
+			recoveredKey = entry.Key
+		}
+		p.recovered[recoveredKey] = inflight
+		log.Infof("In-flight %d recovered. TX=%s nonce=%d addr=%s request=%s", inflight.id, entry.TXHash, inflight.nonce, inflight.from, headers.ID)
+	}
+}
+
+// Resume starts tracking the receipts for all recovered transactions that have not
+// been claimed by a redelivered request, using the supplied factory to build the
+// context that will deliver each reply
+func (p *txnProcessor) Resume(newTxnContext RecoveredTxnContextFactory) {
+	p.inflightTxnsLock.Lock()
+	resumed := make([]*inflightTxn, 0, len(p.recovered))
+	for key, inflight := range p.recovered {
+		jc := inflight.txnContext.(*journalTxnContext)
+		inflight.txnContext = newTxnContext(jc.headers, jc.timeReceived)
+		resumed = append(resumed, inflight)
+		delete(p.recovered, key)
+		if inflight.journaled.RedeliveryKey != "" {
+			p.resumed[key] = inflight.tx.Hash
+		}
+	}
+	p.inflightTxnsLock.Unlock()
+
+	for _, inflight := range resumed {
+		log.Infof("In-flight %d resumed. %s", inflight.id, inflight)
+		p.trackMining(inflight, inflight.tx)
+	}
+}
+
+// claimRecovered checks if an incoming message is a redelivery of a request that
+// was recovered from the journal, and if so resumes tracking of the existing
+// transaction rather than submitting a new one
+func (p *txnProcessor) claimRecovered(txnContext TxnContext) bool {
+	key := redeliveryKey(txnContext)
+	if key == "" {
+		key = txnContext.Headers().ID
+	}
+	if key == "" {
+		return false
+	}
+	p.inflightTxnsLock.Lock()
+	inflight, exists := p.recovered[key]
+	if exists {
+		delete(p.recovered, key)
+	}
+	resumedTXHash, resumed := p.resumed[key]
+	p.inflightTxnsLock.Unlock()
+	if resumed {
+		// The redelivery arrived after the transaction was resumed, so the receipt is already
+		// being delivered as a notification. We complete the request without submitting it again
+		txnContext.SendErrorReplyWithTX(409, errors.Errorf(errors.TransactionSendRecoveredResumed, resumedTXHash), resumedTXHash)
+		return true
+	}
+	if !exists {
+		return false
+	}
+
+	inflight.txnContext = txnContext
+	log.Infof("In-flight %d claimed by redelivered request. %s", inflight.id, inflight)
+	p.trackMining(inflight, inflight.tx)
+	return true
+}
+
+// redeliveryKey returns the key that matches a request when it is redelivered after a
+// restart, for transports that redeliver requests
+func redeliveryKey(txnContext TxnContext) string {
+	if rc, ok := txnContext.(RedeliveredTxnContext); ok {
+		return rc.RedeliveryKey()
+	}
+	return ""
+}
+
+// journalInflight records a submitted transaction in the journal, if enabled
+func (p *txnProcessor) journalInflight(inflight *inflightTxn, tx *eth.Txn) {
+	if p.journal == nil {
+		return
+	}
+	entry := &journalEntry{
+		Key:             utils.UUIDv4(),
+		Headers:         *inflight.txnContext.Headers(),
+		RedeliveryKey:   redeliveryKey(inflight.txnContext),
+		Submitted:       time.Now().UTC(),
+		From:            inflight.from,
+		Nonce:           inflight.nonce,
+		NodeAssignNonce: inflight.nodeAssignNonce,
+		PrivateFrom:     tx.PrivateFrom,
+		PrivacyGroupID:  inflight.privacyGroupID,
+		RegisterAs:      inflight.registerAs,
+		Confirmations:   inflight.confirmations,
+		TXHash:          tx.Hash,
+		GasEstimate:     tx.GasEstimate,
+	}
+	if tx.EthTX != nil {
+		entry.Gas = tx.EthTX.Gas()
+	}
+	if err := p.journal.store(entry); err != nil {
+		// The transaction is already submitted, so we continue to track it in memory
+		log.Errorf("Failed to journal in-flight %d: %s", inflight.id, err)
+		return
+	}
+	inflight.journaled = entry
+	if sc, ok := inflight.txnContext.(*scheduledTxnContext); ok {
+		sc.handedOff()
 	}
 }
 
@@ -138,6 +404,9 @@ func CobraInitTxnProcessor(cmd *cobra.Command, txconf *TxnProcessorConf) {
 	cmd.Flags().BoolVarP(&txconf.HexValuesInReceipt, "hex-values", "H", false, "Include hex values for large numbers in receipts (as well as numeric strings)")
 	cmd.Flags().BoolVarP(&txconf.AlwaysManageNonce, "predict-nonces", "P", false, "Predict the next nonce before sending (default=false for node-signed txns)")
 	cmd.Flags().BoolVarP(&txconf.OrionPrivateAPIS, "orion-privapi", "G", false, "Use Orion JSON/RPC API semantics for private transactions")
+	cmd.Flags().StringVarP(&txconf.JournalLevelDBPath, "tx-journal", "N", "", "Level DB location for the journal of in-flight transactions, resumed on restart")
+	cmd.Flags().StringVar(&txconf.Scheduler.LevelDBPath, "scheduler-db", "", "Level DB location for transactions scheduled with notBefore or notBeforeBlock, until they are due")
+	cmd.Flags().StringVar(&txconf.RevertReason.Mode, "revert-reasons", "", "Find the reason for transactions that fail when mined: 'call' replays with eth_call, 'trace' uses the callTracer of debug_traceTransaction")
 	return
 }
 
@@ -150,6 +419,9 @@ func (p *txnProcessor) OnMessage(txnContext TxnContext) {
 	var unmarshalErr error
 	headers := txnContext.Headers()
 	log.Debugf("Processing %+v", headers)
+	if p.claimRecovered(txnContext) {
+		return
+	}
 	switch headers.MsgType {
 	case messages.MsgTypeDeployContract:
 		var deployContractMsg messages.DeployContract
@@ -165,6 +437,20 @@ func (p *txnProcessor) OnMessage(txnContext TxnContext) {
 		}
 		p.OnSendTransactionMessage(txnContext, &sendTransactionMsg)
 		break
+	case messages.MsgTypeSendRawTransaction:
+		var sendRawTransactionMsg messages.SendRawTransaction
+		if unmarshalErr = txnContext.Unmarshal(&sendRawTransactionMsg); unmarshalErr != nil {
+			break
+		}
+		p.OnSendRawTransactionMessage(txnContext, &sendRawTransactionMsg)
+		break
+	case messages.MsgTypeBatch:
+		var batchMsg messages.Batch
+		if unmarshalErr = txnContext.Unmarshal(&batchMsg); unmarshalErr != nil {
+			break
+		}
+		p.OnBatchMessage(txnContext, &batchMsg)
+		break
 	default:
 		unmarshalErr = errors.Errorf(errors.TransactionSendMsgTypeUnknown, headers.MsgType)
 	}
@@ -194,10 +480,35 @@ func (p *txnProcessor) resolveSigner(from string) (signer eth.TXSigner, err erro
 		if signer, err = p.hdwallet.SignerFor(hdWalletRequest); err != nil {
 			return
 		}
+	} else {
+		// Addresses with a key in the keystore are signed locally, and those routed to a
+		// remote signing service are signed by it. Otherwise the node signs
+		if p.keystore != nil {
+			signer = p.keystore.signerFor(from)
+		}
+		if signer == nil && p.remoteSigners != nil {
+			signer = p.remoteSigners.signerFor(from)
+		}
 	}
 	return
 }
 
+// resolveSender returns the signer, if we sign the transaction, and the RPC to send it with.
+// The from address of the message is updated to the address of the signer
+func (p *txnProcessor) resolveSender(ctx context.Context, msg *messages.TransactionCommon) (signer eth.TXSigner, rpc eth.RPCClient, err error) {
+	rpc = p.rpc
+	if signer, err = p.resolveSigner(msg.From); signer != nil {
+		msg.From = signer.Address()
+	} else if err != nil {
+		return nil, nil, err
+	} else if p.addressBook != nil {
+		if rpc, err = p.addressBook.lookup(ctx, msg.From); err != nil {
+			return nil, nil, err
+		}
+	}
+	return signer, rpc, nil
+}
+
 // newInflightWrapper uses the supplied transaction, the inflight txn list
 // and the ethereum node's transction count to determine the right next
 // nonce for the transaction.
@@ -206,19 +517,21 @@ func (p *txnProcessor) resolveSigner(from string) (signer eth.TXSigner, err erro
 func (p *txnProcessor) addInflightWrapper(txnContext TxnContext, msg *messages.TransactionCommon) (inflight *inflightTxn, err error) {
 
 	inflight = &inflightTxn{
-		txnContext: txnContext,
+		txnContext:    txnContext,
+		requestID:     txnContext.Headers().ID,
+		confirmations: p.conf.Confirmations,
+	}
+	if msg.Confirmations != "" {
+		confirmations, parseErr := msg.Confirmations.Int64()
+		if parseErr != nil || confirmations < 0 {
+			return nil, errors.Errorf(errors.TransactionSendBadConfirmations, msg.Confirmations)
+		}
+		inflight.confirmations = int(confirmations)
 	}
 
 	// Use the correct RPC for sending transactions
-	inflight.rpc = p.rpc
-	if inflight.signer, err = p.resolveSigner(msg.From); inflight.signer != nil {
-		msg.From = inflight.signer.Address()
-	} else if err != nil {
+	if inflight.signer, inflight.rpc, err = p.resolveSender(txnContext.Context(), msg); err != nil {
 		return nil, err
-	} else if p.addressBook != nil {
-		if inflight.rpc, err = p.addressBook.lookup(txnContext.Context(), msg.From); err != nil {
-			return
-		}
 	}
 
 	// Validate the from address, and normalize to lower case with 0x prefix
@@ -228,6 +541,13 @@ func (p *txnProcessor) addInflightWrapper(txnContext TxnContext, msg *messages.T
 	}
 	inflight.from = strings.ToLower(from.Hex())
 
+	// Release the capacity taken under the submission rate limits, if we fail to add the transaction
+	defer func() {
+		if err != nil && inflight.rateLimited {
+			p.rateLimiter.release(inflight.from)
+		}
+	}()
+
 	// Need to resolve privateFrom/privateFor to a privacyGroupID for Orion
 	if p.conf.OrionPrivateAPIS {
 		if msg.PrivacyGroupID != "" && len(msg.PrivateFor) > 0 {
@@ -245,7 +565,9 @@ func (p *txnProcessor) addInflightWrapper(txnContext TxnContext, msg *messages.T
 	nodeAssignNonce := inflight.signer == nil && !p.conf.AlwaysManageNonce
 
 	// Hold the lock just while we're adding it to the map and dealing with nonce checking.
-	p.inflightTxnsLock.Lock()
+	if err = p.acquireAndLock(inflight, txnContext); err != nil {
+		return
+	}
 
 	// The user can supply a nonce and manage them externally, using their own
 	// application-side list of transactions, to prevent the possibility of
@@ -324,6 +646,46 @@ func (p *txnProcessor) addInflightWrapper(txnContext TxnContext, msg *messages.T
 	return
 }
 
+// acquireAndLock waits for capacity under the submission rate limits, and takes the in-flight
+// lock. Capacity is not held while waiting for a batch from the same sender to be dispatched,
+// as the items of the batch might need that capacity
+func (p *txnProcessor) acquireAndLock(inflight *inflightTxn, txnContext TxnContext) error {
+	if !p.rateLimiter.enabled() {
+		p.inflightTxnsLock.Lock()
+		p.waitForBatch(inflight.from, txnContext)
+		return nil
+	}
+	bpCtx, block := txnContext.(BackpressureTxnContext)
+	for {
+		p.inflightTxnsLock.Lock()
+		p.waitForBatch(inflight.from, txnContext)
+		p.inflightTxnsLock.Unlock()
+
+		if err := p.rateLimiter.acquire(inflight.from, block && bpCtx.Backpressure()); err != nil {
+			return err
+		}
+		inflight.rateLimited = true
+
+		// A batch from the sender might have started while we waited for capacity
+		p.inflightTxnsLock.Lock()
+		if !p.batchBlocks(inflight.from, txnContext) {
+			return nil
+		}
+		p.inflightTxnsLock.Unlock()
+		p.rateLimiter.release(inflight.from)
+		inflight.rateLimited = false
+	}
+}
+
+// addInflightErrStatus returns 429 for requests rejected by the submission rate limits,
+// so the caller knows to retry later
+func addInflightErrStatus(err error) int {
+	if IsRateLimited(err) {
+		return 429
+	}
+	return 400
+}
+
 func (p *txnProcessor) cancelInFlight(inflight *inflightTxn, submitted bool) {
 	var before, after int
 	var highestNonce int64 = -1
@@ -360,6 +722,15 @@ func (p *txnProcessor) cancelInFlight(inflight *inflightTxn, submitted bool) {
 	}
 	p.inflightTxnsLock.Unlock()
 
+	if inflight.rateLimited {
+		inflight.rateLimited = false
+		p.rateLimiter.release(inflight.from)
+	}
+
+	if inflight.journaled != nil {
+		p.journal.remove(inflight.journaled.Key)
+	}
+
 	log.Infof("In-flight %d complete. nonce=%d addr=%s nan=%t sub=%t before=%d after=%d highest=%d", inflight.id, inflight.nonce, inflight.from, inflight.nodeAssignNonce, submitted, before, after, highestNonce)
 
 	// If we've got a gap potential, we need to submit a gap-fill TX
@@ -374,21 +745,28 @@ func (p *txnProcessor) cancelInFlight(inflight *inflightTxn, submitted bool) {
 // to complete. Only
 func (p *txnProcessor) submitGapFillTX(inflight *inflightTxn) {
 	if p.conf.AttemptGapFill {
-		tx, err := eth.NewNilTX(inflight.from, inflight.nonce, inflight.signer)
-		if err == nil {
-			inflight.gapFillTxHash = tx.EthTX.Hash().String()
-			err = tx.Send(inflight.txnContext.Context(), inflight.rpc)
-			if err != nil {
-				inflight.gapFillSucceeded = false
-				log.Warnf("Submission of gap-fill TX '%s' failed: %s", tx.Hash, err)
-			} else {
-				inflight.gapFillSucceeded = true
-				log.Infof("Submission of gap-fill TX '%s' completed", tx.Hash)
-			}
-		}
+		p.sendGapFillTX(inflight.txnContext.Context(), inflight)
 	}
 }
 
+// sendGapFillTX sends the gap-fill TX for the nonce of the in-flight transaction
+func (p *txnProcessor) sendGapFillTX(ctx context.Context, inflight *inflightTxn) error {
+	tx, err := eth.NewNilTX(inflight.from, inflight.nonce, inflight.signer)
+	if err != nil {
+		return err
+	}
+	inflight.gapFillTxHash = tx.EthTX.Hash().String()
+	err = tx.Send(ctx, inflight.rpc)
+	if err != nil {
+		inflight.gapFillSucceeded = false
+		log.Warnf("Submission of gap-fill TX '%s' failed: %s", tx.Hash, err)
+	} else {
+		inflight.gapFillSucceeded = true
+		log.Infof("Submission of gap-fill TX '%s' completed", tx.Hash)
+	}
+	return err
+}
+
 // waitForCompletion is the goroutine to track a transaction through
 // to completion and send the result
 func (p *txnProcessor) waitForCompletion(inflight *inflightTxn, initialWaitDelay time.Duration) {
@@ -396,25 +774,46 @@ func (p *txnProcessor) waitForCompletion(inflight *inflightTxn, initialWaitDelay
 	// The initial delay is passed in, based on updates from all the other
 	// go routines that are tracking transactions. The idea is to minimize
 	// both latency beyond the block period, and avoiding spamming the node
-	// with REST calls for long block periods, or when there is a backlog
+	// with REST calls for long block periods, or when there is a backlog.
+	// When subscribed to newHeads, we instead check each time a block arrives
 	replyWaitStart := time.Now().UTC()
-	time.Sleep(initialWaitDelay)
+	deadline := replyWaitStart.Add(p.maxTXWaitTime)
+	p.blocks.waitForBlock(initialWaitDelay, deadline)
 
-	var isMined, timedOut bool
+	var isMined, isConfirmed, timedOut bool
 	var err error
 	var retries int
-	var elapsed time.Duration
-	for !isMined && !timedOut {
+	var elapsed, minedElapsed time.Duration
+	var confirmations, headBlock uint64
+	for !isConfirmed && !timedOut {
 
-		if isMined, err = inflight.tx.GetTXReceipt(inflight.txnContext.Context(), p.rpc); err != nil {
+		if isMined, err = p.pollReceipt(inflight); err != nil {
 			// We wait even on connectivity errors, as we've submitted the transaction and
 			// we want to provide a receipt if connectivity resumes within the timeout
 			log.Infof("Failed to get receipt for %s (retries=%d): %s", inflight, retries, err)
 		}
 
 		elapsed = time.Now().UTC().Sub(replyWaitStart)
+		if isMined {
+			if minedElapsed == 0 {
+				minedElapsed = elapsed
+			}
+			// The receipt is checked again on each pass, as the block containing
+			// the transaction might be replaced while we wait for confirmations
+			if isConfirmed, confirmations, headBlock, err = p.checkConfirmations(inflight); err != nil {
+				log.Infof("Failed to check confirmations for %s (retries=%d): %s", inflight, retries, err)
+			}
+		}
 		timedOut = elapsed > p.maxTXWaitTime
 		if !isMined && !timedOut {
+			p.replaceIfStuck(inflight)
+		} else if isConfirmed || timedOut {
+			// No further changes can be made to the transaction
+			inflight.complete = true
+		}
+		inflight.txLock.Unlock()
+
+		if !isConfirmed && !timedOut {
 			// Need to have the inflight lock to calculate the delay, but not
 			// while we're waiting
 			p.inflightTxnsLock.Lock()
@@ -422,7 +821,7 @@ func (p *txnProcessor) waitForCompletion(inflight *inflightTxn, initialWaitDelay
 			p.inflightTxnsLock.Unlock()
 
 			log.Debugf("Receipt not available after %.2fs (retries=%d): %s", elapsed.Seconds(), retries, inflight)
-			time.Sleep(delayBeforeRetry)
+			p.blocks.waitForBlock(delayBeforeRetry, deadline)
 			retries++
 		}
 	}
@@ -430,13 +829,16 @@ func (p *txnProcessor) waitForCompletion(inflight *inflightTxn, initialWaitDelay
 	if timedOut {
 		if err != nil {
 			inflight.txnContext.SendErrorReplyWithTX(500, errors.Errorf(errors.TransactionSendReceiptCheckError, retries, err), inflight.tx.Hash)
+		} else if isMined {
+			blockNumber := inflight.tx.Receipt.BlockNumber.ToInt().Uint64()
+			inflight.txnContext.SendErrorReplyWithTX(408, errors.Errorf(errors.TransactionSendConfirmationsTimeout, blockNumber, confirmations, inflight.confirmations), inflight.tx.Hash)
 		} else {
 			inflight.txnContext.SendErrorReplyWithTX(408, errors.Errorf(errors.TransactionSendReceiptCheckTimeout), inflight.tx.Hash)
 		}
 	} else {
 		// Update the stats
 		p.inflightTxnsLock.Lock()
-		p.inflightTxnDelayer.ReportSuccess(elapsed)
+		p.inflightTxnDelayer.ReportSuccess(minedElapsed)
 		p.inflightTxnsLock.Unlock()
 
 		receipt := inflight.tx.Receipt
@@ -445,54 +847,40 @@ func (p *txnProcessor) waitForCompletion(inflight *inflightTxn, initialWaitDelay
 
 		// Build our reply
 		var reply messages.TransactionReceipt
-		if isSuccess {
+		if inflight.tx.IsCancelled() {
+			reply.Headers.MsgType = messages.MsgTypeTransactionCancelled
+		} else if isSuccess {
 			reply.Headers.MsgType = messages.MsgTypeTransactionSuccess
 		} else {
 			reply.Headers.MsgType = messages.MsgTypeTransactionFailure
 		}
-		reply.BlockHash = receipt.BlockHash
-		if p.conf.HexValuesInReceipt {
-			reply.BlockNumberHex = receipt.BlockNumber
-		}
-		if receipt.BlockNumber != nil {
-			reply.BlockNumberStr = receipt.BlockNumber.ToInt().Text(10)
-		}
-		reply.ContractAddress = receipt.ContractAddress
+		setReceiptFields(&reply, &receipt, p.conf.HexValuesInReceipt)
 		reply.RegisterAs = inflight.registerAs
-		if p.conf.HexValuesInReceipt {
-			reply.CumulativeGasUsedHex = receipt.CumulativeGasUsed
-		}
-		if receipt.CumulativeGasUsed != nil {
-			reply.CumulativeGasUsedStr = receipt.CumulativeGasUsed.ToInt().Text(10)
-		}
-		reply.From = receipt.From
-		if p.conf.HexValuesInReceipt {
-			reply.GasUsedHex = receipt.GasUsed
-		}
-		if receipt.GasUsed != nil {
-			reply.GasUsedStr = receipt.GasUsed.ToInt().Text(10)
-		}
 		nonceHex := ethbinding.HexUint64(inflight.nonce)
 		if p.conf.HexValuesInReceipt {
 			reply.NonceHex = &nonceHex
 		}
 		reply.NonceStr = strconv.FormatInt(inflight.nonce, 10)
-		if p.conf.HexValuesInReceipt {
-			reply.StatusHex = receipt.Status
+
+		reply.SubmittedHashes = inflight.tx.SubmittedHashes
+		if inflight.confirmations > 0 {
+			reply.ConfirmationsStr = strconv.FormatUint(confirmations, 10)
+			reply.HeadBlockNumberStr = strconv.FormatUint(headBlock, 10)
 		}
-		if receipt.Status != nil {
-			reply.StatusStr = receipt.Status.ToInt().Text(10)
+		if inflight.tx.EthTX != nil {
+			reply.GasStr = strconv.FormatUint(inflight.tx.EthTX.Gas(), 10)
+		} else if inflight.journaled != nil && inflight.journaled.Gas > 0 {
+			reply.GasStr = strconv.FormatUint(inflight.journaled.Gas, 10)
 		}
-		reply.To = receipt.To
-		reply.TransactionHash = receipt.TransactionHash
-		if p.conf.HexValuesInReceipt {
-			reply.TransactionIndexHex = receipt.TransactionIndex
+		if inflight.tx.GasEstimate > 0 {
+			reply.GasEstimateStr = strconv.FormatUint(inflight.tx.GasEstimate, 10)
 		}
-		if receipt.TransactionIndex != nil {
-			reply.TransactionIndexStr = strconv.FormatUint(uint64(*receipt.TransactionIndex), 10)
+		if reply.Headers.MsgType == messages.MsgTypeTransactionFailure && p.conf.RevertReason.enabled() {
+			p.addRevertReason(inflight, &reply)
 		}
 
 		inflight.txnContext.Reply(&reply)
+		p.reorgs.watch(inflight, &reply)
 	}
 
 	// We've submitted the transaction, even if we didn't get a receipt within our timeout.
@@ -500,12 +888,113 @@ func (p *txnProcessor) waitForCompletion(inflight *inflightTxn, initialWaitDelay
 	inflight.wg.Done()
 }
 
+// setReceiptFields copies the fields that come from the receipt on the node into a reply
+func setReceiptFields(reply *messages.TransactionReceipt, receipt *eth.TxnReceipt, hexValues bool) {
+	reply.BlockHash = receipt.BlockHash
+	if hexValues {
+		reply.BlockNumberHex = receipt.BlockNumber
+	}
+	if receipt.BlockNumber != nil {
+		reply.BlockNumberStr = receipt.BlockNumber.ToInt().Text(10)
+	}
+	reply.ContractAddress = receipt.ContractAddress
+	if hexValues {
+		reply.CumulativeGasUsedHex = receipt.CumulativeGasUsed
+	}
+	if receipt.CumulativeGasUsed != nil {
+		reply.CumulativeGasUsedStr = receipt.CumulativeGasUsed.ToInt().Text(10)
+	}
+	reply.From = receipt.From
+	if hexValues {
+		reply.GasUsedHex = receipt.GasUsed
+	}
+	if receipt.GasUsed != nil {
+		reply.GasUsedStr = receipt.GasUsed.ToInt().Text(10)
+	}
+	if hexValues {
+		reply.StatusHex = receipt.Status
+	}
+	if receipt.Status != nil {
+		reply.StatusStr = receipt.Status.ToInt().Text(10)
+	}
+	reply.To = receipt.To
+	reply.TransactionHash = receipt.TransactionHash
+	if hexValues {
+		reply.TransactionIndexHex = receipt.TransactionIndex
+	}
+	if receipt.TransactionIndex != nil {
+		reply.TransactionIndexStr = strconv.FormatUint(uint64(*receipt.TransactionIndex), 10)
+	}
+	reply.Logs = nil
+	for _, l := range receipt.Logs {
+		txLog := &messages.TransactionLog{
+			Address: l.Address,
+			Topics:  l.Topics,
+			Data:    l.Data,
+		}
+		if l.LogIndex != nil {
+			txLog.LogIndex = strconv.FormatUint(uint64(*l.LogIndex), 10)
+		}
+		reply.Logs = append(reply.Logs, txLog)
+	}
+}
+
+// checkConfirmations checks whether the block containing a mined transaction is deep enough
+// in the chain, counting the block itself as the first confirmation. The current head block
+// is only queried when confirmations are required, and not subscribed to newHeads
+func (p *txnProcessor) checkConfirmations(inflight *inflightTxn) (bool, uint64, uint64, error) {
+	if inflight.confirmations <= 0 {
+		return true, 0, 0, nil
+	}
+	headBlock, subscribed := p.blocks.head()
+	if !subscribed {
+		var err error
+		if headBlock, err = eth.GetBlockNumber(inflight.txnContext.Context(), p.rpc); err != nil {
+			return false, 0, 0, err
+		}
+	}
+	var confirmations uint64
+	blockNumber := inflight.tx.Receipt.BlockNumber.ToInt().Uint64()
+	if headBlock >= blockNumber {
+		confirmations = headBlock - blockNumber + 1
+	}
+	log.Debugf("Confirmations for %s: %d/%d (block=%d head=%d)", inflight, confirmations, inflight.confirmations, blockNumber, headBlock)
+	return confirmations >= uint64(inflight.confirmations), confirmations, headBlock, nil
+}
+
+// pollReceipt checks for the receipt using a copy of the transaction, so the transaction lock is
+// not held while waiting for the poll. If the transaction is replaced in the meantime the result
+// is discarded, and the new hash is checked on the next pass. Returns with the lock held
+func (p *txnProcessor) pollReceipt(inflight *inflightTxn) (bool, error) {
+	inflight.txLock.Lock()
+	polled := *inflight.tx
+	polled.Receipt = eth.TxnReceipt{}
+	inflight.txLock.Unlock()
+
+	hash, submitted := polled.Hash, len(polled.SubmittedHashes)
+	isMined, err := p.receiptPoller.getReceipt(inflight.txnContext.Context(), p.rpc, &polled)
+
+	inflight.txLock.Lock()
+	if inflight.tx.Hash != hash || len(inflight.tx.SubmittedHashes) != submitted {
+		log.Debugf("Replaced while checking for receipt: %s", inflight)
+		return false, nil
+	}
+	if err == nil {
+		inflight.tx.Hash = polled.Hash
+		inflight.tx.Receipt = polled.Receipt
+	}
+	return isMined, err
+}
+
 // addInflight adds a transaction to the inflight list, and kick off
 // a goroutine to check for its completion and send the result
 func (p *txnProcessor) trackMining(inflight *inflightTxn, tx *eth.Txn) {
 
 	// Kick off the goroutine to track it to completion
+	inflight.txLock.Lock()
 	inflight.tx = tx
+	inflight.lastSubmitted = time.Now().UTC()
+	inflight.txLock.Unlock()
 	inflight.wg.Add(1)
 	go p.waitForCompletion(inflight, inflight.initialWaitDelay)
 
@@ -513,13 +1002,40 @@ func (p *txnProcessor) trackMining(inflight *inflightTxn, tx *eth.Txn) {
 
 func (p *txnProcessor) OnDeployContractMessage(txnContext TxnContext, msg *messages.DeployContract) {
 
+	if msg.Simulate {
+		p.simulateTransaction(txnContext, &msg.TransactionCommon, "", nil, func(signer eth.TXSigner) (*eth.Txn, error) {
+			return eth.NewContractDeployTxn(msg, signer)
+		})
+		return
+	}
+
+	if msg.Prepare {
+		p.prepareTransaction(txnContext, &msg.TransactionCommon, "", func(signer eth.TXSigner) (*eth.Txn, error) {
+			tx, err := eth.NewContractDeployTxn(msg, signer)
+			if err == nil {
+				tx.GasEstimatePolicy = p.conf.GasEstimate.policyForDeploy(msg)
+			}
+			return tx, err
+		})
+		return
+	}
+
+	if p.schedule(txnContext, &msg.TransactionCommon, msg) {
+		return
+	}
+
 	inflight, err := p.addInflightWrapper(txnContext, &msg.TransactionCommon)
 	if err != nil {
-		txnContext.SendErrorReply(400, err)
+		txnContext.SendErrorReply(addInflightErrStatus(err), err)
 		return
 	}
 	inflight.registerAs = msg.RegisterAs
 	msg.Nonce = inflight.nonceNumber()
+	if err := p.gasPricer.applyGasPrice(txnContext.Context(), inflight.rpc, &msg.TransactionCommon); err != nil {
+		p.cancelInFlight(inflight, false /* not yet submitted */)
+		txnContext.SendErrorReply(400, err)
+		return
+	}
 
 	tx, err := eth.NewContractDeployTxn(msg, inflight.signer)
 	if err != nil {
@@ -527,18 +1043,46 @@ func (p *txnProcessor) OnDeployContractMessage(txnContext TxnContext, msg *messa
 		txnContext.SendErrorReply(400, err)
 		return
 	}
+	tx.GasEstimatePolicy = p.conf.GasEstimate.policyForDeploy(msg)
 
 	p.sendTransactionCommon(txnContext, inflight, tx)
 }
 
 func (p *txnProcessor) OnSendTransactionMessage(txnContext TxnContext, msg *messages.SendTransaction) {
 
+	if msg.Simulate {
+		p.simulateTransaction(txnContext, &msg.TransactionCommon, msg.To, msg.Method, func(signer eth.TXSigner) (*eth.Txn, error) {
+			return eth.NewSendTxn(msg, signer)
+		})
+		return
+	}
+
+	if msg.Prepare {
+		p.prepareTransaction(txnContext, &msg.TransactionCommon, msg.To, func(signer eth.TXSigner) (*eth.Txn, error) {
+			tx, err := eth.NewSendTxn(msg, signer)
+			if err == nil {
+				tx.GasEstimatePolicy = p.conf.GasEstimate.policyForSend(msg, tx)
+			}
+			return tx, err
+		})
+		return
+	}
+
+	if p.schedule(txnContext, &msg.TransactionCommon, msg) {
+		return
+	}
+
 	inflight, err := p.addInflightWrapper(txnContext, &msg.TransactionCommon)
 	if err != nil {
-		txnContext.SendErrorReply(400, err)
+		txnContext.SendErrorReply(addInflightErrStatus(err), err)
 		return
 	}
 	msg.Nonce = inflight.nonceNumber()
+	if err := p.gasPricer.applyGasPrice(txnContext.Context(), inflight.rpc, &msg.TransactionCommon); err != nil {
+		p.cancelInFlight(inflight, false /* not yet submitted */)
+		txnContext.SendErrorReply(400, err)
+		return
+	}
 
 	tx, err := eth.NewSendTxn(msg, inflight.signer)
 	if err != nil {
@@ -546,6 +1090,32 @@ func (p *txnProcessor) OnSendTransactionMessage(txnContext TxnContext, msg *mess
 		txnContext.SendErrorReply(400, err)
 		return
 	}
+	tx.GasEstimatePolicy = p.conf.GasEstimate.policyForSend(msg, tx)
+
+	p.sendTransactionCommon(txnContext, inflight, tx)
+}
+
+// OnSendRawTransactionMessage submits a transaction that was signed by the submitter.
+// The sender and nonce are recovered from the signed transaction, and are used to track
+// it in flight alongside the transactions we build ourselves
+func (p *txnProcessor) OnSendRawTransactionMessage(txnContext TxnContext, msg *messages.SendRawTransaction) {
+
+	tx, err := eth.NewRawTxn(msg)
+	if err != nil {
+		txnContext.SendErrorReply(400, err)
+		return
+	}
+
+	inflight, err := p.addInflightWrapper(txnContext, &messages.TransactionCommon{
+		RequestCommon: msg.RequestCommon,
+		From:          tx.From.Hex(),
+		Nonce:         json.Number(strconv.FormatUint(tx.EthTX.Nonce(), 10)),
+		Confirmations: msg.Confirmations,
+	})
+	if err != nil {
+		txnContext.SendErrorReply(addInflightErrStatus(err), err)
+		return
+	}
 
 	p.sendTransactionCommon(txnContext, inflight, tx)
 }
@@ -555,7 +1125,7 @@ func (p *txnProcessor) sendTransactionCommon(txnContext TxnContext, inflight *in
 	tx.PrivacyGroupID = inflight.privacyGroupID
 	tx.NodeAssignNonce = inflight.nodeAssignNonce
 
-	if p.conf.SendConcurrency > 1 {
+	if p.sendsConcurrently(txnContext) {
 		// The above must happen synchronously for each partition in Kafka - as it is where we assign the nonce.
 		// However, the send to the node can happen at high concurrency.
 		p.concurrencySlots <- true
@@ -566,9 +1136,22 @@ func (p *txnProcessor) sendTransactionCommon(txnContext TxnContext, inflight *in
 	}
 }
 
+// sendsConcurrently is true if the send to the node happens in the background. The items of
+// a batch are always sent in turn, so the batch can stop at the first item that fails
+func (p *txnProcessor) sendsConcurrently(txnContext TxnContext) bool {
+	_, isBatchItem := txnContext.(*batchItemContext)
+	return p.conf.SendConcurrency > 1 && !isBatchItem
+}
+
 func (p *txnProcessor) sendAndTrackMining(txnContext TxnContext, inflight *inflightTxn, tx *eth.Txn) {
-	err := tx.Send(txnContext.Context(), inflight.rpc)
-	if p.conf.SendConcurrency > 1 {
+	var err error
+	if sc, isScheduled := txnContext.(*scheduledTxnContext); isScheduled {
+		err = sc.submitting()
+	}
+	if err == nil {
+		err = tx.Send(txnContext.Context(), inflight.rpc)
+	}
+	if p.sendsConcurrently(txnContext) {
 		<-p.concurrencySlots // return our slot as soon as send is complete, to let an awaiting send go
 	}
 	if err != nil {
@@ -577,5 +1160,6 @@ func (p *txnProcessor) sendAndTrackMining(txnContext TxnContext, inflight *infli
 		return
 	}
 
+	p.journalInflight(inflight, tx)
 	p.trackMining(inflight, tx)
 }
@@ -70,6 +70,13 @@ type testRPC struct {
 	privFindPrivacyGroupErr        error
 	ethEstimateGasResult           ethbinding.HexUint64
 	ethEstimateGasErr              error
+	ethCallResult                  string
+	ethCallErr                     error
+	debugTraceOutput               string
+	debugTraceErr                  error
+	ethBlockNumberResults          []uint64
+	ethBlockNumberErr              error
+	ethChainIDResult               int64
 	condLock                       sync.Mutex
 	calls                          []string
 	params                         [][]interface{}
@@ -147,13 +154,28 @@ func (r *testRPC) CallContext(ctx context.Context, result interface{}, method st
 	} else if method == "priv_findPrivacyGroup" {
 		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(r.privFindPrivacyGroupResult))
 		return r.privFindPrivacyGroupErr
-	} else if method == "eth_getTransactionReceipt" {
+	} else if method == "eth_getTransactionReceipt" || method == "priv_getTransactionReceipt" {
 		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(r.ethGetTransactionReceiptResult))
 		return r.ethGetTransactionReceiptErr
 	} else if method == "eth_estimateGas" {
 		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(&r.ethEstimateGasResult))
 		return r.ethEstimateGasErr
 	} else if method == "eth_call" {
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(r.ethCallResult))
+		return r.ethCallErr
+	} else if method == "debug_traceTransaction" {
+		json.Unmarshal([]byte(`{"type":"CALL","error":"execution reverted","output":"`+r.debugTraceOutput+`"}`), result)
+		return r.debugTraceErr
+	} else if method == "eth_blockNumber" {
+		// Each call moves on to the next head block, until the last one is reached
+		blockNumber := ethbinding.HexUint64(r.ethBlockNumberResults[0])
+		if len(r.ethBlockNumberResults) > 1 {
+			r.ethBlockNumberResults = r.ethBlockNumberResults[1:]
+		}
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(blockNumber))
+		return r.ethBlockNumberErr
+	} else if method == "eth_chainId" {
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(ethbinding.HexBigInt(*big.NewInt(r.ethChainIDResult))))
 		return nil
 	}
 	panic(fmt.Errorf("method unknown to test: %s", method))
@@ -318,6 +340,35 @@ func goodMessageRPC() *testRPC {
 	return testRPC
 }
 
+func TestSetReceiptFieldsLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	receipt := goodMessageRPC().ethGetTransactionReceiptResult
+	logIndex := ethbinding.HexUint(3)
+	topic := ethbind.API.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	receipt.Logs = []*eth.TxnLog{
+		{
+			Address:  receipt.To,
+			Topics:   []*ethbinding.Hash{&topic},
+			Data:     "0x01",
+			LogIndex: &logIndex,
+		},
+	}
+	var reply messages.TransactionReceipt
+	setReceiptFields(&reply, &receipt, false)
+
+	assert.Len(reply.Logs, 1)
+	assert.Equal(receipt.To, reply.Logs[0].Address)
+	assert.Equal("3", reply.Logs[0].LogIndex)
+	assert.Equal("0x01", reply.Logs[0].Data)
+	assert.Equal(&topic, reply.Logs[0].Topics[0])
+	assert.Equal("", reply.Logs[0].Event)
+
+	receipt.Logs = nil
+	setReceiptFields(&reply, &receipt, false)
+	assert.Nil(reply.Logs)
+}
+
 func TestOnDeployContractMessageGoodTxnMined(t *testing.T) {
 	assert := assert.New(t)
 
@@ -360,6 +411,8 @@ func TestOnDeployContractMessageGoodTxnMined(t *testing.T) {
 	assert.Equal("23456", replyMsgMap["cumulativeGasUsed"])
 	assert.Equal("0xba25be62a5c55d4ad1d5520268806a8730a4de5e", replyMsgMap["from"])
 	assert.Equal("345678", replyMsgMap["gasUsed"])
+	assert.Equal("123", replyMsgMap["gas"])
+	assert.Nil(replyMsgMap["gasEstimate"])
 	assert.Equal("123", replyMsgMap["nonce"])
 	assert.Equal("1", replyMsgMap["status"])
 	assert.Equal("0xd7fac2bce408ed7c6ded07a32038b1f79c2b27d3", replyMsgMap["to"])
@@ -710,6 +763,93 @@ func TestOnSendTransactionMessageTxnTimeout(t *testing.T) {
 
 }
 
+func newTestRawTxnJSON(t *testing.T, nonce uint64) (string, string) {
+	key, err := ethbind.API.HexToECDSA("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	assert.NoError(t, err)
+	to := ethbind.API.HexToAddress("0xD7FAC2bCe408Ed7C6ded07a32038b1F79C2b27d3")
+	etx := ethbind.API.NewTransaction(nonce, to, big.NewInt(0), 50000, big.NewInt(1000), []byte{0x01})
+	signed, err := ethbind.API.SignTx(etx, ethbind.API.NewEIP155Signer(big.NewInt(1337)), key)
+	assert.NoError(t, err)
+	raw, _ := signed.MarshalBinary()
+	rawHex := ethbind.API.HexEncode(raw)
+	from := strings.ToLower(ethbind.API.PubkeyToAddress(key.PublicKey).Hex())
+	return "{" +
+		"  \"headers\":{\"type\": \"SendRawTransaction\"}," +
+		"  \"rawTransaction\":\"" + rawHex + "\"" +
+		"}", from
+}
+
+func TestOnSendRawTransactionMessageMined(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	var from string
+	testTxnContext.jsonMsg, from = newTestRawTxnJSON(t, 5)
+	testRPC := goodMessageRPC()
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[from] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[from].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Empty(testTxnContext.errorReplies)
+	assert.Len(testTxnContext.replies, 1)
+
+	assert.Equal("eth_sendRawTransaction", testRPC.calls[0])
+	assert.Equal("eth_getTransactionReceipt", testRPC.calls[1])
+	reply := testTxnContext.replies[0].(*messages.TransactionReceipt)
+	assert.Equal(messages.MsgTypeTransactionSuccess, reply.Headers.MsgType)
+	assert.Equal("5", reply.NonceStr)
+	assert.Equal("50000", reply.GasStr)
+}
+
+func TestOnSendRawTransactionMessageBadRawTxn(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = "{" +
+		"  \"headers\":{\"type\": \"SendRawTransaction\"}," +
+		"  \"rawTransaction\":\"0xf8\"" +
+		"}"
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Len(testTxnContext.errorReplies, 1)
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.Regexp("Failed to decode raw transaction", testTxnContext.errorReplies[0].err)
+}
+
+func TestOnSendRawTransactionMessageBadConfirmations(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	jsonMsg, _ := newTestRawTxnJSON(t, 5)
+	testTxnContext.jsonMsg = strings.Replace(jsonMsg, "{", "{\"confirmations\":\"-1\",", 1)
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Len(testTxnContext.errorReplies, 1)
+	assert.Regexp("confirmations", testTxnContext.errorReplies[0].err)
+}
+
+func TestOnSendRawTransactionMessageBadJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = "badness"
+	testTxnContext.badMsgType = messages.MsgTypeSendRawTransaction
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Len(testTxnContext.errorReplies, 1)
+	assert.Regexp("invalid character", testTxnContext.errorReplies[0].err)
+}
+
 func TestOnSendTransactionMessageFailedTxn(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1125,9 +1265,11 @@ func TestCobraInitTxnProcessor(t *testing.T) {
 	cmd.ParseFlags([]string{
 		"-x", "10",
 		"-P",
+		"-N", "/tmp/journal",
 	})
 	assert.Equal(10, txconf.MaxTXWaitTime)
 	assert.Equal(true, txconf.AlwaysManageNonce)
+	assert.Equal("/tmp/journal", txconf.JournalLevelDBPath)
 }
 
 func TestOnSendTransactionAddressBook(t *testing.T) {
@@ -1300,3 +1442,152 @@ func TestResolveAddressHDWalletFail(t *testing.T) {
 	_, err := txnProcessor.ResolveAddress("hd-testinst-testwallet-1234")
 	assert.EqualError(err, "No HD Wallet Configuration")
 }
+
+func TestOnSendTransactionMessageConfirmations(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		Confirmations: 3,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	testRPC := goodMessageRPC()
+	testRPC.ethBlockNumberResults = []uint64{12345, 12346, 12348}
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(0, len(testTxnContext.errorReplies))
+
+	assert.Equal([]string{
+		"eth_sendTransaction",
+		"eth_getTransactionReceipt", "eth_blockNumber",
+		"eth_getTransactionReceipt", "eth_blockNumber",
+		"eth_getTransactionReceipt", "eth_blockNumber",
+	}, testRPC.calls)
+
+	replyMsg := testTxnContext.replies[0]
+	assert.Equal("TransactionSuccess", replyMsg.ReplyHeaders().MsgType)
+	replyMsgBytes, _ := json.Marshal(&replyMsg)
+	var replyMsgMap map[string]interface{}
+	json.Unmarshal(replyMsgBytes, &replyMsgMap)
+	assert.Equal("12345", replyMsgMap["blockNumber"])
+	assert.Equal("4", replyMsgMap["confirmations"])
+	assert.Equal("12348", replyMsgMap["headBlockNumber"])
+}
+
+func TestOnSendTransactionMessageConfirmationsOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		Confirmations: 10,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = "{" +
+		"  \"headers\":{\"type\": \"SendTransaction\"}," +
+		"  \"from\":\"" + testFromAddr + "\"," +
+		"  \"gas\":\"123\"," +
+		"  \"confirmations\":\"0\"," +
+		"  \"method\":{\"name\":\"test\"}" +
+		"}"
+	testRPC := goodMessageRPC()
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+	assert.Equal(0, len(testTxnContext.errorReplies))
+	assert.Equal([]string{"eth_sendTransaction", "eth_getTransactionReceipt"}, testRPC.calls)
+
+	replyMsgBytes, _ := json.Marshal(testTxnContext.replies[0])
+	var replyMsgMap map[string]interface{}
+	json.Unmarshal(replyMsgBytes, &replyMsgMap)
+	assert.Nil(replyMsgMap["confirmations"])
+	assert.Nil(replyMsgMap["headBlockNumber"])
+}
+
+func TestOnSendTransactionMessageConfirmationsTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		Confirmations: 3,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	testRPC := goodMessageRPC()
+	testRPC.ethBlockNumberResults = []uint64{12346}
+	txnProcessor.Init(testRPC)
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+
+	assert.Empty(testTxnContext.replies)
+	assert.Equal(1, len(testTxnContext.errorReplies))
+	assert.Equal(408, testTxnContext.errorReplies[0].status)
+	assert.EqualError(testTxnContext.errorReplies[0].err, "Timed out waiting for confirmations. Transaction mined in block 12345 with 2 of 3 confirmations")
+}
+
+func TestOnSendTransactionMessageConfirmationsBlockNumberFail(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime: 1,
+		Confirmations: 3,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = goodSendTxnJSON
+	testRPC := goodMessageRPC()
+	testRPC.ethBlockNumberResults = []uint64{0}
+	testRPC.ethBlockNumberErr = fmt.Errorf("pop")
+	txnProcessor.Init(testRPC)
+	txnProcessor.maxTXWaitTime = 250 * time.Millisecond
+
+	txnProcessor.OnMessage(testTxnContext)
+	for inMap := false; !inMap; _, inMap = txnProcessor.inflightTxns[strings.ToLower(testFromAddr)] {
+		time.Sleep(1 * time.Millisecond)
+	}
+	txnWG := &txnProcessor.inflightTxns[strings.ToLower(testFromAddr)].txnsInFlight[0].wg
+	txnWG.Wait()
+
+	assert.Equal(1, len(testTxnContext.errorReplies))
+	assert.Equal(500, testTxnContext.errorReplies[0].status)
+	assert.Regexp("eth_blockNumber returned: pop", testTxnContext.errorReplies[0].err)
+}
+
+func TestOnSendTransactionMessageBadConfirmations(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{}, &eth.RPCConf{}).(*txnProcessor)
+	testTxnContext := &testTxnContext{}
+	testTxnContext.jsonMsg = "{" +
+		"  \"headers\":{\"type\": \"SendTransaction\"}," +
+		"  \"from\":\"" + testFromAddr + "\"," +
+		"  \"gas\":\"123\"," +
+		"  \"confirmations\":\"-1\"," +
+		"  \"method\":{\"name\":\"test\"}" +
+		"}"
+	testRPC := goodMessageRPC()
+	txnProcessor.Init(testRPC)
+
+	txnProcessor.OnMessage(testTxnContext)
+
+	assert.Equal(1, len(testTxnContext.errorReplies))
+	assert.Equal(400, testTxnContext.errorReplies[0].status)
+	assert.EqualError(testTxnContext.errorReplies[0].err, "Invalid confirmations '-1'")
+	assert.Empty(testRPC.calls)
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Nodes such as geth require at least a 10% increase to accept a replacement
+	defaultGasReplacementBumpPercent = 10
+)
+
+// GasReplacementConf configures the replacement of transactions that are not mined
+// in a timely way, by re-submitting the same nonce with increased fees
+type GasReplacementConf struct {
+	IntervalSec     int    `json:"intervalSec"`
+	BumpPercent     int    `json:"bumpPercent"`
+	MaxReplacements int    `json:"maxReplacements"`
+	MaxGasPrice     string `json:"maxGasPrice,omitempty"`
+}
+
+func (p *txnProcessor) initGasReplacement() error {
+	conf := &p.conf.GasReplacement
+	if conf.BumpPercent <= 0 {
+		conf.BumpPercent = defaultGasReplacementBumpPercent
+	}
+	if conf.MaxGasPrice != "" {
+		maxGasPrice, ok := new(big.Int).SetString(conf.MaxGasPrice, 10)
+		if !ok {
+			return errors.Errorf(errors.TransactionReplaceBadMaxGasPrice, conf.MaxGasPrice)
+		}
+		p.replaceMaxGasPrice = maxGasPrice
+	}
+	p.replaceInterval = time.Duration(conf.IntervalSec) * time.Second
+	return nil
+}
+
+// replaceIfStuck re-submits the transaction for the same nonce with increased fees,
+// if it has not been mined within the configured interval since it was last submitted.
+// Only called from the goroutine tracking the transaction to completion
+func (p *txnProcessor) replaceIfStuck(inflight *inflightTxn) {
+	conf := &p.conf.GasReplacement
+	if p.replaceInterval == 0 || time.Now().UTC().Sub(inflight.lastSubmitted) < p.replaceInterval {
+		return
+	}
+	if conf.MaxReplacements > 0 && inflight.replacements >= conf.MaxReplacements {
+		return
+	}
+	if !inflight.tx.Replaceable() {
+		return
+	}
+
+	// Wait the full interval before the next attempt, whether or not this one succeeds
+	inflight.lastSubmitted = time.Now().UTC()
+	prevHash := inflight.tx.Hash
+	if err := inflight.tx.Replace(inflight.txnContext.Context(), inflight.rpc, int64(conf.BumpPercent), p.replaceMaxGasPrice); err != nil {
+		log.Warnf("In-flight %d replacement of TX=%s failed: %s", inflight.id, prevHash, err)
+		return
+	}
+	inflight.replacements++
+	log.Infof("In-flight %d replaced (%d). TX=%s replaces TX=%s", inflight.id, inflight.replacements, inflight.tx.Hash, prevHash)
+
+	p.journalReplaced(inflight)
+}
+
+// journalReplaced updates the journal entry with the hashes of a replaced transaction
+func (p *txnProcessor) journalReplaced(inflight *inflightTxn) {
+	if inflight.journaled == nil {
+		return
+	}
+	inflight.journaled.TXHash = inflight.tx.Hash
+	inflight.journaled.SubmittedHashes = inflight.tx.SubmittedHashes
+	inflight.journaled.CancelHashes = inflight.tx.CancelHashes
+	if err := p.journal.store(inflight.journaled); err != nil {
+		log.Errorf("Failed to journal replacement for in-flight %d: %s", inflight.id, err)
+	}
+}
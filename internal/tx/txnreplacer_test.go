@@ -0,0 +1,194 @@
+// Copyright 2021 Kaleido
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaleido-io/ethconnect/internal/eth"
+	"github.com/kaleido-io/ethconnect/internal/kvstore"
+	"github.com/kaleido-io/ethconnect/internal/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReplacerProcessor(t *testing.T, conf GasReplacementConf) *txnProcessor {
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		MaxTXWaitTime:  1,
+		GasReplacement: conf,
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.NoError(t, err)
+	return txnProcessor
+}
+
+func newTestStuckInflight(t *testing.T, rpc eth.RPCClient) *inflightTxn {
+	var msg messages.SendTransaction
+	msg.From = testFromAddr
+	msg.To = "0xD7FAC2bCe408Ed7C6ded07a32038b1F79C2b27d3"
+	msg.MethodName = "test"
+	msg.Parameters = []interface{}{}
+	msg.Nonce = "5"
+	msg.Gas = "123"
+	msg.GasPrice = "100"
+	tx, err := eth.NewSendTxn(&msg, nil)
+	assert.NoError(t, err)
+	tx.Hash = "0x111"
+	return &inflightTxn{
+		id:            1,
+		from:          strings.ToLower(testFromAddr),
+		nonce:         5,
+		rpc:           rpc,
+		tx:            tx,
+		txnContext:    &testTxnContext{jsonMsg: goodSendTxnJSON},
+		lastSubmitted: time.Now().UTC().Add(-1 * time.Hour),
+	}
+}
+
+func TestInitGasReplacementDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{
+		IntervalSec: 30,
+		MaxGasPrice: "1000000",
+	})
+	assert.Equal(30*time.Second, txnProcessor.replaceInterval)
+	assert.Equal(defaultGasReplacementBumpPercent, txnProcessor.conf.GasReplacement.BumpPercent)
+	assert.Equal("1000000", txnProcessor.replaceMaxGasPrice.String())
+}
+
+func TestInitGasReplacementBadMaxGasPrice(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := NewTxnProcessor(&TxnProcessorConf{
+		GasReplacement: GasReplacementConf{
+			IntervalSec: 30,
+			MaxGasPrice: "lots",
+		},
+	}, &eth.RPCConf{}).(*txnProcessor)
+	err := txnProcessor.Init(goodMessageRPC())
+	assert.EqualError(err, "Invalid maximum gas price for transaction replacement: 'lots'")
+}
+
+func TestReplaceIfStuckReplaces(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{
+		IntervalSec: 30,
+		BumpPercent: 20,
+	})
+	db := kvstore.NewMockKV(nil)
+	txnProcessor.journal = newTxnJournal(db)
+	testRPC := goodMessageRPC()
+	inflight := newTestStuckInflight(t, testRPC)
+	txnProcessor.journalInflight(inflight, inflight.tx)
+
+	txnProcessor.replaceIfStuck(inflight)
+
+	assert.Equal(1, inflight.replacements)
+	assert.Equal([]string{"eth_sendTransaction"}, testRPC.calls)
+	sendTX := testRPC.params[0][0].(*eth.SendTXArgs)
+	assert.Equal(uint64(5), uint64(*sendTX.Nonce))
+	assert.Equal("120", sendTX.GasPrice.ToInt().String())
+	assert.Equal([]string{"0x111", testRPC.ethSendTransactionResult}, inflight.tx.SubmittedHashes)
+	assert.WithinDuration(time.Now(), inflight.lastSubmitted, 1*time.Minute)
+
+	entries := txnProcessor.journal.recover()
+	assert.Equal(1, len(entries))
+	assert.Equal(testRPC.ethSendTransactionResult, entries[0].TXHash)
+	assert.Equal(inflight.tx.SubmittedHashes, entries[0].SubmittedHashes)
+
+	// Not due again until the interval has passed
+	txnProcessor.replaceIfStuck(inflight)
+	assert.Equal(1, inflight.replacements)
+}
+
+func TestReplaceIfStuckJournalFail(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{
+		IntervalSec: 30,
+	})
+	db := kvstore.NewMockKV(nil)
+	txnProcessor.journal = newTxnJournal(db)
+	inflight := newTestStuckInflight(t, goodMessageRPC())
+	txnProcessor.journalInflight(inflight, inflight.tx)
+	db.StoreErr = fmt.Errorf("pop")
+
+	txnProcessor.replaceIfStuck(inflight)
+	assert.Equal(1, inflight.replacements)
+}
+
+func TestReplaceIfStuckDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{})
+	testRPC := goodMessageRPC()
+	inflight := newTestStuckInflight(t, testRPC)
+
+	txnProcessor.replaceIfStuck(inflight)
+	assert.Equal(0, inflight.replacements)
+	assert.Empty(testRPC.calls)
+}
+
+func TestReplaceIfStuckMaxReplacements(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{
+		IntervalSec:     30,
+		MaxReplacements: 1,
+	})
+	testRPC := goodMessageRPC()
+	inflight := newTestStuckInflight(t, testRPC)
+	inflight.replacements = 1
+
+	txnProcessor.replaceIfStuck(inflight)
+	assert.Equal(1, inflight.replacements)
+	assert.Empty(testRPC.calls)
+}
+
+func TestReplaceIfStuckNotReplaceable(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{
+		IntervalSec: 30,
+	})
+	testRPC := goodMessageRPC()
+	inflight := newTestStuckInflight(t, testRPC)
+	inflight.tx.PrivateFor = []string{"member1"}
+
+	txnProcessor.replaceIfStuck(inflight)
+	assert.Equal(0, inflight.replacements)
+	assert.Empty(testRPC.calls)
+}
+
+func TestReplaceIfStuckFails(t *testing.T) {
+	assert := assert.New(t)
+
+	txnProcessor := newTestReplacerProcessor(t, GasReplacementConf{
+		IntervalSec: 30,
+	})
+	testRPC := goodMessageRPC()
+	testRPC.ethSendTransactionErr = fmt.Errorf("replacement transaction underpriced")
+	inflight := newTestStuckInflight(t, testRPC)
+
+	txnProcessor.replaceIfStuck(inflight)
+	assert.Equal(0, inflight.replacements)
+	assert.Equal("0x111", inflight.tx.Hash)
+	assert.Empty(inflight.tx.SubmittedHashes)
+	assert.WithinDuration(time.Now(), inflight.lastSubmitted, 1*time.Minute)
+}
@@ -16,6 +16,7 @@ package utils
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -39,12 +40,19 @@ type HTTPRequesterConf struct {
 
 // NewHTTPRequester constructor
 func NewHTTPRequester(name string, conf *HTTPRequesterConf) *HTTPRequester {
+	return NewHTTPRequesterWithTLS(name, conf, nil)
+}
+
+// NewHTTPRequesterWithTLS constructor for requesters that connect using a TLS configuration,
+// such as one built by CreateTLSConfiguration. A nil configuration uses the defaults
+func NewHTTPRequesterWithTLS(name string, conf *HTTPRequesterConf, tlsConfig *tls.Config) *HTTPRequester {
 	return &HTTPRequester{
 		name: name,
 		conf: conf,
 		client: &http.Client{
 			Transport: &http.Transport{
-				MaxIdleConns: 1,
+				MaxIdleConns:    1,
+				TLSClientConfig: tlsConfig,
 			},
 		},
 	}
@@ -64,7 +72,8 @@ func (hr *HTTPRequester) DoRequest(method, url string, bodyMap map[string]interf
 	req, _ := http.NewRequest(method, url, body)
 	req.Header = http.Header{}
 	if hr.conf.Headers != nil {
-		req.Header = hr.conf.Headers
+		// Copied, as requesters can be used concurrently
+		req.Header = http.Header(hr.conf.Headers).Clone()
 	}
 	req.Header.Add("content-type", "application/json")
 	res, ehr := hr.client.Do(req)
@@ -15,6 +15,7 @@
 package utils
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -107,6 +108,27 @@ func TestHTTPRequesterPOSTSuccess(t *testing.T) {
 	assert.Equal("response", resBody["some"])
 }
 
+func TestHTTPRequesterWithTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	router := &httprouter.Router{}
+	router.GET("/", func(res http.ResponseWriter, req *http.Request, parms httprouter.Params) {
+		res.WriteHeader(200)
+		res.Write([]byte("{\"some\":\"response\"}"))
+	})
+	server := httptest.NewTLSServer(router)
+	defer server.Close()
+
+	hr := NewHTTPRequester("unit test", &HTTPRequesterConf{})
+	_, err := hr.DoRequest("GET", server.URL, nil)
+	assert.EqualError(err, "Error querying unit test")
+
+	hr = NewHTTPRequesterWithTLS("unit test", &HTTPRequesterConf{}, &tls.Config{InsecureSkipVerify: true})
+	resBody, err := hr.DoRequest("GET", server.URL, nil)
+	assert.NoError(err)
+	assert.Equal("response", resBody["some"])
+}
+
 func TestHTTPRequester404ToNil(t *testing.T) {
 	assert := assert.New(t)
 
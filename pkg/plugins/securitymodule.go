@@ -36,3 +36,22 @@ type SecurityModule interface {
 	// AuthReadAsyncReplyByUUID - Authorization plugpoint for getting an individual reply by UUID (containing an individual receipt/error)
 	AuthReadAsyncReplyByUUID(authCtx interface{}) error
 }
+
+// IdentitySecurityModule can optionally be implemented by a SecurityModule, to scope resources
+// such as idempotency keys to the identity each token was issued to.
+// If it is not implemented, resources are not scoped, and are shared by all callers
+type IdentitySecurityModule interface {
+
+	// Identity - Returns the identity the context object was issued to
+	Identity(authCtx interface{}) string
+}
+
+// PendingTransactionsSecurityModule can optionally be implemented by a SecurityModule, to control
+// the management of transactions held by ethconnect, such as cancelling or replacing them.
+// If it is not implemented, any caller with a verified token is authorized, in the same way
+// as for submitting transactions
+type PendingTransactionsSecurityModule interface {
+
+	// AuthPendingTransactions - Authorization plugpoint for querying, cancelling and replacing transactions that are waiting to be mined
+	AuthPendingTransactions(authCtx interface{}) error
+}